@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$") {
+		t.Fatalf("hash %q isn't an argon2id PHC string", hash)
+	}
+	if match, err := CheckPassword(hash, "correct horse"); err != nil || !match {
+		t.Fatalf("the right password didn't match: %v, %v", match, err)
+	}
+	if match, err := CheckPassword(hash, "correct horse "); err != nil || match {
+		t.Fatalf("a wrong password matched: %v, %v", match, err)
+	}
+	other, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Fatal("two hashes of the same password are the same, the salt isn't random")
+	}
+}
+
+// A hash made with other parameters is checked with its own, so changing the cost keeps old accounts working.
+func TestCheckPasswordUsesTheHashParameters(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("hunter2"), salt, 1, 8*1024, 2, 24)
+	hash := fmt.Sprintf("$argon2id$v=19$m=8192,t=1,p=2$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	if match, err := CheckPassword(hash, "hunter2"); err != nil || !match {
+		t.Fatalf("the right password didn't match: %v, %v", match, err)
+	}
+	if match, err := CheckPassword(hash, "hunter3"); err != nil || match {
+		t.Fatalf("a wrong password matched: %v, %v", match, err)
+	}
+}
+
+func TestCheckPasswordRejectsMalformedHashes(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"pbkdf2-sha256$600000$c2FsdA$a2V5",
+		"$argon2i$v=19$m=19456,t=2,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=16$m=19456,t=2,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=19456,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=19456,t=2,p=1$not base64!$a2V5a2V5",
+		"$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHQ$",
+	} {
+		if _, err := CheckPassword(hash, "password"); err != ErrMalformedHash {
+			t.Errorf("%q: expected ErrMalformedHash, got %v", hash, err)
+		}
+	}
+}
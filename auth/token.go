@@ -0,0 +1,83 @@
+// Package auth token.go issues and checks HS256 JSON Web Tokens.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Claims are what a token says about its holder.
+type Claims struct {
+	Subject   string `json:"sub"`                // player ID
+	Username  string `json:"username,omitempty"` // empty for guests
+	Guest     bool   `json:"guest,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (c Claims) Expires() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// header is the only JWT header the signer writes or accepts.
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Issue signs claims for a player that are valid for the given duration.
+func (s *Signer) Issue(claims Claims, valid time.Duration) (string, Claims, error) {
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(valid).Unix()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", claims, err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + s.sign(unsigned), claims, nil
+}
+
+// Verify checks a token's signature and expiry and returns its claims.
+func (s *Signer) Verify(token string) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return claims, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(parts[0]+"."+parts[1]))) {
+		return claims, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return claims, ErrInvalidToken
+	}
+	if time.Now().After(claims.Expires()) {
+		return claims, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+func (s *Signer) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyIssuedToken(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	token, issued, err := signer.Issue(Claims{Subject: "p1", Username: "ann"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := signer.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims != issued || claims.Subject != "p1" || claims.Username != "ann" {
+		t.Fatalf("got claims %+v, issued %+v", claims, issued)
+	}
+}
+
+func TestVerifyRejectsTamperedTokens(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	token, _, err := signer.Issue(Claims{Subject: "p1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","iat":0,"exp":9999999999}`))
+
+	// The signature of the same claims under another key
+	otherKey, _, err := NewSigner([]byte("other")).Issue(Claims{Subject: "p1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := []byte(parts[2])
+	flipped[0] ^= 1
+
+	for name, tampered := range map[string]string{
+		"changed claims":    parts[0] + "." + forged + "." + parts[2],
+		"changed signature": parts[0] + "." + parts[1] + "." + string(flipped),
+		"other key":         otherKey,
+		"no signature":      parts[0] + "." + parts[1] + ".",
+		"too few parts":     parts[0] + "." + parts[1],
+	} {
+		if _, err := signer.Verify(tampered); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+// Only HS256 is accepted, even when the token is signed correctly under another header.
+func TestVerifyRejectsOtherAlgorithms(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"p1","iat":0,"exp":9999999999}`))
+	for _, alg := range []string{`{"alg":"none","typ":"JWT"}`, `{"alg":"HS512","typ":"JWT"}`, `{"typ":"JWT","alg":"HS256"}`} {
+		unsigned := base64.RawURLEncoding.EncodeToString([]byte(alg)) + "." + payload
+		for _, token := range []string{unsigned + "." + signer.sign(unsigned), unsigned + "."} {
+			if _, err := signer.Verify(token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("%s: expected ErrInvalidToken, got %v", alg, err)
+			}
+		}
+	}
+}
+
+func TestVerifyRejectsExpiredTokens(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	token, _, err := signer.Issue(Claims{Subject: "p1"}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Verify(token); !errors.Is(err, ErrExpiredToken) {
+		t.Fatalf("expected ErrExpiredToken, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package bots contains the AI that drives server-side bot players.
+package bots
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+var directions = []string{"up", "down", "left", "right"}
+
+// View is what a bot can see of the room on the tick it decides.
+type View struct {
+	Tick   uint64
+	Width  float64
+	Height float64
+	Self   models.PlayerState
+	Others []models.PlayerState
+
+	// Blocked reports whether a point is off the field or on a wall, hazard or hole of the map, nil when the
+	// field has nothing but its edges
+	Blocked func(x, y float64) bool
+}
+
+// Strategy picks the next direction for a bot. Returning an empty string keeps the current one.
+type Strategy interface {
+	Name() string
+	Next(view View, difficulty Difficulty, rng *rand.Rand) string
+}
+
+// Difficulty tunes how quickly and how well a bot plays.
+type Difficulty struct {
+	Name          string
+	ReactionTicks int     // ticks between decisions
+	MistakeChance float64 // chance of a random move instead of the planned one
+	SightRange    float64 // how far away other players are noticed, in pixels
+}
+
+var (
+	Easy   = Difficulty{Name: "easy", ReactionTicks: 10, MistakeChance: 0.2, SightRange: 150}
+	Normal = Difficulty{Name: "normal", ReactionTicks: 5, MistakeChance: 0.08, SightRange: 300}
+	Hard   = Difficulty{Name: "hard", ReactionTicks: 2, MistakeChance: 0.02, SightRange: 600}
+)
+
+// DifficultyByName looks up one of the preset difficulties.
+func DifficultyByName(name string) (Difficulty, error) {
+	switch strings.ToLower(name) {
+	case "easy":
+		return Easy, nil
+	case "", "normal":
+		return Normal, nil
+	case "hard":
+		return Hard, nil
+	default:
+		return Difficulty{}, fmt.Errorf("unknown bot difficulty %q", name)
+	}
+}
+
+var strategies = map[string]func() Strategy{
+	"random":   func() Strategy { return &RandomWalker{} },
+	"capturer": func() Strategy { return &SquareCapturer{} },
+	"hunter":   func() Strategy { return &TrailHunter{} },
+}
+
+// NewStrategy creates a fresh strategy by name, strategies keep per-bot state so they are never shared.
+func NewStrategy(name string) (Strategy, error) {
+	constructor, ok := strategies[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown bot strategy %q", name)
+	}
+	return constructor(), nil
+}
+
+// StrategyNames lists the registered strategies in a stable order.
+func StrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Bot wraps a strategy with the reaction time and mistakes of its difficulty.
+type Bot struct {
+	Strategy   Strategy
+	Difficulty Difficulty
+	rng        *rand.Rand
+	wait       int
+}
+
+func New(strategy Strategy, difficulty Difficulty, rng *rand.Rand) *Bot {
+	return &Bot{
+		Strategy:   strategy,
+		Difficulty: difficulty,
+		rng:        rng,
+		wait:       rng.Intn(difficulty.ReactionTicks + 1), // so bots added together don't move in lockstep
+	}
+}
+
+// Decide returns the direction the bot wants to move in, or an empty string to keep going.
+func (b *Bot) Decide(view View) string {
+	if b.wait > 0 {
+		b.wait--
+		return ""
+	}
+	b.wait = b.Difficulty.ReactionTicks
+
+	if b.rng.Float64() < b.Difficulty.MistakeChance {
+		return directions[b.rng.Intn(len(directions))]
+	}
+	return b.Strategy.Next(view, b.Difficulty, b.rng)
+}
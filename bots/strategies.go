@@ -0,0 +1,225 @@
+// Package bots strategies.go contains the built-in bot strategies.
+package bots
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// wallMargin is how close to a wall a bot gets before turning away, in pixels
+const wallMargin = 40
+
+// RandomWalker wanders around, turning at random and away from walls.
+type RandomWalker struct{}
+
+func (s *RandomWalker) Name() string { return "random" }
+
+func (s *RandomWalker) Next(view View, difficulty Difficulty, rng *rand.Rand) string {
+	current := currentDirection(view.Self)
+	if current == "" || rng.Float64() < 0.3 {
+		current = turn(current, rng)
+	}
+	return avoidWalls(view, current, rng)
+}
+
+// SquareCapturer carefully walks small squares around its home and runs back home when anyone comes close.
+type SquareCapturer struct {
+	home    models.Point
+	homeSet bool
+	leg     int // which side of the square is being walked
+	steps   int // decisions spent on the current side
+}
+
+func (s *SquareCapturer) Name() string { return "capturer" }
+
+func (s *SquareCapturer) Next(view View, difficulty Difficulty, rng *rand.Rand) string {
+	self := view.Self
+	if !s.homeSet {
+		s.home = models.Point{X: self.X, Y: self.Y}
+		s.homeSet = true
+	}
+
+	// Retreat if another player is within sight
+	if _, distance := nearestPlayer(view); distance < difficulty.SightRange/2 {
+		s.leg, s.steps = 0, 0
+		return avoidWalls(view, towards(self, s.home.X, s.home.Y), rng)
+	}
+
+	// Walk the square clockwise, the sides get longer on harder difficulties
+	legs := []string{"right", "down", "left", "up"}
+	side := 2 + int(difficulty.SightRange/150)
+	s.steps++
+	if s.steps > side {
+		s.steps = 0
+		s.leg = (s.leg + 1) % len(legs)
+	}
+	direction := legs[s.leg]
+	if hitsWall(view, direction) {
+		s.leg = (s.leg + 1) % len(legs)
+		s.steps = 0
+		direction = legs[s.leg]
+	}
+	return direction
+}
+
+// TrailHunter goes after the nearest trail of another player, or the player itself when no trail is in sight.
+type TrailHunter struct{}
+
+func (s *TrailHunter) Name() string { return "hunter" }
+
+func (s *TrailHunter) Next(view View, difficulty Difficulty, rng *rand.Rand) string {
+	self := view.Self
+	target, distance := nearestTrailPoint(view)
+	if distance > difficulty.SightRange {
+		var player *models.PlayerState
+		player, distance = nearestPlayer(view)
+		if player != nil {
+			target = models.Point{X: player.X, Y: player.Y}
+		}
+	}
+	if distance > difficulty.SightRange {
+		return (&RandomWalker{}).Next(view, difficulty, rng)
+	}
+	return avoidWalls(view, towards(self, target.X, target.Y), rng)
+}
+
+// currentDirection derives the direction a player is moving in from their velocity.
+func currentDirection(player models.PlayerState) string {
+	switch {
+	case player.VelocityY < 0:
+		return "up"
+	case player.VelocityY > 0:
+		return "down"
+	case player.VelocityX < 0:
+		return "left"
+	case player.VelocityX > 0:
+		return "right"
+	default:
+		return ""
+	}
+}
+
+func opposite(direction string) string {
+	switch direction {
+	case "up":
+		return "down"
+	case "down":
+		return "up"
+	case "left":
+		return "right"
+	case "right":
+		return "left"
+	default:
+		return ""
+	}
+}
+
+// turn picks a random direction that isn't a reversal of the current one.
+func turn(current string, rng *rand.Rand) string {
+	for {
+		direction := directions[rng.Intn(len(directions))]
+		if direction != opposite(current) {
+			return direction
+		}
+	}
+}
+
+// towards picks the axis with the larger gap to the target, avoiding an instant reversal.
+func towards(self models.PlayerState, x, y float64) string {
+	dx, dy := x-self.X, y-self.Y
+	horizontal, vertical := "right", "down"
+	if dx < 0 {
+		horizontal = "left"
+	}
+	if dy < 0 {
+		vertical = "up"
+	}
+
+	preferred, fallback := horizontal, vertical
+	if math.Abs(dy) > math.Abs(dx) {
+		preferred, fallback = vertical, horizontal
+	}
+	if preferred == opposite(currentDirection(self)) {
+		return fallback
+	}
+	return preferred
+}
+
+// hitsWall reports whether the bot is close to the edge of the field, or to an obstacle of the map, in the
+// direction it wants to go.
+func hitsWall(view View, direction string) bool {
+	self := view.Self
+	var dx, dy float64
+	switch direction {
+	case "up":
+		dy = -1
+		if self.Y < wallMargin {
+			return true
+		}
+	case "down":
+		dy = 1
+		if self.Y > view.Height-wallMargin {
+			return true
+		}
+	case "left":
+		dx = -1
+		if self.X < wallMargin {
+			return true
+		}
+	case "right":
+		dx = 1
+		if self.X > view.Width-wallMargin {
+			return true
+		}
+	default:
+		return false
+	}
+	if view.Blocked == nil {
+		return false
+	}
+	// Looking a cell and two cells ahead, so thin walls aren't missed
+	for _, distance := range []float64{wallMargin / 2, wallMargin} {
+		if view.Blocked(self.X+dx*distance, self.Y+dy*distance) {
+			return true
+		}
+	}
+	return false
+}
+
+// avoidWalls turns away from a wall the bot is about to run into.
+func avoidWalls(view View, direction string, rng *rand.Rand) string {
+	for attempt := 0; hitsWall(view, direction) && attempt < 8; attempt++ {
+		direction = turn(direction, rng)
+	}
+	return direction
+}
+
+func nearestPlayer(view View) (*models.PlayerState, float64) {
+	var nearest *models.PlayerState
+	best := math.Inf(1)
+	for i := range view.Others {
+		other := &view.Others[i]
+		if !other.IsAlive {
+			continue
+		}
+		if d := math.Hypot(other.X-view.Self.X, other.Y-view.Self.Y); d < best {
+			nearest, best = other, d
+		}
+	}
+	return nearest, best
+}
+
+func nearestTrailPoint(view View) (models.Point, float64) {
+	var nearest models.Point
+	best := math.Inf(1)
+	for _, other := range view.Others {
+		for _, point := range other.PlayerTrail {
+			if d := math.Hypot(point.X-view.Self.X, point.Y-view.Self.Y); d < best {
+				nearest, best = point, d
+			}
+		}
+	}
+	return nearest, best
+}
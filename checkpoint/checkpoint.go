@@ -0,0 +1,127 @@
+// Package checkpoint saves rooms to disk while they play, so a crash loses at most the last few seconds of them.
+//
+// A checkpoint file is one gzipped JSON Checkpoint per room. It is written under a temporary name and renamed over
+// the previous checkpoint, so a crash while writing leaves the previous one whole. Version changes whenever the
+// format does and checkpoints of other versions are not read.
+package checkpoint
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/game"
+)
+
+const (
+	Version = 1
+	Ext     = ".checkpoint.gz"
+)
+
+var ErrVersion = errors.New("unsupported checkpoint version")
+
+// Checkpoint is a room as it was at one tick.
+type Checkpoint struct {
+	Version  int             `json:"version"`
+	RoomID   string          `json:"room"`
+	Saved    time.Time       `json:"saved"`
+	Seed     int64           `json:"seed"`
+	Capacity int             `json:"capacity"`
+	Gameplay config.Gameplay `json:"gameplay"`      // the settings the world was built with
+	Map      string          `json:"map,omitempty"` // the map the world was built from, its terrain is in World
+	World    game.Snapshot   `json:"world"`         // every player in the room with their land, the tick and the random source
+	Scores   []Score         `json:"scores"`
+	Sessions []Session       `json:"sessions"`
+}
+
+// Score is a player's standing on the room's live leaderboard.
+type Score struct {
+	PlayerID string `json:"playerId"`
+	Kills    int    `json:"kills"`
+}
+
+// Session is the resume token of a human in the room. Player is set for players who had disconnected and could
+// still resume, the players who were connected are in the world.
+type Session struct {
+	Token    string               `json:"token"`
+	ClientID string               `json:"clientId"`
+	Player   *game.PlayerSnapshot `json:"player,omitempty"`
+}
+
+// Path is where the checkpoint of a room is kept in dir.
+func Path(dir, roomID string) string {
+	return filepath.Join(dir, url.PathEscape(roomID)+Ext)
+}
+
+// Write saves a checkpoint in dir, replacing the room's previous one. The file holds resume tokens, so only its
+// owner can read it.
+func Write(dir string, checkpoint Checkpoint) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	checkpoint.Version = Version
+	path := Path(dir, checkpoint.RoomID)
+	file, err := os.OpenFile(path+".part", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(file)
+	err = json.NewEncoder(gz).Encode(checkpoint)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Read loads a checkpoint file.
+func Read(path string) (Checkpoint, error) {
+	var checkpoint Checkpoint
+	file, err := os.Open(path)
+	if err != nil {
+		return checkpoint, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return checkpoint, fmt.Errorf("%s: %w", path, err)
+	}
+	defer gz.Close()
+	if err := json.NewDecoder(gz).Decode(&checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("%s: %w", path, err)
+	}
+	if checkpoint.Version != Version {
+		return checkpoint, fmt.Errorf("%s: %w %d", path, ErrVersion, checkpoint.Version)
+	}
+	return checkpoint, nil
+}
+
+// List returns the checkpoint files in dir.
+func List(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "*"+Ext))
+}
+
+// Remove deletes the checkpoint of a room, if there is one.
+func Remove(dir, roomID string) error {
+	err := os.Remove(Path(dir, roomID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+package checkpoint
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// playing returns a world a few ticks into a match between two players.
+func playing() *game.World {
+	gameConfig := game.DefaultConfig()
+	gameConfig.Seed = 7
+	w := game.NewWorld(gameConfig)
+	w.AddPlayer(&models.Player{ID: "a", Name: "Ann"})
+	w.AddPlayer(&models.Player{ID: "b", Name: "Bo"})
+	w.Step([]game.Input{{PlayerID: "a", Direction: "right"}, {PlayerID: "b", Direction: "up"}})
+	for i := 0; i < 10; i++ {
+		w.Step(nil)
+	}
+	return w
+}
+
+func TestWriteAndRead(t *testing.T) {
+	dir := t.TempDir()
+	w := playing()
+	saved := Checkpoint{
+		RoomID:   "arena/1 of 2",
+		Saved:    time.Unix(1000, 0).UTC(),
+		Seed:     7,
+		Capacity: 8,
+		Gameplay: config.Default().Gameplay,
+		Map:      "islands",
+		World:    w.Snapshot(),
+		Scores:   []Score{{PlayerID: "a", Kills: 2}},
+		Sessions: []Session{
+			{Token: "t1", ClientID: "a"},
+			{Token: "t2", ClientID: "c", Player: &game.PlayerSnapshot{PlayerState: models.PlayerState{ID: "c"}}},
+		},
+	}
+	if err := Write(dir, saved); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != Path(dir, saved.RoomID) {
+		t.Fatalf("expected the one checkpoint at %s, found %v", Path(dir, saved.RoomID), paths)
+	}
+	// The room ID is escaped so it can't reach outside dir
+	if filepath.Dir(paths[0]) != dir {
+		t.Fatalf("the checkpoint of %q was written outside %s: %s", saved.RoomID, dir, paths[0])
+	}
+	if info, err := os.Stat(paths[0]); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a file only its owner can read, got %v %v", info.Mode(), err)
+	}
+
+	loaded, err := Read(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Version != Version || loaded.RoomID != saved.RoomID || !loaded.Saved.Equal(saved.Saved) ||
+		loaded.Seed != saved.Seed || loaded.Capacity != saved.Capacity || loaded.Map != saved.Map ||
+		loaded.Gameplay != saved.Gameplay {
+		t.Fatalf("the room's settings changed on the way through:\nsaved %+v\nread %+v", saved, loaded)
+	}
+	if len(loaded.Scores) != 1 || loaded.Scores[0] != saved.Scores[0] {
+		t.Fatalf("expected the scores %v, got %v", saved.Scores, loaded.Scores)
+	}
+	if len(loaded.Sessions) != 2 || loaded.Sessions[0].Player != nil || loaded.Sessions[1].Player == nil ||
+		loaded.Sessions[1].Player.ID != "c" {
+		t.Fatalf("the sessions changed on the way through: %+v", loaded.Sessions)
+	}
+
+	// The restored world plays on exactly as the original does
+	restored := game.Restore(loaded.World)
+	if restored.Digest() != w.Digest() {
+		t.Fatal("the restored world differs from the saved one")
+	}
+	for i := 0; i < 200; i++ {
+		w.Step(nil)
+		restored.Step(nil)
+	}
+	if restored.Digest() != w.Digest() {
+		t.Fatal("the restored world played on differently from the saved one")
+	}
+}
+
+func TestWriteReplacesThePreviousCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	w := playing()
+	if err := Write(dir, Checkpoint{RoomID: "main", World: w.Snapshot()}); err != nil {
+		t.Fatal(err)
+	}
+	w.Step(nil)
+	if err := Write(dir, Checkpoint{RoomID: "main", World: w.Snapshot()}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the latest checkpoint in %s, found %d files", dir, len(entries))
+	}
+	loaded, err := Read(Path(dir, "main"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.World.Tick != w.Tick {
+		t.Fatalf("expected the checkpoint of tick %d, read tick %d", w.Tick, loaded.World.Tick)
+	}
+}
+
+func TestReadRefusesOtherVersions(t *testing.T) {
+	dir := t.TempDir()
+	path := Path(dir, "main")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	if err := json.NewEncoder(gz).Encode(Checkpoint{Version: Version + 1, RoomID: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	file.Close()
+
+	if _, err := Read(path); !errors.Is(err, ErrVersion) {
+		t.Fatalf("expected ErrVersion, got %v", err)
+	}
+}
+
+func TestReadRefusesDamagedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := Path(dir, "main")
+	if err := os.WriteFile(path, []byte("not gzip"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(path); err == nil {
+		t.Fatal("read a checkpoint from a damaged file")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	dir := t.TempDir()
+	if err := Write(dir, Checkpoint{RoomID: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove(dir, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := List(dir); len(paths) != 0 {
+		t.Fatalf("the checkpoint is still there: %v", paths)
+	}
+	// Removing a room without a checkpoint is not an error
+	if err := Remove(dir, "main"); err != nil {
+		t.Fatalf("removing a missing checkpoint failed: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Package client auth.go gets session tokens from the server's HTTP API.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GuestToken asks the server behind a WebSocket URL for a guest session token.
+func GuestToken(ctx context.Context, httpClient *http.Client, wsURL string) (string, error) {
+	return requestToken(ctx, httpClient, wsURL, "/auth/guest", nil)
+}
+
+// Login exchanges a username and password for a session token.
+func Login(ctx context.Context, httpClient *http.Client, wsURL, username, password string) (string, error) {
+	return requestToken(ctx, httpClient, wsURL, "/auth/login", map[string]string{"username": username, "password": password})
+}
+
+func requestToken(ctx context.Context, httpClient *http.Client, wsURL, path string, body any) (string, error) {
+	endpoint, err := url.Parse(wsURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	// The API lives on the same host as the socket
+	endpoint.Scheme = strings.Replace(endpoint.Scheme, "ws", "http", 1)
+	endpoint.Path = path
+	endpoint.RawQuery = ""
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(string(payload)))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return "", fmt.Errorf("%s: %s: %s", path, response.Status, strings.TrimSpace(string(message)))
+	}
+
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&session); err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return session.Token, nil
+}
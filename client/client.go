@@ -0,0 +1,555 @@
+// Package client is a headless Go client for the game's WebSocket protocol, for bots, tests and CLI tools.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/gorilla/websocket"
+)
+
+// ErrClosed is returned when sending on a client that has been closed or gave up reconnecting.
+var ErrClosed = errors.New("client: closed")
+
+// StatusError is returned when the server answers the WebSocket request with an HTTP error instead of upgrading
+// it, like 429 when too many connections come from one address.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("dial %s: %v (%s)", e.URL, e.Err, e.Status)
+}
+
+func (e *StatusError) Unwrap() error { return e.Err }
+
+// How many times a dial follows the server sending it to the node that plays the room
+const maxRedirects = 3
+
+type Direction string
+
+const (
+	Up    Direction = "up"
+	Down  Direction = "down"
+	Left  Direction = "left"
+	Right Direction = "right"
+)
+
+// Signal is a {"type", "content"} message such as roomFull or slotAvailable.
+type Signal struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// Options configures a Client. Callbacks run on the client's read goroutine and must not block.
+type Options struct {
+	URL       string // WebSocket endpoint, e.g. ws://localhost:8080/ws
+	Room      string // room to join, the server default when empty
+	Spectator bool   // connect with role=spectator
+	Token     string // session token from /auth/login or /auth/register, a guest token is fetched when empty
+	Header    http.Header
+	Dialer    *websocket.Dialer
+	HTTP      *http.Client // used to fetch a guest token, http.DefaultClient when nil
+
+	HandshakeTimeout time.Duration // how long to wait for the welcome, 10s when zero
+	MinBackoff       time.Duration // first reconnect delay, 500ms when zero
+	MaxBackoff       time.Duration // reconnect delay cap, 30s when zero
+	MaxRetries       int           // reconnect attempts before giving up, 0 retries forever
+	NoReconnect      bool
+
+	OnWelcome       func(models.Welcome)
+	OnPlayerUpdate  func(models.PlayerState)
+	OnPlayerRemoved func(playerID string)
+	OnCapture       func(models.PlayerState)
+	OnSnapshot      func(models.WorldSnapshot)
+	OnLeaderboard   func(models.Leaderboard)
+	OnSignal        func(Signal)
+	OnMessage       func(messageType string, raw []byte) // anything the client doesn't decode itself
+	OnDisconnect    func(err error)
+	OnReconnect     func(attempt int)
+}
+
+// Stats counts the traffic of a client across reconnects.
+type Stats struct {
+	MessagesSent     int64
+	MessagesReceived int64
+	BytesSent        int64
+	BytesReceived    int64
+	Reconnects       int64
+}
+
+// Client is a connection to the game server with a local mirror of the world.
+type Client struct {
+	opts Options
+
+	messagesSent     atomic.Int64
+	messagesReceived atomic.Int64
+	bytesSent        atomic.Int64
+	bytesReceived    atomic.Int64
+	reconnects       atomic.Int64
+
+	writeMu     sync.Mutex
+	mu          sync.Mutex
+	conn        *websocket.Conn
+	welcome     models.Welcome
+	world       World
+	leaderboard models.Leaderboard
+	restartWait time.Duration // set by serverShutdown, waited out before reconnecting
+	closed      bool
+	done        chan struct{}
+}
+
+// envelope covers every message the server sends: render instructions use payload, signals use content.
+type envelope struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Content string          `json:"content"`
+}
+
+// Dial connects to the server and waits for the handshake to finish.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	if opts.Dialer == nil {
+		opts.Dialer = websocket.DefaultDialer
+	}
+	if opts.HandshakeTimeout == 0 {
+		opts.HandshakeTimeout = 10 * time.Second
+	}
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	if opts.HTTP == nil {
+		opts.HTTP = http.DefaultClient
+	}
+	if opts.Token == "" {
+		token, err := GuestToken(ctx, opts.HTTP, opts.URL)
+		if err != nil {
+			return nil, err
+		}
+		opts.Token = token
+	}
+
+	c := &Client{
+		opts:  opts,
+		world: World{Players: make(map[string]models.PlayerState)},
+		done:  make(chan struct{}),
+	}
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go c.readLoop(conn)
+	return c, nil
+}
+
+// connect dials, resuming the previous session if there is one, and performs the handshake.
+func (c *Client) connect(ctx context.Context) (*websocket.Conn, error) {
+	endpoint, err := url.Parse(c.opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	query := endpoint.Query()
+	if c.opts.Room != "" {
+		query.Set("room", c.opts.Room)
+	}
+	if c.opts.Spectator {
+		query.Set("role", "spectator")
+	}
+	c.mu.Lock()
+	if c.welcome.ResumeToken != "" {
+		query.Set("resume", c.welcome.ResumeToken)
+	}
+	c.mu.Unlock()
+	endpoint.RawQuery = query.Encode()
+
+	header := c.opts.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Authorization", "Bearer "+c.opts.Token)
+	conn, response, err := c.opts.Dialer.DialContext(ctx, endpoint.String(), header)
+	// Each room is played on one server node, the others send us there
+	for redirects := 0; err != nil && isRedirect(response) && redirects < maxRedirects; redirects++ {
+		location, parseErr := endpoint.Parse(response.Header.Get("Location"))
+		if parseErr != nil {
+			break
+		}
+		endpoint = location
+		home := *location
+		home.RawQuery = ""
+		c.opts.URL = home.String() // reconnects go straight to the room's node
+		conn, response, err = c.opts.Dialer.DialContext(ctx, endpoint.String(), header)
+	}
+	if err != nil {
+		if response != nil {
+			return nil, &StatusError{URL: c.opts.URL, StatusCode: response.StatusCode, Status: response.Status, Err: err}
+		}
+		return nil, fmt.Errorf("dial %s: %w", c.opts.URL, err)
+	}
+
+	// Spectators aren't welcomed, everything they need arrives in snapshots
+	if !c.opts.Spectator {
+		if err := c.handshake(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	return conn, nil
+}
+
+// isRedirect reports whether a failed dial was answered with a redirect to another node.
+func isRedirect(response *http.Response) bool {
+	return response != nil && (response.StatusCode == http.StatusTemporaryRedirect || response.StatusCode == http.StatusPermanentRedirect)
+}
+
+// handshake reads until the welcome arrives, or roomFull when the server made us a spectator instead.
+func (c *Client) handshake(conn *websocket.Conn) error {
+	err := conn.SetReadDeadline(time.Now().Add(c.opts.HandshakeTimeout))
+	if err != nil {
+		return err
+	}
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("handshake: %w", err)
+		}
+		c.messagesReceived.Add(1)
+		c.bytesReceived.Add(int64(len(message)))
+		messageType := c.handle(message)
+		if messageType == "welcome" || messageType == "roomFull" {
+			return conn.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+func (c *Client) readLoop(conn *websocket.Conn) {
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if c.isClosed() {
+				return
+			}
+			if c.opts.OnDisconnect != nil {
+				c.opts.OnDisconnect(err)
+			}
+			c.reconnect()
+			return
+		}
+		c.messagesReceived.Add(1)
+		c.bytesReceived.Add(int64(len(message)))
+		c.handle(message)
+	}
+}
+
+// reconnect retries with exponential backoff until it succeeds, runs out of retries or the client is closed.
+func (c *Client) reconnect() {
+	if c.opts.NoReconnect {
+		c.Close()
+		return
+	}
+
+	// A restarting server says when it will be back, waiting for it doesn't use up retries
+	c.mu.Lock()
+	wait := c.restartWait
+	c.restartWait = 0
+	c.mu.Unlock()
+	select {
+	case <-time.After(wait):
+	case <-c.done:
+		return
+	}
+
+	for attempt := 1; c.opts.MaxRetries == 0 || attempt <= c.opts.MaxRetries; attempt++ {
+		select {
+		case <-time.After(c.backoff(attempt)):
+		case <-c.done:
+			return
+		}
+
+		conn, err := c.connect(context.Background())
+		if err != nil {
+			continue
+		}
+		if c.isClosed() {
+			conn.Close()
+			return
+		}
+		c.reconnects.Add(1)
+		if c.opts.OnReconnect != nil {
+			c.opts.OnReconnect(attempt)
+		}
+		go c.readLoop(conn)
+		return
+	}
+	c.Close()
+}
+
+// backoff doubles the delay each attempt up to MaxBackoff, with up to 20% jitter so clients don't reconnect in lockstep.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.opts.MinBackoff
+	for i := 1; i < attempt && delay < c.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > c.opts.MaxBackoff {
+		delay = c.opts.MaxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// handle decodes a server message into the world mirror, fires its callback and returns its type.
+func (c *Client) handle(message []byte) string {
+	var env envelope
+	if err := json.Unmarshal(message, &env); err != nil {
+		if c.opts.OnMessage != nil {
+			c.opts.OnMessage("", message)
+		}
+		return ""
+	}
+
+	switch env.Type {
+	case "welcome":
+		var welcome models.Welcome
+		if json.Unmarshal(env.Payload, &welcome) != nil {
+			break
+		}
+		c.mu.Lock()
+		c.welcome = welcome
+		c.world.RoomID = welcome.RoomID
+		c.mu.Unlock()
+		if c.opts.OnWelcome != nil {
+			c.opts.OnWelcome(welcome)
+		}
+	case "updatePlayer":
+		var state models.PlayerState
+		if json.Unmarshal(env.Payload, &state) != nil {
+			break
+		}
+		c.mu.Lock()
+		c.world.applyUpdate(state)
+		c.mu.Unlock()
+		if c.opts.OnPlayerUpdate != nil {
+			c.opts.OnPlayerUpdate(state)
+		}
+	case "removePlayer":
+		var state models.PlayerState
+		if json.Unmarshal(env.Payload, &state) != nil {
+			break
+		}
+		c.mu.Lock()
+		delete(c.world.Players, state.ID)
+		c.mu.Unlock()
+		if c.opts.OnPlayerRemoved != nil {
+			c.opts.OnPlayerRemoved(state.ID)
+		}
+	case "captureTerritory":
+		var state models.PlayerState
+		if json.Unmarshal(env.Payload, &state) != nil {
+			break
+		}
+		c.mu.Lock()
+		c.world.applyCapture(state)
+		c.mu.Unlock()
+		if c.opts.OnCapture != nil {
+			c.opts.OnCapture(state)
+		}
+	case "worldSnapshot":
+		var snapshot models.WorldSnapshot
+		if json.Unmarshal(env.Payload, &snapshot) != nil {
+			break
+		}
+		c.mu.Lock()
+		c.world.applySnapshot(snapshot)
+		c.mu.Unlock()
+		if c.opts.OnSnapshot != nil {
+			c.opts.OnSnapshot(snapshot)
+		}
+	case "leaderboard":
+		var leaderboard models.Leaderboard
+		if json.Unmarshal(env.Payload, &leaderboard) != nil {
+			break
+		}
+		c.mu.Lock()
+		c.leaderboard = leaderboard
+		c.mu.Unlock()
+		if c.opts.OnLeaderboard != nil {
+			c.opts.OnLeaderboard(leaderboard)
+		}
+	case "serverShutdown":
+		var notice struct {
+			ReconnectAfter float64 `json:"reconnectAfter"`
+		}
+		if json.Unmarshal([]byte(env.Content), &notice) == nil {
+			c.mu.Lock()
+			c.restartWait = time.Duration(notice.ReconnectAfter * float64(time.Second))
+			c.mu.Unlock()
+		}
+		if c.opts.OnSignal != nil {
+			c.opts.OnSignal(Signal{Type: env.Type, Content: env.Content})
+		}
+	case "roomFull", "slotAvailable":
+		if c.opts.OnSignal != nil {
+			c.opts.OnSignal(Signal{Type: env.Type, Content: env.Content})
+		}
+	default:
+		if c.opts.OnMessage != nil {
+			c.opts.OnMessage(env.Type, message)
+		}
+	}
+	return env.Type
+}
+
+// send writes a {"type", "content"} signal, the format the server reads input in.
+func (c *Client) send(messageType, content string) error {
+	message, err := json.Marshal(Signal{Type: messageType, Content: content})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	conn, closed := c.conn, c.closed
+	c.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	err = conn.WriteMessage(websocket.TextMessage, message)
+	if err != nil {
+		return err
+	}
+	c.messagesSent.Add(1)
+	c.bytesSent.Add(int64(len(message)))
+	return nil
+}
+
+// Move turns the player.
+func (c *Client) Move(direction Direction) error {
+	content, err := json.Marshal(map[string]string{"id": c.PlayerID(), "direction": string(direction)})
+	if err != nil {
+		return err
+	}
+	return c.send("move", string(content))
+}
+
+// Join asks to be promoted from spectator to player.
+func (c *Client) Join() error {
+	return c.send("join", "")
+}
+
+// Follow points a spectator's camera at a player, an empty ID switches to free roam.
+func (c *Client) Follow(playerID string) error {
+	return c.send("follow", playerID)
+}
+
+// SetCamera moves a spectator's free-roaming camera.
+func (c *Client) SetCamera(camera models.Viewport) error {
+	content, err := json.Marshal(camera)
+	if err != nil {
+		return err
+	}
+	return c.send("camera", string(content))
+}
+
+// PlayerID is the ID of our player, empty for spectators.
+func (c *Client) PlayerID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome.PlayerID
+}
+
+// Token returns the session token the client connects with.
+func (c *Client) Token() string {
+	return c.opts.Token
+}
+
+// ResumeToken resumes our player after a disconnect, the client does this on its own when reconnecting.
+func (c *Client) ResumeToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome.ResumeToken
+}
+
+// World returns a copy of the local mirror of the world.
+func (c *Client) World() World {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.world.copy()
+}
+
+// Leaderboard returns the room's live leaderboard as last sent.
+func (c *Client) Leaderboard() models.Leaderboard {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leaderboard
+}
+
+// Self returns our own player as last seen.
+func (c *Client) Self() (models.PlayerState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.world.Players[c.welcome.PlayerID]
+	return state, ok
+}
+
+// Stats returns the traffic counters so far.
+func (c *Client) Stats() Stats {
+	return Stats{
+		MessagesSent:     c.messagesSent.Load(),
+		MessagesReceived: c.messagesReceived.Load(),
+		BytesSent:        c.bytesSent.Load(),
+		BytesReceived:    c.bytesReceived.Load(),
+		Reconnects:       c.reconnects.Load(),
+	}
+}
+
+// Done is closed once the client is closed or has given up reconnecting.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Close leaves the game. The player can still be resumed with ResumeToken until the server's grace period ends.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	conn := c.conn
+	close(c.done)
+	c.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	c.writeMu.Lock()
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	return conn.Close()
+}
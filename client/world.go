@@ -0,0 +1,47 @@
+// Package client world.go contains the local mirror of the world built from server messages.
+package client
+
+import "github.com/4cecoder/multiplayer/models"
+
+// World is what the client knows about its room.
+type World struct {
+	RoomID  string
+	Tick    uint64 // only advanced by snapshots, so it stays zero for players
+	Players map[string]models.PlayerState
+}
+
+// applySnapshot replaces the mirror with a spectator snapshot.
+func (w *World) applySnapshot(snapshot models.WorldSnapshot) {
+	w.RoomID = snapshot.RoomID
+	w.Tick = snapshot.Tick
+	w.Players = make(map[string]models.PlayerState, len(snapshot.Players))
+	for _, player := range snapshot.Players {
+		w.Players[player.ID] = player
+	}
+}
+
+// applyUpdate stores a player update, movement updates leave out the land so the last known land is kept.
+func (w *World) applyUpdate(state models.PlayerState) {
+	if previous, ok := w.Players[state.ID]; ok && state.LandCapture == nil {
+		state.LandCapture, state.StartingLand = previous.LandCapture, previous.StartingLand
+	}
+	w.Players[state.ID] = state
+}
+
+// applyCapture replaces a player's land with the land the server sent.
+func (w *World) applyCapture(capture models.PlayerState) {
+	player, ok := w.Players[capture.ID]
+	if !ok {
+		return
+	}
+	player.LandCapture = capture.LandCapture
+	w.Players[capture.ID] = player
+}
+
+func (w *World) copy() World {
+	players := make(map[string]models.PlayerState, len(w.Players))
+	for id, player := range w.Players {
+		players[id] = player
+	}
+	return World{RoomID: w.RoomID, Tick: w.Tick, Players: players}
+}
@@ -0,0 +1,212 @@
+// Command loadtest connects many headless players to a game server, has them steer around and reports how the server holds up.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/client"
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/gorilla/websocket"
+)
+
+// result is what a single simulated player saw.
+type result struct {
+	connected      bool
+	connectLatency time.Duration
+	echoLatencies  []time.Duration
+	echoesMissed   int
+	spectated      bool // the room was full at some point so the player had to watch
+	limited        bool // the server turned the connection away with 429
+	stats          client.Stats
+	err            error
+}
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/ws", "WebSocket endpoint of the server")
+	clients := flag.Int("clients", 100, "number of simulated players, all connecting from this address, so the server's\n"+
+		"MAX_CONNECTIONS_PER_IP (default 8) must allow them or be 0 for no limit")
+	duration := flag.Duration("duration", 30*time.Second, "how long each player plays")
+	rate := flag.Float64("rate", 3, "direction changes per second per player")
+	ramp := flag.Duration("ramp", 5*time.Second, "time over which players connect")
+	room := flag.String("room", "", "room to join, the server default when empty")
+	roomCount := flag.Int("rooms", 0, "spread players over this many rooms named loadtest-<n> instead of using -room")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate checks, for wss:// servers with a self-signed certificate")
+	flag.Parse()
+
+	if *clients <= 0 || *rate <= 0 {
+		log.Fatal("-clients and -rate must be positive")
+	}
+
+	var tlsConfig *tls.Config
+	if *insecure {
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	log.Printf("Starting %d players against %s for %s", *clients, *url, *duration)
+	results := make([]result, *clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Spread connections over the ramp so the server isn't hit all at once
+			time.Sleep(*ramp * time.Duration(i) / time.Duration(*clients))
+			playerRoom := *room
+			if *roomCount > 0 {
+				playerRoom = fmt.Sprintf("loadtest-%d", i%*roomCount)
+			}
+			results[i] = play(*url, playerRoom, *duration, *rate, tlsConfig, rand.New(rand.NewSource(int64(i))))
+		}(i)
+	}
+	wg.Wait()
+
+	r := newReport(*url, *clients, time.Since(start), results)
+	var err error
+	if *jsonOutput {
+		err = r.writeJSON(os.Stdout)
+	} else {
+		err = r.writeText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// play runs one player: connect, change direction at roughly the given rate and time how long each change takes to come back.
+func play(url, room string, duration time.Duration, rate float64, tlsConfig *tls.Config, rng *rand.Rand) result {
+	var res result
+	var mu sync.Mutex
+	var playerID string
+	var spectating bool
+	var pending client.Direction
+	var pendingAt time.Time
+
+	connectStart := time.Now()
+	var c *client.Client
+	dialed, err := client.Dial(context.Background(), client.Options{
+		URL:        url,
+		Room:       room,
+		MaxRetries: 3,
+		Dialer:     &websocket.Dialer{TLSClientConfig: tlsConfig, HandshakeTimeout: 45 * time.Second},
+		HTTP:       &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		OnWelcome: func(welcome models.Welcome) {
+			mu.Lock()
+			playerID = welcome.PlayerID
+			spectating = false
+			mu.Unlock()
+		},
+		OnSignal: func(signal client.Signal) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch signal.Type {
+			case "roomFull":
+				spectating = true
+				res.spectated = true
+			case "slotAvailable":
+				// Grab the slot like the browser does
+				if c != nil {
+					c.Join()
+				}
+			}
+		},
+		OnPlayerUpdate: func(state models.PlayerState) {
+			mu.Lock()
+			defer mu.Unlock()
+			if state.ID == playerID && pending != "" && directionOf(state) == pending {
+				res.echoLatencies = append(res.echoLatencies, time.Since(pendingAt))
+				pending = ""
+			}
+		},
+	})
+	if err != nil {
+		var status *client.StatusError
+		res.limited = errors.As(err, &status) && status.StatusCode == http.StatusTooManyRequests
+		res.err = err
+		return res
+	}
+	mu.Lock()
+	c = dialed
+	mu.Unlock()
+	res.connected = true
+	res.connectLatency = time.Since(connectStart)
+
+	deadline := time.After(duration)
+	current := client.Right
+	for {
+		// Humans don't steer on a metronome, so vary the gap by up to half either way
+		interval := time.Duration(float64(time.Second) / rate * (0.5 + rng.Float64()))
+		select {
+		case <-deadline:
+			c.Close()
+			mu.Lock()
+			if pending != "" {
+				res.echoesMissed++
+			}
+			mu.Unlock()
+			res.stats = c.Stats()
+			return res
+		case <-c.Done():
+			res.err = client.ErrClosed
+			res.stats = c.Stats()
+			return res
+		case <-time.After(interval):
+		}
+
+		// Always turn, the server only echoes changes we can tell apart
+		current = turn(current, rng)
+		mu.Lock()
+		if spectating {
+			mu.Unlock()
+			continue
+		}
+		if pending != "" {
+			res.echoesMissed++
+		}
+		pending, pendingAt = current, time.Now()
+		mu.Unlock()
+		if err := c.Move(current); err != nil {
+			res.err = err
+		}
+	}
+}
+
+// turn picks one of the two directions perpendicular to the current one.
+func turn(current client.Direction, rng *rand.Rand) client.Direction {
+	if current == client.Up || current == client.Down {
+		if rng.Intn(2) == 0 {
+			return client.Left
+		}
+		return client.Right
+	}
+	if rng.Intn(2) == 0 {
+		return client.Up
+	}
+	return client.Down
+}
+
+func directionOf(state models.PlayerState) client.Direction {
+	switch {
+	case state.VelocityY < 0:
+		return client.Up
+	case state.VelocityY > 0:
+		return client.Down
+	case state.VelocityX < 0:
+		return client.Left
+	case state.VelocityX > 0:
+		return client.Right
+	default:
+		return ""
+	}
+}
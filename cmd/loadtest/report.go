@@ -0,0 +1,160 @@
+// Command loadtest report.go summarises the results of all players.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// percentiles of a latency distribution, in milliseconds
+type percentiles struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+type report struct {
+	Target          string         `json:"target"`
+	Clients         int            `json:"clients"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	Connected       int            `json:"connected"`
+	Failed          int            `json:"failed"`
+	RateLimited     int            `json:"rateLimited"` // failed with 429, most likely the server's connections per address limit
+	Spectated       int            `json:"spectated"`
+	Reconnects      int64          `json:"reconnects"`
+	ConnectLatency  percentiles    `json:"connectLatencyMs"`
+	EchoLatency     percentiles    `json:"echoLatencyMs"`
+	EchoesMissed    int            `json:"echoesMissed"`
+	MessagesSent    int64          `json:"messagesSent"`
+	MessagesRecv    int64          `json:"messagesReceived"`
+	BytesSent       int64          `json:"bytesSent"`
+	BytesRecv       int64          `json:"bytesReceived"`
+	SentPerSecond   float64        `json:"messagesSentPerSecond"`
+	RecvPerSecond   float64        `json:"messagesReceivedPerSecond"`
+	BytesSentPerSec float64        `json:"bytesSentPerSecond"`
+	BytesRecvPerSec float64        `json:"bytesReceivedPerSecond"`
+	Errors          map[string]int `json:"errors,omitempty"`
+}
+
+func newReport(target string, clients int, elapsed time.Duration, results []result) report {
+	r := report{
+		Target:          target,
+		Clients:         clients,
+		DurationSeconds: elapsed.Seconds(),
+		Errors:          make(map[string]int),
+	}
+
+	var connectLatencies, echoLatencies []time.Duration
+	for _, res := range results {
+		if res.connected {
+			r.Connected++
+			connectLatencies = append(connectLatencies, res.connectLatency)
+		} else {
+			r.Failed++
+		}
+		if res.limited {
+			r.RateLimited++
+		}
+		if res.spectated {
+			r.Spectated++
+		}
+		if res.err != nil {
+			r.Errors[res.err.Error()]++
+		}
+		echoLatencies = append(echoLatencies, res.echoLatencies...)
+		r.EchoesMissed += res.echoesMissed
+		r.Reconnects += res.stats.Reconnects
+		r.MessagesSent += res.stats.MessagesSent
+		r.MessagesRecv += res.stats.MessagesReceived
+		r.BytesSent += res.stats.BytesSent
+		r.BytesRecv += res.stats.BytesReceived
+	}
+
+	r.ConnectLatency = percentilesOf(connectLatencies)
+	r.EchoLatency = percentilesOf(echoLatencies)
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		r.SentPerSecond = float64(r.MessagesSent) / seconds
+		r.RecvPerSecond = float64(r.MessagesRecv) / seconds
+		r.BytesSentPerSec = float64(r.BytesSent) / seconds
+		r.BytesRecvPerSec = float64(r.BytesRecv) / seconds
+	}
+	return r
+}
+
+func percentilesOf(latencies []time.Duration) percentiles {
+	if len(latencies) == 0 {
+		return percentiles{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(q float64) float64 {
+		index := int(q * float64(len(latencies)-1))
+		return float64(latencies[index]) / float64(time.Millisecond)
+	}
+	return percentiles{
+		Count: len(latencies),
+		Min:   at(0),
+		P50:   at(0.5),
+		P90:   at(0.9),
+		P95:   at(0.95),
+		P99:   at(0.99),
+		Max:   at(1),
+	}
+}
+
+func (r report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r report) writeText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `Target:            %s
+Duration:          %.1fs
+Players:           %d connected, %d failed (%d with 429), %d spectated a full room, %d reconnects
+Connect latency:   %s
+Input-to-echo:     %s, %d missed
+Messages sent:     %d (%.1f/s)
+Messages received: %d (%.1f/s)
+Bytes sent:        %d (%.1f KB/s)
+Bytes received:    %d (%.1f KB/s)
+`,
+		r.Target, r.DurationSeconds,
+		r.Connected, r.Failed, r.RateLimited, r.Spectated, r.Reconnects,
+		r.ConnectLatency, r.EchoLatency, r.EchoesMissed,
+		r.MessagesSent, r.SentPerSecond,
+		r.MessagesRecv, r.RecvPerSecond,
+		r.BytesSent, r.BytesSentPerSec/1024,
+		r.BytesRecv, r.BytesRecvPerSec/1024)
+	if err != nil {
+		return err
+	}
+
+	if r.RateLimited > 0 {
+		_, err := fmt.Fprintf(w, "%d players were turned away with 429, start the server with MAX_CONNECTIONS_PER_IP=0\n"+
+			"or at least %d, every player connects from this address\n", r.RateLimited, r.Clients)
+		if err != nil {
+			return err
+		}
+	}
+	for message, count := range r.Errors {
+		if _, err := fmt.Fprintf(w, "Error (%dx):        %s\n", count, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p percentiles) String() string {
+	if p.Count == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("p50 %.1fms  p90 %.1fms  p95 %.1fms  p99 %.1fms  max %.1fms  (n=%d)",
+		p.P50, p.P90, p.P95, p.P99, p.Max, p.Count)
+}
@@ -0,0 +1,175 @@
+// Command moderate lists, adds and lifts bans and reads the audit log through a running server's admin API.
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+const usage = `Usage: moderate [flags] <command> [arguments]
+
+Commands:
+  bans                                          list the bans in force
+  ban [-for 24h] [-reason text] <kind> <target> ban a client ID, an account or an address or CIDR range
+  unban <ban ID>                                lift a ban
+  audit [-n 50]                                 show the latest entries of the audit log
+
+Flags:
+`
+
+// api calls the admin API of one server.
+type api struct {
+	server string
+	token  string
+	name   string
+	client *http.Client
+}
+
+func main() {
+	log.SetFlags(0)
+	server := flag.String("server", "http://localhost:8080", "base URL of the game server")
+	token := flag.String("token", os.Getenv("ADMIN_TOKEN"), "admin token, ADMIN_TOKEN by default")
+	name := flag.String("as", os.Getenv("USER"), "name the audit log records you by")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate checks, for servers with a self-signed certificate")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || *token == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	if *insecure {
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	a := api{server: strings.TrimSuffix(*server, "/"), token: *token, name: *name, client: httpClient}
+
+	var err error
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "bans":
+		err = a.listBans()
+	case "ban":
+		err = a.ban(args)
+	case "unban":
+		if len(args) != 1 {
+			log.Fatal("unban takes the ID of the ban, see the bans command")
+		}
+		err = a.do(http.MethodDelete, "/admin/bans/"+url.PathEscape(args[0]), nil, nil)
+	case "audit":
+		err = a.audit(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// do sends a request to the admin API and decodes the JSON response into out, if out isn't nil.
+func (a api) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	request, err := http.NewRequest(method, a.server+path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+a.token)
+	request.Header.Set("Content-Type", "application/json")
+	if a.name != "" {
+		request.Header.Set("X-Admin-Name", a.name)
+	}
+	response, err := a.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("%s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+func (a api) listBans() error {
+	var bans []models.Ban
+	if err := a.do(http.MethodGet, "/admin/bans", nil, &bans); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tTARGET\tBY\tCREATED\tEXPIRES\tREASON")
+	for _, ban := range bans {
+		expires := "never"
+		if ban.Expires != nil {
+			expires = ban.Expires.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", ban.ID, ban.Kind, ban.Target, ban.By,
+			ban.Created.Local().Format(time.DateTime), expires, ban.Reason)
+	}
+	return w.Flush()
+}
+
+func (a api) ban(args []string) error {
+	flags := flag.NewFlagSet("ban", flag.ExitOnError)
+	duration := flags.Duration("for", 0, "how long the ban lasts, for good when 0")
+	reason := flags.String("reason", "", "why, the player is told")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return fmt.Errorf("ban takes a kind of client, account or ip and a target, such as: ban ip 203.0.113.0/24")
+	}
+	body := map[string]string{"kind": flags.Arg(0), "target": flags.Arg(1), "reason": *reason}
+	if *duration > 0 {
+		body["duration"] = duration.String()
+	}
+	var ban models.Ban
+	if err := a.do(http.MethodPost, "/admin/bans", body, &ban); err != nil {
+		return err
+	}
+	fmt.Printf("Banned %s %s as %s\n", ban.Kind, ban.Target, ban.ID)
+	return nil
+}
+
+func (a api) audit(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	limit := flags.Int("n", 50, "how many entries to show")
+	flags.Parse(args)
+	var entries []models.AuditEntry
+	if err := a.do(http.MethodGet, fmt.Sprintf("/admin/audit?limit=%d", *limit), nil, &entries); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "AT\tBY\tACTION\tTARGET\tREASON\tDETAIL")
+	// Oldest first, so the latest ends up next to the prompt
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.At.Local().Format(time.DateTime), entry.By, entry.Action,
+			entry.Target, entry.Reason, entry.Detail)
+	}
+	return w.Flush()
+}
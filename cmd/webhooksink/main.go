@@ -0,0 +1,73 @@
+// Command webhooksink is a local stand-in for a webhook receiver. It prints every delivery it gets, checks its
+// signature against the webhook's secret and can fail the first attempts at each delivery to try the retries.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/webhook"
+)
+
+func main() {
+	log.SetFlags(log.Ltime)
+	addr := flag.String("addr", "localhost:9090", "address to listen on")
+	secret := flag.String("secret", "", "the webhook's secret, signatures are not checked without it")
+	fail := flag.Int("fail", 0, "answer 503 to this many attempts at each delivery before taking it")
+	tolerance := flag.Duration("tolerance", 5*time.Minute, "how far a delivery's timestamp may be from now")
+	flag.Parse()
+
+	var mu sync.Mutex
+	attempts := make(map[string]int) // delivery ID to the attempts seen
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST deliveries here", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		delivery := r.Header.Get(webhook.HeaderDelivery)
+		mu.Lock()
+		attempts[delivery]++
+		attempt := attempts[delivery]
+		mu.Unlock()
+
+		signature := "not checked"
+		if *secret != "" {
+			signature = "valid"
+			if err := webhook.Verify(*secret, r.Header, body, *tolerance, time.Now()); err != nil {
+				signature = err.Error()
+			}
+		}
+		log.Printf("%s delivery %s attempt %d, timestamp %s, signature %s", r.Header.Get(webhook.HeaderEvent), delivery,
+			attempt, r.Header.Get(webhook.HeaderTimestamp), signature)
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, body, "  ", "  ") == nil {
+			fmt.Printf("  %s\n", pretty.Bytes())
+		}
+
+		if attempt <= *fail {
+			http.Error(w, "failing on purpose", http.StatusServiceUnavailable)
+			return
+		}
+		if signature != "valid" && signature != "not checked" {
+			http.Error(w, signature, http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Printf("Listening for webhooks on http://%s/", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
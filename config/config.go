@@ -0,0 +1,505 @@
+// Package config holds the server's network, gameplay, queue, room, limit, webhook and cluster settings, read from
+// defaults, a JSON file, environment variables and command-line flags, each overriding the one before.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/4cecoder/multiplayer/bots"
+)
+
+// DefaultFile is read when CONFIG_FILE and -config are unset, it may be missing.
+const DefaultFile = "config.json"
+
+type Config struct {
+	Network  Network  `json:"network"`
+	Gameplay Gameplay `json:"gameplay"`
+	Queue    Queue    `json:"queue"`
+	Rooms    Rooms    `json:"rooms"`
+	Limits   Limits   `json:"limits"`
+	Webhooks Webhooks `json:"webhooks"`
+	Cluster  Cluster  `json:"cluster"`
+}
+
+// Network settings take effect on restart.
+type Network struct {
+	Port            string        `json:"port" env:"PORT"`
+	Host            string        `json:"host" env:"HOST"` // WebSocket host clients reconnect to, with the scheme
+	ReadBufferSize  int           `json:"readBufferSize" env:"READ_BUFFER_SIZE"`
+	WriteBufferSize int           `json:"writeBufferSize" env:"WRITE_BUFFER_SIZE"`
+	ShutdownDrain   time.Duration `json:"shutdownDrain" env:"SHUTDOWN_DRAIN"` // /readyz fails this long before the server stops listening
+	ShutdownTimeout time.Duration `json:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+	ReconnectAfter  time.Duration `json:"reconnectAfter" env:"RECONNECT_AFTER"` // players wait this long after a restart
+
+	// Browsers may open the WebSocket from the server's own pages and these origins, "*" allows any
+	AllowedOrigins []string `json:"allowedOrigins" env:"ALLOWED_ORIGINS"`
+	// HTTPS and wss:// are served when both files are set, DevTLS generates a self-signed pair if they are missing
+	TLSCert string `json:"tlsCert" env:"TLS_CERT"`
+	TLSKey  string `json:"tlsKey" env:"TLS_KEY"`
+	DevTLS  bool   `json:"devTLS" env:"DEV_TLS"`
+}
+
+// Gameplay settings can be reloaded while the server runs, a room picks them up when its next round starts.
+type Gameplay struct {
+	FieldWidth   float64       `json:"fieldWidth" env:"FIELD_WIDTH"`
+	FieldHeight  float64       `json:"fieldHeight" env:"FIELD_HEIGHT"`
+	CellSize     float64       `json:"cellSize" env:"CELL_SIZE"`
+	MaxVelocity  float64       `json:"maxVelocity" env:"MAX_VELOCITY"` // pixels per tick
+	RespawnDelay time.Duration `json:"respawnDelay" env:"RESPAWN_DELAY"`
+}
+
+// Queue settings size the per-connection buffers and decide how long a dropped player is kept.
+type Queue struct {
+	SendBuffer           int           `json:"sendBuffer" env:"SEND_BUFFER"`   // messages, overflow goes to the MessageQueue
+	EventBuffer          int           `json:"eventBuffer" env:"EVENT_BUFFER"` // events waiting for each event bus subscriber
+	SignalBuffer         int           `json:"signalBuffer" env:"SIGNAL_BUFFER"`
+	SpectatorBuffer      int           `json:"spectatorBuffer" env:"SPECTATOR_BUFFER"`
+	ReconnectInterval    time.Duration `json:"reconnectInterval" env:"RECONNECT_INTERVAL"`
+	MaxReconnectAttempts int           `json:"maxReconnectAttempts" env:"MAX_RECONNECT_ATTEMPTS"`
+	ResumeGracePeriod    time.Duration `json:"resumeGracePeriod" env:"RESUME_GRACE_PERIOD"`
+}
+
+// Rooms settings apply to rooms created after they are loaded, the room cap and idle timeout straight away, the
+// checkpoint interval to the next checkpoint and map rotations when a room moves on to its next map.
+type Rooms struct {
+	Capacity      int      `json:"capacity" env:"ROOM_CAPACITY"` // players including bots
+	BotsPerRoom   int      `json:"botsPerRoom" env:"BOTS_PER_ROOM"`
+	BotDifficulty string   `json:"botDifficulty" env:"BOT_DIFFICULTY"`
+	BotStrategies []string `json:"botStrategies" env:"BOT_STRATEGIES"`
+	Seed          int64    `json:"seed" env:"SEED"` // 0 picks a random seed per room
+
+	// Any room ID a player asks for is a room, so their number is capped and empty ones are closed
+	MaxRooms    int           `json:"maxRooms" env:"MAX_ROOMS"`
+	IdleTimeout time.Duration `json:"idleTimeout" env:"ROOM_IDLE_TIMEOUT"` // how long a room is kept with nobody in it
+
+	CheckpointInterval time.Duration `json:"checkpointInterval" env:"CHECKPOINT_INTERVAL"` // 0 turns checkpoints off
+
+	// Rooms play the maps of their rotation in turn, the gameplay field when it is empty
+	Maps        []string            `json:"maps" env:"MAPS"`                // the rotation of rooms without their own
+	RoomMaps    map[string][]string `json:"roomMaps" env:"ROOM_MAPS"`       // rotations of particular rooms, room=map|map,...
+	MapDuration time.Duration       `json:"mapDuration" env:"MAP_DURATION"` // how long each map is played, 0 until the room is reset
+}
+
+// Limits protect the server from clients that connect or send too much, they take effect on restart. Messages over
+// a rate are dropped, a client that keeps going is warned, then disconnected, and an address disconnected BanAfter
+// times within BanDuration is turned away for BanDuration.
+type Limits struct {
+	MaxConnectionsPerIP int           `json:"maxConnectionsPerIP" env:"MAX_CONNECTIONS_PER_IP"` // 0 is unlimited
+	MaxMessageSize      int64         `json:"maxMessageSize" env:"MAX_MESSAGE_SIZE"`            // bytes, larger messages close the connection
+	MoveRate            float64       `json:"moveRate" env:"MOVE_RATE"`                         // per second
+	MoveBurst           int           `json:"moveBurst" env:"MOVE_BURST"`
+	ChatRate            float64       `json:"chatRate" env:"CHAT_RATE"`
+	ChatBurst           int           `json:"chatBurst" env:"CHAT_BURST"`
+	SignalRate          float64       `json:"signalRate" env:"SIGNAL_RATE"` // every other message type
+	SignalBurst         int           `json:"signalBurst" env:"SIGNAL_BURST"`
+	ViolationWindow     time.Duration `json:"violationWindow" env:"VIOLATION_WINDOW"` // dropped messages are counted over this long
+	WarnAfter           int           `json:"warnAfter" env:"WARN_AFTER"`             // dropped messages before a warning
+	DisconnectAfter     int           `json:"disconnectAfter" env:"DISCONNECT_AFTER"` // dropped messages before disconnecting
+	BanAfter            int           `json:"banAfter" env:"BAN_AFTER"`               // 0 never bans
+	BanDuration         time.Duration `json:"banDuration" env:"BAN_DURATION"`
+	AuthRate            float64       `json:"authRate" env:"AUTH_RATE"` // logins and registrations per second from one address
+	AuthBurst           int           `json:"authBurst" env:"AUTH_BURST"`
+}
+
+// Webhooks settings decide how hard events are pushed to webhook subscriptions, they take effect on restart.
+type Webhooks struct {
+	MaxAttempts    int           `json:"maxAttempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+	InitialBackoff time.Duration `json:"initialBackoff" env:"WEBHOOK_INITIAL_BACKOFF"` // doubled after each failed attempt
+	MaxBackoff     time.Duration `json:"maxBackoff" env:"WEBHOOK_MAX_BACKOFF"`
+	Timeout        time.Duration `json:"timeout" env:"WEBHOOK_TIMEOUT"`          // per attempt
+	DeliveryLog    int           `json:"deliveryLog" env:"WEBHOOK_DELIVERY_LOG"` // deliveries kept in the log
+}
+
+// Cluster settings let several servers share the rooms: each room is played on one node and players asking another
+// node for it are sent there. They take effect on restart.
+type Cluster struct {
+	Directory string        `json:"directory" env:"ROOM_DIRECTORY"` // "memory" for a single node, or "file:<path>" shared by the nodes on one host
+	NodeID    string        `json:"nodeId" env:"NODE_ID"`           // the hostname and port when empty
+	PublicURL string        `json:"publicURL" env:"PUBLIC_URL"`     // where players reach this node, http://localhost:<port> when empty
+	NodeTTL   time.Duration `json:"nodeTTL" env:"NODE_TTL"`         // a node not heard from for this long loses its rooms
+}
+
+func Default() Config {
+	return Config{
+		Network: Network{
+			Port:            "8080",
+			Host:            "ws://localhost",
+			ReadBufferSize:  1022,
+			WriteBufferSize: 1022,
+			ShutdownDrain:   5 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+			ReconnectAfter:  5 * time.Second,
+		},
+		Gameplay: Gameplay{
+			FieldWidth:   800,
+			FieldHeight:  600,
+			CellSize:     20,
+			MaxVelocity:  5,
+			RespawnDelay: 3 * time.Second,
+		},
+		Queue: Queue{
+			SendBuffer:           256,
+			EventBuffer:          1024,
+			SignalBuffer:         16,
+			SpectatorBuffer:      16,
+			ReconnectInterval:    5 * time.Second,
+			MaxReconnectAttempts: 5,
+			ResumeGracePeriod:    2 * time.Minute,
+		},
+		Rooms: Rooms{
+			Capacity:      8,
+			BotDifficulty: bots.Normal.Name,
+			BotStrategies: bots.StrategyNames(),
+			MaxRooms:      100,
+			IdleTimeout:   time.Minute,
+
+			CheckpointInterval: 10 * time.Second,
+			MapDuration:        10 * time.Minute,
+		},
+		Limits: Limits{
+			MaxConnectionsPerIP: 8,
+			MaxMessageSize:      4096,
+			MoveRate:            20,
+			MoveBurst:           20,
+			ChatRate:            1,
+			ChatBurst:           5,
+			SignalRate:          10,
+			SignalBurst:         20,
+			ViolationWindow:     10 * time.Second,
+			WarnAfter:           20,
+			DisconnectAfter:     100,
+			BanAfter:            3,
+			BanDuration:         5 * time.Minute,
+			AuthRate:            0.2,
+			AuthBurst:           10,
+		},
+		Webhooks: Webhooks{
+			MaxAttempts:    6,
+			InitialBackoff: time.Second,
+			MaxBackoff:     time.Minute,
+			Timeout:        10 * time.Second,
+			DeliveryLog:    1000,
+		},
+		Cluster: Cluster{
+			Directory: "memory",
+			NodeTTL:   15 * time.Second,
+		},
+	}
+}
+
+// Load reads the configuration file named by -config or CONFIG_FILE, falling back to DefaultFile, then applies
+// the environment and the flags in args. It returns the file it read, empty if there was none.
+func Load(args []string) (Config, string, error) {
+	config := Default()
+
+	flags := flag.NewFlagSet("multiplayer", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "configuration file, "+DefaultFile+" when it exists")
+	var overrides []func() error
+	for _, field := range settingFields(&config) {
+		flags.Func(field.flag, field.help(), func(value string) error {
+			// Flags win over the file and environment, so they are applied last
+			overrides = append(overrides, func() error { return field.set(value) })
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, "", err
+	}
+
+	file := *path
+	if file == "" {
+		if _, err := os.Stat(DefaultFile); err == nil {
+			file = DefaultFile
+		}
+	}
+	if file != "" {
+		if err := config.readFile(file); err != nil {
+			return Config{}, "", err
+		}
+	}
+	for _, field := range settingFields(&config) {
+		if value, ok := os.LookupEnv(field.env); ok && value != "" {
+			if err := field.set(value); err != nil {
+				return Config{}, "", err
+			}
+		}
+	}
+	for _, override := range overrides {
+		if err := override(); err != nil {
+			return Config{}, "", err
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, "", err
+	}
+	return config, file, nil
+}
+
+// readFile merges a JSON file over the config. Sections and settings left out keep their values, unknown ones
+// are an error so typos don't go unnoticed.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var sections map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	fields := make(map[string]setting)
+	for _, field := range settingFields(c) {
+		fields[field.section+"."+field.key] = field
+	}
+	for section, values := range sections {
+		for key, raw := range values {
+			field, ok := fields[section+"."+key]
+			if !ok {
+				return fmt.Errorf("%s: unknown setting %s.%s", path, section, key)
+			}
+			if err := field.setJSON(raw); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks that the settings make sense together.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	n := c.Network
+	port, err := strconv.Atoi(n.Port)
+	check(err == nil && port > 0 && port < 65536, "network.port %q is not a port number", n.Port)
+	check(strings.HasPrefix(n.Host, "ws://") || strings.HasPrefix(n.Host, "wss://"), "network.host %q must start with ws:// or wss://", n.Host)
+	check(n.ReadBufferSize > 0 && n.WriteBufferSize > 0, "network buffer sizes must be positive")
+	check(n.ShutdownDrain >= 0, "network.shutdownDrain can't be negative")
+	check(n.ShutdownTimeout > 0, "network.shutdownTimeout must be positive")
+	check(n.ReconnectAfter >= 0, "network.reconnectAfter can't be negative")
+	for _, origin := range n.AllowedOrigins {
+		u, err := url.Parse(origin)
+		check(origin == "*" || err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && strings.Trim(u.Path, "/") == "",
+			"network.allowedOrigins: %q is not an origin like https://example.com or *", origin)
+	}
+	check((n.TLSCert == "") == (n.TLSKey == ""), "network.tlsCert and tlsKey must be set together")
+
+	g := c.Gameplay
+	check(g.CellSize > 0, "gameplay.cellSize must be positive")
+	check(g.FieldWidth >= 3*g.CellSize && g.FieldHeight >= 3*g.CellSize, "the field must be at least three cells each way")
+	if g.CellSize > 0 {
+		check(isMultiple(g.FieldWidth, g.CellSize) && isMultiple(g.FieldHeight, g.CellSize),
+			"gameplay.fieldWidth and fieldHeight must be multiples of cellSize")
+	}
+	check(g.MaxVelocity > 0 && g.MaxVelocity <= g.CellSize, "gameplay.maxVelocity must be positive and at most cellSize")
+	check(g.RespawnDelay >= 0, "gameplay.respawnDelay can't be negative")
+
+	q := c.Queue
+	check(q.SendBuffer > 0 && q.EventBuffer > 0 && q.SignalBuffer > 0 && q.SpectatorBuffer > 0, "queue buffers must be positive")
+	check(q.ReconnectInterval >= 0, "queue.reconnectInterval can't be negative")
+	check(q.MaxReconnectAttempts >= 0, "queue.maxReconnectAttempts can't be negative")
+	check(q.ResumeGracePeriod > 0, "queue.resumeGracePeriod must be positive")
+
+	r := c.Rooms
+	check(r.Capacity > 0, "rooms.capacity must be positive")
+	check(r.BotsPerRoom >= 0, "rooms.botsPerRoom can't be negative")
+	check(r.MaxRooms > 0, "rooms.maxRooms must be positive")
+	check(r.IdleTimeout >= time.Second, "rooms.idleTimeout must be at least a second")
+	check(r.CheckpointInterval >= 0, "rooms.checkpointInterval can't be negative")
+	check(r.MapDuration >= 0, "rooms.mapDuration can't be negative")
+	for room, rotation := range r.RoomMaps {
+		check(room != "" && len(rotation) > 0, "rooms.roomMaps: %q needs a room and at least one map", room)
+	}
+	if _, err := bots.DifficultyByName(r.BotDifficulty); err != nil {
+		errs = append(errs, fmt.Errorf("rooms.botDifficulty: %w", err))
+	}
+	check(r.BotsPerRoom == 0 || len(r.BotStrategies) > 0, "rooms.botStrategies can't be empty when there are bots")
+	for _, name := range r.BotStrategies {
+		if _, err := bots.NewStrategy(strings.TrimSpace(name)); err != nil {
+			errs = append(errs, fmt.Errorf("rooms.botStrategies: %w", err))
+		}
+	}
+
+	l := c.Limits
+	check(l.MaxConnectionsPerIP >= 0, "limits.maxConnectionsPerIP can't be negative")
+	check(l.MaxMessageSize > 0, "limits.maxMessageSize must be positive")
+	check(l.MoveRate > 0 && l.ChatRate > 0 && l.SignalRate > 0, "limits rates must be positive")
+	check(l.MoveBurst > 0 && l.ChatBurst > 0 && l.SignalBurst > 0, "limits bursts must be positive")
+	check(l.ViolationWindow > 0, "limits.violationWindow must be positive")
+	check(l.WarnAfter > 0 && l.DisconnectAfter > l.WarnAfter, "limits.warnAfter must be positive and below disconnectAfter")
+	check(l.BanAfter >= 0, "limits.banAfter can't be negative")
+	check(l.BanAfter == 0 || l.BanDuration > 0, "limits.banDuration must be positive when banAfter is set")
+	check(l.AuthRate > 0 && l.AuthBurst > 0, "limits.authRate and authBurst must be positive")
+
+	w := c.Webhooks
+	check(w.MaxAttempts > 0, "webhooks.maxAttempts must be positive")
+	check(w.InitialBackoff > 0 && w.MaxBackoff >= w.InitialBackoff, "webhooks.initialBackoff must be positive and at most maxBackoff")
+	check(w.Timeout > 0, "webhooks.timeout must be positive")
+	check(w.DeliveryLog > 0, "webhooks.deliveryLog must be positive")
+
+	cl := c.Cluster
+	check(cl.Directory == "memory" || strings.HasPrefix(cl.Directory, "file:") && len(cl.Directory) > len("file:"),
+		"cluster.directory %q must be memory or file:<path>", cl.Directory)
+	if cl.PublicURL != "" {
+		u, err := url.Parse(cl.PublicURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && strings.Trim(u.Path, "/") == "",
+			"cluster.publicURL %q is not a base URL like https://eu1.example.com", cl.PublicURL)
+	}
+	check(cl.NodeTTL > 0, "cluster.nodeTTL must be positive")
+	return errors.Join(errs...)
+}
+
+func isMultiple(value, of float64) bool {
+	n := value / of
+	return n == float64(int64(n))
+}
+
+// setting is one field of a config section, reachable by its JSON key, environment variable and flag.
+type setting struct {
+	section string
+	key     string
+	env     string
+	flag    string
+	value   reflect.Value
+}
+
+// settingFields lists every setting of the config, pointing into it.
+func settingFields(c *Config) []setting {
+	var fields []setting
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		sectionKey := sections.Type().Field(i).Tag.Get("json")
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			env := field.Tag.Get("env")
+			fields = append(fields, setting{
+				section: sectionKey,
+				key:     field.Tag.Get("json"),
+				env:     env,
+				flag:    strings.ReplaceAll(strings.ToLower(env), "_", "-"),
+				value:   section.Field(j),
+			})
+		}
+	}
+	return fields
+}
+
+func (s setting) help() string {
+	return fmt.Sprintf("%s.%s, also %s (default %s)", s.section, s.key, s.env, s.format())
+}
+
+// format renders the setting's value the way set parses it.
+func (s setting) format() string {
+	switch v := s.value.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case map[string][]string:
+		rooms := make([]string, 0, len(v))
+		for room, items := range v {
+			rooms = append(rooms, room+"="+strings.Join(items, "|"))
+		}
+		sort.Strings(rooms)
+		return strings.Join(rooms, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// set parses a value given as text, from the environment or a flag. Durations take Go syntax like "90s" or a
+// plain number of seconds, lists are comma separated, and lists by key are key=item|item pairs, comma separated.
+func (s setting) set(text string) error {
+	var err error
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(text)
+	case time.Duration:
+		var d time.Duration
+		d, err = parseDuration(text)
+		s.value.SetInt(int64(d))
+	case bool:
+		var b bool
+		b, err = strconv.ParseBool(text)
+		s.value.SetBool(b)
+	case int, int64:
+		var n int64
+		n, err = strconv.ParseInt(text, 10, 64)
+		s.value.SetInt(n)
+	case float64:
+		var f float64
+		f, err = strconv.ParseFloat(text, 64)
+		s.value.SetFloat(f)
+	case []string:
+		var list []string
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(list))
+	case map[string][]string:
+		lists := make(map[string][]string)
+		for _, pair := range strings.Split(text, ",") {
+			key, items, ok := strings.Cut(pair, "=")
+			if !ok {
+				err = fmt.Errorf("%q is not key=item|item", pair)
+				break
+			}
+			var list []string
+			for _, item := range strings.Split(items, "|") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			lists[strings.TrimSpace(key)] = list
+		}
+		s.value.Set(reflect.ValueOf(lists))
+	}
+	if err != nil {
+		return fmt.Errorf("%s (-%s): invalid value %q", s.env, s.flag, text)
+	}
+	return nil
+}
+
+// setJSON sets the value from the configuration file, where durations may be strings or numbers of seconds.
+func (s setting) setJSON(raw json.RawMessage) error {
+	if _, ok := s.value.Interface().(time.Duration); ok {
+		var text string
+		if json.Unmarshal(raw, &text) != nil {
+			text = string(raw)
+		}
+		d, err := parseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%s.%s: invalid duration %s", s.section, s.key, raw)
+		}
+		s.value.SetInt(int64(d))
+		return nil
+	}
+	if err := json.Unmarshal(raw, s.value.Addr().Interface()); err != nil {
+		return fmt.Errorf("%s.%s: %w", s.section, s.key, err)
+	}
+	return nil
+}
+
+func parseDuration(text string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(text, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(text)
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Players spawn in a 3x3 area, so a field needs at least three cells each way.
+func TestFieldMinimum(t *testing.T) {
+	for _, test := range []struct {
+		width, height float64
+		ok            bool
+	}{
+		{60, 60, true},
+		{60, 40, false},
+		{40, 60, false},
+		{20, 20, false},
+	} {
+		c := Default()
+		c.Gameplay.FieldWidth, c.Gameplay.FieldHeight = test.width, test.height
+		err := c.Validate()
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("a %vx%v field: expected ok=%v, got %v", test.width, test.height, test.ok, err)
+		} else if !ok && !strings.Contains(err.Error(), "at least three cells") {
+			t.Errorf("a %vx%v field: unexpected error %v", test.width, test.height, err)
+		}
+	}
+}
+
+// writeFile writes a configuration file in a temporary directory and returns its path.
+func writeFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// The file overrides the defaults, the environment the file and flags everything.
+func TestLoadPrecedence(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		file, env string
+		flags     []string
+		want      time.Duration
+	}{
+		{name: "default", want: 10 * time.Second},
+		{name: "file", file: `{"network": {"shutdownTimeout": "30s"}}`, want: 30 * time.Second},
+		{name: "environment", env: "45s", want: 45 * time.Second},
+		{name: "environment over file", file: `{"network": {"shutdownTimeout": "30s"}}`, env: "45s", want: 45 * time.Second},
+		{name: "flag", flags: []string{"-shutdown-timeout", "1m"}, want: time.Minute},
+		{name: "flag over everything", file: `{"network": {"shutdownTimeout": "30s"}}`, env: "45s",
+			flags: []string{"-shutdown-timeout=1m"}, want: time.Minute},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv("SHUTDOWN_TIMEOUT", test.env)
+			args := test.flags
+			if test.file != "" {
+				args = append([]string{"-config", writeFile(t, test.file)}, args...)
+			}
+			c, _, err := Load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Network.ShutdownTimeout != test.want {
+				t.Fatalf("expected a shutdown timeout of %v, got %v", test.want, c.Network.ShutdownTimeout)
+			}
+			// Settings left out keep their defaults
+			if c.Network.Port != Default().Network.Port {
+				t.Fatalf("the port changed to %q", c.Network.Port)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		file  string
+		env   map[string]string
+		flags []string
+		err   string
+	}{
+		{name: "unknown setting", file: `{"network": {"prot": "80"}}`, err: "unknown setting network.prot"},
+		{name: "unknown section", file: `{"netwrok": {"port": "80"}}`, err: "unknown setting netwrok.port"},
+		{name: "not JSON", file: `network: {}`, err: "invalid character"},
+		{name: "wrong type", file: `{"network": {"port": 80}}`, err: "network.port"},
+		{name: "invalid duration in the file", file: `{"network": {"shutdownTimeout": "soon"}}`,
+			err: "network.shutdownTimeout: invalid duration"},
+		{name: "invalid duration in the environment", env: map[string]string{"SHUTDOWN_TIMEOUT": "soon"},
+			err: `SHUTDOWN_TIMEOUT (-shutdown-timeout): invalid value "soon"`},
+		{name: "invalid number in a flag", flags: []string{"-room-capacity", "lots"}, err: `invalid value "lots"`},
+		{name: "unknown flag", flags: []string{"-prot", "80"}, err: "flag provided but not defined"},
+		{name: "missing file", flags: []string{"-config", "missing.json"}, err: "missing.json"},
+		{name: "invalid settings", file: `{"network": {"shutdownTimeout": 0}}`, err: "network.shutdownTimeout must be positive"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			args := test.flags
+			if test.file != "" {
+				args = append([]string{"-config", writeFile(t, test.file)}, args...)
+			}
+			_, _, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// Durations are Go durations or plain seconds, and the file can also give seconds as a number.
+func TestDurations(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want time.Duration
+		ok   bool
+	}{
+		{"90s", 90 * time.Second, true},
+		{"1m30s", 90 * time.Second, true},
+		{"250ms", 250 * time.Millisecond, true},
+		{"90", 90 * time.Second, true},
+		{"2.5", 2500 * time.Millisecond, true},
+		{"", 0, false},
+		{"soon", 0, false},
+		{"5 minutes", 0, false},
+	} {
+		got, err := parseDuration(test.text)
+		if ok := err == nil; ok != test.ok || got != test.want {
+			t.Errorf("%q: expected %v ok=%v, got %v %v", test.text, test.want, test.ok, got, err)
+		}
+	}
+
+	t.Setenv("CONFIG_FILE", "")
+	c, _, err := Load([]string{"-config", writeFile(t, `{"network": {"shutdownTimeout": 2.5, "reconnectAfter": "1m"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Network.ShutdownTimeout != 2500*time.Millisecond || c.Network.ReconnectAfter != time.Minute {
+		t.Fatalf("expected 2.5s and 1m from the file, got %v and %v", c.Network.ShutdownTimeout, c.Network.ReconnectAfter)
+	}
+}
+
+// Loading again picks up an edited file, and Watch notices the edit.
+func TestReload(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	path := writeFile(t, `{"gameplay": {"respawnDelay": "5s"}}`)
+	c, file, err := Load([]string{"-config", path})
+	if err != nil || file != path || c.Gameplay.RespawnDelay != 5*time.Second {
+		t.Fatalf("expected a respawn delay of 5s from %s, got %v from %q: %v", path, c.Gameplay.RespawnDelay, file, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan struct{}, 1)
+	go Watch(ctx, path, 10*time.Millisecond, func() {
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
+	})
+	time.Sleep(30 * time.Millisecond)
+	if err := os.WriteFile(path, []byte(`{"gameplay": {"respawnDelay": "1s"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The edit may land within the file system's timestamp resolution
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch didn't notice the edit")
+	}
+	if c, _, err := Load([]string{"-config", path}); err != nil || c.Gameplay.RespawnDelay != time.Second {
+		t.Fatalf("expected a respawn delay of 1s after the edit, got %v: %v", c.Gameplay.RespawnDelay, err)
+	}
+}
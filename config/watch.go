@@ -0,0 +1,33 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Watch calls reload whenever the file's modification time changes, checking every interval until ctx is done.
+func Watch(ctx context.Context, path string, interval time.Duration, reload func()) {
+	modified := modTime(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if current := modTime(path); !current.Equal(modified) {
+			modified = current
+			reload()
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
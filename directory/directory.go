@@ -0,0 +1,155 @@
+// Package directory keeps track of which server node owns each room, so several nodes can share the rooms.
+//
+// Every node registers itself with the rooms it plays and renews its registration every few seconds. A node that
+// stops renewing is gone once the TTL passes, and its rooms go to the live nodes as players ask for them.
+package directory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrNoNodes = errors.New("no live nodes in the room directory")
+
+// Node is a server that plays rooms.
+type Node struct {
+	ID    string    `json:"id"`
+	URL   string    `json:"url"`   // base URL players reach the node at, like https://eu1.example.com
+	Seen  time.Time `json:"seen"`  // when it last registered
+	Rooms int       `json:"rooms"` // rooms it owns, filled in by Nodes
+}
+
+// Directory maps room IDs to the nodes that own them. Implementations are safe for concurrent use.
+type Directory interface {
+	// Register adds a node or renews its registration, claiming the rooms it plays that no other live node owns.
+	Register(node Node, rooms []string) error
+	// Leave removes a node and frees its rooms.
+	Leave(nodeID string) error
+	// Owner returns the live node owning a room, first giving it to the live node with the fewest rooms if it has none.
+	Owner(room string) (Node, error)
+	// Find returns the live node owning a room, or the node Owner would give it to, without giving it.
+	Find(room string) (Node, error)
+	// Release frees a room if the node owns it.
+	Release(room, nodeID string) error
+	// Nodes lists the live nodes by ID.
+	Nodes() ([]Node, error)
+	// Rooms maps the rooms owned by live nodes to their node IDs.
+	Rooms() (map[string]string, error)
+}
+
+// Open opens the directory a spec names: "memory" for one kept in the process, which is enough for a single node,
+// or "file:<path>" for a JSON file shared by the nodes on one host. Nodes not heard from for ttl are gone.
+func Open(spec string, ttl time.Duration) (Directory, error) {
+	if spec == "memory" {
+		return NewMemory(ttl), nil
+	}
+	if path, ok := strings.CutPrefix(spec, "file:"); ok && path != "" {
+		return NewFile(path, ttl), nil
+	}
+	return nil, fmt.Errorf("room directory %q must be memory or file:<path>", spec)
+}
+
+// table is what a directory holds, the backends keep it in memory or in a file.
+type table struct {
+	Nodes map[string]Node   `json:"nodes"`
+	Rooms map[string]string `json:"rooms"` // room ID to the ID of the node that owns it
+}
+
+func newTable() *table {
+	return &table{Nodes: make(map[string]Node), Rooms: make(map[string]string)}
+}
+
+func (t *table) alive(nodeID string, now time.Time, ttl time.Duration) bool {
+	node, ok := t.Nodes[nodeID]
+	return ok && now.Sub(node.Seen) < ttl
+}
+
+// register renews a node and claims its rooms, forgetting nodes that have been gone for a while.
+func (t *table) register(node Node, rooms []string, now time.Time, ttl time.Duration) {
+	node.Seen = now
+	node.Rooms = 0
+	t.Nodes[node.ID] = node
+	for _, room := range rooms {
+		if owner, ok := t.Rooms[room]; !ok || !t.alive(owner, now, ttl) {
+			t.Rooms[room] = node.ID
+		}
+	}
+	for id, other := range t.Nodes {
+		if now.Sub(other.Seen) > 10*ttl {
+			t.leave(id)
+		}
+	}
+}
+
+func (t *table) leave(nodeID string) {
+	delete(t.Nodes, nodeID)
+	for room, owner := range t.Rooms {
+		if owner == nodeID {
+			delete(t.Rooms, room)
+		}
+	}
+}
+
+// owner returns a room's live owner, or the live node with the fewest rooms when it has none, reporting whether
+// the room was given to that node. Rooms are only given when claim is set.
+func (t *table) owner(room string, claim bool, now time.Time, ttl time.Duration) (Node, bool, error) {
+	if id, ok := t.Rooms[room]; ok && t.alive(id, now, ttl) {
+		return t.Nodes[id], false, nil
+	}
+	nodes := t.nodes(now, ttl)
+	if len(nodes) == 0 {
+		return Node{}, false, ErrNoNodes
+	}
+	// Ties go to the first node by ID, so every node picks the same one
+	least := nodes[0]
+	for _, node := range nodes[1:] {
+		if node.Rooms < least.Rooms {
+			least = node
+		}
+	}
+	if !claim {
+		return least, false, nil
+	}
+	t.Rooms[room] = least.ID
+	least.Rooms++
+	return least, true, nil
+}
+
+func (t *table) release(room, nodeID string) bool {
+	if t.Rooms[room] != nodeID {
+		return false
+	}
+	delete(t.Rooms, room)
+	return true
+}
+
+// nodes lists the live nodes by ID with how many rooms each owns.
+func (t *table) nodes(now time.Time, ttl time.Duration) []Node {
+	var nodes []Node
+	for id, node := range t.Nodes {
+		if t.alive(id, now, ttl) {
+			node.Rooms = 0
+			for _, owner := range t.Rooms {
+				if owner == id {
+					node.Rooms++
+				}
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+func (t *table) rooms(now time.Time, ttl time.Duration) map[string]string {
+	rooms := make(map[string]string)
+	for room, owner := range t.Rooms {
+		if t.alive(owner, now, ttl) {
+			rooms[room] = owner
+		}
+	}
+	return rooms
+}
@@ -0,0 +1,128 @@
+package directory
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// backends returns an empty directory of each kind.
+func backends(t *testing.T) map[string]Directory {
+	return map[string]Directory{
+		"memory": NewMemory(time.Minute),
+		"file":   NewFile(filepath.Join(t.TempDir(), "rooms.json"), time.Minute),
+	}
+}
+
+func TestRoomsGoToTheNodeWithTheFewest(t *testing.T) {
+	for name, d := range backends(t) {
+		if _, err := d.Owner("main"); err != ErrNoNodes {
+			t.Fatalf("%s: expected ErrNoNodes without nodes, got %v", name, err)
+		}
+		if err := d.Register(Node{ID: "a", URL: "http://a"}, []string{"one", "two"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Register(Node{ID: "b", URL: "http://b"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		// A node's rooms stay with it
+		if node, err := d.Owner("one"); err != nil || node.ID != "a" {
+			t.Fatalf("%s: expected a to own one, got %q %v", name, node.ID, err)
+		}
+		for _, room := range []string{"three", "four"} {
+			if node, err := d.Owner(room); err != nil || node.ID != "b" {
+				t.Fatalf("%s: expected %s to go to b, got %q %v", name, room, node.ID, err)
+			}
+		}
+		// Now they have two each and ties go to the first by ID
+		if node, err := d.Owner("five"); err != nil || node.ID != "a" {
+			t.Fatalf("%s: expected five to go to a, got %q %v", name, node.ID, err)
+		}
+		nodes, err := d.Nodes()
+		if err != nil || len(nodes) != 2 || nodes[0].Rooms != 3 || nodes[1].Rooms != 2 {
+			t.Fatalf("%s: expected a with 3 rooms and b with 2, got %+v %v", name, nodes, err)
+		}
+	}
+}
+
+func TestFindDoesNotGiveRoomsAway(t *testing.T) {
+	for name, d := range backends(t) {
+		d.Register(Node{ID: "a"}, []string{"one"})
+		d.Register(Node{ID: "b"}, nil)
+
+		if node, err := d.Find("one"); err != nil || node.ID != "a" {
+			t.Fatalf("%s: expected to find a owning one, got %q %v", name, node.ID, err)
+		}
+		// b would get a new room, but only once someone joins it
+		if node, err := d.Find("two"); err != nil || node.ID != "b" {
+			t.Fatalf("%s: expected b to be the node two would go to, got %q %v", name, node.ID, err)
+		}
+		rooms, err := d.Rooms()
+		if err != nil || len(rooms) != 1 {
+			t.Fatalf("%s: finding a room gave it to a node: %v %v", name, rooms, err)
+		}
+		if node, err := d.Owner("two"); err != nil || node.ID != "b" {
+			t.Fatalf("%s: expected two to go to b, got %q %v", name, node.ID, err)
+		}
+		if rooms, _ := d.Rooms(); rooms["two"] != "b" {
+			t.Fatalf("%s: joining two didn't give it to b: %v", name, rooms)
+		}
+	}
+}
+
+func TestReleaseAndLeave(t *testing.T) {
+	for name, d := range backends(t) {
+		d.Register(Node{ID: "a"}, []string{"one", "two"})
+		d.Register(Node{ID: "b"}, []string{"three"})
+
+		// Only the owner can release a room
+		d.Release("one", "b")
+		if rooms, _ := d.Rooms(); rooms["one"] != "a" {
+			t.Fatalf("%s: b released a's room: %v", name, rooms)
+		}
+		d.Release("one", "a")
+		if rooms, _ := d.Rooms(); len(rooms) != 2 || rooms["one"] != "" {
+			t.Fatalf("%s: expected one to be free, got %v", name, rooms)
+		}
+
+		if err := d.Leave("a"); err != nil {
+			t.Fatal(err)
+		}
+		rooms, _ := d.Rooms()
+		nodes, _ := d.Nodes()
+		if len(rooms) != 1 || rooms["three"] != "b" || len(nodes) != 1 {
+			t.Fatalf("%s: expected only b and its room after a left, got %v %v", name, nodes, rooms)
+		}
+	}
+}
+
+func TestRoomsOfGoneNodesMove(t *testing.T) {
+	d := NewMemory(time.Minute)
+	d.Register(Node{ID: "a"}, []string{"one"})
+	d.Register(Node{ID: "b"}, nil)
+	// a stops renewing
+	d.table.Nodes["a"] = Node{ID: "a", Seen: time.Now().Add(-2 * time.Minute)}
+
+	if node, err := d.Owner("one"); err != nil || node.ID != "b" {
+		t.Fatalf("expected one to move to b, got %q %v", node.ID, err)
+	}
+	if nodes, _ := d.Nodes(); len(nodes) != 1 || nodes[0].ID != "b" {
+		t.Fatalf("expected only b to be live, got %+v", nodes)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open("memory", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := Open("file:"+filepath.Join(t.TempDir(), "rooms.json"), time.Minute); err != nil {
+		t.Fatal(err)
+	} else if _, ok := d.(*File); !ok {
+		t.Fatalf("expected a file directory, got %T", d)
+	}
+	for _, spec := range []string{"", "file:", "redis://localhost"} {
+		if _, err := Open(spec, time.Minute); err == nil {
+			t.Fatalf("opened a directory for %q", spec)
+		}
+	}
+}
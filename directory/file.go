@@ -0,0 +1,155 @@
+// Package directory file.go keeps the directory in a JSON file, for nodes sharing one host or filesystem.
+package directory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	lockWait  = 5 * time.Second  // how long to wait for another node to unlock the file
+	lockStale = 10 * time.Second // a lock this old was left by a node that crashed holding it
+)
+
+// File is a directory kept in a JSON file. Every call reads the file under a lock file, so the nodes using it
+// always agree; writes replace the file whole.
+type File struct {
+	path string
+	ttl  time.Duration
+	mu   sync.Mutex // nodes in the same process share the lock file too
+}
+
+// NewFile returns a directory kept at path, the file is created on the first write.
+func NewFile(path string, ttl time.Duration) *File {
+	return &File{path: path, ttl: ttl}
+}
+
+func (f *File) Register(node Node, rooms []string) error {
+	return f.update(func(t *table, now time.Time) (bool, error) {
+		t.register(node, rooms, now, f.ttl)
+		return true, nil
+	})
+}
+
+func (f *File) Leave(nodeID string) error {
+	return f.update(func(t *table, now time.Time) (bool, error) {
+		t.leave(nodeID)
+		return true, nil
+	})
+}
+
+func (f *File) Owner(room string) (Node, error) {
+	var node Node
+	err := f.update(func(t *table, now time.Time) (bool, error) {
+		var assigned bool
+		var err error
+		node, assigned, err = t.owner(room, true, now, f.ttl)
+		return assigned, err
+	})
+	return node, err
+}
+
+func (f *File) Find(room string) (Node, error) {
+	var node Node
+	err := f.update(func(t *table, now time.Time) (bool, error) {
+		var err error
+		node, _, err = t.owner(room, false, now, f.ttl)
+		return false, err
+	})
+	return node, err
+}
+
+func (f *File) Release(room, nodeID string) error {
+	return f.update(func(t *table, now time.Time) (bool, error) {
+		return t.release(room, nodeID), nil
+	})
+}
+
+func (f *File) Nodes() ([]Node, error) {
+	var nodes []Node
+	err := f.update(func(t *table, now time.Time) (bool, error) {
+		nodes = t.nodes(now, f.ttl)
+		return false, nil
+	})
+	return nodes, err
+}
+
+func (f *File) Rooms() (map[string]string, error) {
+	var rooms map[string]string
+	err := f.update(func(t *table, now time.Time) (bool, error) {
+		rooms = t.rooms(now, f.ttl)
+		return false, nil
+	})
+	return rooms, err
+}
+
+// update reads the table with the file locked and writes it back if fn changed it.
+func (f *File) update(fn func(t *table, now time.Time) (bool, error)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	unlock, err := f.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	t := newTable()
+	data, err := os.ReadFile(f.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, t); err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+	}
+
+	changed, err := fn(t, time.Now())
+	if err != nil || !changed {
+		return err
+	}
+	data, err = json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Readers never see half a file
+	temp := f.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, f.path)
+}
+
+// lock creates the lock file next to the directory, waiting for another node to remove it.
+func (f *File) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return nil, err
+	}
+	path := f.path + ".lock"
+	deadline := time.Now().Add(lockWait)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("room directory %s is locked", f.path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
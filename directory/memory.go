@@ -0,0 +1,66 @@
+// Package directory memory.go keeps the directory in the process, for a single node.
+package directory
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory is a directory held in memory. Only the nodes in the same process see it.
+type Memory struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	table *table
+}
+
+// NewMemory returns an empty directory in memory.
+func NewMemory(ttl time.Duration) *Memory {
+	return &Memory{ttl: ttl, table: newTable()}
+}
+
+func (m *Memory) Register(node Node, rooms []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.table.register(node, rooms, time.Now(), m.ttl)
+	return nil
+}
+
+func (m *Memory) Leave(nodeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.table.leave(nodeID)
+	return nil
+}
+
+func (m *Memory) Owner(room string) (Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, _, err := m.table.owner(room, true, time.Now(), m.ttl)
+	return node, err
+}
+
+func (m *Memory) Find(room string) (Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, _, err := m.table.owner(room, false, time.Now(), m.ttl)
+	return node, err
+}
+
+func (m *Memory) Release(room, nodeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.table.release(room, nodeID)
+	return nil
+}
+
+func (m *Memory) Nodes() ([]Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.table.nodes(time.Now(), m.ttl), nil
+}
+
+func (m *Memory) Rooms() (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.table.rooms(time.Now(), m.ttl), nil
+}
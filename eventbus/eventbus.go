@@ -0,0 +1,132 @@
+// Package eventbus is an in-process publish and subscribe bus. Every subscriber gets the events published after
+// it subscribed, in the order they were published, and handles them on its own goroutine behind a bounded buffer
+// so a slow subscriber never holds up the publisher or the others.
+package eventbus
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// Bus delivers events of type E to its subscribers.
+type Bus[E any] struct {
+	mu     sync.Mutex
+	subs   []*Subscription[E]
+	closed bool
+
+	// OnDrop, when set, is called with the subscriber's name for each event dropped because its buffer was full.
+	OnDrop func(subscriber string)
+}
+
+// Subscription is a subscriber's place on the bus.
+type Subscription[E any] struct {
+	Name    string
+	events  chan E
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+// New returns a bus without subscribers.
+func New[E any]() *Bus[E] {
+	return &Bus[E]{}
+}
+
+// Subscribe calls handle with every event published from now on, one at a time and in order. Up to buffer events
+// wait for handle, events published while the buffer is full are dropped for this subscriber.
+func (b *Bus[E]) Subscribe(name string, buffer int, handle func(E)) *Subscription[E] {
+	s := &Subscription[E]{Name: name, events: make(chan E, buffer), done: make(chan struct{})}
+	go s.run(handle)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.events)
+		return s
+	}
+	b.subs = append(b.subs, s)
+	return s
+}
+
+// Unsubscribe stops delivering events to a subscriber, which still handles the ones in its buffer.
+func (b *Bus[E]) Unsubscribe(s *Subscription[E]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			close(s.events)
+			return
+		}
+	}
+}
+
+// Publish hands an event to every subscriber without waiting for any of them. Events published one after the
+// other reach each subscriber in that order, even from different goroutines.
+func (b *Bus[E]) Publish(event E) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, s := range b.subs {
+		select {
+		case s.events <- event:
+		default:
+			s.dropped.Add(1)
+			if b.OnDrop != nil {
+				b.OnDrop(s.Name)
+			}
+		}
+	}
+}
+
+// Close stops taking events and waits until the subscribers handled what is in their buffers, or ctx is done.
+func (b *Bus[E]) Close(ctx context.Context) error {
+	b.mu.Lock()
+	subs := b.subs
+	if !b.closed {
+		b.closed = true
+		b.subs = nil
+		for _, s := range subs {
+			close(s.events)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Dropped is how many events the subscriber missed because its buffer was full.
+func (s *Subscription[E]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Pending is how many events wait in the subscriber's buffer.
+func (s *Subscription[E]) Pending() int {
+	return len(s.events)
+}
+
+func (s *Subscription[E]) run(handle func(E)) {
+	defer close(s.done)
+	for event := range s.events {
+		s.handle(handle, event)
+	}
+}
+
+// handle runs the subscriber on one event, a panic is logged and the subscriber goes on with the next event.
+func (s *Subscription[E]) handle(handle func(E), event E) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Event subscriber panicked", "subscriber", s.Name, "panic", r)
+		}
+	}()
+	handle(event)
+}
@@ -0,0 +1,200 @@
+package eventbus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func closeBus[E any](t *testing.T, b *Bus[E]) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribersGetEventsInOrder(t *testing.T) {
+	b := New[int]()
+	var first, second []int
+	b.Subscribe("first", 1000, func(e int) { first = append(first, e) })
+	b.Subscribe("second", 1000, func(e int) { second = append(second, e) })
+	for i := 0; i < 500; i++ {
+		b.Publish(i)
+	}
+	closeBus(t, b)
+
+	for name, got := range map[string][]int{"first": first, "second": second} {
+		if len(got) != 500 {
+			t.Fatalf("%s got %d events, expected 500", name, len(got))
+		}
+		for i, e := range got {
+			if e != i {
+				t.Fatalf("%s got event %d in place %d", name, e, i)
+			}
+		}
+	}
+}
+
+// Events published from several goroutines arrive in the same order at every subscriber.
+func TestConcurrentPublishersKeepOneOrder(t *testing.T) {
+	b := New[int]()
+	var first, second []int
+	b.Subscribe("first", 10000, func(e int) { first = append(first, e) })
+	b.Subscribe("second", 10000, func(e int) { second = append(second, e) })
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				b.Publish(p*1000 + i)
+			}
+		}()
+	}
+	wg.Wait()
+	closeBus(t, b)
+
+	if len(first) != 4000 || len(second) != 4000 {
+		t.Fatalf("got %d and %d events, expected 4000 each", len(first), len(second))
+	}
+	last := make(map[int]int)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("the subscribers disagree on event %d: %d and %d", i, first[i], second[i])
+		}
+		// Each publisher's own events stay in the order it published them
+		publisher := first[i] / 1000
+		if previous, ok := last[publisher]; ok && first[i] < previous {
+			t.Fatalf("publisher %d's event %d arrived after %d", publisher, first[i], previous)
+		}
+		last[publisher] = first[i]
+	}
+}
+
+// A subscriber that can't keep up loses the events that don't fit its buffer, without slowing the others.
+func TestSlowSubscriberDropsEvents(t *testing.T) {
+	b := New[int]()
+	var dropsMutex sync.Mutex
+	drops := make(map[string]int)
+	b.OnDrop = func(subscriber string) {
+		dropsMutex.Lock()
+		drops[subscriber]++
+		dropsMutex.Unlock()
+	}
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var slowGot []int
+	slow := b.Subscribe("slow", 4, func(e int) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		slowGot = append(slowGot, e)
+	})
+	var fastGot []int
+	fast := b.Subscribe("fast", 100, func(e int) { fastGot = append(fastGot, e) })
+
+	b.Publish(0)
+	<-started
+	published := make(chan struct{})
+	go func() {
+		for i := 1; i < 20; i++ {
+			b.Publish(i)
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing waited for the slow subscriber")
+	}
+	close(release)
+	closeBus(t, b)
+
+	if len(fastGot) != 20 || fast.Dropped() != 0 {
+		t.Fatalf("the fast subscriber got %d events and dropped %d, expected all 20", len(fastGot), fast.Dropped())
+	}
+	// The slow one is handling the first event and has the next 4 buffered, the rest are dropped
+	if len(slowGot) != 5 || slow.Dropped() != 15 {
+		t.Fatalf("the slow subscriber got %d events and dropped %d, expected 5 and 15", len(slowGot), slow.Dropped())
+	}
+	for i, e := range slowGot {
+		if e != i {
+			t.Fatalf("the slow subscriber got event %d in place %d", e, i)
+		}
+	}
+	if drops["slow"] != 15 || drops["fast"] != 0 {
+		t.Fatalf("OnDrop saw %v, expected 15 drops for slow", drops)
+	}
+}
+
+func TestUnsubscribeStopsDelivery(t *testing.T) {
+	b := New[int]()
+	var got []int
+	s := b.Subscribe("s", 10, func(e int) { got = append(got, e) })
+	b.Publish(1)
+	b.Unsubscribe(s)
+	b.Publish(2)
+	<-s.done
+	closeBus(t, b)
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("got %v, expected only the event before unsubscribing", got)
+	}
+}
+
+func TestPanickingSubscriberGoesOn(t *testing.T) {
+	b := New[int]()
+	var got []int
+	b.Subscribe("s", 10, func(e int) {
+		if e == 2 {
+			panic("boom")
+		}
+		got = append(got, e)
+	})
+	for i := 1; i <= 3; i++ {
+		b.Publish(i)
+	}
+	closeBus(t, b)
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Fatalf("got %v, expected 1 and 3", got)
+	}
+}
+
+func TestCloseWaitsForBufferedEvents(t *testing.T) {
+	b := New[int]()
+	var handled int
+	b.Subscribe("s", 10, func(int) {
+		time.Sleep(10 * time.Millisecond)
+		handled++
+	})
+	for i := 0; i < 5; i++ {
+		b.Publish(i)
+	}
+	closeBus(t, b)
+	if handled != 5 {
+		t.Fatalf("Close returned with %d of 5 events handled", handled)
+	}
+
+	// Nothing is delivered once the bus is closed
+	late := b.Subscribe("late", 10, func(int) { t.Error("a subscriber of a closed bus got an event") })
+	b.Publish(6)
+	<-late.done
+}
+
+func TestCloseGivesUpWhenTheContextIsDone(t *testing.T) {
+	b := New[int]()
+	release := make(chan struct{})
+	defer close(release)
+	b.Subscribe("stuck", 1, func(int) { <-release })
+	b.Publish(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to pass, got %v", err)
+	}
+}
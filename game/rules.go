@@ -0,0 +1,261 @@
+// Package game rules.go contains movement, trails, territory capture and deaths.
+package game
+
+import (
+	"math"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// MaxSpeedMultiplier caps the speed bonus a kill streak gives
+const MaxSpeedMultiplier = 1.09
+
+// move updates the player's position based on their velocity. The edges of the field stop them, and so do walls
+// and the outside of the playable area, at the edge of the cell they are on.
+func (w *World) move(player *models.Player) {
+	maxX, maxY := w.Config.Width-w.Config.CellSize, w.Config.Height-w.Config.CellSize
+	x := math.Max(0, math.Min(maxX, player.X+player.VelocityX))
+	y := math.Max(0, math.Min(maxY, player.Y+player.VelocityY))
+	if cell := w.cellAt(w.cellOf(x, y)); cell == Wall || cell == Void {
+		position := w.pointOf(w.cellOf(player.X, player.Y))
+		if player.VelocityX != 0 {
+			x = position.X
+		}
+		if player.VelocityY != 0 {
+			y = position.Y
+		}
+	}
+	player.X, player.Y = x, y
+}
+
+// cellOf returns the cell under the centre of a player standing at x, y.
+func (w *World) cellOf(x, y float64) (int, int) {
+	row := int((y + w.Config.CellSize/2) / w.Config.CellSize)
+	col := int((x + w.Config.CellSize/2) / w.Config.CellSize)
+	return min(max(row, 0), w.rows-1), min(max(col, 0), w.cols-1)
+}
+
+func (w *World) pointOf(row, col int) models.Point {
+	return models.Point{X: float64(col) * w.Config.CellSize, Y: float64(row) * w.Config.CellSize}
+}
+
+func owns(player *models.Player, row, col int) bool {
+	return row >= 0 && row < len(player.LandCapture) && col >= 0 && col < len(player.LandCapture[row]) && player.LandCapture[row][col]
+}
+
+func (w *World) inTrail(player *models.Player, row, col int) bool {
+	for _, point := range player.PlayerTrail {
+		if r, c := w.cellOf(point.X, point.Y); r == row && c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// updateTrail runs after a player moved: back on their own land closes the trail and captures,
+// outside it the trail grows and running into a trail kills.
+func (w *World) updateTrail(player *models.Player) []Event {
+	row, col := w.cellOf(player.X, player.Y)
+
+	if w.cellAt(row, col) == Hazard {
+		return []Event{w.die(player, nil, models.CauseHazard)}
+	}
+
+	if owns(player, row, col) {
+		if len(player.PlayerTrail) > 0 {
+			return []Event{w.capture(player)}
+		}
+		return nil
+	}
+
+	if n := len(player.PlayerTrail); n > 0 {
+		if r, c := w.cellOf(player.PlayerTrail[n-1].X, player.PlayerTrail[n-1].Y); r == row && c == col {
+			// Still in the same cell
+			return nil
+		}
+	}
+
+	// The player has run into their own trail
+	if w.inTrail(player, row, col) {
+		return []Event{w.die(player, nil, models.CauseTrail)}
+	}
+
+	// The player has run into another player's trail, who gets the kill and the player's territory
+	for _, id := range w.order {
+		other := w.players[id]
+		if other != player && other.IsAlive && w.inTrail(other, row, col) {
+			return []Event{w.die(player, other, models.CauseKilled)}
+		}
+	}
+
+	player.PlayerTrail = append(player.PlayerTrail, w.pointOf(row, col))
+	return nil
+}
+
+// capture turns the trail and everything it encloses into the player's land, taking it from anyone who owned it.
+func (w *World) capture(player *models.Player) Event {
+	gained := 0
+	take := func(row, col int) {
+		if player.LandCapture[row][col] || !w.Playable(row, col) {
+			return
+		}
+		player.LandCapture[row][col] = true
+		gained++
+		for _, id := range w.order {
+			if other := w.players[id]; other != player && owns(other, row, col) {
+				other.LandCapture[row][col] = false
+			}
+		}
+	}
+
+	for _, point := range player.PlayerTrail {
+		take(w.cellOf(point.X, point.Y))
+	}
+	player.PlayerTrail = []models.Point{}
+
+	// Flood fill from the edges over cells the player doesn't own, whatever the fill can't reach is enclosed.
+	// The fill goes through walls and holes like any other cell, take leaves them out.
+	reached := w.newGrid()
+	var queue [][2]int
+	visit := func(row, col int) {
+		if row < 0 || row >= w.rows || col < 0 || col >= w.cols || reached[row][col] || player.LandCapture[row][col] {
+			return
+		}
+		reached[row][col] = true
+		queue = append(queue, [2]int{row, col})
+	}
+	for row := 0; row < w.rows; row++ {
+		visit(row, 0)
+		visit(row, w.cols-1)
+	}
+	for col := 0; col < w.cols; col++ {
+		visit(0, col)
+		visit(w.rows-1, col)
+	}
+	for len(queue) > 0 {
+		cell := queue[0]
+		queue = queue[1:]
+		visit(cell[0]-1, cell[1])
+		visit(cell[0]+1, cell[1])
+		visit(cell[0], cell[1]-1)
+		visit(cell[0], cell[1]+1)
+	}
+	for row := 0; row < w.rows; row++ {
+		for col := 0; col < w.cols; col++ {
+			if !reached[row][col] {
+				take(row, col)
+			}
+		}
+	}
+
+	return Event{Tick: w.Tick, Type: EventCapture, PlayerID: player.ID, Cells: gained}
+}
+
+// die kills a player. A killer gets the kill, a faster speed and the player's territory, otherwise it turns neutral.
+func (w *World) die(player, killer *models.Player, cause string) Event {
+	event := Event{Tick: w.Tick, Type: EventDeath, PlayerID: player.ID, Cause: cause}
+	if killer != nil {
+		event.OtherID = killer.ID
+		killer.KillStreak++
+		killer.SpeedMultiplier = math.Min(1+float64(killer.KillStreak)*0.01, MaxSpeedMultiplier)
+		for row := range player.LandCapture {
+			for col, owned := range player.LandCapture[row] {
+				if owned {
+					killer.LandCapture[row][col] = true
+				}
+			}
+		}
+	}
+
+	player.IsAlive = false
+	player.VelocityX, player.VelocityY = 0, 0
+	player.PlayerTrail = []models.Point{}
+	player.KillStreak = 0
+	player.SpeedMultiplier = 1
+	player.LandCapture = w.newGrid()
+	w.diedAt[player.ID] = w.Tick
+	return event
+}
+
+// spawn places a player on a free spot with a 3x3 patch of starting land.
+func (w *World) spawn(player *models.Player) {
+	row, col := w.spawnCell()
+	w.spawnAt(player, row, col)
+}
+
+// spawnAt places a player on a cell with a 3x3 patch of starting land around it, taken from whoever owned it.
+func (w *World) spawnAt(player *models.Player, row, col int) {
+	if w.rows >= 3 && w.cols >= 3 {
+		row, col = min(max(row, 1), w.rows-2), min(max(col, 1), w.cols-2)
+	}
+	land := w.newGrid()
+	for r := row - 1; r <= row+1; r++ {
+		for c := col - 1; c <= col+1; c++ {
+			if !w.Playable(r, c) {
+				continue
+			}
+			land[r][c] = true
+			for _, id := range w.order {
+				if other := w.players[id]; other != player && owns(other, r, c) {
+					other.LandCapture[r][c] = false
+				}
+			}
+		}
+	}
+
+	position := w.pointOf(row, col)
+	player.X, player.Y = position.X, position.Y
+	player.StartingPosition = position
+	player.VelocityX, player.VelocityY = 0, 0
+	player.LandCapture = land
+	player.StartingLand = w.newGrid()
+	for r := range land {
+		copy(player.StartingLand[r], land[r])
+	}
+	player.PlayerTrail = []models.Point{}
+	player.IsAlive = true
+	player.SpeedMultiplier = 1
+}
+
+// spawnCell picks the centre of a 3x3 area nobody owns or has a trail in, giving up after a while on crowded maps.
+// With terrain the centre is one of its spawn cells, and a field too small for the area spawns in its middle.
+func (w *World) spawnCell() (int, int) {
+	if w.rows < 3 || w.cols < 3 {
+		return w.rows / 2, w.cols / 2
+	}
+	var row, col int
+	if len(w.spawns) > 0 {
+		for attempt := 0; attempt < 100; attempt++ {
+			cell := w.spawns[w.rng.Intn(len(w.spawns))]
+			row, col = cell[0], cell[1]
+			if w.areaFree(row, col) {
+				break
+			}
+		}
+		return row, col
+	}
+	for attempt := 0; attempt < 100; attempt++ {
+		row = 1 + w.rng.Intn(w.rows-2)
+		col = 1 + w.rng.Intn(w.cols-2)
+		if w.areaFree(row, col) {
+			break
+		}
+	}
+	return row, col
+}
+
+func (w *World) areaFree(row, col int) bool {
+	for r := row - 1; r <= row+1; r++ {
+		for c := col - 1; c <= col+1; c++ {
+			if w.OwnerAt(r, c) != nil {
+				return false
+			}
+			for _, id := range w.order {
+				if w.inTrail(w.players[id], r, c) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,469 @@
+// Package game scenarios_test.go plays scripted games that pin down the rules, driving a world from a scripted
+// input stream without a server.
+package game
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// Harness plays scripted inputs into a world and records every event.
+type Harness struct {
+	World  *World
+	Events []Event
+	script map[uint64][]Input
+}
+
+func NewHarness(config Config) *Harness {
+	return &Harness{
+		World:  NewWorld(config),
+		script: make(map[uint64][]Input),
+	}
+}
+
+// Move is shorthand for an input.
+func Move(playerID, direction string) Input {
+	return Input{PlayerID: playerID, Direction: direction}
+}
+
+// Join adds a player that spawns wherever the seed puts them.
+func (h *Harness) Join(id string) *models.Player {
+	player := &models.Player{ID: id, Name: id, IsAlive: true}
+	h.Events = append(h.Events, h.World.AddPlayer(player))
+	return player
+}
+
+// Place adds a player standing on the given cell with the 3x3 land around it.
+func (h *Harness) Place(id string, row, col int) *models.Player {
+	player := &models.Player{ID: id, Name: id}
+	h.World.register(player)
+	h.World.spawnAt(player, row, col)
+	h.Events = append(h.Events, Event{Tick: h.World.Tick, Type: EventJoin, PlayerID: id})
+	return player
+}
+
+// At schedules inputs to be applied on the given tick.
+func (h *Harness) At(tick uint64, inputs ...Input) *Harness {
+	h.script[tick] = append(h.script[tick], inputs...)
+	return h
+}
+
+// Run advances the world the given number of ticks, feeding in the scripted inputs.
+func (h *Harness) Run(ticks int) {
+	for i := 0; i < ticks; i++ {
+		next := h.World.Tick + 1
+		h.Events = append(h.Events, h.World.Step(h.script[next])...)
+	}
+}
+
+func (h *Harness) player(id string) (*models.Player, error) {
+	player := h.World.Player(id)
+	if player == nil {
+		return nil, fmt.Errorf("player %s is not in the world", id)
+	}
+	return player, nil
+}
+
+func (h *Harness) ExpectAlive(id string, alive bool) error {
+	player, err := h.player(id)
+	if err != nil {
+		return err
+	}
+	if player.IsAlive != alive {
+		return fmt.Errorf("tick %d: expected %s alive=%v, got %v", h.World.Tick, id, alive, player.IsAlive)
+	}
+	return nil
+}
+
+// ExpectCell checks which cell a player is standing on.
+func (h *Harness) ExpectCell(id string, row, col int) error {
+	player, err := h.player(id)
+	if err != nil {
+		return err
+	}
+	if r, c := h.World.cellOf(player.X, player.Y); r != row || c != col {
+		return fmt.Errorf("tick %d: expected %s on cell %d,%d, got %d,%d", h.World.Tick, id, row, col, r, c)
+	}
+	return nil
+}
+
+func (h *Harness) ExpectOwned(id string, cells int) error {
+	player, err := h.player(id)
+	if err != nil {
+		return err
+	}
+	if owned := CountOwned(player); owned != cells {
+		return fmt.Errorf("tick %d: expected %s to own %d cells, got %d", h.World.Tick, id, cells, owned)
+	}
+	return nil
+}
+
+func (h *Harness) ExpectKillStreak(id string, streak int) error {
+	player, err := h.player(id)
+	if err != nil {
+		return err
+	}
+	if player.KillStreak != streak {
+		return fmt.Errorf("tick %d: expected %s to have a kill streak of %d, got %d", h.World.Tick, id, streak, player.KillStreak)
+	}
+	return nil
+}
+
+// ExpectEvent checks that an event happened and returns the first match.
+func (h *Harness) ExpectEvent(eventType EventType, playerID string) (Event, error) {
+	for _, event := range h.Events {
+		if event.Type == eventType && event.PlayerID == playerID {
+			return event, nil
+		}
+	}
+	return Event{}, fmt.Errorf("tick %d: expected a %s event for %s", h.World.Tick, eventType, playerID)
+}
+
+// Scenario is a scripted game with a check on how it ends.
+type Scenario struct {
+	Name   string
+	Config Config
+	Setup  func(h *Harness) // joins players and schedules inputs
+	Ticks  int
+	Check  func(h *Harness) error
+}
+
+// Play runs the scenario once and returns the harness for inspection.
+func (s Scenario) Play() *Harness {
+	h := NewHarness(s.Config)
+	s.Setup(h)
+	h.Run(s.Ticks)
+	return h
+}
+
+// Run plays the scenario twice, checking the outcome and that both runs ended in the same world.
+func (s Scenario) Run() error {
+	first := s.Play()
+	if s.Check != nil {
+		if err := s.Check(first); err != nil {
+			return err
+		}
+	}
+	if second := s.Play(); first.World.Digest() != second.World.Digest() {
+		return fmt.Errorf("two runs ended in different worlds")
+	}
+	return nil
+}
+
+// scenarioConfig is the default field without respawns so dead players stay dead.
+func scenarioConfig() Config {
+	config := DefaultConfig()
+	config.Seed = 1
+	config.RespawnTicks = 0
+	return config
+}
+
+// area is a rectangle of cells of one kind, painted on the scenario field by terrainConfig.
+type area struct {
+	kind                 byte
+	row, col, rows, cols int
+}
+
+// terrainConfig is the scenario field with the areas painted on it and open everywhere else.
+func terrainConfig(areas ...area) Config {
+	config := scenarioConfig()
+	grid := make([][]byte, int(config.Height/config.CellSize))
+	for row := range grid {
+		grid[row] = []byte(strings.Repeat(string(Open), int(config.Width/config.CellSize)))
+	}
+	for _, a := range areas {
+		for row := a.row; row < a.row+a.rows; row++ {
+			for col := a.col; col < a.col+a.cols; col++ {
+				grid[row][col] = a.kind
+			}
+		}
+	}
+	for _, row := range grid {
+		config.Terrain = append(config.Terrain, string(row))
+	}
+	return config
+}
+
+var scenarios = []Scenario{
+	{
+		// Out along row 10 to column 15, down to row 14, back to column 10 and up into the starting land
+		Name:   "walking a loop captures the trail and what it encloses",
+		Config: scenarioConfig(),
+		Setup: func(h *Harness) {
+			h.Place("a", 10, 10)
+			h.At(1, Move("a", "right"))
+			h.At(21, Move("a", "down"))
+			h.At(37, Move("a", "left"))
+			h.At(57, Move("a", "up"))
+		},
+		Ticks: 70,
+		Check: func(h *Harness) error {
+			if err := h.ExpectAlive("a", true); err != nil {
+				return err
+			}
+			capture, err := h.ExpectEvent(EventCapture, "a")
+			if err != nil {
+				return err
+			}
+			// 15 trail cells plus the 11 they enclose
+			if capture.Cells != 26 {
+				return fmt.Errorf("expected the capture to gain 26 cells, got %d", capture.Cells)
+			}
+			return h.ExpectOwned("a", 35)
+		},
+	},
+	{
+		Name:   "running into your own trail kills you",
+		Config: scenarioConfig(),
+		Setup: func(h *Harness) {
+			h.Place("a", 10, 10)
+			h.At(1, Move("a", "right"))
+			h.At(17, Move("a", "down"))
+			h.At(25, Move("a", "left"))
+			h.At(33, Move("a", "up"))
+		},
+		Ticks: 45,
+		Check: func(h *Harness) error {
+			if err := h.ExpectAlive("a", false); err != nil {
+				return err
+			}
+			death, err := h.ExpectEvent(EventDeath, "a")
+			if err != nil {
+				return err
+			}
+			if death.OtherID != "" || death.Cause != models.CauseTrail {
+				return fmt.Errorf("expected a death on the player's own trail, got %q by %q", death.Cause, death.OtherID)
+			}
+			return h.ExpectOwned("a", 0)
+		},
+	},
+	{
+		// a lays a trail along row 10, b walks up column 12 into it
+		Name:   "running into another trail kills the runner and credits the trail owner",
+		Config: scenarioConfig(),
+		Setup: func(h *Harness) {
+			h.Place("a", 10, 5)
+			h.Place("b", 14, 12)
+			h.At(1, Move("a", "right"))
+			h.At(30, Move("b", "up"))
+		},
+		Ticks: 50,
+		Check: func(h *Harness) error {
+			if err := h.ExpectAlive("b", false); err != nil {
+				return err
+			}
+			if err := h.ExpectAlive("a", true); err != nil {
+				return err
+			}
+			death, err := h.ExpectEvent(EventDeath, "b")
+			if err != nil {
+				return err
+			}
+			if death.OtherID != "a" || death.Cause != models.CauseKilled {
+				return fmt.Errorf("expected a to get the kill, got %q by %q", death.Cause, death.OtherID)
+			}
+			if err := h.ExpectKillStreak("a", 1); err != nil {
+				return err
+			}
+			// a's own 3x3 plus b's
+			return h.ExpectOwned("a", 18)
+		},
+	},
+	{
+		Name:   "walls stop players instead of killing them",
+		Config: scenarioConfig(),
+		Setup: func(h *Harness) {
+			h.Place("a", 1, 1)
+			h.At(1, Move("a", "up"))
+		},
+		Ticks: 20,
+		Check: func(h *Harness) error {
+			if err := h.ExpectAlive("a", true); err != nil {
+				return err
+			}
+			return h.ExpectCell("a", 0, 1)
+		},
+	},
+	{
+		Name:   "walls on a map stop players on the cell before them",
+		Config: terrainConfig(area{Wall, 0, 15, 30, 1}),
+		Setup: func(h *Harness) {
+			h.Place("a", 10, 10)
+			h.At(1, Move("a", "right"))
+		},
+		Ticks: 40,
+		Check: func(h *Harness) error {
+			if err := h.ExpectAlive("a", true); err != nil {
+				return err
+			}
+			return h.ExpectCell("a", 10, 14)
+		},
+	},
+	{
+		Name:   "hazards kill players who run into them",
+		Config: terrainConfig(area{Hazard, 0, 15, 30, 1}),
+		Setup: func(h *Harness) {
+			h.Place("a", 10, 10)
+			h.At(1, Move("a", "right"))
+		},
+		Ticks: 40,
+		Check: func(h *Harness) error {
+			if err := h.ExpectAlive("a", false); err != nil {
+				return err
+			}
+			death, err := h.ExpectEvent(EventDeath, "a")
+			if err != nil {
+				return err
+			}
+			if death.OtherID != "" || death.Cause != models.CauseHazard {
+				return fmt.Errorf("expected a death on the hazard, got %q by %q", death.Cause, death.OtherID)
+			}
+			return nil
+		},
+	},
+	{
+		// The loop of the first scenario around a 2x2 hole in the field
+		Name:   "a loop around a hole in the field captures everything but the hole",
+		Config: terrainConfig(area{Void, 12, 12, 2, 2}),
+		Setup: func(h *Harness) {
+			h.Place("a", 10, 10)
+			h.At(1, Move("a", "right"))
+			h.At(21, Move("a", "down"))
+			h.At(37, Move("a", "left"))
+			h.At(57, Move("a", "up"))
+		},
+		Ticks: 70,
+		Check: func(h *Harness) error {
+			capture, err := h.ExpectEvent(EventCapture, "a")
+			if err != nil {
+				return err
+			}
+			if capture.Cells != 22 {
+				return fmt.Errorf("expected the capture to gain 22 cells, got %d", capture.Cells)
+			}
+			return h.ExpectOwned("a", 31)
+		},
+	},
+	{
+		Name:   "players spawn in the map's spawn zones",
+		Config: terrainConfig(area{Wall, 0, 0, 30, 20}, area{Spawn, 20, 30, 5, 5}),
+		Setup: func(h *Harness) {
+			for _, id := range []string{"a", "b", "c"} {
+				h.Join(id)
+			}
+		},
+		Check: func(h *Harness) error {
+			for _, player := range h.World.Players() {
+				if row, col := h.World.cellOf(player.X, player.Y); row < 20 || row > 24 || col < 30 || col > 34 {
+					return fmt.Errorf("expected %s to spawn in the zone, got cell %d,%d", player.ID, row, col)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// a leaves its land, then an operator moves it mid-trail to the middle of the field
+		Name:   "a teleport moves a player to the cell under the destination and drops their trail",
+		Config: scenarioConfig(),
+		Setup: func(h *Harness) {
+			h.Place("a", 10, 10)
+			h.At(1, Move("a", "right"))
+			h.At(12, Input{PlayerID: "a", Direction: Teleport, X: 405, Y: 290})
+		},
+		Ticks: 20,
+		Check: func(h *Harness) error {
+			if err := h.ExpectAlive("a", true); err != nil {
+				return err
+			}
+			if err := h.ExpectCell("a", 15, 20); err != nil {
+				return err
+			}
+			if _, err := h.ExpectEvent(EventCapture, "a"); err == nil {
+				return fmt.Errorf("expected the dropped trail not to capture anything")
+			}
+			return h.ExpectOwned("a", 9)
+		},
+	},
+	{
+		Name:   "dead players respawn after the configured ticks",
+		Config: Config{Width: 800, Height: 600, CellSize: 20, MaxVelocity: 5, Seed: 1, RespawnTicks: 10},
+		Setup: func(h *Harness) {
+			h.Place("a", 10, 10)
+			h.At(1, Move("a", "right"))
+			h.At(17, Move("a", "down"))
+			h.At(25, Move("a", "left"))
+			h.At(33, Move("a", "up"))
+		},
+		Ticks: 60,
+		Check: func(h *Harness) error {
+			if _, err := h.ExpectEvent(EventRespawn, "a"); err != nil {
+				return err
+			}
+			if err := h.ExpectAlive("a", true); err != nil {
+				return err
+			}
+			return h.ExpectOwned("a", 9)
+		},
+	},
+	{
+		Name:   "the seed decides spawns and colours",
+		Config: scenarioConfig(),
+		Setup: func(h *Harness) {
+			for _, id := range []string{"a", "b", "c", "d"} {
+				h.Join(id)
+			}
+			h.At(1, Move("a", "up"), Move("b", "down"), Move("c", "left"), Move("d", "right"))
+		},
+		Ticks: 40,
+		Check: func(h *Harness) error {
+			joinAll := func(seed int64) string {
+				config := scenarioConfig()
+				config.Seed = seed
+				other := NewHarness(config)
+				for _, id := range []string{"a", "b", "c", "d"} {
+					other.Join(id)
+				}
+				return other.World.Digest()
+			}
+			if joinAll(1) != joinAll(1) {
+				return fmt.Errorf("the same seed spawned different worlds")
+			}
+			if joinAll(1) == joinAll(2) {
+				return fmt.Errorf("different seeds spawned the same world")
+			}
+			return nil
+		},
+	},
+	{
+		Name: "a field too small for a spawn area spawns players in its middle",
+		Config: func() Config {
+			config := scenarioConfig()
+			config.Width, config.Height = 2*config.CellSize, 2*config.CellSize
+			return config
+		}(),
+		Setup: func(h *Harness) {
+			h.Join("a")
+		},
+		Ticks: 1,
+		Check: func(h *Harness) error {
+			if err := h.ExpectAlive("a", true); err != nil {
+				return err
+			}
+			return h.ExpectCell("a", 1, 1)
+		},
+	},
+}
+
+// TestScenarios plays each scenario twice, checking how it ends and that both runs end in the same world.
+func TestScenarios(t *testing.T) {
+	for _, scenario := range scenarios {
+		t.Run(scenario.Name, func(t *testing.T) {
+			if err := scenario.Run(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
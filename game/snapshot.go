@@ -0,0 +1,133 @@
+// Package game snapshot.go saves and restores a world exactly, including where its random source is.
+package game
+
+import (
+	"math/rand"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// Snapshot is everything needed to rebuild a world so it plays on exactly as the original would.
+type Snapshot struct {
+	Config  Config           `json:"config"`
+	Tick    uint64           `json:"tick"`
+	RNG     uint64           `json:"rng"`
+	Players []PlayerSnapshot `json:"players"` // in join order
+}
+
+// PlayerSnapshot is a player with the fields the wire format leaves out.
+type PlayerSnapshot struct {
+	models.PlayerState
+	MaxVelocity     float64 `json:"maxVelocity"`
+	SpeedMultiplier float64 `json:"speedMultiplier"`
+	KillStreak      int     `json:"killStreak,omitempty"`
+	DiedAt          uint64  `json:"diedAt,omitempty"`
+}
+
+// SnapshotOf copies a player, the copy shares nothing with the original.
+func SnapshotOf(player *models.Player) PlayerSnapshot {
+	return PlayerSnapshot{
+		PlayerState: models.PlayerState{
+			ID:               player.ID,
+			StartingPosition: player.StartingPosition,
+			Name:             player.Name,
+			Color:            player.Color,
+			X:                player.X,
+			Y:                player.Y,
+			VelocityX:        player.VelocityX,
+			VelocityY:        player.VelocityY,
+			LandCapture:      copyGrid(player.LandCapture),
+			PlayerTrail:      append([]models.Point{}, player.PlayerTrail...),
+			StartingLand:     copyGrid(player.StartingLand),
+			IsAlive:          player.IsAlive,
+		},
+		MaxVelocity:     player.MaxVelocity,
+		SpeedMultiplier: player.SpeedMultiplier,
+		KillStreak:      player.KillStreak,
+	}
+}
+
+// Player builds a new player from the snapshot.
+func (s PlayerSnapshot) Player() *models.Player {
+	return &models.Player{
+		ID:               s.ID,
+		StartingPosition: s.StartingPosition,
+		Name:             s.Name,
+		Color:            s.Color,
+		X:                s.X,
+		Y:                s.Y,
+		VelocityX:        s.VelocityX,
+		VelocityY:        s.VelocityY,
+		MaxVelocity:      s.MaxVelocity,
+		LandCapture:      copyGrid(s.LandCapture),
+		PlayerTrail:      append([]models.Point{}, s.PlayerTrail...),
+		StartingLand:     copyGrid(s.StartingLand),
+		IsAlive:          s.IsAlive,
+		KillStreak:       s.KillStreak,
+		SpeedMultiplier:  s.SpeedMultiplier,
+	}
+}
+
+func (w *World) Snapshot() Snapshot {
+	snapshot := Snapshot{Config: w.Config, Tick: w.Tick, RNG: w.source.state}
+	for _, player := range w.Players() {
+		playerSnapshot := SnapshotOf(player)
+		playerSnapshot.DiedAt = w.diedAt[player.ID]
+		snapshot.Players = append(snapshot.Players, playerSnapshot)
+	}
+	return snapshot
+}
+
+// Restore builds a world from a snapshot.
+func Restore(snapshot Snapshot) *World {
+	w := NewWorld(snapshot.Config)
+	w.Tick = snapshot.Tick
+	w.source.state = snapshot.RNG
+	for _, playerSnapshot := range snapshot.Players {
+		player := playerSnapshot.Player()
+		w.order = append(w.order, player.ID)
+		w.players[player.ID] = player
+		if !player.IsAlive {
+			w.diedAt[player.ID] = playerSnapshot.DiedAt
+		}
+		w.ensureGrid(player)
+	}
+	return w
+}
+
+func copyGrid(grid [][]bool) [][]bool {
+	if grid == nil {
+		return nil
+	}
+	copied := make([][]bool, len(grid))
+	for row := range grid {
+		copied[row] = append([]bool{}, grid[row]...)
+	}
+	return copied
+}
+
+// source is a splitmix64 generator, its whole state is one number so snapshots can save it.
+type source struct {
+	state uint64
+}
+
+func newRand(seed int64) (*rand.Rand, *source) {
+	src := &source{state: uint64(seed)}
+	return rand.New(src), src
+}
+
+func (s *source) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *source) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *source) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
@@ -0,0 +1,87 @@
+// Package game terrain.go contains the cells of a map that aren't plain field: walls, hazards, the outside of a
+// non-rectangular field and the zones players spawn in.
+package game
+
+// Kinds of cell, written as these characters in a Terrain
+const (
+	Open   = '.'
+	Wall   = '#' // stops players like the edge of the field
+	Hazard = 'x' // kills players who run into it
+	Void   = ' ' // outside the playable area, stops players like a wall
+	Spawn  = 'S' // open, and when a terrain has any, players only spawn around these
+)
+
+// Terrain lays out the field one string per row of cells. Cells past the end of a row are Void, and a world
+// without terrain is open everywhere. Only Open and Spawn cells can be walked on and owned.
+type Terrain []string
+
+// At returns the kind of a cell, Void outside the terrain.
+func (t Terrain) At(row, col int) byte {
+	if row < 0 || row >= len(t) || col < 0 || col >= len(t[row]) {
+		return Void
+	}
+	return t[row][col]
+}
+
+// SpawnCells lists the cells a player can spawn around on a rows by cols field, in row order: cells whose 3x3
+// area can all be walked on, and that are spawn cells when the terrain has any. It is empty without terrain,
+// where players spawn anywhere.
+func (t Terrain) SpawnCells(rows, cols int) [][2]int {
+	if len(t) == 0 {
+		return nil
+	}
+	zoned := false
+	for _, line := range t {
+		for i := 0; i < len(line) && !zoned; i++ {
+			zoned = line[i] == Spawn
+		}
+	}
+
+	var cells [][2]int
+	for row := 1; row < rows-1; row++ {
+		for col := 1; col < cols-1; col++ {
+			if zoned && t.At(row, col) != Spawn {
+				continue
+			}
+			fits := true
+			for r := row - 1; r <= row+1 && fits; r++ {
+				for c := col - 1; c <= col+1 && fits; c++ {
+					fits = walkable(t.At(r, c))
+				}
+			}
+			if fits {
+				cells = append(cells, [2]int{row, col})
+			}
+		}
+	}
+	return cells
+}
+
+func walkable(cell byte) bool {
+	return cell == Open || cell == Spawn
+}
+
+// Playable reports whether a cell can be walked on and owned.
+func (w *World) Playable(row, col int) bool {
+	if row < 0 || row >= w.rows || col < 0 || col >= w.cols {
+		return false
+	}
+	return len(w.Config.Terrain) == 0 || walkable(w.Config.Terrain.At(row, col))
+}
+
+// Blocked reports whether a point is off the field or over a cell players can't walk on: a wall, a hazard or the
+// outside of the playable area. The cell is the one a player standing at the point is on.
+func (w *World) Blocked(x, y float64) bool {
+	if x < 0 || y < 0 || x >= w.Config.Width || y >= w.Config.Height {
+		return true
+	}
+	return !w.Playable(w.cellOf(x, y))
+}
+
+// cellAt returns the kind of a cell, Open everywhere without terrain.
+func (w *World) cellAt(row, col int) byte {
+	if len(w.Config.Terrain) == 0 {
+		return Open
+	}
+	return w.Config.Terrain.At(row, col)
+}
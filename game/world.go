@@ -0,0 +1,329 @@
+// Package game contains the simulation: movement, trails, territory capture and deaths.
+// It never reads the wall clock, touches the network or uses the global random source,
+// so the same seed and the same input stream always produce the same world.
+package game
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// Colors are handed out to players that don't bring their own.
+var Colors = []string{"#FF0000", "#00FF00", "#0000FF", "#FFFF00", "#00FFFF", "#FF00FF"}
+
+type Config struct {
+	Width        float64 `json:"width"`
+	Height       float64 `json:"height"`
+	CellSize     float64 `json:"cellSize"`    // players and territory cells are CellSize pixels square
+	MaxVelocity  float64 `json:"maxVelocity"` // pixels per tick
+	Seed         int64   `json:"seed"`
+	RespawnTicks uint64  `json:"respawnTicks"`      // ticks before a dead player respawns, 0 means never
+	Terrain      Terrain `json:"terrain,omitempty"` // walls, hazards and spawn zones, an open field when empty
+}
+
+// DefaultConfig is the classic 800x600 field with 20px cells.
+func DefaultConfig() Config {
+	return Config{
+		Width:        800,
+		Height:       600,
+		CellSize:     20,
+		MaxVelocity:  5,
+		RespawnTicks: 60,
+	}
+}
+
+// Teleport is the Direction of an input that moves a player to X, Y, sent by operators rather than players.
+const Teleport = "teleport"
+
+// Input is a direction change requested by a player for the next tick.
+type Input struct {
+	PlayerID  string  `json:"id"`
+	Direction string  `json:"direction"`
+	X         float64 `json:"x,omitempty"` // teleport destination
+	Y         float64 `json:"y,omitempty"`
+}
+
+type EventType string
+
+const (
+	EventJoin    EventType = "join"
+	EventLeave   EventType = "leave"
+	EventDeath   EventType = "death"
+	EventCapture EventType = "capture"
+	EventRespawn EventType = "respawn"
+)
+
+// Event is something that happened in the world during a tick.
+type Event struct {
+	Tick     uint64    `json:"tick"`
+	Type     EventType `json:"type"`
+	PlayerID string    `json:"playerId"`
+	OtherID  string    `json:"otherId,omitempty"` // the killer for deaths
+	Cause    string    `json:"cause,omitempty"`   // how a player died, one of the models.Cause constants
+	Cells    int       `json:"cells,omitempty"`   // cells gained by a capture
+}
+
+type World struct {
+	Config Config
+	Tick   uint64
+
+	rows    int
+	cols    int
+	players map[string]*models.Player
+	order   []string // join order, every loop over players uses it so results don't depend on map order
+	diedAt  map[string]uint64
+	spawns  [][2]int // the cells the terrain lets players spawn around, empty for anywhere
+	rng     *rand.Rand
+	source  *source // rng's state, kept for snapshots
+}
+
+func NewWorld(config Config) *World {
+	rng, source := newRand(config.Seed)
+	w := &World{
+		Config:  config,
+		rows:    int(config.Height / config.CellSize),
+		cols:    int(config.Width / config.CellSize),
+		players: make(map[string]*models.Player),
+		diedAt:  make(map[string]uint64),
+		rng:     rng,
+		source:  source,
+	}
+	w.spawns = config.Terrain.SpawnCells(w.rows, w.cols)
+	return w
+}
+
+// Rows and Cols are the size of the territory grid.
+func (w *World) Rows() int { return w.rows }
+func (w *World) Cols() int { return w.cols }
+
+// AddPlayer puts a player into the world. New and dead players spawn somewhere free, a resumed
+// player keeps their position and whatever of their land nobody else took in the meantime.
+func (w *World) AddPlayer(player *models.Player) Event {
+	w.register(player)
+	if player.IsAlive && CountOwned(player) > 0 && w.Playable(w.cellOf(player.X, player.Y)) {
+		for row := 0; row < w.rows; row++ {
+			for col := 0; col < w.cols; col++ {
+				if owner := w.OwnerAt(row, col); !w.Playable(row, col) || owner != nil && owner != player {
+					player.LandCapture[row][col] = false
+				}
+			}
+		}
+	} else {
+		w.spawn(player)
+	}
+	return Event{Tick: w.Tick, Type: EventJoin, PlayerID: player.ID}
+}
+
+// register adds a player to the world without placing them.
+func (w *World) register(player *models.Player) {
+	if _, ok := w.players[player.ID]; !ok {
+		w.order = append(w.order, player.ID)
+	}
+	w.players[player.ID] = player
+
+	if player.Color == "" {
+		player.Color = Colors[w.rng.Intn(len(Colors))]
+	}
+	if player.MaxVelocity == 0 {
+		player.MaxVelocity = w.Config.MaxVelocity
+	}
+	if player.SpeedMultiplier == 0 {
+		player.SpeedMultiplier = 1
+	}
+	w.ensureGrid(player)
+}
+
+// RemovePlayer takes a player out of the world, their land goes with them.
+func (w *World) RemovePlayer(id string) (Event, bool) {
+	if _, ok := w.players[id]; !ok {
+		return Event{}, false
+	}
+	delete(w.players, id)
+	delete(w.diedAt, id)
+	for i, playerID := range w.order {
+		if playerID == id {
+			w.order = append(w.order[:i], w.order[i+1:]...)
+			break
+		}
+	}
+	return Event{Tick: w.Tick, Type: EventLeave, PlayerID: id}, true
+}
+
+func (w *World) Player(id string) *models.Player {
+	return w.players[id]
+}
+
+// Players returns the players in join order.
+func (w *World) Players() []*models.Player {
+	players := make([]*models.Player, 0, len(w.order))
+	for _, id := range w.order {
+		players = append(players, w.players[id])
+	}
+	return players
+}
+
+// Step advances the world by one tick: inputs are applied in the order given, then every player moves in join order.
+func (w *World) Step(inputs []Input) []Event {
+	w.Tick++
+	var events []Event
+
+	for _, input := range inputs {
+		w.ApplyInput(input)
+	}
+	for _, id := range w.order {
+		w.ensureGrid(w.players[id])
+	}
+
+	for _, id := range w.order {
+		player := w.players[id]
+		if !player.IsAlive {
+			if w.Config.RespawnTicks > 0 && w.Tick-w.diedAt[id] >= w.Config.RespawnTicks {
+				w.spawn(player)
+				events = append(events, Event{Tick: w.Tick, Type: EventRespawn, PlayerID: id})
+			}
+			continue
+		}
+		w.move(player)
+		events = append(events, w.updateTrail(player)...)
+	}
+	return events
+}
+
+// Heading is the direction a player is moving in, empty when they are stopped.
+func Heading(player *models.Player) string {
+	switch {
+	case player.VelocityY < 0:
+		return "up"
+	case player.VelocityY > 0:
+		return "down"
+	case player.VelocityX < 0:
+		return "left"
+	case player.VelocityX > 0:
+		return "right"
+	}
+	return ""
+}
+
+// Reverse is the direction opposite to the given one, empty for anything that isn't a direction.
+func Reverse(direction string) string {
+	switch direction {
+	case "up":
+		return "down"
+	case "down":
+		return "up"
+	case "left":
+		return "right"
+	case "right":
+		return "left"
+	}
+	return ""
+}
+
+// ApplyInput turns or teleports a player, returning false if the input was ignored.
+func (w *World) ApplyInput(input Input) bool {
+	player := w.players[input.PlayerID]
+	if player == nil || !player.IsAlive {
+		return false
+	}
+
+	speed := player.MaxVelocity * player.SpeedMultiplier
+	switch input.Direction {
+	case "up":
+		player.VelocityX, player.VelocityY = 0, -speed
+	case "down":
+		player.VelocityX, player.VelocityY = 0, speed
+	case "left":
+		player.VelocityX, player.VelocityY = -speed, 0
+	case "right":
+		player.VelocityX, player.VelocityY = speed, 0
+	case Teleport:
+		// The player lands on the cell under the destination, stopped and without the trail they were drawing
+		row, col := w.cellOf(input.X, input.Y)
+		if !w.Playable(row, col) {
+			return false
+		}
+		position := w.pointOf(row, col)
+		player.X, player.Y = position.X, position.Y
+		player.VelocityX, player.VelocityY = 0, 0
+		player.PlayerTrail = []models.Point{}
+	default:
+		return false
+	}
+	return true
+}
+
+// OwnerAt returns the player owning a cell, or nil if it is neutral.
+func (w *World) OwnerAt(row, col int) *models.Player {
+	for _, id := range w.order {
+		player := w.players[id]
+		if owns(player, row, col) {
+			return player
+		}
+	}
+	return nil
+}
+
+// CountOwned returns how many cells a player owns.
+func CountOwned(player *models.Player) int {
+	count := 0
+	for _, row := range player.LandCapture {
+		for _, owned := range row {
+			if owned {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Digest is a hash of everything in the world, equal digests mean equal worlds.
+func (w *World) Digest() string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "tick %d\n", w.Tick)
+	for _, player := range w.Players() {
+		fmt.Fprintf(hash, "%s %s %v %v %v %v %v %d %v\n", player.ID, player.Color, player.X, player.Y,
+			player.VelocityX, player.VelocityY, player.IsAlive, player.KillStreak, player.PlayerTrail)
+		for _, row := range player.LandCapture {
+			fmt.Fprintf(hash, "%v", row)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ensureGrid makes sure a player's land grids match the world, keeping any cells that fit.
+func (w *World) ensureGrid(player *models.Player) {
+	player.LandCapture = w.resizeGrid(player.LandCapture)
+	player.StartingLand = w.resizeGrid(player.StartingLand)
+	if player.PlayerTrail == nil {
+		player.PlayerTrail = []models.Point{}
+	}
+}
+
+func (w *World) resizeGrid(grid [][]bool) [][]bool {
+	if len(grid) == w.rows {
+		fits := true
+		for _, row := range grid {
+			fits = fits && len(row) == w.cols
+		}
+		if fits {
+			return grid
+		}
+	}
+	resized := w.newGrid()
+	for row := 0; row < w.rows && row < len(grid); row++ {
+		copy(resized[row], grid[row])
+	}
+	return resized
+}
+
+func (w *World) newGrid() [][]bool {
+	grid := make([][]bool, w.rows)
+	for row := range grid {
+		grid[row] = make([]bool, w.cols)
+	}
+	return grid
+}
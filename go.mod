@@ -7,6 +7,10 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.1
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.22.0
 )
 
-require golang.org/x/net v0.24.0 // indirect
+require (
+	golang.org/x/net v0.24.0 // indirect
+	golang.org/x/sys v0.19.0 // indirect
+)
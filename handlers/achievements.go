@@ -0,0 +1,209 @@
+// Package handlers achievements.go awards achievements and XP from game events, with the rules read from a file.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// Metrics achievements can be defined on. Lifetime ones come from the profile, the others from the player's
+// current life.
+const (
+	MetricKills         = "kills"
+	MetricDeaths        = "deaths"
+	MetricCaptures      = "captures"
+	MetricCellsCaptured = "cellsCaptured"
+	MetricGames         = "games"
+	MetricSecondsPlayed = "secondsPlayed"
+	MetricStreak        = "streak"    // kills since the last death
+	MetricTerritory     = "territory" // percent of the field owned
+	MetricSurvival      = "survival"  // seconds since spawning
+)
+
+var achievementMetrics = []string{
+	MetricKills, MetricDeaths, MetricCaptures, MetricCellsCaptured, MetricGames, MetricSecondsPlayed,
+	MetricStreak, MetricTerritory, MetricSurvival,
+}
+
+// achievementConfig is the achievements file.
+type achievementConfig struct {
+	XP           map[string]int       `json:"xp"`     // XP for every "kill" and "capture"
+	Levels       []int                `json:"levels"` // XP needed for each level, starting at level 1
+	Achievements []models.Achievement `json:"achievements"`
+}
+
+// The achievements in play, set by LoadAchievements
+var achievements achievementConfig
+
+// liveMetrics are the metrics of a player's current life, taken while the room is locked.
+type liveMetrics struct {
+	streak    int
+	territory float64
+	survival  float64
+}
+
+// LoadAchievements reads the achievement rules from a JSON file, a missing file turns achievements off.
+func LoadAchievements(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info("No achievements file, achievements are off", "path", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var config achievementConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, achievement := range config.Achievements {
+		switch {
+		case achievement.ID == "":
+			return fmt.Errorf("%s: achievement %q has no id", path, achievement.Name)
+		case seen[achievement.ID]:
+			return fmt.Errorf("%s: achievement %s is defined twice", path, achievement.ID)
+		case !slices.Contains(achievementMetrics, achievement.Metric):
+			return fmt.Errorf("%s: achievement %s has unknown metric %q", path, achievement.ID, achievement.Metric)
+		case achievement.AtLeast <= 0:
+			return fmt.Errorf("%s: achievement %s needs a positive atLeast", path, achievement.ID)
+		}
+		seen[achievement.ID] = true
+	}
+	if !slices.IsSorted(config.Levels) {
+		return fmt.Errorf("%s: levels must go up", path)
+	}
+
+	achievements = config
+	slog.Info("Loaded achievements", "achievements", len(config.Achievements), "levels", len(config.Levels), "path", path)
+	return nil
+}
+
+// levelFor returns the level a player with the given XP is at, starting at 1.
+func levelFor(xp int) int {
+	level := 1
+	for i, needed := range achievements.Levels {
+		if xp >= needed {
+			level = i + 1
+		}
+	}
+	return level
+}
+
+func metricValue(metric string, stats models.ProfileStats, live liveMetrics) float64 {
+	switch metric {
+	case MetricKills:
+		return float64(stats.Kills)
+	case MetricDeaths:
+		return float64(stats.Deaths)
+	case MetricCaptures:
+		return float64(stats.Captures)
+	case MetricCellsCaptured:
+		return float64(stats.CellsCaptured)
+	case MetricGames:
+		return float64(stats.Games)
+	case MetricSecondsPlayed:
+		return stats.SecondsPlayed
+	case MetricStreak:
+		return float64(live.streak)
+	case MetricTerritory:
+		return live.territory
+	case MetricSurvival:
+		return live.survival
+	}
+	return 0
+}
+
+// awardProgress gives a player XP and any achievements they now qualify for, telling them about each one.
+func awardProgress(client *Client, xp int, live liveMetrics) {
+	if db == nil {
+		return
+	}
+	var profile models.Profile
+	var unlocked []models.Achievement
+	err := db.Update(profilesBucket, client.ID, &profile, func(exists bool) error {
+		if !exists {
+			return errUnchanged
+		}
+		now := time.Now().UTC()
+		for _, achievement := range achievements.Achievements {
+			if _, ok := profile.Achievements[achievement.ID]; ok {
+				continue
+			}
+			if metricValue(achievement.Metric, profile.Stats, live) >= achievement.AtLeast {
+				if profile.Achievements == nil {
+					profile.Achievements = make(map[string]time.Time)
+				}
+				profile.Achievements[achievement.ID] = now
+				xp += achievement.XP
+				unlocked = append(unlocked, achievement)
+			}
+		}
+		if xp == 0 && len(unlocked) == 0 {
+			return errUnchanged
+		}
+		profile.XP += xp
+		profile.Level = levelFor(profile.XP)
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errUnchanged) {
+			client.logger.Error("Error awarding progress", "err", err)
+		}
+		return
+	}
+
+	for _, achievement := range unlocked {
+		client.logger.Info("Unlocked achievement", "achievement", achievement.ID)
+		message, err := json.Marshal(models.UnlockInstruction{
+			Type:    "achievementUnlocked",
+			Payload: models.Unlock{Achievement: achievement, TotalXP: profile.XP, Level: profile.Level},
+		})
+		if err != nil {
+			client.logger.Error("Error marshalling achievement", "err", err)
+			continue
+		}
+		client.SendMessage(message)
+	}
+}
+
+// survivalUpdatesLocked checks the survival achievements of the humans alive in the room once a second, to run
+// once the room is unlocked. Captures and deaths are checked by the event subscriber. The caller must hold r.mu
+// and playersMutex.
+func (r *Room) survivalUpdatesLocked() []func() {
+	if len(achievements.Achievements) == 0 || r.world.Tick%TickRate != 0 {
+		return nil
+	}
+	var updates []func()
+	for id, client := range r.clients {
+		player := r.world.Player(id)
+		if player == nil || !player.IsAlive {
+			continue
+		}
+		live := liveMetrics{streak: player.KillStreak, territory: r.territoryPercent(player), survival: r.survivalSeconds(id)}
+		if live.survival > 0 {
+			updates = append(updates, func() {
+				awardProgress(client, 0, live)
+			})
+		}
+	}
+	return updates
+}
+
+// ListAchievements returns every achievement and the XP needed for each level.
+func ListAchievements(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, achievementConfig{
+		XP:           achievements.XP,
+		Levels:       achievements.Levels,
+		Achievements: append([]models.Achievement{}, achievements.Achievements...),
+	})
+}
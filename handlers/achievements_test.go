@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// useAchievements loads an achievements file for the test, the rules in play are put back when it ends.
+func useAchievements(t *testing.T, path string) {
+	t.Helper()
+	previous := achievements
+	t.Cleanup(func() { achievements = previous })
+	if err := LoadAchievements(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// withMetric returns the stats and live metrics of a player with value on the metric and nothing else.
+func withMetric(metric string, value float64) (models.ProfileStats, liveMetrics) {
+	var stats models.ProfileStats
+	var live liveMetrics
+	switch metric {
+	case MetricKills:
+		stats.Kills = int(value)
+	case MetricDeaths:
+		stats.Deaths = int(value)
+	case MetricCaptures:
+		stats.Captures = int(value)
+	case MetricCellsCaptured:
+		stats.CellsCaptured = int(value)
+	case MetricGames:
+		stats.Games = int(value)
+	case MetricSecondsPlayed:
+		stats.SecondsPlayed = value
+	case MetricStreak:
+		live.streak = int(value)
+	case MetricTerritory:
+		live.territory = value
+	case MetricSurvival:
+		live.survival = value
+	}
+	return stats, live
+}
+
+// progress awards a player with the stats and live metrics and returns the achievements they unlocked.
+func progress(t *testing.T, id string, stats models.ProfileStats, live liveMetrics) []string {
+	t.Helper()
+	if err := db.Put(profilesBucket, id, models.Profile{ID: id, Stats: stats}); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(nil, id, NewMessageQueue())
+	awardProgress(client, 0, live)
+	var profile models.Profile
+	if err := db.Get(profilesBucket, id, &profile); err != nil {
+		t.Fatal(err)
+	}
+	var unlocked []string
+	for id := range profile.Achievements {
+		unlocked = append(unlocked, id)
+	}
+	if len(client.Send) != len(unlocked) {
+		t.Fatalf("%s unlocked %v but was told about %d", id, unlocked, len(client.Send))
+	}
+	slices.Sort(unlocked)
+	return unlocked
+}
+
+// Every rule of the shipped file unlocks once its metric reaches atLeast, along with the easier ones on that metric.
+func TestAchievementsFileRules(t *testing.T) {
+	useStore(t)
+	useAchievements(t, filepath.Join("..", "achievements.json"))
+	if len(achievements.Achievements) == 0 {
+		t.Fatal("the achievements file has no achievements")
+	}
+
+	for _, achievement := range achievements.Achievements {
+		var want []string
+		for _, other := range achievements.Achievements {
+			if other.Metric == achievement.Metric && other.AtLeast <= achievement.AtLeast {
+				want = append(want, other.ID)
+			}
+		}
+		slices.Sort(want)
+		stats, live := withMetric(achievement.Metric, achievement.AtLeast)
+		if got := progress(t, "at-"+achievement.ID, stats, live); !slices.Equal(got, want) {
+			t.Errorf("%s: at %v %s expected %v, got %v", achievement.ID, achievement.AtLeast, achievement.Metric, want, got)
+		}
+		stats, live = withMetric(achievement.Metric, achievement.AtLeast-1)
+		if got := progress(t, "below-"+achievement.ID, stats, live); slices.Contains(got, achievement.ID) {
+			t.Errorf("%s: unlocked just below %v %s", achievement.ID, achievement.AtLeast, achievement.Metric)
+		}
+	}
+}
+
+// An achievement is unlocked once, and its XP counts towards the player's level.
+func TestAchievementsUnlockOnceWithXP(t *testing.T) {
+	useStore(t)
+	useAchievements(t, filepath.Join("..", "achievements.json"))
+	stats, live := withMetric(MetricKills, 1)
+	if got := progress(t, "p", stats, live); !slices.Equal(got, []string{"first-blood"}) {
+		t.Fatalf("expected first-blood, got %v", got)
+	}
+
+	client := NewClient(nil, "p", NewMessageQueue())
+	awardProgress(client, achievements.XP["kill"], live)
+	var profile models.Profile
+	if err := db.Get(profilesBucket, "p", &profile); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.Send) != 0 {
+		t.Fatal("first-blood was unlocked again")
+	}
+	want := achievements.Achievements[0].XP + achievements.XP["kill"]
+	if profile.XP != want || profile.Level != levelFor(want) {
+		t.Fatalf("expected %d XP at level %d, got %d at %d", want, levelFor(want), profile.XP, profile.Level)
+	}
+}
+
+func TestLevelFor(t *testing.T) {
+	previous := achievements
+	t.Cleanup(func() { achievements = previous })
+	achievements = achievementConfig{Levels: []int{0, 100, 250}}
+	for xp, want := range map[int]int{0: 1, 99: 1, 100: 2, 249: 2, 250: 3, 10000: 3} {
+		if got := levelFor(xp); got != want {
+			t.Errorf("%d XP: expected level %d, got %d", xp, want, got)
+		}
+	}
+}
+
+func TestLoadAchievementsRejectsBadRules(t *testing.T) {
+	for _, test := range []struct {
+		name, file, err string
+	}{
+		{"no id", `{"achievements": [{"name": "A", "metric": "kills", "atLeast": 1}]}`, "has no id"},
+		{"twice", `{"achievements": [{"id": "a", "metric": "kills", "atLeast": 1}, {"id": "a", "metric": "kills", "atLeast": 2}]}`,
+			"defined twice"},
+		{"unknown metric", `{"achievements": [{"id": "a", "metric": "jumps", "atLeast": 1}]}`, `unknown metric "jumps"`},
+		{"no threshold", `{"achievements": [{"id": "a", "metric": "kills"}]}`, "positive atLeast"},
+		{"levels going down", `{"levels": [0, 100, 50]}`, "levels must go up"},
+		{"not JSON", `achievements`, "invalid character"},
+	} {
+		path := filepath.Join(t.TempDir(), "achievements.json")
+		if err := os.WriteFile(path, []byte(test.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		previous := achievements
+		err := LoadAchievements(path)
+		achievements = previous
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	// A missing file turns achievements off without an error
+	previous := achievements
+	t.Cleanup(func() { achievements = previous })
+	achievements = achievementConfig{}
+	if err := LoadAchievements(filepath.Join(t.TempDir(), "missing.json")); err != nil || len(achievements.Achievements) != 0 {
+		t.Fatalf("expected no achievements and no error for a missing file, got %v", err)
+	}
+}
@@ -0,0 +1,566 @@
+// Package handlers admin.go contains the operator endpoints, guarded by the ADMIN_TOKEN environment variable.
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/logging"
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/store"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/gorilla/websocket"
+)
+
+// logLevelRequest is the body of GET and PUT /admin/loglevel.
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// RequireAdmin lets a request through only with the ADMIN_TOKEN as its bearer token. The admin endpoints
+// are off while ADMIN_TOKEN is unset.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminToken := os.Getenv("ADMIN_TOKEN")
+		if adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			slog.Warn("Rejected admin request", "path", r.URL.Path, "remote", r.RemoteAddr,
+				"request", middleware.GetReqID(r.Context()))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "admin token required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetLogLevel returns the minimum level the server logs at.
+func GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevelRequest{Level: strings.ToLower(logging.Level.Level().String())})
+}
+
+// SetLogLevel changes the minimum level the server logs at until it restarts.
+func SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var request logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := logging.SetLevel(request.Level); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("Changed log level", "level", logging.Level.Level(), "request", middleware.GetReqID(r.Context()))
+	adminAudit(r, "logLevel", "", "", request.Level)
+	GetLogLevel(w, r)
+}
+
+// adminRequest is the body of the admin actions, each reads the fields it needs.
+type adminRequest struct {
+	Reason   string   `json:"reason"`
+	Name     string   `json:"name"`
+	Message  string   `json:"message"`
+	X        float64  `json:"x"`
+	Y        float64  `json:"y"`
+	Kind     string   `json:"kind"`
+	Target   string   `json:"target"`
+	Duration string   `json:"duration"` // how long a ban lasts, such as 24h, for good when empty
+	URL      string   `json:"url"`
+	Events   []string `json:"events"`
+	Secret   string   `json:"secret"`
+	Map      string   `json:"map"`
+}
+
+// banDuration parses the request's ban duration, an empty one is 0 for a permanent ban.
+func (request adminRequest) banDuration() (time.Duration, error) {
+	if request.Duration == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(request.Duration)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive, such as 30m or 24h", request.Duration)
+	}
+	return duration, nil
+}
+
+// readAdminRequest decodes an admin action's body, an empty body leaves every field unset.
+func readAdminRequest(w http.ResponseWriter, r *http.Request) (adminRequest, bool) {
+	var request adminRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return request, false
+	}
+	return request, true
+}
+
+// adminLogger is the logger for an admin action, carrying the request ID.
+func adminLogger(r *http.Request) *slog.Logger {
+	return slog.With("request", middleware.GetReqID(r.Context()), "admin", true, "by", adminName(r))
+}
+
+// adminName is who is behind an admin request, as they say in the X-Admin-Name header. Everyone shares the
+// admin token, so it is only as honest as the admins are.
+func adminName(r *http.Request) string {
+	name := strings.TrimSpace(r.Header.Get("X-Admin-Name"))
+	if name == "" {
+		return "admin"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// adminAudit adds an admin action to the audit log.
+func adminAudit(r *http.Request, action, target, reason, detail string) {
+	audit(models.AuditEntry{
+		By:      adminName(r),
+		Action:  action,
+		Target:  target,
+		Reason:  reason,
+		Detail:  detail,
+		Remote:  r.RemoteAddr,
+		Request: middleware.GetReqID(r.Context()),
+	})
+}
+
+// AdminPage serves the admin dashboard, which asks for the admin token and calls the admin API with it.
+func AdminPage(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ADMIN_TOKEN") == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, "templates/admin.html")
+}
+
+// ListAdminRooms lists the rooms with their players, bots and spectators.
+func ListAdminRooms(w http.ResponseWriter, r *http.Request) {
+	list := []models.RoomInfo{}
+	for _, room := range roomList() {
+		list = append(list, room.info())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// ListAdminClients lists the connected players with their address, round trip time and queued messages.
+func ListAdminClients(w http.ResponseWriter, r *http.Request) {
+	clientsMutex.Lock()
+	connected := make([]*Client, 0, len(clients))
+	for _, client := range clients {
+		connected = append(connected, client)
+	}
+	clientsMutex.Unlock()
+
+	list := make([]models.ClientInfo, 0, len(connected))
+	for _, client := range connected {
+		info := models.ClientInfo{
+			ID:         client.ID,
+			RemoteAddr: client.RemoteAddr,
+			RTT:        float64(client.rtt.Load()) / float64(time.Millisecond),
+			SendQueue:  len(client.Send),
+			Overflow:   client.messageQueue.QueueSize(client.ID),
+			Joined:     client.Joined,
+			Muted:      isMuted(client.ID),
+		}
+		if client.Room != nil {
+			info.RoomID = client.Room.ID
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Joined.Before(list[j].Joined) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// ListAdminPlayers lists every player in every room, bots included, with where they are and how they are doing.
+func ListAdminPlayers(w http.ResponseWriter, r *http.Request) {
+	list := []models.PlayerInfo{}
+	for _, room := range roomList() {
+		list = append(list, room.playerInfos()...)
+	}
+	if db != nil {
+		for i := range list {
+			var strikes models.StrikeLog
+			if db.Get(strikesBucket, list[i].ID, &strikes) == nil {
+				list[i].Strikes = strikes.Total
+				list[i].Flagged = strikes.Flagged != nil && time.Since(*strikes.Flagged) < StrikeWindow
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].RoomID != list[j].RoomID {
+			return list[i].RoomID < list[j].RoomID
+		}
+		return list[i].Territory > list[j].Territory
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+// KickClient disconnects a player, who can come straight back unless they are also banned.
+func KickClient(w http.ResponseWriter, r *http.Request) {
+	request, ok := readAdminRequest(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if !kick(id, request.Reason) {
+		http.NotFound(w, r)
+		return
+	}
+	adminLogger(r).Info("Kicked player", "client", id, "reason", request.Reason)
+	adminAudit(r, "kick", id, request.Reason, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// kick sends a connected player a kicked signal and closes their connection, returning false if they are not connected.
+func kick(playerID, reason string) bool {
+	clientsMutex.Lock()
+	client, ok := clients[playerID]
+	clientsMutex.Unlock()
+	if !ok || client.Conn == nil {
+		return false
+	}
+	if reason == "" {
+		reason = "kicked by an admin"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	go func() {
+		defer cancel()
+		client.disconnect(ctx, SignalMessage{Type: "kicked", Content: reason}, websocket.ClosePolicyViolation, "kicked")
+	}()
+	return true
+}
+
+// BanPlayer bans a player's client ID, for good or for the request's duration, and kicks them if they are connected.
+func BanPlayer(w http.ResponseWriter, r *http.Request) {
+	request, ok := readAdminRequest(w, r)
+	if !ok {
+		return
+	}
+	request.Kind, request.Target = BanClient, chi.URLParam(r, "id")
+	createBan(w, r, request)
+}
+
+// UnbanPlayer lifts every ban on a player's client ID.
+func UnbanPlayer(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	found := bansOn(BanClient, id)
+	if len(found) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	for _, ban := range found {
+		if _, err := liftBan(ban.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		adminAudit(r, "unban", ban.Kind+":"+ban.Target, "", ban.ID)
+	}
+	adminLogger(r).Info("Unbanned player", "player", id, "bans", len(found))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListBans lists the bans in force, oldest first.
+func ListBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, listBans(time.Now()))
+}
+
+// CreateBan bans a client ID, an account or an address or CIDR range, and kicks the connected players it applies to.
+func CreateBan(w http.ResponseWriter, r *http.Request) {
+	request, ok := readAdminRequest(w, r)
+	if !ok {
+		return
+	}
+	createBan(w, r, request)
+}
+
+func createBan(w http.ResponseWriter, r *http.Request, request adminRequest) {
+	duration, err := request.banDuration()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ban, err := addBan(request.Kind, request.Target, request.Reason, adminName(r), duration)
+	switch {
+	case err != nil && db == nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kicked := enforceBan(ban)
+	publish(Event{Type: EventTypeBan, Ban: &ban})
+	detail := "permanent"
+	if ban.Expires != nil {
+		detail = "for " + duration.String()
+	}
+	adminAudit(r, "ban", ban.Kind+":"+ban.Target, ban.Reason, detail)
+	adminLogger(r).Info("Banned", "kind", ban.Kind, "target", ban.Target, "reason", ban.Reason, "expires", ban.Expires, "kicked", kicked)
+	writeJSON(w, http.StatusCreated, ban)
+}
+
+// LiftBan lifts a ban by its ID.
+func LiftBan(w http.ResponseWriter, r *http.Request) {
+	ban, err := liftBan(chi.URLParam(r, "id"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	adminAudit(r, "unban", ban.Kind+":"+ban.Target, "", ban.ID)
+	adminLogger(r).Info("Lifted ban", "kind", ban.Kind, "target", ban.Target)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAudit returns the latest entries of the audit log, newest first, 100 unless the limit parameter says otherwise.
+func ListAudit(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	entries, err := auditLog(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// MutePlayer drops a player's chat messages until they are unmuted.
+func MutePlayer(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := setMuted(id, true); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	adminAudit(r, "mute", id, "", "")
+	adminLogger(r).Info("Muted player", "player", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnmutePlayer lets a muted player chat again.
+func UnmutePlayer(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := setMuted(id, false); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	adminAudit(r, "unmute", id, "", "")
+	adminLogger(r).Info("Unmuted player", "player", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RenamePlayer changes a player's display name, in their profile if they have one and in the room they are in.
+func RenamePlayer(w http.ResponseWriter, r *http.Request) {
+	request, ok := readAdminRequest(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	name, err := validateName(request.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	saved := false
+	if db != nil {
+		_, err = saveProfile(id, models.ProfileUpdate{Name: name})
+		saved = err == nil
+		switch {
+		case errors.Is(err, ErrNameTaken):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil && !errors.Is(err, store.ErrNotFound):
+			// Bots and players without a profile are only renamed in their room
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	room, player := locatePlayer(id)
+	if room == nil && !saved {
+		http.NotFound(w, r)
+		return
+	}
+	if room != nil {
+		playersMutex.Lock()
+		player.Name = name
+		playersMutex.Unlock()
+		room.broadcastPlayerUpdate(player)
+	}
+	adminAudit(r, "rename", id, "", name)
+	adminLogger(r).Info("Renamed player", "player", id, "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TeleportPlayer moves a living player to a point on their room's field on its next tick.
+func TeleportPlayer(w http.ResponseWriter, r *http.Request) {
+	request, ok := readAdminRequest(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	room, _ := locatePlayer(id)
+	if room == nil {
+		http.NotFound(w, r)
+		return
+	}
+	field := room.field()
+	if request.X < 0 || request.Y < 0 || request.X >= field.Width || request.Y >= field.Height {
+		http.Error(w, fmt.Sprintf("x and y must be within the %gx%g field", field.Width, field.Height), http.StatusBadRequest)
+		return
+	}
+	if room.blocked(request.X, request.Y) {
+		http.Error(w, "x and y are on a wall, a hazard or outside the playable field", http.StatusBadRequest)
+		return
+	}
+	room.queueInput(game.Input{PlayerID: id, Direction: game.Teleport, X: request.X, Y: request.Y})
+	adminAudit(r, "teleport", id, "", fmt.Sprintf("%g, %g", request.X, request.Y))
+	adminLogger(r).Info("Teleported player", "player", id, "room", room.ID, "x", request.X, "y", request.Y)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetRoom ends a room's match and starts it over on a fresh field: the map named in the request, or the next
+// map of the room's rotation.
+func ResetRoom(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	roomsMutex.Lock()
+	room, ok := rooms[id]
+	roomsMutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	request, ok := readAdminRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := lookupMap(request.Map); request.Map != "" && !ok {
+		http.Error(w, fmt.Sprintf("there is no map %q", request.Map), http.StatusBadRequest)
+		return
+	}
+	room.reset(request.Map)
+	adminAudit(r, "resetRoom", id, "", request.Map)
+	adminLogger(r).Info("Reset room", "room", id, "map", room.currentMap())
+	writeJSON(w, http.StatusOK, room.info())
+}
+
+// Announce sends every player and spectator on the server an announcement.
+func Announce(w http.ResponseWriter, r *http.Request) {
+	request, ok := readAdminRequest(w, r)
+	if !ok {
+		return
+	}
+	text := strings.TrimSpace(request.Message)
+	if text == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+	message, err := json.Marshal(SignalMessage{Type: "announcement", Content: text})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, room := range roomList() {
+		room.broadcastEveryone(message)
+	}
+	adminAudit(r, "announce", "", "", text)
+	adminLogger(r).Info("Sent announcement", "message", text)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// locatePlayer finds the room a player is in and their player, or returns a nil room.
+func locatePlayer(playerID string) (*Room, *models.Player) {
+	for _, room := range roomList() {
+		room.mu.Lock()
+		var player *models.Player
+		if client, ok := room.clients[playerID]; ok {
+			player = client.Player
+		} else if bot, ok := room.bots[playerID]; ok {
+			player = bot.Player
+		}
+		room.mu.Unlock()
+		if player != nil {
+			return room, player
+		}
+	}
+	return nil, nil
+}
+
+// info summarises the room for the admin API.
+func (r *Room) info() models.RoomInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info := models.RoomInfo{
+		ID:         r.ID,
+		Capacity:   r.Capacity,
+		Seed:       r.Seed,
+		Tick:       r.world.Tick,
+		Width:      r.world.Config.Width,
+		Height:     r.world.Config.Height,
+		Map:        r.mapNameLocked(),
+		Clients:    len(r.clients),
+		Bots:       len(r.bots),
+		Spectators: len(r.spectators),
+	}
+	if r.match != nil {
+		info.MatchID = r.match.match.ID
+	}
+	if r.recorder != nil {
+		info.Replay = r.recorder.Name
+	}
+	return info
+}
+
+// playerInfos describes the room's players for the admin API.
+func (r *Room) playerInfos() []models.PlayerInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	playersMutex.Lock()
+	defer playersMutex.Unlock()
+
+	list := make([]models.PlayerInfo, 0, len(r.clients)+len(r.bots))
+	for _, player := range r.playersLocked() {
+		_, bot := r.bots[player.ID]
+		list = append(list, models.PlayerInfo{
+			ID:        player.ID,
+			Name:      player.Name,
+			RoomID:    r.ID,
+			Bot:       bot,
+			Alive:     player.IsAlive,
+			X:         player.X,
+			Y:         player.Y,
+			Territory: r.territoryPercent(player),
+			Streak:    player.KillStreak,
+		})
+	}
+	return list
+}
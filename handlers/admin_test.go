@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/go-chi/chi"
+)
+
+// useRoom registers a room for the admin API to find, it is taken out again when the test ends.
+func useRoom(t *testing.T, room *Room) {
+	roomsMutex.Lock()
+	rooms[room.ID] = room
+	roomsMutex.Unlock()
+	t.Cleanup(func() {
+		roomsMutex.Lock()
+		delete(rooms, room.ID)
+		roomsMutex.Unlock()
+	})
+}
+
+// admin calls an admin handler on the player or room id with a JSON body and returns the status code.
+func admin(handler http.HandlerFunc, id, body string) int {
+	request := httptest.NewRequest(http.MethodPost, "/admin/api/"+id, strings.NewReader(body))
+	params := chi.NewRouteContext()
+	params.URLParams.Add("id", id)
+	request = request.WithContext(context.WithValue(request.Context(), chi.RouteCtxKey, params))
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder.Code
+}
+
+func TestRequireAdmin(t *testing.T) {
+	handler := RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	call := func(authorization string) int {
+		request := httptest.NewRequest(http.MethodGet, "/admin/api/rooms", nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	t.Setenv("ADMIN_TOKEN", "")
+	if code := call("Bearer "); code != http.StatusNotFound {
+		t.Fatalf("expected the admin API to be off without ADMIN_TOKEN, got %d", code)
+	}
+	t.Setenv("ADMIN_TOKEN", "secret")
+	for authorization, want := range map[string]int{
+		"":               http.StatusUnauthorized,
+		"Bearer wrong":   http.StatusUnauthorized,
+		"secret":         http.StatusUnauthorized,
+		"Basic secret":   http.StatusUnauthorized,
+		"Bearer secret":  http.StatusNoContent,
+		"Bearer secret ": http.StatusUnauthorized,
+	} {
+		if code := call(authorization); code != want {
+			t.Errorf("%q: expected %d, got %d", authorization, want, code)
+		}
+	}
+}
+
+func TestTeleportPlayer(t *testing.T) {
+	useStore(t)
+	room := movingRoom(t, "p")
+	room.clients["p"] = &Client{ID: "p", Player: room.world.Player("p")}
+	useRoom(t, room)
+	field := room.field()
+
+	for _, test := range []struct {
+		id, body string
+		want     int
+	}{
+		{"nobody", `{"x": 10, "y": 10}`, http.StatusNotFound},
+		{"p", `{"x": -1, "y": 10}`, http.StatusBadRequest},
+		{"p", `{"x": 10, "y": 1e9}`, http.StatusBadRequest},
+		{"p", `{"x": 10,`, http.StatusBadRequest},
+		{"p", `{"x": 10, "y": 10}`, http.StatusNoContent},
+	} {
+		if code := admin(TeleportPlayer, test.id, test.body); code != test.want {
+			t.Errorf("teleporting %s to %s in a %gx%g field: expected %d, got %d", test.id, test.body, field.Width,
+				field.Height, test.want, code)
+		}
+	}
+	want := game.Input{PlayerID: "p", Direction: game.Teleport, X: 10, Y: 10}
+	if len(room.inputs) != 1 || room.inputs[0] != want {
+		t.Fatalf("expected only %v queued, got %v", want, room.inputs)
+	}
+}
+
+// A player without a profile is renamed in their room.
+func TestRenamePlayer(t *testing.T) {
+	useStore(t)
+	room := movingRoom(t, "p")
+	player := room.world.Player("p")
+	room.clients["p"] = &Client{ID: "p", Player: player}
+	useRoom(t, room)
+
+	if code := admin(RenamePlayer, "p", `{"name": ""}`); code != http.StatusBadRequest {
+		t.Errorf("expected an empty name to be refused, got %d", code)
+	}
+	if code := admin(RenamePlayer, "nobody", `{"name": "Ann"}`); code != http.StatusNotFound {
+		t.Errorf("expected a player who isn't anywhere to be missing, got %d", code)
+	}
+	if code := admin(RenamePlayer, "p", `{"name": "Ann"}`); code != http.StatusNoContent {
+		t.Fatalf("renaming got %d", code)
+	}
+	playersMutex.Lock()
+	name := player.Name
+	playersMutex.Unlock()
+	if name != "Ann" {
+		t.Fatalf("expected the player to be called Ann, got %q", name)
+	}
+}
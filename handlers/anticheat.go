@@ -0,0 +1,114 @@
+// Package handlers anticheat.go checks what players send against the game rules and keeps a log of the strikes
+// they earn. The server never takes a client's word for its own state, only for the direction it wants to turn.
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/store"
+	"github.com/go-chi/chi"
+)
+
+const (
+	strikesBucket = "strikes" // player ID to their StrikeLog
+
+	MaxStrikeLog      = 100         // strikes listed per player, older ones only count towards the total
+	MaxInputsPerTick  = 2           // turns a player can queue for one tick
+	SuspiciousStrikes = 30          // strikes within StrikeWindow that flag a player as a likely cheater
+	StrikeWindow      = time.Minute // how far back strikes count towards flagging
+)
+
+// Kinds of strike
+const (
+	strikeReversal      = "reversal"      // turning straight back
+	strikeInputRate     = "inputRate"     // more turns than MaxInputsPerTick before a tick
+	strikeInvalidInput  = "invalidInput"  // a direction that doesn't exist
+	strikeImpersonation = "impersonation" // an input for another player
+	strikeClientState   = "clientState"   // claiming land, a position or a trail
+)
+
+// recordStrike logs a broken rule to the player's strike log, flagging them once they earn SuspiciousStrikes
+// within StrikeWindow.
+func recordStrike(client *Client, kind, detail string) {
+	anticheatStrikes.With(kind).Inc()
+	client.logger.Info("Anti-cheat strike", "kind", kind, "detail", detail)
+	if db == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	flagged := false
+	var log models.StrikeLog
+	err := db.Update(strikesBucket, client.ID, &log, func(bool) error {
+		log.PlayerID = client.ID
+		log.Total++
+		log.Strikes = append(log.Strikes, models.Strike{Kind: kind, Detail: detail, At: now})
+		if len(log.Strikes) > MaxStrikeLog {
+			log.Strikes = log.Strikes[len(log.Strikes)-MaxStrikeLog:]
+		}
+		recent := 0
+		for _, strike := range log.Strikes {
+			if now.Sub(strike.At) < StrikeWindow {
+				recent++
+			}
+		}
+		if recent >= SuspiciousStrikes && (log.Flagged == nil || now.Sub(*log.Flagged) >= StrikeWindow) {
+			log.Flagged = &now
+			flagged = true
+		}
+		return nil
+	})
+	if err != nil {
+		client.logger.Error("Error recording strike", "err", err)
+		return
+	}
+	if flagged {
+		playersFlagged.Inc()
+		client.logger.Warn("Player flagged as a likely cheater", "strikes", SuspiciousStrikes, "window", StrikeWindow,
+			"total", log.Total)
+	}
+}
+
+// assertsState reports whether a move carries state only the server decides, which honest clients never send.
+func assertsState(state models.PlayerState) bool {
+	return state.LandCapture != nil || state.StartingLand != nil || state.PlayerTrail != nil ||
+		state.X != 0 || state.Y != 0 || state.VelocityX != 0 || state.VelocityY != 0 || state.IsAlive
+}
+
+// GetStrikes returns a player's strike log.
+func GetStrikes(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "strike logs need the store", http.StatusServiceUnavailable)
+		return
+	}
+	var log models.StrikeLog
+	err := db.Get(strikesBucket, chi.URLParam(r, "id"), &log)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, log)
+}
+
+// ClearStrikes deletes a player's strike log, once an admin has looked into it.
+func ClearStrikes(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "strike logs need the store", http.StatusServiceUnavailable)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if err := db.Delete(strikesBucket, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	adminAudit(r, "clearStrikes", id, "", "")
+	adminLogger(r).Info("Cleared strikes", "player", id)
+	w.WriteHeader(http.StatusNoContent)
+}
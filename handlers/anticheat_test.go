@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"log/slog"
+	"math/rand"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/bots"
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// movingRoom returns a room with one player in it heading right.
+func movingRoom(t *testing.T, playerID string) *Room {
+	t.Helper()
+	room := NewRoom("moves", 4, 1, config.Default().Gameplay)
+	player := newPlayer(playerID, nil)
+	room.mu.Lock()
+	playersMutex.Lock()
+	room.addPlayerLocked(player)
+	player.VelocityX, player.VelocityY = 1, 0
+	playersMutex.Unlock()
+	room.mu.Unlock()
+	return room
+}
+
+func strikeLog(t *testing.T, playerID string) models.StrikeLog {
+	t.Helper()
+	var log models.StrikeLog
+	if err := db.Get(strikesBucket, playerID, &log); err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+func TestAssertsState(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		state models.PlayerState
+		want  bool
+	}{
+		{"a turn", models.PlayerState{ID: "p", Direction: "up"}, false},
+		{"a position", models.PlayerState{Direction: "up", X: 40}, true},
+		{"a velocity", models.PlayerState{Direction: "up", VelocityY: -1}, true},
+		{"being alive", models.PlayerState{Direction: "up", IsAlive: true}, true},
+		{"land", models.PlayerState{Direction: "up", LandCapture: [][]bool{}}, true},
+		{"a trail", models.PlayerState{Direction: "up", PlayerTrail: []models.Point{{X: 1, Y: 1}}}, true},
+	} {
+		if got := assertsState(test.state); got != test.want {
+			t.Errorf("%s: expected assertsState=%v", test.name, test.want)
+		}
+	}
+}
+
+func TestQueueMoveFollowsTheMovementRules(t *testing.T) {
+	room := movingRoom(t, "p")
+	for _, test := range []struct {
+		direction, strike string
+	}{
+		{"right", ""},            // the way the player is heading, dropped
+		{"left", strikeReversal}, // straight back
+		{"up", ""},               // queued
+		{"down", strikeReversal}, // back from the queued turn
+		{"right", ""},            // queued, the second for this tick
+		{"up", strikeInputRate},  // one too many
+	} {
+		if strike := room.queueMove("p", test.direction); strike != test.strike {
+			t.Errorf("turning %s: expected strike %q, got %q", test.direction, test.strike, strike)
+		}
+	}
+	want := []game.Input{{PlayerID: "p", Direction: "up"}, {PlayerID: "p", Direction: "right"}}
+	if len(room.inputs) != len(want) || room.inputs[0] != want[0] || room.inputs[1] != want[1] {
+		t.Fatalf("expected inputs %v, got %v", want, room.inputs)
+	}
+}
+
+func TestMovesThatBreakTheRulesAreLoggedAndFlagged(t *testing.T) {
+	useStore(t)
+	room := movingRoom(t, "p")
+	client := &Client{ID: "p", Room: room, logger: slog.Default()}
+	move := func(state models.PlayerState) {
+		handleMoveMessage(client, models.RenderInstruction{Type: "move", Payload: state})
+	}
+
+	move(models.PlayerState{Direction: "left"})
+	move(models.PlayerState{ID: "someone", Direction: "up"})
+	move(models.PlayerState{Direction: "up", IsAlive: true})
+	move(models.PlayerState{Direction: "sideways"})
+	log := strikeLog(t, "p")
+	kinds := []string{strikeReversal, strikeImpersonation, strikeClientState, strikeInvalidInput}
+	if log.Total != len(kinds) || len(log.Strikes) != len(kinds) || log.Flagged != nil {
+		t.Fatalf("expected %d strikes and no flag, got %+v", len(kinds), log)
+	}
+	for i, kind := range kinds {
+		if log.Strikes[i].Kind != kind {
+			t.Errorf("strike %d: expected %s, got %s", i+1, kind, log.Strikes[i].Kind)
+		}
+	}
+
+	for i := len(kinds); i < SuspiciousStrikes-1; i++ {
+		move(models.PlayerState{Direction: "sideways"})
+	}
+	if log := strikeLog(t, "p"); log.Flagged != nil {
+		t.Fatalf("flagged after %d strikes", log.Total)
+	}
+	move(models.PlayerState{Direction: "sideways"})
+	if log := strikeLog(t, "p"); log.Flagged == nil || log.Total != SuspiciousStrikes {
+		t.Fatalf("expected a flag after %d strikes, got %+v", SuspiciousStrikes, log)
+	}
+}
+
+// reverser always turns straight back.
+type reverser struct{}
+
+func (reverser) Name() string { return "reverser" }
+
+func (reverser) Next(view bots.View, _ bots.Difficulty, _ *rand.Rand) string {
+	player := models.Player{VelocityX: view.Self.VelocityX, VelocityY: view.Self.VelocityY}
+	return game.Reverse(game.Heading(&player))
+}
+
+func TestBotsCantTurnStraightBack(t *testing.T) {
+	room := movingRoom(t, "bot-moves-1")
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	playersMutex.Lock()
+	bot := room.world.Player("bot-moves-1")
+	playersMutex.Unlock()
+	brain := bots.New(reverser{}, bots.Difficulty{Name: "test"}, rand.New(rand.NewSource(1)))
+	room.bots[bot.ID] = &roomBot{Player: bot, Brain: brain}
+
+	room.driveBotsLocked()
+	if len(room.inputs) != 0 {
+		t.Fatalf("the bot turned straight back: %v", room.inputs)
+	}
+}
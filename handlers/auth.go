@@ -0,0 +1,296 @@
+// Package handlers auth.go logs players in as guests or accounts and checks their session tokens.
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/4cecoder/multiplayer/auth"
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/store"
+	"github.com/go-chi/chi/middleware"
+)
+
+const (
+	accountsBucket       = "accounts"       // lower-cased username to the account
+	accountPlayersBucket = "accountPlayers" // player ID to the username of its account
+	settingsBucket       = "settings"
+
+	GuestTokenLifetime   = 30 * 24 * time.Hour
+	AccountTokenLifetime = 7 * 24 * time.Hour
+	SessionCookie        = "session"
+
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+)
+
+var (
+	ErrInvalidUsername = errors.New("usernames are 3 to 32 lower-case letters, digits, '-', '_' or '.'")
+	ErrInvalidPassword = errors.New("passwords are 8 to 128 characters")
+	ErrUsernameTaken   = errors.New("username is already taken")
+	ErrHasAccount      = errors.New("this player already has an account, log in to it")
+	ErrBadCredentials  = errors.New("wrong username or password")
+)
+
+// Signs and checks session tokens, set by InitAuth
+var signer *auth.Signer
+
+// A hash to check passwords against when the username doesn't exist, so a login takes as long either way
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("not a real password")
+	return hash
+})
+
+type account struct {
+	Username     string    `json:"username"`
+	PlayerID     string    `json:"playerId"`
+	PasswordHash string    `json:"passwordHash"`
+	Created      time.Time `json:"created"`
+}
+
+// credentials is the body of register and login requests.
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// authResponse is what the auth endpoints return, the token is also set as the session cookie.
+type authResponse struct {
+	Token    string    `json:"token,omitempty"`
+	PlayerID string    `json:"playerId"`
+	Username string    `json:"username,omitempty"`
+	Guest    bool      `json:"guest"`
+	Expires  time.Time `json:"expires"`
+}
+
+// InitAuth sets up token signing with AUTH_SECRET, or a secret kept in the store so tokens survive restarts.
+func InitAuth() error {
+	if secret := os.Getenv("AUTH_SECRET"); secret != "" {
+		signer = auth.NewSigner([]byte(secret))
+		return nil
+	}
+
+	var secret string
+	err := db.Update(settingsBucket, "authSecret", &secret, func(exists bool) error {
+		if exists {
+			return nil
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		secret = hex.EncodeToString(key)
+		slog.Warn("Generated a new token signing secret, set AUTH_SECRET to share one between servers")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	signer = auth.NewSigner([]byte(secret))
+	return nil
+}
+
+// authenticate returns the claims of the request's session token, from the token query parameter,
+// an Authorization bearer header or the session cookie.
+func authenticate(r *http.Request) (auth.Claims, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		if cookie, err := r.Cookie(SessionCookie); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return auth.Claims{}, auth.ErrInvalidToken
+	}
+	return signer.Verify(token)
+}
+
+// issueSession signs a token for the player, sets it as the session cookie and writes it in the response.
+func issueSession(w http.ResponseWriter, r *http.Request, claims auth.Claims, valid time.Duration) {
+	token, claims, err := signer.Issue(claims, valid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  claims.Expires(),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	writeJSON(w, http.StatusOK, authResponse{
+		Token:    token,
+		PlayerID: claims.Subject,
+		Username: claims.Username,
+		Guest:    claims.Guest,
+		Expires:  claims.Expires(),
+	})
+}
+
+// GuestLogin gives a new player ID to play without an account, or renews the token of a guest that still has one.
+func GuestLogin(w http.ResponseWriter, r *http.Request) {
+	playerID := generateClientID()
+	if claims, err := authenticate(r); err == nil && claims.Guest {
+		playerID = claims.Subject
+	}
+	if _, err := loadProfile(playerID); err != nil {
+		slog.Error("Error creating profile for guest", "client", playerID, "err", err)
+	}
+	issueSession(w, r, auth.Claims{Subject: playerID, Guest: true}, GuestTokenLifetime)
+}
+
+// Register creates an account. A guest registering keeps their player ID, so their profile and stats come along;
+// each player ID has at most one account, so bans on an account can't be dodged by registering another.
+func Register(w http.ResponseWriter, r *http.Request) {
+	creds, ok := readCredentials(w, r)
+	if !ok {
+		return
+	}
+	username, err := validateUsername(creds.Username)
+	if length := utf8.RuneCountInString(creds.Password); err == nil && (length < MinPasswordLength || length > MaxPasswordLength) {
+		err = ErrInvalidPassword
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := auth.HashPassword(creds.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	playerID := generateClientID()
+	if claims, err := authenticate(r); err == nil && claims.Guest {
+		playerID = claims.Subject
+	}
+	var owner string
+	err = db.Update(accountPlayersBucket, playerID, &owner, func(exists bool) error {
+		if exists {
+			return ErrHasAccount
+		}
+		owner = username
+		return nil
+	})
+	if err == nil {
+		var created account
+		err = db.Update(accountsBucket, username, &created, func(exists bool) error {
+			if exists {
+				return ErrUsernameTaken
+			}
+			created = account{Username: username, PlayerID: playerID, PasswordHash: hash, Created: time.Now().UTC()}
+			return nil
+		})
+		if err != nil {
+			if deleteErr := db.Delete(accountPlayersBucket, playerID); deleteErr != nil {
+				slog.Error("Error releasing the player of a failed registration", "client", playerID, "err", deleteErr)
+			}
+		}
+	}
+	if errors.Is(err, ErrUsernameTaken) || errors.Is(err, ErrHasAccount) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Use the username as the display name if nobody has it yet
+	if _, err := loadProfile(playerID); err == nil {
+		if _, err := saveProfile(playerID, models.ProfileUpdate{Name: username}); err != nil {
+			slog.Info("Not naming player after their username", "client", playerID, "err", err)
+		}
+	}
+	slog.Info("Registered account", "username", username, "client", playerID, "request", middleware.GetReqID(r.Context()))
+	issueSession(w, r, auth.Claims{Subject: playerID, Username: username}, AccountTokenLifetime)
+}
+
+func Login(w http.ResponseWriter, r *http.Request) {
+	creds, ok := readCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	var found account
+	err := db.Get(accountsBucket, strings.ToLower(strings.TrimSpace(creds.Username)), &found)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hash := found.PasswordHash
+	if hash == "" {
+		hash = dummyHash()
+	}
+	match, err := auth.CheckPassword(hash, creds.Password)
+	if err != nil {
+		slog.Error("Error checking password", "username", found.Username, "err", err)
+	}
+	if !match || found.PasswordHash == "" {
+		http.Error(w, ErrBadCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+	if ban, banned := banFor(found.PlayerID, found.Username, remoteIP(r.RemoteAddr), time.Now()); banned {
+		http.Error(w, banMessage(ban), http.StatusForbidden)
+		return
+	}
+	issueSession(w, r, auth.Claims{Subject: found.PlayerID, Username: found.Username}, AccountTokenLifetime)
+}
+
+// Me returns who the request's token belongs to.
+func Me(w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, authResponse{
+		PlayerID: claims.Subject,
+		Username: claims.Username,
+		Guest:    claims.Guest,
+		Expires:  claims.Expires(),
+	})
+}
+
+// Logout clears the session cookie, the token itself stays valid until it expires.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readCredentials(w http.ResponseWriter, r *http.Request) (credentials, bool) {
+	var creds credentials
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&creds); err != nil {
+		http.Error(w, "invalid credentials: "+err.Error(), http.StatusBadRequest)
+		return creds, false
+	}
+	return creds, true
+}
+
+func validateUsername(username string) (string, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if len(username) < 3 || len(username) > 32 {
+		return "", ErrInvalidUsername
+	}
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return "", ErrInvalidUsername
+		}
+	}
+	return username, nil
+}
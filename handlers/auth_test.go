@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useAuth gives the test its own store and a token signer kept in it.
+func useAuth(t *testing.T) {
+	t.Helper()
+	t.Setenv("AUTH_SECRET", "")
+	useStore(t)
+	previous := signer
+	if err := InitAuth(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { signer = previous })
+}
+
+// post calls an auth handler with a JSON body and the token, if there is one.
+func post(t *testing.T, handler http.HandlerFunc, body, token string) (int, authResponse) {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodPost, "/auth", strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	var response authResponse
+	if recorder.Code == http.StatusOK {
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder.Code, response
+}
+
+func TestGuestRegistersOnce(t *testing.T) {
+	useAuth(t)
+	_, guest := post(t, GuestLogin, "", "")
+
+	// A taken username doesn't use up the guest's one registration
+	if code, _ := post(t, Register, `{"username": "taken", "password": "password1"}`, ""); code != http.StatusOK {
+		t.Fatalf("registering taken got %d", code)
+	}
+	if code, _ := post(t, Register, `{"username": "taken", "password": "password1"}`, guest.Token); code != http.StatusConflict {
+		t.Fatalf("registering a taken username got %d, expected 409", code)
+	}
+
+	code, registered := post(t, Register, `{"username": "ann", "password": "password1"}`, guest.Token)
+	if code != http.StatusOK || registered.PlayerID != guest.PlayerID || registered.Guest {
+		t.Fatalf("expected the guest's player %s to get an account, got %d %+v", guest.PlayerID, code, registered)
+	}
+	// The guest token still works, but not for another account of the same player
+	if code, _ := post(t, Register, `{"username": "ann2", "password": "password1"}`, guest.Token); code != http.StatusConflict {
+		t.Fatalf("a guest registered a second account, got %d", code)
+	}
+	// An account's token registers a new player
+	code, other := post(t, Register, `{"username": "ann3", "password": "password1"}`, registered.Token)
+	if code != http.StatusOK || other.PlayerID == guest.PlayerID {
+		t.Fatalf("expected a new player for ann3, got %d %+v", code, other)
+	}
+
+	code, login := post(t, Login, `{"username": "ANN", "password": "password1"}`, "")
+	if code != http.StatusOK || login.PlayerID != guest.PlayerID || login.Username != "ann" {
+		t.Fatalf("expected ann to log in as %s, got %d %+v", guest.PlayerID, code, login)
+	}
+	if code, _ := post(t, Login, `{"username": "ann", "password": "password2"}`, ""); code != http.StatusUnauthorized {
+		t.Fatalf("a wrong password got %d", code)
+	}
+}
@@ -0,0 +1,144 @@
+// Package handlers bots.go fills rooms with server-side bot players when humans are scarce.
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strings"
+
+	"github.com/4cecoder/multiplayer/bots"
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// roomBot is a bot player and the AI driving it.
+type roomBot struct {
+	Player *models.Player
+	Brain  *bots.Bot
+}
+
+// configureBots applies the bot settings to a room, they were checked when the configuration was loaded.
+func configureBots(room *Room, rooms config.Rooms) {
+	room.BotTarget = rooms.BotsPerRoom
+
+	difficulty, err := bots.DifficultyByName(rooms.BotDifficulty)
+	if err != nil {
+		slog.Warn("Invalid bot difficulty", "err", err, "using", bots.Normal.Name)
+		difficulty = bots.Normal
+	}
+	room.BotDifficulty = difficulty
+	room.BotStrategies = append([]string{}, rooms.BotStrategies...)
+}
+
+// balanceBots adds or removes bots so there are BotTarget bots minus one per human, within the room capacity.
+func (r *Room) balanceBots() {
+	r.mu.Lock()
+	var added, removed []*models.Player
+	for len(r.clients)+len(r.bots) < r.BotTarget && len(r.clients)+len(r.bots) < r.Capacity {
+		bot, err := r.addBotLocked()
+		if err != nil {
+			r.logger.Error("Error adding bot", "err", err)
+			break
+		}
+		added = append(added, bot)
+	}
+	for len(r.bots) > 0 && (len(r.clients)+len(r.bots) > r.BotTarget || len(r.clients)+len(r.bots) > r.Capacity) {
+		removed = append(removed, r.removeBotLocked())
+	}
+	r.mu.Unlock()
+
+	for _, player := range added {
+		r.broadcastPlayerUpdate(player)
+	}
+	for _, player := range removed {
+		r.broadcastRemovePlayer(player.ID)
+	}
+}
+
+// addBotLocked creates a bot with the next strategy in rotation, the caller must hold r.mu.
+func (r *Room) addBotLocked() (*models.Player, error) {
+	if len(r.BotStrategies) == 0 {
+		return nil, fmt.Errorf("no bot strategies configured")
+	}
+	name := strings.TrimSpace(r.BotStrategies[r.botsCreated%len(r.BotStrategies)])
+	strategy, err := bots.NewStrategy(name)
+	if err != nil {
+		return nil, err
+	}
+	r.botsCreated++
+
+	// Named after the room so bots of rooms playing the same seed don't clash, and with their own generator drawn
+	// from the room's so a bot's decisions don't depend on what the others drew
+	id := fmt.Sprintf("bot-%s-%d", r.ID, r.botsCreated)
+	player := newPlayer(id, nil)
+	player.Name = fmt.Sprintf("Bot %d (%s)", r.botsCreated, strategy.Name())
+	rng := rand.New(rand.NewSource(r.rng.Int63()))
+
+	r.bots[id] = &roomBot{Player: player, Brain: bots.New(strategy, r.BotDifficulty, rng)}
+	playersMutex.Lock()
+	r.addPlayerLocked(player)
+	players[id] = player
+	publish(Event{Type: EventTypeJoin, RoomID: r.ID, Player: r.eventPlayerLocked(id)})
+	playersMutex.Unlock()
+	r.logger.Info("Added bot", "bot", id, "difficulty", r.BotDifficulty.Name)
+	return player, nil
+}
+
+// removeBotLocked removes the bot that joined last, the caller must hold r.mu and make sure there is one.
+func (r *Room) removeBotLocked() *models.Player {
+	joined := r.world.Players()
+	for i := len(joined) - 1; i >= 0; i-- {
+		id := joined[i].ID
+		bot, ok := r.bots[id]
+		if !ok {
+			continue
+		}
+		delete(r.bots, id)
+		playersMutex.Lock()
+		r.removePlayerLocked(id)
+		delete(players, id)
+		playersMutex.Unlock()
+		r.logger.Info("Removed bot", "bot", id)
+		return bot.Player
+	}
+	return nil
+}
+
+// driveBotsLocked queues every bot's direction for this tick, in the order they joined so the same seed makes
+// the same decisions. The caller must hold r.mu.
+func (r *Room) driveBotsLocked() {
+	if len(r.bots) == 0 {
+		return
+	}
+
+	playersMutex.Lock()
+	var states []models.PlayerState
+	var order []*roomBot
+	for _, player := range r.world.Players() {
+		states = append(states, playerStateOf(player))
+		if bot, ok := r.bots[player.ID]; ok {
+			order = append(order, bot)
+		}
+	}
+	playersMutex.Unlock()
+
+	for _, bot := range order {
+		if !bot.Player.IsAlive {
+			continue
+		}
+		view := bots.View{Tick: r.world.Tick, Width: r.world.Config.Width, Height: r.world.Config.Height, Blocked: r.world.Blocked}
+		for _, state := range states {
+			if state.ID == bot.Player.ID {
+				view.Self = state
+			} else {
+				view.Others = append(view.Others, state)
+			}
+		}
+		// Bots play by the players' rules, a turn that breaks them is dropped
+		direction := bot.Brain.Decide(view)
+		if validateDirection(direction) {
+			r.queueMoveLocked(bot.Player.ID, direction)
+		}
+	}
+}
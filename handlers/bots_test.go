@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/config"
+)
+
+// newBotRoom returns a room of the default settings that keeps target bots, without registering it.
+func newBotRoom(t *testing.T, seed int64, capacity, target int) *Room {
+	t.Helper()
+	room := NewRoom("bots", capacity, seed, config.Default().Gameplay)
+	room.BotTarget = target
+	// Bots are in the global players, so they are taken out when the test ends
+	t.Cleanup(func() {
+		room.BotTarget = 0
+		room.balanceBots()
+	})
+	return room
+}
+
+// playBots steps a room of bots and returns the players as they are after the last tick.
+func playBots(t *testing.T, seed int64, ticks int) string {
+	room := newBotRoom(t, seed, 10, 4)
+	for i := 0; i < ticks; i++ {
+		room.step()
+	}
+	room.mu.Lock()
+	playersMutex.Lock()
+	var states []any
+	for _, player := range room.world.Players() {
+		states = append(states, playerStateOf(player))
+	}
+	playersMutex.Unlock()
+	room.mu.Unlock()
+
+	played, err := json.Marshal(states)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.BotTarget = 0
+	room.balanceBots()
+	return string(played)
+}
+
+func TestBotsPlayTheSameGameForTheSameSeed(t *testing.T) {
+	first := playBots(t, 42, 300)
+	if second := playBots(t, 42, 300); second != first {
+		t.Fatalf("the same seed played out differently:\n%s\n%s", first, second)
+	}
+	if other := playBots(t, 43, 300); other == first {
+		t.Fatal("another seed played out the same game")
+	}
+}
+
+// botIDs lists the room's bots in the order they joined.
+func botIDs(room *Room) []string {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	var ids []string
+	for _, player := range room.world.Players() {
+		if _, ok := room.bots[player.ID]; ok {
+			ids = append(ids, player.ID)
+		}
+	}
+	return ids
+}
+
+func TestBotsBackfillWithinCapacity(t *testing.T) {
+	t.Setenv("REPLAY_DIR", t.TempDir())
+	room := newBotRoom(t, 1, 3, 5)
+	expect := func(when string, want ...string) {
+		t.Helper()
+		if got := botIDs(room); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Fatalf("%s: expected bots %v, got %v", when, want, got)
+		}
+	}
+
+	// Five wanted, but only three fit
+	room.balanceBots()
+	expect("in an empty room", "bot-bots-1", "bot-bots-2", "bot-bots-3")
+
+	// A human joining a full room takes the newest bot's place
+	human := &Client{ID: "human", Player: newPlayer("human", nil), logger: slog.Default()}
+	if !room.addClient(human) {
+		t.Fatal("the human didn't get a bot's place")
+	}
+	room.balanceBots()
+	expect("with a human", "bot-bots-1", "bot-bots-2")
+
+	// Fewer bots wanted, the newest go first, and the human counts towards the target
+	room.BotTarget = 2
+	room.balanceBots()
+	expect("with a lower target", "bot-bots-1")
+
+	room.removeClient(human)
+	room.balanceBots()
+	expect("after the human left", "bot-bots-1", "bot-bots-4")
+
+	room.BotTarget = 0
+	room.balanceBots()
+	expect("without bots wanted")
+}
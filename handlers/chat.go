@@ -0,0 +1,43 @@
+// Package handlers chat.go relays chat messages to everyone in the sender's room.
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// MaxChatLength is the longest chat message relayed in characters, longer ones are cut
+const MaxChatLength = 200
+
+// handleChat relays a chat signal's text to the room unless the sender is muted.
+func handleChat(client *Client, text string) {
+	text = strings.TrimSpace(text)
+	room := client.Room
+	if text == "" || room == nil {
+		return
+	}
+	if isMuted(client.ID) {
+		client.SendSignal(SignalMessage{Type: "muted", Content: "you are muted"})
+		return
+	}
+	if runes := []rune(text); len(runes) > MaxChatLength {
+		text = string(runes[:MaxChatLength])
+	}
+
+	playersMutex.Lock()
+	name := client.Player.Name
+	playersMutex.Unlock()
+	message, err := json.Marshal(models.ChatInstruction{
+		Type:    "chat",
+		Payload: models.ChatLine{PlayerID: client.ID, Name: name, Text: text, At: time.Now().UTC()},
+	})
+	if err != nil {
+		client.logger.Error("Error marshalling chat message", "err", err)
+		return
+	}
+	room.broadcastEveryone(message)
+	client.emitEvent(Event{Type: EventTypeChat, RoomID: room.ID, Player: &EventPlayer{ID: client.ID, Name: name}, Text: text})
+}
@@ -0,0 +1,205 @@
+// Package handlers checkpoints.go saves the rooms to disk every few seconds and, after a crash, rebuilds them from
+// their checkpoints when the server starts, so players resuming their session get their land back.
+package handlers
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/checkpoint"
+	"github.com/4cecoder/multiplayer/game"
+)
+
+// Where the checkpoints are kept, set by RestoreCheckpoints
+var checkpointDir string
+
+// Held while checkpoints are written, so shutdown doesn't remove them halfway
+var checkpointMutex sync.Mutex
+
+// Closed by stopCheckpoints to end the checkpoint loop
+var checkpointsStop = make(chan struct{})
+var stopCheckpointsOnce sync.Once
+
+// RestoreCheckpoints rebuilds the rooms checkpointed in dir and keeps the checkpoints there from then on. A room
+// comes back with its tick and random source; its humans become resumable sessions with their land, as if they had
+// just disconnected, and its bots start over. Call it after RestoreSessions and before StartCluster.
+func RestoreCheckpoints(dir string) error {
+	checkpointDir = dir
+	paths, err := checkpoint.List(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		saved, err := checkpoint.Read(path)
+		if err != nil {
+			slog.Error("Skipping a checkpoint that can't be read", "err", err)
+			continue
+		}
+		restoreRoom(saved)
+	}
+	return nil
+}
+
+// restoreRoom starts a room from its checkpoint.
+func restoreRoom(saved checkpoint.Checkpoint) {
+	settings := currentSettings()
+	room := NewRoom(saved.RoomID, saved.Capacity, saved.Seed, saved.Gameplay)
+	room.world = game.Restore(saved.World)
+	room.mapIndex, room.mapDef = -1, nil
+	if saved.Map != "" {
+		// A map that is gone is replaced when the next round starts
+		room.mapIndex, room.mapDef, _ = pickMap(room.ID, saved.Map)
+	}
+	players := make(map[string]game.PlayerSnapshot)
+	for _, player := range saved.World.Players {
+		players[player.ID] = player
+		room.world.RemovePlayer(player.ID)
+	}
+	for _, score := range saved.Scores {
+		room.carriedKills[score.PlayerID] = score.Kills
+	}
+	configureBots(room, settings.Rooms)
+
+	expires := time.Now().Add(settings.Queue.ResumeGracePeriod)
+	restored := 0
+	sessionsMutex.Lock()
+	for _, s := range saved.Sessions {
+		player, ok := players[s.ClientID]
+		if s.Player != nil {
+			player, ok = *s.Player, true
+		}
+		if !ok {
+			continue
+		}
+		sessions[s.Token] = &session{ClientID: s.ClientID, RoomID: room.ID, Player: player.Player(), Expires: expires}
+		restored++
+	}
+	sessionsMutex.Unlock()
+
+	roomsMutex.Lock()
+	rooms[room.ID] = room
+	roomsMutex.Unlock()
+	go room.run()
+	room.logger.Info("Restored room from checkpoint", "tick", room.world.Tick, "saved", saved.Saved, "sessions", restored)
+}
+
+// StartCheckpoints checkpoints the rooms every Rooms.CheckpointInterval until shutdown, looking at the setting
+// again after each checkpoint so a reload can change it or turn checkpoints off.
+func StartCheckpoints() {
+	go func() {
+		for {
+			interval := currentSettings().Rooms.CheckpointInterval
+			wait := interval
+			if wait <= 0 {
+				wait = time.Second
+			}
+			select {
+			case <-checkpointsStop:
+				return
+			case <-time.After(wait):
+			}
+			if interval > 0 {
+				checkpointRooms()
+			}
+		}
+	}()
+}
+
+// checkpointRooms writes a checkpoint of every room that humans play in or can resume in, and drops the
+// checkpoints of the others.
+func checkpointRooms() {
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+	if checkpointDir == "" {
+		return
+	}
+
+	start := time.Now()
+	written := 0
+	for _, room := range roomList() {
+		saved := room.checkpoint()
+		var err error
+		if len(saved.Sessions) == 0 {
+			err = checkpoint.Remove(checkpointDir, room.ID)
+		} else {
+			err = checkpoint.Write(checkpointDir, saved)
+			written++
+		}
+		if err != nil {
+			room.logger.Error("Error writing checkpoint", "err", err)
+		}
+	}
+	if written > 0 {
+		checkpointsWritten.Add(uint64(written))
+		slog.Debug("Checkpointed rooms", "rooms", written, "took", time.Since(start))
+	}
+}
+
+// dropCheckpoint removes the checkpoint of a room that was closed.
+func dropCheckpoint(r *Room) {
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+	if checkpointDir == "" {
+		return
+	}
+	if err := checkpoint.Remove(checkpointDir, r.ID); err != nil {
+		r.logger.Error("Error removing checkpoint", "err", err)
+	}
+}
+
+// checkpoint captures the room with the resume tokens of its humans, connected or not.
+func (r *Room) checkpoint() checkpoint.Checkpoint {
+	r.mu.Lock()
+	playersMutex.Lock()
+	saved := checkpoint.Checkpoint{
+		RoomID:   r.ID,
+		Saved:    time.Now().UTC(),
+		Seed:     r.Seed,
+		Capacity: r.Capacity,
+		Gameplay: r.gameplay,
+		Map:      r.mapNameLocked(),
+		World:    r.world.Snapshot(),
+	}
+	for id, s := range r.standings {
+		if _, bot := r.bots[id]; !bot && s.kills > 0 {
+			saved.Scores = append(saved.Scores, checkpoint.Score{PlayerID: id, Kills: s.kills})
+		}
+	}
+	for id, client := range r.clients {
+		saved.Sessions = append(saved.Sessions, checkpoint.Session{Token: client.ResumeToken, ClientID: id})
+	}
+	playersMutex.Unlock()
+	r.mu.Unlock()
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	for token, s := range sessions {
+		if s.RoomID != r.ID {
+			continue
+		}
+		playersMutex.Lock()
+		player := game.SnapshotOf(s.Player)
+		playersMutex.Unlock()
+		saved.Sessions = append(saved.Sessions, checkpoint.Session{Token: token, ClientID: s.ClientID, Player: &player})
+	}
+	return saved
+}
+
+// stopCheckpoints ends the checkpoint loop and removes the checkpoints, a clean shutdown saves the sessions itself.
+func stopCheckpoints() {
+	stopCheckpointsOnce.Do(func() {
+		close(checkpointsStop)
+	})
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+	if checkpointDir == "" {
+		return
+	}
+
+	for _, room := range roomList() {
+		if err := checkpoint.Remove(checkpointDir, room.ID); err != nil {
+			room.logger.Error("Error removing checkpoint", "err", err)
+		}
+	}
+}
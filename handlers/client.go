@@ -2,20 +2,26 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"github.com/4cecoder/multiplayer/logging"
 	"github.com/4cecoder/multiplayer/models"
 	"github.com/gorilla/websocket"
-	"log"
-	"net/http"
-	"os"
+	"log/slog"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// PingInterval is how often clients are pinged to measure their round trip time
+const PingInterval = 5 * time.Second
+
 var upgrader = websocket.Upgrader{
-	ReadBufferSize:    1022,
+	ReadBufferSize:    1022, // replaced by Configure
 	WriteBufferSize:   1022,
-	CheckOrigin:       func(r *http.Request) bool { return true },
+	CheckOrigin:       checkOrigin,
 	EnableCompression: false, // Disable compression
 }
 
@@ -25,24 +31,8 @@ var clientsMutex sync.Mutex
 // Map to keep track of connected clients
 var clients map[string]*Client = make(map[string]*Client)
 
-type EventType int
-
-const (
-	EventTypeMessage EventType = iota
-	EventTypeLogin
-	EventTypeLogout
-	EventTypeError
-	EventTypeReconnect
-	EventTypeSignal
-	EventTypeMove
-)
-
-type Event struct {
-	Type    EventType
-	Client  *Client
-	Message []byte
-	Err     error
-}
+// Every move also arrives as a signal, so only some of them are logged
+var signalSampler = logging.NewSampler(100)
 
 type Client struct {
 	ID                string
@@ -55,8 +45,14 @@ type Client struct {
 	isClosed          bool
 	messageQueue      *MessageQueue
 	Player            *models.Player
-	EventQueue        chan Event
 	SignalChannel     chan SignalMessage
+	ResumeToken       string
+	Room              *Room // set once the client holds a player slot
+	Joined            time.Time
+	logger            *slog.Logger // carries the client, room and request IDs
+	RemoteAddr        string
+	rtt               atomic.Int64 // last measured round trip in nanoseconds, 0 until the first pong
+	limiter           *messageLimiter
 }
 
 type SignalMessage struct {
@@ -65,38 +61,57 @@ type SignalMessage struct {
 }
 
 func NewClient(conn *websocket.Conn, id string, messageQueue *MessageQueue) *Client {
+	queue := currentSettings().Queue
+	remoteAddr := ""
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
 	return &Client{
 		ID:                id,
 		Conn:              conn,
-		Send:              make(chan []byte, 256),
-		reconnectInterval: 5 * time.Second,
-		maxRetryAttempts:  5,
+		Send:              make(chan []byte, queue.SendBuffer),
+		reconnectInterval: queue.ReconnectInterval,
+		maxRetryAttempts:  queue.MaxReconnectAttempts,
 		messageQueue:      messageQueue,
 		Player:            &models.Player{},
-		EventQueue:        make(chan Event, 16),
-		SignalChannel:     make(chan SignalMessage, 16),
+		SignalChannel:     make(chan SignalMessage, queue.SignalBuffer),
+		logger:            slog.With("client", id),
+		RemoteAddr:        remoteAddr,
+		ResumeToken:       newResumeToken(),
+		limiter:           newMessageLimiter(currentSettings().Limits),
 	}
 }
 
 func (c *Client) ReadPump() {
+	conn := c.Conn
 	defer func() {
-		c.emitEvent(Event{Type: EventTypeLogout, Client: c})
+		releaseConnection(conn)
 		err := c.Conn.Close()
 		if err != nil {
-			log.Println("error closing connection:", err)
+			c.logger.Debug("Error closing connection", "err", err)
 			return
 		}
-		c.isClosed = true
-		close(c.Send)
-		close(c.SignalChannel)
+		c.closeChannels()
 	}()
 
+	c.Conn.SetPongHandler(func(data string) error {
+		// The ping carried the time it was sent
+		if sent, err := strconv.ParseInt(data, 10, 64); err == nil {
+			c.rtt.Store(time.Now().UnixNano() - sent)
+		}
+		return nil
+	})
+
 	for {
 		messageType, message, err := c.Conn.ReadMessage()
 		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				oversizedMessages.Inc()
+				c.logger.Warn("Closing connection, message over the size limit")
+			}
 			c.emitEvent(Event{Type: EventTypeError, Client: c, Err: err})
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("websocket error: %v", err)
+				c.logger.Warn("WebSocket error", "err", err)
 				c.handleReconnect()
 			}
 			break
@@ -105,33 +120,47 @@ func (c *Client) ReadPump() {
 		if messageType == websocket.TextMessage {
 			var signalMessage SignalMessage
 			err := json.Unmarshal(message, &signalMessage)
+			countIncoming(signalMessage.Type)
+			if c.overLimit(signalMessage.Type) {
+				continue
+			}
 			if err != nil {
 				c.emitEvent(Event{Type: EventTypeMessage, Client: c, Message: message})
 			} else {
-				c.emitEvent(Event{Type: EventTypeSignal, Client: c, Message: message})
+				// Signals are handled in the order they arrive, so turns are checked in the order they were made
+				c.handleEvent(Event{Type: EventTypeSignal, Client: c, Message: message})
 			}
 		}
 	}
 }
 
 func (c *Client) WritePump() {
+	ping := time.NewTicker(PingInterval)
+	defer ping.Stop()
+
 	for {
 		select {
+		case <-ping.C:
+			sent := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err := c.Conn.WriteControl(websocket.PingMessage, sent, time.Now().Add(time.Second)); err != nil {
+				c.logger.Debug("Error pinging client", "err", err)
+			}
 		case message := <-c.Send:
 			c.Mutex.Lock()
 			err := c.Conn.WriteMessage(websocket.TextMessage, message)
 			c.Mutex.Unlock()
 			if err != nil {
 				c.emitEvent(Event{Type: EventTypeError, Client: c, Err: err})
-				log.Printf("error writing to websocket: %v", err)
+				c.logger.Warn("Error writing to WebSocket", "err", err)
 				c.handleReconnect()
 				return
 			}
+			bytesSent.Add(uint64(len(message)))
 		case signal := <-c.SignalChannel:
 			signalMessage, err := json.Marshal(signal)
 			if err != nil {
 				c.emitEvent(Event{Type: EventTypeError, Client: c, Err: err})
-				log.Printf("error marshaling signal message: %v", err)
+				c.logger.Error("Error marshalling signal message", "err", err)
 				continue
 			}
 			c.Mutex.Lock()
@@ -139,28 +168,28 @@ func (c *Client) WritePump() {
 			c.Mutex.Unlock()
 			if err != nil {
 				c.emitEvent(Event{Type: EventTypeError, Client: c, Err: err})
-				log.Printf("error writing signal to websocket: %v", err)
+				c.logger.Warn("Error writing signal to WebSocket", "err", err)
 				c.handleReconnect()
 				return
 			}
+			bytesSent.Add(uint64(len(signalMessage)))
 		default:
-			messages, err := c.messageQueue.Dequeue(c.ID)
+			message, err := c.messageQueue.Dequeue(c.ID)
 			if err != nil {
 				time.Sleep(100 * time.Millisecond)
 				continue
 			}
 
-			for _, message := range messages {
-				c.Mutex.Lock()
-				err := c.Conn.WriteMessage(websocket.TextMessage, []byte{message})
-				c.Mutex.Unlock()
-				if err != nil {
-					c.emitEvent(Event{Type: EventTypeError, Client: c, Err: err})
-					log.Printf("error writing to websocket: %v", err)
-					c.handleReconnect()
-					return
-				}
+			c.Mutex.Lock()
+			err = c.Conn.WriteMessage(websocket.TextMessage, message)
+			c.Mutex.Unlock()
+			if err != nil {
+				c.emitEvent(Event{Type: EventTypeError, Client: c, Err: err})
+				c.logger.Warn("Error writing to WebSocket", "err", err)
+				c.handleReconnect()
+				return
 			}
+			bytesSent.Add(uint64(len(message)))
 		}
 	}
 }
@@ -168,10 +197,15 @@ func (c *Client) WritePump() {
 func (c *Client) SendMessage(message []byte) {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
+	if c.isClosed {
+		return
+	}
+	messagesOut.With(messageType(message)).Inc()
 	select {
 	case c.Send <- message:
 	default:
-		log.Printf("Send buffer is full, buffering message for client %s", c.ID)
+		c.logger.Warn("Send buffer is full, buffering message")
+		sendOverflows.Inc()
 		c.messageQueue.Enqueue(c.ID, message)
 	}
 }
@@ -179,37 +213,91 @@ func (c *Client) SendMessage(message []byte) {
 func (c *Client) SendSignal(signal SignalMessage) {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
+	if c.isClosed {
+		return
+	}
+	messagesOut.With(signal.Type).Inc()
 	select {
 	case c.SignalChannel <- signal:
 	default:
-		log.Printf("Signal channel is full, dropping signal for client %s", c.ID)
+		c.logger.Warn("Signal channel is full, dropping signal", "type", signal.Type)
 	}
 }
 
 func (c *Client) handleReconnect() {
 	if c.retryAttempts < c.maxRetryAttempts && !c.isClosed {
 		c.retryAttempts++
-		log.Printf("Attempting to reconnect client %s (attempt %d/%d)", c.ID, c.retryAttempts, c.maxRetryAttempts)
-		c.emitEvent(Event{Type: EventTypeReconnect, Client: c})
+		c.logger.Info("Attempting to reconnect", "attempt", c.retryAttempts, "maxAttempts", c.maxRetryAttempts)
 		time.Sleep(c.reconnectInterval)
 		c.reconnect()
 	} else {
-		log.Printf("Maximum reconnect attempts reached for client %s, disconnecting", c.ID)
+		c.logger.Info("Maximum reconnect attempts reached, disconnecting")
 		err := c.Conn.Close()
 		if err != nil {
-			log.Println("error closing connection:", err)
+			c.logger.Debug("Error closing connection", "err", err)
 			return
 		}
-		c.isClosed = true
-		close(c.Send)
-		close(c.SignalChannel)
+		c.closeChannels()
 	}
 }
 
+// disconnect sends the client a last signal, waits for its queued messages to go out and closes the connection
+// with the given close code, which makes it leave the game.
+func (c *Client) disconnect(ctx context.Context, signal SignalMessage, code int, reason string) {
+	c.SendSignal(signal)
+	for ctx.Err() == nil && (len(c.Send) > 0 || len(c.SignalChannel) > 0 || c.messageQueue.QueueSize(c.ID) > 0) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	closeMessage := websocket.FormatCloseMessage(code, reason)
+	if err := c.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+		c.logger.Debug("Error sending close", "err", err)
+	}
+	// Closing the channels first keeps the read pump from trying to reconnect
+	c.closeChannels()
+	if err := c.Conn.Close(); err != nil {
+		c.logger.Debug("Error closing connection", "err", err)
+	}
+}
+
+// overLimit applies the rate limits to a message and returns true if it must be dropped. A client that keeps
+// sending too fast is warned, then disconnected, and its address is banned for a while if that keeps happening.
+func (c *Client) overLimit(messageType string) bool {
+	now := time.Now()
+	switch c.limiter.check(messageType, now) {
+	case penaltyNone:
+		return false
+	case penaltyWarn:
+		c.logger.Warn("Client is sending too fast, dropping messages", "type", messageType)
+		c.SendSignal(SignalMessage{Type: "rateLimited", Content: "slow down, messages are being dropped"})
+	case penaltyDisconnect:
+		banned := floodedOff(remoteIP(c.RemoteAddr), now)
+		c.logger.Warn("Disconnecting client for sending too fast", "type", messageType, "addressBanned", banned)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		go func() {
+			defer cancel()
+			c.disconnect(ctx, SignalMessage{Type: "kicked", Content: "sending too fast"}, websocket.ClosePolicyViolation, "rate limited")
+		}()
+	}
+	return true
+}
+
+// closeChannels marks the client closed so SendMessage and SendSignal stop writing to its channels.
+func (c *Client) closeChannels() {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	if c.isClosed {
+		return
+	}
+	c.isClosed = true
+	close(c.Send)
+	close(c.SignalChannel)
+}
+
 func (c *Client) reconnect() {
 	conn, _, err := websocket.DefaultDialer.Dial(c.getWebSocketURL(), nil)
 	if err != nil {
-		log.Printf("Error reconnecting client %s: %v", c.ID, err)
+		c.logger.Warn("Error reconnecting", "err", err)
 		return
 	}
 	c.Conn = conn
@@ -224,10 +312,10 @@ func (c *Client) reconnect() {
 		err := c.Conn.WriteMessage(websocket.TextMessage, []byte{message})
 		c.Mutex.Unlock()
 		if err != nil {
-			log.Printf("Error resending message for client %s: %v", c.ID, err)
+			c.logger.Warn("Error resending message", "err", err)
 			err := c.messageQueue.Enqueue(c.ID, []byte{message})
 			if err != nil {
-				log.Println("error re-enqueueing message:", err)
+				c.logger.Error("Error re-enqueueing message", "err", err)
 				return
 			}
 		}
@@ -240,40 +328,39 @@ func (c *Client) reconnect() {
 }
 
 func (c *Client) getWebSocketURL() string {
-	host := os.Getenv("HOST")
-	if host == "" {
-		host = "ws://localhost"
-	}
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	return host + ":" + port + "/ws"
+	network := currentSettings().Network
+	return network.Host + ":" + network.Port + "/ws"
 }
 
+// emitEvent publishes an event on the event bus, or handles it right away if it isn't one that is published.
 func (c *Client) emitEvent(event Event) {
-	go c.handleEvent(event)
+	event.Client = c
+	if event.Type.published() {
+		if event.Player == nil {
+			event.Player = &EventPlayer{ID: c.ID}
+		}
+		if event.RoomID == "" && c.Room != nil {
+			event.RoomID = c.Room.ID
+		}
+		publish(event)
+		return
+	}
+	c.handleEvent(event)
 }
 
 func (c *Client) handleEvent(event Event) {
 	switch event.Type {
 	case EventTypeMessage:
-		log.Printf("New message from %s: %s", c.ID, string(event.Message))
-	case EventTypeLogin:
-		log.Printf("User %s logged in", c.ID)
-	case EventTypeLogout:
-		log.Printf("User %s logged out", c.ID)
+		c.logger.Debug("New message", "message", string(event.Message))
+		handleMessageEvent(c, event.Message)
 	case EventTypeError:
-		log.Printf("Error from %s: %v", c.ID, event.Err)
-	case EventTypeReconnect:
-		log.Printf("Attempting to reconnect client %s", c.ID)
+		c.logger.Debug("Connection error", "err", event.Err)
 	case EventTypeSignal:
 		c.handleSignalMessage(event.Message)
 	case EventTypeMove:
-		log.Printf("Move event from client %s: %s", c.ID, string(event.Message))
+		handleMoveEvent(c, event.Message)
 	default:
-		log.Printf("unhandled default case for event type %d", event.Type)
+		c.logger.Warn("Unhandled event type", "type", event.Type)
 	}
 }
 
@@ -281,26 +368,32 @@ func (c *Client) handleSignalMessage(message []byte) {
 	var signalMessage SignalMessage
 	err := json.Unmarshal(message, &signalMessage)
 	if err != nil {
-		log.Printf("Error decoding signal message for client %s: %v", c.ID, err)
+		c.logger.Warn("Error decoding signal message", "err", err)
 		return
 	}
-	log.Println("Received signal message from client", c.ID, signalMessage)
+	if signalSampler.Allow() {
+		c.logger.Debug("Received signal message", "type", signalMessage.Type, signalSampler.Sampled())
+	}
 
 	switch signalMessage.Type {
 	case "offer":
-		log.Printf("Received offer from client %s: %s", c.ID, signalMessage.Content)
+		c.logger.Debug("Received offer", "content", signalMessage.Content)
 		// Handle the WebRTC offer
 	case "answer":
-		log.Printf("Received answer from client %s: %s", c.ID, signalMessage.Content)
+		c.logger.Debug("Received answer", "content", signalMessage.Content)
 		// Handle the WebRTC answer
 	case "iceCandidate":
-		log.Printf("Received ICE candidate from client %s: %s", c.ID, signalMessage.Content)
+		c.logger.Debug("Received ICE candidate", "content", signalMessage.Content)
 		// Handle the new ICE candidate
+	case "chat":
+		handleChat(c, signalMessage.Content)
+	case "capture":
+		// Land is only ever captured by the room's tick
+		recordStrike(c, strikeClientState, "capture")
 	case "move":
-		log.Printf("Received move signal from client %s: %s", c.ID, signalMessage.Content)
 		// Handle the move signal
-		c.emitEvent(Event{Type: EventTypeMove, Client: c, Message: []byte(signalMessage.Content)})
+		c.handleEvent(Event{Type: EventTypeMove, Client: c, Message: []byte(signalMessage.Content)})
 	default:
-		log.Printf("Unknown signal message type from client %s: %s", c.ID, signalMessage.Type)
+		c.logger.Warn("Unknown signal message type", "type", signalMessage.Type)
 	}
 }
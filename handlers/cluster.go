@@ -0,0 +1,244 @@
+// Package handlers cluster.go shares the rooms with the other server nodes through the room directory. Rooms this
+// node owns are played here, players asking for a room another node owns are sent there.
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/directory"
+)
+
+// The room directory, nil until StartCluster so every room is played here
+var roomDirectory directory.Directory
+
+// This node as the directory knows it
+var localNode directory.Node
+
+// Closed by leaveCluster to stop renewing this node's registration
+var clusterStop = make(chan struct{})
+var leaveClusterOnce sync.Once
+
+// lobbyInfo is the response of GET /lobby, where a room is played.
+type lobbyInfo struct {
+	Room   string `json:"room"`
+	Node   string `json:"node"`
+	URL    string `json:"url"`    // the game page on the room's node
+	Socket string `json:"socket"` // the WebSocket endpoint on the room's node
+}
+
+// clusterInfo is the response of GET /admin/cluster.
+type clusterInfo struct {
+	Node  string            `json:"node"` // this node's ID
+	Nodes []directory.Node  `json:"nodes"`
+	Rooms map[string]string `json:"rooms"` // room ID to node ID
+}
+
+// StartCluster opens the room directory and registers this node with the rooms it plays, renewing the registration
+// in the background. Call it after RestoreSessions and before serving, so the rooms of resumable sessions stay here.
+// Nodes sharing a directory must share AUTH_SECRET, or players sent to another node couldn't sign in there.
+func StartCluster() error {
+	settings := currentSettings()
+	cluster, network := settings.Cluster, settings.Network
+	if cluster.Directory != "memory" && os.Getenv("AUTH_SECRET") == "" {
+		return errors.New("nodes sharing a room directory need the same AUTH_SECRET, set it on every node")
+	}
+	opened, err := directory.Open(cluster.Directory, cluster.NodeTTL)
+	if err != nil {
+		return err
+	}
+
+	node := directory.Node{ID: cluster.NodeID, URL: strings.TrimRight(cluster.PublicURL, "/")}
+	if node.ID == "" {
+		hostname, _ := os.Hostname()
+		node.ID = hostname + ":" + network.Port
+	}
+	if node.URL == "" {
+		scheme := "http"
+		if network.TLSCert != "" || network.DevTLS {
+			scheme = "https"
+		}
+		node.URL = scheme + "://localhost:" + network.Port
+	}
+	if err := opened.Register(node, localRoomIDs()); err != nil {
+		return err
+	}
+	roomDirectory, localNode = opened, node
+	go renewNode(cluster.NodeTTL / 3)
+	slog.Info("Joined the room directory", "node", node.ID, "url", node.URL, "directory", cluster.Directory)
+	return nil
+}
+
+// renewNode registers this node again every interval until leaveCluster.
+func renewNode(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-clusterStop:
+			return
+		case <-ticker.C:
+		}
+		if err := roomDirectory.Register(localNode, localRoomIDs()); err != nil {
+			slog.Warn("Error renewing this node in the room directory", "node", localNode.ID, "err", err)
+		}
+	}
+}
+
+// localRoomIDs lists the rooms played here and the rooms resumable sessions will come back to.
+func localRoomIDs() []string {
+	var ids []string
+	for _, room := range roomList() {
+		ids = append(ids, room.ID)
+	}
+	sessionsMutex.Lock()
+	// Expired sessions would claim back the rooms that closed
+	pruneSessionsLocked()
+	for _, s := range sessions {
+		ids = append(ids, s.RoomID)
+	}
+	sessionsMutex.Unlock()
+	return ids
+}
+
+// leaveCluster stops renewing this node and frees its rooms for the other nodes.
+func leaveCluster() {
+	leaveClusterOnce.Do(func() {
+		close(clusterStop)
+		if roomDirectory == nil {
+			return
+		}
+		if err := roomDirectory.Leave(localNode.ID); err != nil {
+			slog.Warn("Error leaving the room directory", "node", localNode.ID, "err", err)
+			return
+		}
+		slog.Info("Left the room directory", "node", localNode.ID)
+	})
+}
+
+// roomOwner returns the node a room is played on and whether that is this node. A room nobody plays is given to a
+// node when claim is set, for a player joining it, otherwise the node it would be given to is returned. When the
+// directory can't be reached the room is played here, so players aren't locked out.
+func roomOwner(roomID string, claim bool) (directory.Node, bool) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	if roomDirectory == nil {
+		return localNode, true
+	}
+	find := roomDirectory.Find
+	if claim {
+		find = roomDirectory.Owner
+	}
+	node, err := find(roomID)
+	if err != nil {
+		slog.Warn("Room directory unavailable, playing the room here", "room", roomID, "err", err)
+		return localNode, true
+	}
+	return node, node.ID == localNode.ID
+}
+
+// nodeURL is the address of a path on a node, with ws:// or wss:// for a WebSocket.
+func nodeURL(node directory.Node, path, query string, socket bool) string {
+	target, err := url.Parse(node.URL)
+	if err != nil {
+		return node.URL + path + "?" + query
+	}
+	if socket && target.Scheme == "https" {
+		target.Scheme = "wss"
+	} else if socket {
+		target.Scheme = "ws"
+	}
+	target.Path = path
+	target.RawQuery = query
+	return target.String()
+}
+
+// clusterOrigin reports whether an origin is the public URL of a live node in the room directory.
+func clusterOrigin(origin string) bool {
+	if roomDirectory == nil {
+		return false
+	}
+	nodes, err := roomDirectory.Nodes()
+	if err != nil {
+		return false
+	}
+	for _, node := range nodes {
+		if strings.EqualFold(node.URL, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseRoom frees a room this node no longer plays in the room directory.
+func releaseRoom(roomID string) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	if roomDirectory == nil {
+		return
+	}
+	if err := roomDirectory.Release(roomID, localNode.ID); err != nil {
+		slog.Warn("Error releasing a room in the room directory", "room", roomID, "err", err)
+	}
+}
+
+// redirectToOwner sends a request for a room to the node that owns it, returning true if it did. WebSocket requests
+// join the room, so they give it to a node if nobody plays it; the game page only looks it up.
+func redirectToOwner(w http.ResponseWriter, r *http.Request, roomID string, socket bool) bool {
+	owner, local := roomOwner(roomID, socket)
+	if local {
+		return false
+	}
+	slog.Debug("Sending player to the room's node", "room", roomID, "node", owner.ID, "path", r.URL.Path)
+	roomRedirects.Inc()
+	http.Redirect(w, r, nodeURL(owner, r.URL.Path, r.URL.RawQuery, socket), http.StatusTemporaryRedirect)
+	return true
+}
+
+// Lobby tells where the room parameter's room, or the default room, is played. A room nobody plays yet is given to
+// a node when the first player joins it, until then the lobby names the node it would go to.
+func Lobby(w http.ResponseWriter, r *http.Request) {
+	roomID := r.URL.Query().Get("room")
+	if !validRoomID(roomID) {
+		http.Error(w, errInvalidRoomID.Error(), http.StatusBadRequest)
+		return
+	}
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	owner, _ := roomOwner(roomID, false)
+	query := url.Values{"room": {roomID}}.Encode()
+	writeJSON(w, http.StatusOK, lobbyInfo{
+		Room:   roomID,
+		Node:   owner.ID,
+		URL:    nodeURL(owner, "/", query, false),
+		Socket: nodeURL(owner, "/ws", query, true),
+	})
+}
+
+// GetCluster lists the live nodes and which of them plays each room.
+func GetCluster(w http.ResponseWriter, r *http.Request) {
+	info := clusterInfo{Node: localNode.ID, Nodes: []directory.Node{}, Rooms: map[string]string{}}
+	if roomDirectory != nil {
+		nodes, err := roomDirectory.Nodes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		rooms, err := roomDirectory.Rooms()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		info.Nodes, info.Rooms = append(info.Nodes, nodes...), rooms
+	}
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,82 @@
+// Package handlers config.go keeps the settings the server runs with and applies reloaded ones.
+package handlers
+
+import (
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/mapdef"
+)
+
+// Mutex to protect access to the settings
+var settingsMutex sync.RWMutex
+
+// The settings in use, set by Configure and updated by ReloadConfig
+var settings = config.Default()
+
+// Configure sets the settings the server runs with, call it before serving.
+func Configure(c config.Config) {
+	settingsMutex.Lock()
+	settings = c
+	settingsMutex.Unlock()
+
+	upgrader.ReadBufferSize = c.Network.ReadBufferSize
+	upgrader.WriteBufferSize = c.Network.WriteBufferSize
+}
+
+// ReloadConfig applies settings reloaded while the server runs and reads the map files again. Gameplay settings
+// reach each room when its next round starts and room settings apply to new rooms, the others need a restart.
+func ReloadConfig(c config.Config) {
+	settingsMutex.Lock()
+	previous := settings
+	settings.Gameplay = c.Gameplay
+	settings.Rooms = c.Rooms
+	settingsMutex.Unlock()
+	reloadMaps(c.Rooms)
+
+	if c.Gameplay != previous.Gameplay {
+		slog.Info("Reloaded gameplay settings, rooms use them from their next round",
+			"fieldWidth", c.Gameplay.FieldWidth,
+			"fieldHeight", c.Gameplay.FieldHeight,
+			"cellSize", c.Gameplay.CellSize,
+			"maxVelocity", c.Gameplay.MaxVelocity,
+			"respawnDelay", c.Gameplay.RespawnDelay,
+		)
+	}
+	if !reflect.DeepEqual(c.Rooms, previous.Rooms) {
+		slog.Info("Reloaded room settings, new rooms use them")
+	}
+	if !reflect.DeepEqual(c.Network, previous.Network) || c.Queue != previous.Queue || c.Limits != previous.Limits || c.Webhooks != previous.Webhooks ||
+		c.Cluster != previous.Cluster {
+		slog.Warn("Network, queue, limit, webhook and cluster settings changed, they take effect on restart")
+	}
+}
+
+// currentSettings returns a copy of the settings in use.
+func currentSettings() config.Config {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return settings
+}
+
+// worldConfig is the simulation configuration for a round played with the given gameplay settings, on the map's
+// field when there is one. Players never move more than a cell a tick, however small the map's cells are.
+func worldConfig(gameplay config.Gameplay, m *mapdef.Map, seed int64) game.Config {
+	c := game.Config{
+		Width:        gameplay.FieldWidth,
+		Height:       gameplay.FieldHeight,
+		CellSize:     gameplay.CellSize,
+		MaxVelocity:  gameplay.MaxVelocity,
+		Seed:         seed,
+		RespawnTicks: uint64(gameplay.RespawnDelay.Seconds() * TickRate),
+	}
+	if m != nil {
+		c.Width, c.Height, c.CellSize = m.Width, m.Height, m.CellSize
+		c.MaxVelocity = min(c.MaxVelocity, m.CellSize)
+		c.Terrain = m.Terrain()
+	}
+	return c
+}
@@ -0,0 +1,301 @@
+// Package handlers events.go publishes connection and game events on the event bus, in the order they happen,
+// and runs the subscribers that log them, keep the players' stats and leaderboards and award achievements; webhooks.go
+// sends them on.
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/eventbus"
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/logging"
+	"github.com/4cecoder/multiplayer/models"
+)
+
+type EventType int
+
+const (
+	EventTypeMessage EventType = iota
+	EventTypeLogin
+	EventTypeLogout
+	EventTypeError
+	EventTypeReconnect
+	EventTypeSignal
+	EventTypeMove
+	EventTypeJoin
+	EventTypeDeath
+	EventTypeCapture
+	EventTypeChat
+	EventTypeMatchEnd
+	EventTypeRecord
+	EventTypeBan
+	EventTypePing // sent to a webhook to try it, never published
+)
+
+var eventTypeNames = [...]string{"message", "login", "logout", "error", "reconnect", "signal", "move", "join", "death",
+	"capture", "chat", "matchEnd", "record", "ban", "ping"}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return "unknown"
+	}
+	return eventTypeNames[t]
+}
+
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// published reports whether events of the type go on the bus, the others are handled by the client they came from.
+func (t EventType) published() bool {
+	switch t {
+	case EventTypeLogin, EventTypeLogout, EventTypeReconnect, EventTypeJoin, EventTypeDeath, EventTypeCapture, EventTypeChat,
+		EventTypeMatchEnd, EventTypeRecord, EventTypeBan:
+		return true
+	}
+	return false
+}
+
+// EventPlayer is a player as they were when an event happened.
+type EventPlayer struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name,omitempty"`
+	Bot       bool    `json:"bot,omitempty"`
+	Alive     bool    `json:"alive"`
+	Owned     int     `json:"owned"`     // cells of land
+	Territory float64 `json:"territory"` // percent of the field
+	Streak    int     `json:"streak"`
+	Survival  float64 `json:"survival"` // seconds alive, for the player who died the life that just ended
+}
+
+// Event is something that happened to a connection or in a room.
+type Event struct {
+	Seq    uint64        `json:"seq"` // order of publishing, from 1 when the server starts
+	Type   EventType     `json:"type"`
+	At     time.Time     `json:"at"`
+	RoomID string        `json:"roomId,omitempty"`
+	Player *EventPlayer  `json:"player,omitempty"` // who it happened to
+	Other  *EventPlayer  `json:"other,omitempty"`  // the killer, for a death
+	Cells  int           `json:"cells,omitempty"`  // land won by a capture
+	Text   string        `json:"text,omitempty"`   // the chat message
+	Played float64       `json:"played,omitempty"` // seconds connected, for a logout
+	Match  *models.Match `json:"match,omitempty"`  // the finished match
+	Board  string        `json:"board,omitempty"`  // the all-time leaderboard of a record
+	Score  float64       `json:"score,omitempty"`  // the record score
+	Ban    *models.Ban   `json:"ban,omitempty"`
+
+	Client  *Client `json:"-"` // the connection the event came from, if any
+	Message []byte  `json:"-"`
+	Err     error   `json:"-"`
+}
+
+// The server's event bus, subscribers are added by StartEvents
+var bus = newBus()
+
+// Mutex to protect access to the event sequence, held while publishing so events keep their order
+var eventsMutex sync.Mutex
+var eventSeq uint64
+
+// A full subscriber buffer drops every event until it drains, so only some drops are logged
+var dropSampler = logging.NewSampler(100)
+
+func newBus() *eventbus.Bus[Event] {
+	b := eventbus.New[Event]()
+	b.OnDrop = func(subscriber string) {
+		eventsDropped.With(subscriber).Inc()
+		if dropSampler.Allow() {
+			slog.Warn("Event subscriber is falling behind, dropping events", "subscriber", subscriber, dropSampler.Sampled())
+		}
+	}
+	return b
+}
+
+// StartEvents subscribes the logging, stats, leaderboard, achievement and webhook subscribers, call it before serving.
+func StartEvents() {
+	subscribe("log", logEvent)
+	subscribe("leaderboards", recordLeaderboards)
+	// Achievements look at the stats, so they are checked after the stats are updated on the same subscription
+	subscribe("profiles", func(event Event) {
+		recordStats(event)
+		awardAchievements(event)
+	})
+	subscribe("webhooks", deliverEvent)
+}
+
+// subscribe adds a subscriber with a buffer of Queue.EventBuffer events.
+func subscribe(name string, handle func(Event)) *eventbus.Subscription[Event] {
+	return bus.Subscribe(name, currentSettings().Queue.EventBuffer, handle)
+}
+
+// publish numbers an event and hands it to the subscribers. It never blocks, so it may be called with locks held.
+func publish(event Event) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	eventSeq++
+	event.Seq = eventSeq
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+	eventsPublished.With(event.Type.String()).Inc()
+	bus.Publish(event)
+}
+
+// closeEvents lets the subscribers handle what is left in their buffers, giving up when ctx is done.
+func closeEvents(ctx context.Context) error {
+	return bus.Close(ctx)
+}
+
+// eventPlayerLocked describes a player in the room for an event, the caller must hold r.mu and playersMutex.
+func (r *Room) eventPlayerLocked(playerID string) *EventPlayer {
+	_, bot := r.bots[playerID]
+	described := &EventPlayer{ID: playerID, Bot: bot, Survival: r.survivalSeconds(playerID)}
+	if player := r.world.Player(playerID); player != nil {
+		described.Name = player.Name
+		described.Alive = player.IsAlive
+		described.Owned = game.CountOwned(player)
+		described.Territory = r.territoryPercent(player)
+		described.Streak = player.KillStreak
+	}
+	return described
+}
+
+// publishGameEventsLocked publishes a tick's captures and deaths, the caller must hold r.mu and playersMutex.
+func (r *Room) publishGameEventsLocked(events []game.Event) {
+	for _, event := range events {
+		switch event.Type {
+		case game.EventCapture:
+			publish(Event{Type: EventTypeCapture, RoomID: r.ID, Player: r.eventPlayerLocked(event.PlayerID), Cells: event.Cells})
+		case game.EventDeath:
+			death := Event{Type: EventTypeDeath, RoomID: r.ID, Player: r.eventPlayerLocked(event.PlayerID)}
+			if event.OtherID != "" {
+				death.Other = r.eventPlayerLocked(event.OtherID)
+			}
+			publish(death)
+		}
+	}
+}
+
+// publishJoin publishes that a player joined the room.
+func (r *Room) publishJoin(playerID string, client *Client) {
+	r.mu.Lock()
+	playersMutex.Lock()
+	player := r.eventPlayerLocked(playerID)
+	playersMutex.Unlock()
+	r.mu.Unlock()
+	publish(Event{Type: EventTypeJoin, RoomID: r.ID, Player: player, Client: client})
+}
+
+// logEvent logs connection events, and game events at debug level.
+func logEvent(event Event) {
+	logger := slog.Default()
+	if event.Client != nil {
+		logger = event.Client.logger
+	} else if event.Player != nil {
+		logger = logger.With("client", event.Player.ID, "room", event.RoomID)
+	}
+
+	switch event.Type {
+	case EventTypeLogin:
+		logger.Info("User logged in")
+	case EventTypeLogout:
+		logger.Info("User logged out", "played", time.Duration(event.Played*float64(time.Second)).Round(time.Second))
+	case EventTypeReconnect:
+		logger.Info("User reconnected")
+	case EventTypeJoin:
+		logger.Debug("Player joined", "bot", event.Player.Bot)
+	case EventTypeCapture:
+		logger.Debug("Player captured land", "cells", event.Cells, "territory", event.Player.Territory)
+	case EventTypeDeath:
+		killer := ""
+		if event.Other != nil {
+			killer = event.Other.ID
+		}
+		logger.Debug("Player died", "killer", killer, "survived", event.Player.Survival)
+	case EventTypeChat:
+		logger.Debug("Relaying chat message")
+	case EventTypeMatchEnd:
+		logger.Info("Match ended", "match", event.Match.ID, "room", event.RoomID, "players", len(event.Match.Players))
+	case EventTypeRecord:
+		logger.Info("New leaderboard record", "board", event.Board, "score", event.Score)
+	}
+}
+
+// recordStats keeps the lifetime stats of the humans in an event, players without a profile are ignored.
+func recordStats(event Event) {
+	player := event.Player
+	if player == nil || player.Bot {
+		player = nil
+	}
+	switch event.Type {
+	case EventTypeJoin:
+		if player != nil {
+			updateStats(player.ID, func(stats *models.ProfileStats) {
+				stats.Games++
+			})
+		}
+	case EventTypeLogout:
+		if player != nil {
+			updateStats(player.ID, func(stats *models.ProfileStats) {
+				stats.SecondsPlayed += event.Played
+			})
+		}
+	case EventTypeCapture:
+		if player != nil {
+			updateStats(player.ID, func(stats *models.ProfileStats) {
+				stats.Captures++
+				stats.CellsCaptured += event.Cells
+				stats.BestTerritory = max(stats.BestTerritory, player.Owned)
+			})
+		}
+	case EventTypeDeath:
+		if player != nil {
+			updateStats(player.ID, func(stats *models.ProfileStats) {
+				stats.Deaths++
+			})
+		}
+		if killer := event.Other; killer != nil && !killer.Bot {
+			updateStats(killer.ID, func(stats *models.ProfileStats) {
+				stats.Kills++
+				stats.BestKillStreak = max(stats.BestKillStreak, killer.Streak)
+				stats.BestTerritory = max(stats.BestTerritory, killer.Owned)
+			})
+		}
+	}
+}
+
+// awardAchievements gives the connected players in a capture or death their XP and the achievements they now
+// qualify for. Survival is checked every second by the room, without an event.
+func awardAchievements(event Event) {
+	if len(achievements.Achievements) == 0 && len(achievements.XP) == 0 {
+		return
+	}
+	award := func(player *EventPlayer, xp int, live liveMetrics) {
+		clientsMutex.Lock()
+		client, ok := clients[player.ID]
+		clientsMutex.Unlock()
+		if ok {
+			awardProgress(client, xp, live)
+		}
+	}
+	current := func(player *EventPlayer) liveMetrics {
+		if !player.Alive {
+			return liveMetrics{}
+		}
+		return liveMetrics{streak: player.Streak, territory: player.Territory, survival: player.Survival}
+	}
+
+	switch event.Type {
+	case EventTypeCapture:
+		award(event.Player, achievements.XP["capture"], current(event.Player))
+	case EventTypeDeath:
+		// The life that just ended still counts for survival
+		award(event.Player, 0, liveMetrics{survival: event.Player.Survival})
+		if event.Other != nil {
+			award(event.Other, achievements.XP["kill"], current(event.Other))
+		}
+	}
+}
@@ -5,14 +5,33 @@ import (
 	"net/http"
 )
 
+// pageConfig is handed to the game page's script, which connects to the host the page was loaded from unless the
+// lobby says another node plays the room.
+type pageConfig struct {
+	SocketPath     string  `json:"socketPath"`
+	ReconnectAfter float64 `json:"reconnectAfter"` // seconds before reconnecting a dropped connection
+	Node           string  `json:"node"`           // the node that served the page
+}
+
+// HandleRoot serves the game page, from the node that plays the room parameter's room. Replays are watched on the
+// node they were recorded on.
 func HandleRoot(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !query.Has("replay") && redirectToOwner(w, r, query.Get("room"), false) {
+		return
+	}
 	tmpl, err := template.ParseFiles("templates/game.html")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = tmpl.Execute(w, nil)
+	config := pageConfig{
+		SocketPath:     "/ws",
+		ReconnectAfter: currentSettings().Network.ReconnectAfter.Seconds(),
+		Node:           localNode.ID,
+	}
+	err = tmpl.Execute(w, config)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
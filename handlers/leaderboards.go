@@ -0,0 +1,358 @@
+// Package handlers leaderboards.go ranks players live in each room and keeps daily, weekly and all-time records.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/go-chi/chi"
+)
+
+const (
+	LeaderboardInterval = TickRate / 4 // ticks between live leaderboard updates
+
+	BoardTerritory = "territory" // most of the field owned at once, in percent
+	BoardKills     = "kills"     // total kills
+	BoardSurvival  = "survival"  // longest life, in seconds
+
+	DefaultRecordLimit = 10
+	MaxRecordLimit     = 100
+)
+
+var (
+	ErrUnknownBoard  = errors.New("boards are territory, kills or survival")
+	ErrUnknownPeriod = errors.New("periods are daily, weekly or all")
+	errUnchanged     = errors.New("record unchanged")
+)
+
+// How each persistent board combines a new score with the player's record
+var boards = map[string]func(record, score float64) float64{
+	BoardTerritory: math.Max,
+	BoardKills:     func(record, score float64) float64 { return record + score },
+	BoardSurvival:  math.Max,
+}
+
+var boardNames = []string{BoardTerritory, BoardKills, BoardSurvival}
+
+// Every score counts towards each of these periods
+var periods = []string{"daily", "weekly", "all"}
+
+// Mutex to protect access to the best all-time scores
+var bestScoresMutex sync.Mutex
+
+// Best all-time score on each board, read from the store the first time a score is recorded on it
+var bestScores = make(map[string]float64)
+
+// standing is what the live leaderboard knows about a player beyond the world.
+type standing struct {
+	kills      int
+	aliveSince uint64 // tick of the last spawn
+}
+
+// periodKey names the period containing t: a date for daily boards, an ISO week for weekly ones.
+func periodKey(period string, t time.Time) (string, error) {
+	t = t.UTC()
+	switch period {
+	case "daily":
+		return t.Format(time.DateOnly), nil
+	case "weekly":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week), nil
+	case "all":
+		return "all", nil
+	}
+	return "", ErrUnknownPeriod
+}
+
+func recordBucket(board, key string) string {
+	return "leaderboard:" + board + ":" + key
+}
+
+// recordScore adds a human player's score to a board for every period, keeping it if it beats their record.
+func recordScore(board, playerID, name string, score float64) {
+	if db == nil || score <= 0 {
+		return
+	}
+	loadBestScore(board)
+	now := time.Now().UTC()
+	for _, period := range periods {
+		key, _ := periodKey(period, now)
+		var record models.Record
+		err := db.Update(recordBucket(board, key), playerID, &record, func(exists bool) error {
+			combined := boards[board](record.Score, score)
+			if exists && combined == record.Score {
+				return errUnchanged
+			}
+			record = models.Record{PlayerID: playerID, Name: name, Score: combined, At: now}
+			return nil
+		})
+		if err != nil {
+			if !errors.Is(err, errUnchanged) {
+				slog.Error("Error recording score", "board", board, "client", playerID, "err", err)
+			}
+			continue
+		}
+		if period == "all" && beatsBestScore(board, record.Score) {
+			publish(Event{Type: EventTypeRecord, Player: &EventPlayer{ID: playerID, Name: name}, Board: board, Score: record.Score})
+		}
+	}
+}
+
+// loadBestScore reads the best all-time score on a board from the store, once, before the first score on it is recorded.
+func loadBestScore(board string) {
+	bestScoresMutex.Lock()
+	defer bestScoresMutex.Unlock()
+
+	if _, ok := bestScores[board]; ok {
+		return
+	}
+	best := 0.0
+	err := db.ForEach(recordBucket(board, "all"), func(_ string, raw json.RawMessage) error {
+		var record models.Record
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return err
+		}
+		best = max(best, record.Score)
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error reading the best score", "board", board, "err", err)
+		return
+	}
+	bestScores[board] = best
+}
+
+// beatsBestScore reports whether an all-time score is the new best on its board, keeping it if it is.
+func beatsBestScore(board string, score float64) bool {
+	bestScoresMutex.Lock()
+	defer bestScoresMutex.Unlock()
+
+	best, ok := bestScores[board]
+	if !ok || score <= best {
+		return false
+	}
+	bestScores[board] = score
+	return true
+}
+
+// readBoard returns the best records of one period of a board.
+func readBoard(board, period string, at time.Time, limit int) (models.RecordBoard, error) {
+	key, err := periodKey(period, at)
+	if err != nil {
+		return models.RecordBoard{}, err
+	}
+	result := models.RecordBoard{Board: board, Period: period, Key: key, Records: []models.Record{}}
+	err = db.ForEach(recordBucket(board, key), func(_ string, raw json.RawMessage) error {
+		var record models.Record
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return err
+		}
+		result.Records = append(result.Records, record)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// Ties go to whoever got there first
+	sort.Slice(result.Records, func(i, j int) bool {
+		a, b := result.Records[i], result.Records[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if !a.At.Equal(b.At) {
+			return a.At.Before(b.At)
+		}
+		return a.PlayerID < b.PlayerID
+	})
+	if len(result.Records) > limit {
+		result.Records = result.Records[:limit]
+	}
+	for i := range result.Records {
+		result.Records[i].Rank = i + 1
+	}
+	return result, nil
+}
+
+// territoryPercent is the share of the field a player owns, to one decimal.
+func (r *Room) territoryPercent(player *models.Player) float64 {
+	cells := r.world.Rows() * r.world.Cols()
+	if cells == 0 {
+		return 0
+	}
+	return math.Round(float64(game.CountOwned(player))*1000/float64(cells)) / 10
+}
+
+// survivalSeconds is how long a player has been alive, the caller must hold r.mu.
+func (r *Room) survivalSeconds(playerID string) float64 {
+	s, ok := r.standings[playerID]
+	if !ok {
+		return 0
+	}
+	return float64(r.world.Tick-s.aliveSince) / TickRate
+}
+
+// updateStandingsLocked keeps the live standings up to date with a tick's events. The caller must hold r.mu and
+// playersMutex.
+func (r *Room) updateStandingsLocked(events []game.Event) {
+	for _, event := range events {
+		switch event.Type {
+		case game.EventDeath:
+			if killer, ok := r.standings[event.OtherID]; ok {
+				killer.kills++
+			}
+		case game.EventRespawn:
+			if s, ok := r.standings[event.PlayerID]; ok {
+				s.aliveSince = event.Tick
+			}
+		}
+	}
+}
+
+// recordLeaderboards keeps the records of the humans in an event. It runs on its own subscription, so the store
+// is written off the rooms' ticks.
+func recordLeaderboards(event Event) {
+	human := func(player *EventPlayer) bool {
+		return player != nil && !player.Bot
+	}
+	switch event.Type {
+	case EventTypeCapture:
+		if human(event.Player) {
+			recordScore(BoardTerritory, event.Player.ID, event.Player.Name, event.Player.Territory)
+		}
+	case EventTypeDeath:
+		if human(event.Player) {
+			recordScore(BoardSurvival, event.Player.ID, event.Player.Name, event.Player.Survival)
+		}
+		if killer := event.Other; human(killer) {
+			recordScore(BoardKills, killer.ID, killer.Name, 1)
+			recordScore(BoardTerritory, killer.ID, killer.Name, killer.Territory)
+		}
+	case EventTypeLogout:
+		// Leaving ends the life too
+		if human(event.Player) && event.Player.Alive {
+			recordScore(BoardSurvival, event.Player.ID, event.Player.Name, event.Player.Survival)
+		}
+	}
+}
+
+// leaderboardMessageLocked ranks the room's players, the caller must hold r.mu and playersMutex.
+func (r *Room) leaderboardMessageLocked() []byte {
+	leaderboard := models.Leaderboard{RoomID: r.ID, Tick: r.world.Tick, Standings: []models.Standing{}}
+	for _, player := range r.world.Players() {
+		s := models.Standing{
+			ID:        player.ID,
+			Name:      player.Name,
+			Color:     player.Color,
+			Territory: r.territoryPercent(player),
+			Streak:    player.KillStreak,
+			IsAlive:   player.IsAlive,
+		}
+		if tracked, ok := r.standings[player.ID]; ok {
+			s.Kills = tracked.kills
+		}
+		leaderboard.Standings = append(leaderboard.Standings, s)
+	}
+	sort.SliceStable(leaderboard.Standings, func(i, j int) bool {
+		a, b := leaderboard.Standings[i], leaderboard.Standings[j]
+		if a.Territory != b.Territory {
+			return a.Territory > b.Territory
+		}
+		return a.Kills > b.Kills
+	})
+
+	message, err := json.Marshal(models.LeaderboardInstruction{Type: "leaderboard", Payload: leaderboard})
+	if err != nil {
+		r.logger.Error("Error marshalling leaderboard", "err", err)
+		return nil
+	}
+	return message
+}
+
+// GetLeaderboard returns the best records of a board, ?period= picks daily, weekly or all (the default),
+// ?date=YYYY-MM-DD an earlier day or week and ?limit= how many.
+func GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	board := chi.URLParam(r, "board")
+	if _, ok := boards[board]; !ok {
+		http.Error(w, ErrUnknownBoard.Error(), http.StatusNotFound)
+		return
+	}
+	period, at, limit, ok := leaderboardQuery(w, r)
+	if !ok {
+		return
+	}
+	result, err := readBoard(board, period, at, limit)
+	if errors.Is(err, ErrUnknownPeriod) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// ListLeaderboards returns every board for a period, taking the same query parameters as GetLeaderboard.
+func ListLeaderboards(w http.ResponseWriter, r *http.Request) {
+	period, at, limit, ok := leaderboardQuery(w, r)
+	if !ok {
+		return
+	}
+	results := make([]models.RecordBoard, 0, len(boardNames))
+	for _, board := range boardNames {
+		result, err := readBoard(board, period, at, limit)
+		if errors.Is(err, ErrUnknownPeriod) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results = append(results, result)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func leaderboardQuery(w http.ResponseWriter, r *http.Request) (string, time.Time, int, bool) {
+	if db == nil {
+		http.Error(w, "leaderboards are not enabled", http.StatusServiceUnavailable)
+		return "", time.Time{}, 0, false
+	}
+	query := r.URL.Query()
+	period := strings.ToLower(query.Get("period"))
+	if period == "" {
+		period = "all"
+	}
+	at := time.Now()
+	if value := query.Get("date"); value != "" {
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return "", time.Time{}, 0, false
+		}
+		at = parsed
+	}
+	limit := DefaultRecordLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return "", time.Time{}, 0, false
+		}
+		limit = min(parsed, MaxRecordLimit)
+	}
+	return period, at, limit, true
+}
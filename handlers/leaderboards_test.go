@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// useBoards gives the test its own store and forgets the best scores, put back when it ends.
+func useBoards(t *testing.T) {
+	useStore(t)
+	bestScoresMutex.Lock()
+	previous := bestScores
+	bestScores = make(map[string]float64)
+	bestScoresMutex.Unlock()
+	t.Cleanup(func() {
+		bestScoresMutex.Lock()
+		bestScores = previous
+		bestScoresMutex.Unlock()
+	})
+}
+
+// scores returns the all-time scores on a board by player.
+func scores(t *testing.T, board string) map[string]float64 {
+	t.Helper()
+	result, err := readBoard(board, "all", time.Now(), MaxRecordLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := make(map[string]float64)
+	for _, record := range result.Records {
+		scores[record.PlayerID] = record.Score
+	}
+	return scores
+}
+
+func TestLeaderboardsRecordHumansFromEvents(t *testing.T) {
+	useBoards(t)
+	human := &EventPlayer{ID: "h", Name: "Human", Alive: true, Territory: 12.5, Survival: 30}
+	bot := &EventPlayer{ID: "b", Name: "Bot", Bot: true, Alive: true, Territory: 40, Survival: 90}
+
+	recordLeaderboards(Event{Type: EventTypeCapture, Player: human})
+	recordLeaderboards(Event{Type: EventTypeCapture, Player: bot})
+	// The human kills the bot with more land than before
+	killer := *human
+	killer.Territory = 20
+	recordLeaderboards(Event{Type: EventTypeDeath, Player: bot, Other: &killer})
+	// then dies to the bot
+	dead := *human
+	dead.Alive, dead.Survival = false, 45
+	recordLeaderboards(Event{Type: EventTypeDeath, Player: &dead, Other: bot})
+	// and leaves dead, which ends no life
+	recordLeaderboards(Event{Type: EventTypeLogout, Player: &EventPlayer{ID: "h", Name: "Human", Survival: 99}})
+
+	for board, want := range map[string]float64{BoardTerritory: 20, BoardKills: 1, BoardSurvival: 45} {
+		got := scores(t, board)
+		if len(got) != 1 || got["h"] != want {
+			t.Errorf("%s: expected only the human with %v, got %v", board, want, got)
+		}
+	}
+
+	// Leaving alive ends the life there
+	recordLeaderboards(Event{Type: EventTypeLogout, Player: &EventPlayer{ID: "h", Name: "Human", Alive: true, Survival: 60}})
+	if got := scores(t, BoardSurvival); got["h"] != 60 {
+		t.Errorf("expected a survival record of 60 after leaving alive, got %v", got)
+	}
+}
+
+func TestPeriodKey(t *testing.T) {
+	for _, test := range []struct {
+		period string
+		at     time.Time
+		want   string
+	}{
+		{"daily", time.Date(2026, 10, 19, 23, 59, 0, 0, time.UTC), "2026-10-19"},
+		{"daily", time.Date(2026, 10, 20, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), "2026-10-19"},
+		{"weekly", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), "2026-W43"},
+		// ISO weeks belong to the year of their Thursday
+		{"weekly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), "2026-W53"},
+		{"weekly", time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), "2025-W01"},
+		{"all", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), "all"},
+	} {
+		if got, err := periodKey(test.period, test.at); err != nil || got != test.want {
+			t.Errorf("%s %v: expected %s, got %s %v", test.period, test.at, test.want, got, err)
+		}
+	}
+	if _, err := periodKey("monthly", time.Now()); err != ErrUnknownPeriod {
+		t.Errorf("expected ErrUnknownPeriod for monthly, got %v", err)
+	}
+}
+
+func TestRecordScoreKeepsEveryPeriod(t *testing.T) {
+	useBoards(t)
+	records := make(chan Event, 10)
+	sub := bus.Subscribe("records", 10, func(event Event) {
+		if event.Type == EventTypeRecord {
+			records <- event
+		}
+	})
+	t.Cleanup(func() { bus.Unsubscribe(sub) })
+
+	recordScore(BoardTerritory, "a", "Ann", 10)
+	recordScore(BoardTerritory, "a", "Ann", 5) // worse, the record stays
+	recordScore(BoardTerritory, "b", "Bob", 7.5)
+	recordScore(BoardKills, "a", "Ann", 1)
+	recordScore(BoardKills, "a", "Ann", 1)    // kills add up
+	recordScore(BoardSurvival, "a", "Ann", 0) // nothing to record
+
+	now := time.Now()
+	for _, period := range periods {
+		for board, want := range map[string][]float64{BoardTerritory: {10, 7.5}, BoardKills: {2}, BoardSurvival: {}} {
+			result, err := readBoard(board, period, now, MaxRecordLimit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Records) != len(want) {
+				t.Fatalf("%s %s: expected %d records, got %+v", period, board, len(want), result.Records)
+			}
+			for i, score := range want {
+				if record := result.Records[i]; record.Score != score || record.Rank != i+1 {
+					t.Errorf("%s %s: expected %v at rank %d, got %+v", period, board, score, i+1, record)
+				}
+			}
+		}
+	}
+	// Yesterday's board is a board of its own
+	if result, _ := readBoard(BoardTerritory, "daily", now.AddDate(0, 0, -1), MaxRecordLimit); len(result.Records) != 0 {
+		t.Fatalf("expected nothing on yesterday's board, got %+v", result.Records)
+	}
+
+	// Only scores that beat the best all-time score are announced
+	var announced []float64
+	for len(announced) < 3 {
+		select {
+		case event := <-records:
+			announced = append(announced, event.Score)
+		case <-time.After(time.Second):
+			t.Fatalf("expected three records announced, got %v", announced)
+		}
+	}
+	if announced[0] != 10 || announced[1] != 1 || announced[2] != 2 {
+		t.Fatalf("expected records of 10, 1 and 2, got %v", announced)
+	}
+}
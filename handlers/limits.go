@@ -0,0 +1,249 @@
+// Package handlers limits.go limits how many connections an address opens and how fast each connection sends,
+// with penalties that grow for clients that keep going.
+package handlers
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/ratelimit"
+	"github.com/gorilla/websocket"
+)
+
+// penalty is what happens to a message over its rate limit.
+type penalty int
+
+const (
+	penaltyNone penalty = iota
+	penaltyDrop
+	penaltyWarn       // dropped, and the client is told to slow down
+	penaltyDisconnect // dropped, and the client is disconnected
+)
+
+func (p penalty) String() string {
+	switch p {
+	case penaltyDrop:
+		return "drop"
+	case penaltyWarn:
+		return "warn"
+	case penaltyDisconnect:
+		return "disconnect"
+	default:
+		return "none"
+	}
+}
+
+// Mutex to protect access to the connection counts and temporary bans
+var connectionsMutex sync.Mutex
+
+// Open WebSocket connections per remote address, and the address of each connection
+var connectionsPerIP = make(map[string]int)
+var connectionIPs = make(map[*websocket.Conn]string)
+
+// When each address was disconnected for sending too fast, and addresses turned away until a time
+var floodDisconnects = make(map[string][]time.Time)
+var tempBans = make(map[string]time.Time)
+
+// Mutex to protect access to the login buckets
+var authMutex sync.Mutex
+
+// Token buckets for logins and registrations by remote address, each hashes a password
+var authBuckets = make(map[string]*authBucket)
+
+type authBucket struct {
+	*ratelimit.Bucket
+	last time.Time
+}
+
+// LimitAuth turns away logins and registrations from an address going over Limits.AuthRate, so passwords can't be
+// guessed quickly and the memory each password hash takes can't be used up.
+func LimitAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowAuth(remoteIP(r.RemoteAddr), time.Now()) {
+			authThrottled.Inc()
+			slog.Info("Throttled login attempts", "remote", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(1/currentSettings().Limits.AuthRate)+1))
+			http.Error(w, "too many login attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowAuth takes a token from an address's login bucket, forgetting the buckets that have refilled.
+func allowAuth(ip string, now time.Time) bool {
+	limits := currentSettings().Limits
+	refill := time.Duration(float64(limits.AuthBurst) / limits.AuthRate * float64(time.Second))
+
+	authMutex.Lock()
+	defer authMutex.Unlock()
+	for address, bucket := range authBuckets {
+		if now.Sub(bucket.last) > refill {
+			delete(authBuckets, address)
+		}
+	}
+	bucket, ok := authBuckets[ip]
+	if !ok {
+		bucket = &authBucket{Bucket: ratelimit.NewBucket(limits.AuthRate, limits.AuthBurst)}
+		authBuckets[ip] = bucket
+	}
+	bucket.last = now
+	return bucket.Allow(now)
+}
+
+// remoteIP is the address a request came from, without the port.
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// upgrade turns away temporarily banned addresses and addresses with too many connections open, then upgrades
+// the request and limits the size of the messages the connection may send. It writes the error response itself.
+func upgrade(w http.ResponseWriter, r *http.Request, logger *slog.Logger) (*websocket.Conn, bool) {
+	ip := remoteIP(r.RemoteAddr)
+	limits := currentSettings().Limits
+
+	connectionsMutex.Lock()
+	if until, banned := tempBans[ip]; banned && time.Now().Before(until) {
+		connectionsMutex.Unlock()
+		logger.Info("Rejected WebSocket connection from a temporarily banned address", "until", until)
+		connectionsRejected.With("banned").Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+		http.Error(w, "too many messages, try again later", http.StatusTooManyRequests)
+		return nil, false
+	}
+	delete(tempBans, ip)
+	if limits.MaxConnectionsPerIP > 0 && connectionsPerIP[ip] >= limits.MaxConnectionsPerIP {
+		connectionsMutex.Unlock()
+		logger.Info("Rejected WebSocket connection, too many from its address", "open", limits.MaxConnectionsPerIP)
+		connectionsRejected.With("perIP").Inc()
+		http.Error(w, "too many connections from your address", http.StatusTooManyRequests)
+		return nil, false
+	}
+	connectionsPerIP[ip]++
+	connectionsMutex.Unlock()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warn("Error upgrading to WebSocket", "err", err)
+		releaseIP(ip)
+		return nil, false
+	}
+	conn.SetReadLimit(limits.MaxMessageSize)
+
+	connectionsMutex.Lock()
+	connectionIPs[conn] = ip
+	connectionsMutex.Unlock()
+	return conn, true
+}
+
+// releaseConnection frees the connection's place in its address's count, it is safe to call more than once.
+func releaseConnection(conn *websocket.Conn) {
+	connectionsMutex.Lock()
+	ip, ok := connectionIPs[conn]
+	delete(connectionIPs, conn)
+	connectionsMutex.Unlock()
+	if ok {
+		releaseIP(ip)
+	}
+}
+
+func releaseIP(ip string) {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	if connectionsPerIP[ip]--; connectionsPerIP[ip] <= 0 {
+		delete(connectionsPerIP, ip)
+	}
+}
+
+// floodedOff records that an address was disconnected for sending too fast and bans it for BanDuration once
+// that happened BanAfter times within BanDuration. It returns whether the address is now banned.
+func floodedOff(ip string, now time.Time) bool {
+	limits := currentSettings().Limits
+	if limits.BanAfter == 0 {
+		return false
+	}
+
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	recent := floodDisconnects[ip][:0]
+	for _, at := range floodDisconnects[ip] {
+		if now.Sub(at) < limits.BanDuration {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < limits.BanAfter {
+		floodDisconnects[ip] = recent
+		return false
+	}
+	delete(floodDisconnects, ip)
+	tempBans[ip] = now.Add(limits.BanDuration)
+	rateLimitPenalties.With("ban").Inc()
+	audit(models.AuditEntry{At: now.UTC(), By: "server", Action: "tempBan", Target: "ip:" + ip, Reason: "sending too fast", Detail: "for " + limits.BanDuration.String()})
+	return true
+}
+
+// messageLimiter holds a connection's token buckets and counts the messages it had dropped lately.
+type messageLimiter struct {
+	limits             config.Limits
+	move, chat, signal *ratelimit.Bucket
+
+	mu          sync.Mutex
+	windowStart time.Time
+	dropped     int
+}
+
+func newMessageLimiter(limits config.Limits) *messageLimiter {
+	return &messageLimiter{
+		limits: limits,
+		move:   ratelimit.NewBucket(limits.MoveRate, limits.MoveBurst),
+		chat:   ratelimit.NewBucket(limits.ChatRate, limits.ChatBurst),
+		signal: ratelimit.NewBucket(limits.SignalRate, limits.SignalBurst),
+	}
+}
+
+// check takes a token for a message of the given type and says what to do with it. Messages over their rate
+// are dropped; the WarnAfter-th dropped within ViolationWindow also warns the client and the DisconnectAfter-th
+// disconnects it.
+func (l *messageLimiter) check(messageType string, now time.Time) penalty {
+	bucket := l.signal
+	switch messageType {
+	case "move":
+		bucket = l.move
+	case "chat":
+		bucket = l.chat
+	}
+	if bucket.Allow(now) {
+		return penaltyNone
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.windowStart) > l.limits.ViolationWindow {
+		l.windowStart = now
+		l.dropped = 0
+	}
+	l.dropped++
+	p := penaltyDrop
+	switch l.dropped {
+	case l.limits.WarnAfter:
+		p = penaltyWarn
+	case l.limits.DisconnectAfter:
+		p = penaltyDisconnect
+	}
+	rateLimitPenalties.With(p.String()).Inc()
+	return p
+}
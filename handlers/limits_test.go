@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitAuth(t *testing.T) {
+	previous := currentSettings()
+	c := previous
+	c.Limits.AuthRate, c.Limits.AuthBurst = 1, 3
+	Configure(c)
+	t.Cleanup(func() { Configure(previous) })
+
+	handler := LimitAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	attempt := func(remote string) int {
+		request := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+		request.RemoteAddr = remote
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := attempt("10.0.0.1:1000"); code != http.StatusOK {
+			t.Fatalf("attempt %d within the burst got %d", i+1, code)
+		}
+	}
+	if code := attempt("10.0.0.1:1001"); code != http.StatusTooManyRequests {
+		t.Fatalf("an attempt past the burst got %d, expected 429", code)
+	}
+	if code := attempt("10.0.0.2:1000"); code != http.StatusOK {
+		t.Fatalf("another address was throttled too, got %d", code)
+	}
+
+	// The bucket refills at AuthRate and is forgotten once full
+	now := time.Now()
+	if !allowAuth("10.0.0.1", now.Add(1100*time.Millisecond)) {
+		t.Fatal("no attempt was allowed a second later")
+	}
+	allowAuth("10.0.0.3", now.Add(time.Minute))
+	authMutex.Lock()
+	_, kept := authBuckets["10.0.0.1"]
+	authMutex.Unlock()
+	if kept {
+		t.Fatal("the bucket of an address that stopped trying was kept")
+	}
+}
@@ -0,0 +1,196 @@
+// Package handlers maps.go loads the map definitions and moves each room through its map rotation.
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/mapdef"
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// Where the map files are kept, set by LoadMaps
+var mapsDir string
+
+// Mutex to protect access to the loaded maps
+var mapsMutex sync.RWMutex
+
+// Map of map name to its definition
+var mapDefs = make(map[string]*mapdef.Map)
+
+// LoadMaps reads the map files in dir and checks that the rotations only name maps found there. The maps are read
+// again whenever the configuration is reloaded.
+func LoadMaps(dir string) error {
+	mapsDir = dir
+	loaded, err := mapdef.LoadDir(dir)
+	if err != nil {
+		return err
+	}
+	if err := checkRotations(loaded, currentSettings().Rooms); err != nil {
+		return err
+	}
+	setMaps(loaded)
+	slog.Info("Loaded maps", "dir", dir, "maps", len(loaded))
+	return nil
+}
+
+// reloadMaps reads the map files again, keeping the maps already loaded if any of them is broken.
+func reloadMaps(rooms config.Rooms) {
+	loaded, err := mapdef.LoadDir(mapsDir)
+	if err != nil {
+		slog.Error("Error reloading maps, keeping the ones loaded", "err", err)
+	} else {
+		setMaps(loaded)
+	}
+
+	mapsMutex.RLock()
+	names := make(map[string]mapdef.Map, len(mapDefs))
+	for name, m := range mapDefs {
+		names[name] = *m
+	}
+	mapsMutex.RUnlock()
+	if err := checkRotations(names, rooms); err != nil {
+		slog.Warn("Rooms skip the maps of their rotation that aren't loaded", "err", err)
+	}
+}
+
+func setMaps(loaded map[string]mapdef.Map) {
+	maps := make(map[string]*mapdef.Map, len(loaded))
+	for name, m := range loaded {
+		maps[name] = &m
+	}
+	mapsMutex.Lock()
+	mapDefs = maps
+	mapsMutex.Unlock()
+}
+
+// checkRotations returns an error for every map a rotation names that isn't loaded.
+func checkRotations(maps map[string]mapdef.Map, rooms config.Rooms) error {
+	var errs []error
+	check := func(setting string, rotation []string) {
+		for _, name := range rotation {
+			if _, ok := maps[name]; !ok {
+				errs = append(errs, fmt.Errorf("%s: there is no map %q in %s", setting, name, mapsDir))
+			}
+		}
+	}
+	check("rooms.maps", rooms.Maps)
+	for room, rotation := range rooms.RoomMaps {
+		check("rooms.roomMaps."+room, rotation)
+	}
+	return errors.Join(errs...)
+}
+
+// lookupMap returns a loaded map by name.
+func lookupMap(name string) (*mapdef.Map, bool) {
+	mapsMutex.RLock()
+	defer mapsMutex.RUnlock()
+	m, ok := mapDefs[name]
+	return m, ok
+}
+
+// mapRotation is the list of maps a room plays in turn.
+func mapRotation(rooms config.Rooms, roomID string) []string {
+	if rotation, ok := rooms.RoomMaps[roomID]; ok {
+		return rotation
+	}
+	return rooms.Maps
+}
+
+// rotationMap picks a room's map from its rotation: the one at index, or the one after it when advance is set,
+// skipping maps that aren't loaded. It returns -1 and nil when there is none, the room then plays the gameplay field.
+func rotationMap(roomID string, index int, advance bool) (int, *mapdef.Map) {
+	rotation := mapRotation(currentSettings().Rooms, roomID)
+	start := index
+	if advance || index < 0 {
+		start = index + 1
+	}
+	for i := range rotation {
+		next := (start + i) % len(rotation)
+		if m, ok := lookupMap(rotation[next]); ok {
+			return next, m
+		}
+	}
+	return -1, nil
+}
+
+// pickMap returns a map by name with its place in the room's rotation, -1 if it isn't in it.
+func pickMap(roomID, name string) (int, *mapdef.Map, bool) {
+	m, ok := lookupMap(name)
+	if !ok {
+		return -1, nil, false
+	}
+	return slices.Index(mapRotation(currentSettings().Rooms, roomID), name), m, true
+}
+
+// sameMap reports whether two maps are the same, even when one of them was read again since.
+func sameMap(a, b *mapdef.Map) bool {
+	return a == b || a != nil && b != nil && reflect.DeepEqual(*a, *b)
+}
+
+// mapNameLocked is the name of the map the room plays, empty for the gameplay field. The caller must hold r.mu.
+func (r *Room) mapNameLocked() string {
+	if r.mapDef == nil {
+		return ""
+	}
+	return r.mapDef.Name
+}
+
+// currentMap returns the name of the map the room plays, empty for the gameplay field.
+func (r *Room) currentMap() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mapNameLocked()
+}
+
+// blocked reports whether a point of the room's field is on a cell players can't walk on.
+func (r *Room) blocked(x, y float64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.world.Blocked(x, y)
+}
+
+// mapDueLocked reports whether the room has played its map for Rooms.MapDuration and has another map to move on
+// to. The caller must hold r.mu.
+func (r *Room) mapDueLocked() bool {
+	rooms := currentSettings().Rooms
+	return rooms.MapDuration > 0 && len(mapRotation(rooms, r.ID)) > 1 && time.Since(r.mapStarted) >= rooms.MapDuration
+}
+
+// rotateMap starts the room over on the next map of its rotation once its time on the current one is up. A room
+// without humans waits for the next one to join instead, the round then starts on the next map.
+func (r *Room) rotateMap() {
+	r.mu.Lock()
+	due := len(r.clients) > 0 && r.mapDueLocked()
+	r.mu.Unlock()
+	if due {
+		r.reset("")
+		r.logger.Info("Moved on to the next map", "map", r.currentMap())
+	}
+}
+
+// ListMaps lists the loaded maps with their terrain, sorted by name.
+func ListMaps(w http.ResponseWriter, r *http.Request) {
+	mapsMutex.RLock()
+	infos := make([]models.MapInfo, 0, len(mapDefs))
+	for _, m := range mapDefs {
+		infos = append(infos, models.MapInfo{
+			Name:     m.Name,
+			Width:    m.Width,
+			Height:   m.Height,
+			CellSize: m.CellSize,
+			Terrain:  m.Terrain(),
+		})
+	}
+	mapsMutex.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	writeJSON(w, http.StatusOK, infos)
+}
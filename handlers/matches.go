@@ -0,0 +1,289 @@
+// Package handlers matches.go keeps a history of finished matches and works out per-player statistics from it.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/store"
+	"github.com/go-chi/chi"
+)
+
+const (
+	matchesBucket       = "matches"
+	playerMatchesPrefix = "playerMatches:" // plus a player ID, a bucket of their match summaries keyed by finish time
+
+	DefaultRecentMatches = 10
+	MaxRecentMatches     = 100
+)
+
+// matchRecord collects a match while it is played, it is saved when the last human leaves.
+type matchRecord struct {
+	match   models.Match
+	players map[string]*matchPlayer
+	order   []string // join order
+}
+
+type matchPlayer struct {
+	models.MatchPlayer
+	life *models.Life // nil while dead or gone
+}
+
+// startMatchLocked starts recording a match with whoever is already in the room, the caller must hold r.mu.
+func (r *Room) startMatchLocked() {
+	r.match = &matchRecord{
+		match:   models.Match{ID: generateClientID(), RoomID: r.ID, Started: time.Now().UTC()},
+		players: make(map[string]*matchPlayer),
+	}
+	if r.recorder != nil {
+		r.match.match.Replay = r.recorder.Name
+	}
+	playersMutex.Lock()
+	for _, player := range r.world.Players() {
+		r.joinMatchLocked(player)
+	}
+	playersMutex.Unlock()
+}
+
+// joinMatchLocked adds a player to the match, the caller must hold r.mu and playersMutex.
+func (r *Room) joinMatchLocked(player *models.Player) {
+	if r.match == nil {
+		return
+	}
+	now := time.Now().UTC()
+	p, ok := r.match.players[player.ID]
+	if !ok {
+		_, human := r.clients[player.ID]
+		p = &matchPlayer{MatchPlayer: models.MatchPlayer{ID: player.ID, Bot: !human, Joined: now}}
+		r.match.players[player.ID] = p
+		r.match.order = append(r.match.order, player.ID)
+	}
+	p.Name, p.Color, p.Left = player.Name, player.Color, time.Time{}
+	if player.IsAlive && p.life == nil {
+		p.life = &models.Life{Started: now}
+	}
+	r.matchTerritoryLocked(player)
+}
+
+// leaveMatchLocked ends a leaving player's life, the caller must hold r.mu.
+func (r *Room) leaveMatchLocked(playerID string) {
+	if r.match == nil {
+		return
+	}
+	if p, ok := r.match.players[playerID]; ok {
+		now := time.Now().UTC()
+		p.endLife(models.CauseLeft, "", now)
+		p.Left = now
+	}
+}
+
+// matchTerritoryLocked raises the player's peak territory, the caller must hold r.mu and playersMutex.
+func (r *Room) matchTerritoryLocked(player *models.Player) {
+	p, ok := r.match.players[player.ID]
+	if !ok {
+		return
+	}
+	territory := r.territoryPercent(player)
+	p.PeakTerritory = max(p.PeakTerritory, territory)
+	if p.life != nil {
+		p.life.PeakTerritory = max(p.life.PeakTerritory, territory)
+	}
+}
+
+// matchEventsLocked adds a tick's events to the match, the caller must hold r.mu and playersMutex.
+func (r *Room) matchEventsLocked(events []game.Event) {
+	if r.match == nil {
+		return
+	}
+	now := time.Now().UTC()
+	for _, event := range events {
+		p, ok := r.match.players[event.PlayerID]
+		if !ok {
+			continue
+		}
+		switch event.Type {
+		case game.EventCapture:
+			r.matchTerritoryLocked(r.world.Player(event.PlayerID))
+		case game.EventDeath:
+			p.Deaths++
+			if killer, ok := r.match.players[event.OtherID]; ok {
+				killer.Kills++
+				if killer.life != nil {
+					killer.life.Kills++
+				}
+				// The killer takes the dead player's land
+				r.matchTerritoryLocked(r.world.Player(event.OtherID))
+				p.endLife(event.Cause, event.OtherID, now)
+			} else {
+				p.endLife(event.Cause, "", now)
+			}
+		case game.EventRespawn:
+			p.life = &models.Life{Started: now}
+			r.matchTerritoryLocked(r.world.Player(event.PlayerID))
+		}
+	}
+}
+
+func (p *matchPlayer) endLife(cause, killedBy string, now time.Time) {
+	if p.life == nil {
+		return
+	}
+	p.life.Duration = now.Sub(p.life.Started).Seconds()
+	p.life.Cause, p.life.KilledBy = cause, killedBy
+	p.Lives = append(p.Lives, *p.life)
+	p.life = nil
+}
+
+// finishMatchLocked ends the match and returns a function that saves it, to run once the room is unlocked.
+// The caller must hold r.mu.
+func (r *Room) finishMatchLocked() func() {
+	record := r.match
+	r.match = nil
+	if record == nil {
+		return func() {}
+	}
+
+	match := record.match
+	match.Ended = time.Now().UTC()
+	match.Duration = match.Ended.Sub(match.Started).Seconds()
+	for _, id := range record.order {
+		p := record.players[id]
+		p.endLife(models.CauseMatchEnd, "", match.Ended)
+		if p.Left.IsZero() {
+			p.Left = match.Ended
+		}
+		match.Players = append(match.Players, p.MatchPlayer)
+	}
+	return func() {
+		saveMatch(match)
+		publish(Event{Type: EventTypeMatchEnd, RoomID: match.RoomID, Match: &match})
+	}
+}
+
+// saveMatch stores a finished match and adds it to the history of every human in it.
+func saveMatch(match models.Match) {
+	if db == nil {
+		return
+	}
+	if err := db.Put(matchesBucket, match.ID, match); err != nil {
+		slog.Error("Error saving match", "match", match.ID, "room", match.RoomID, "err", err)
+		return
+	}
+	for _, player := range match.Players {
+		if player.Bot {
+			continue
+		}
+		summary := models.MatchSummary{
+			MatchID:       match.ID,
+			RoomID:        match.RoomID,
+			Started:       match.Started,
+			Ended:         match.Ended,
+			SecondsPlayed: player.Left.Sub(player.Joined).Seconds(),
+			PeakTerritory: player.PeakTerritory,
+			Kills:         player.Kills,
+			Deaths:        player.Deaths,
+			Lives:         len(player.Lives),
+		}
+		for _, life := range player.Lives {
+			summary.SecondsAlive += life.Duration
+			summary.LongestLife = max(summary.LongestLife, life.Duration)
+		}
+		// Keys sort by finish time so the history reads in order
+		key := match.Ended.Format("20060102T150405.000000000") + "/" + match.ID
+		if err := db.Put(playerMatchesPrefix+player.ID, key, summary); err != nil {
+			slog.Error("Error adding match to player history", "match", match.ID, "client", player.ID, "err", err)
+		}
+	}
+	slog.Info("Saved match", "match", match.ID, "room", match.RoomID, "players", len(match.Players))
+}
+
+// GetMatch returns a finished match with every player's lives.
+func GetMatch(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "match history is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var match models.Match
+	err := db.Get(matchesBucket, chi.URLParam(r, "id"), &match)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, match)
+}
+
+// GetPlayerStats returns a player's lifetime stats and their match history, ?limit= sets how many recent
+// matches are listed.
+func GetPlayerStats(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "match history is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	limit := DefaultRecentMatches
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "limit must be a number", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, MaxRecentMatches)
+	}
+
+	id := chi.URLParam(r, "id")
+	stats := models.PlayerStats{PlayerID: id, Recent: []models.MatchSummary{}}
+	var profile models.Profile
+	err := db.Get(profilesBucket, id, &profile)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats.Name, stats.Lifetime = profile.Name, profile.Stats
+
+	var history []models.MatchSummary
+	err = db.ForEach(playerMatchesPrefix+id, func(_ string, raw json.RawMessage) error {
+		var summary models.MatchSummary
+		if err := json.Unmarshal(raw, &summary); err != nil {
+			return err
+		}
+		history = append(history, summary)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var kills, deaths int
+	var lived float64
+	for _, summary := range history {
+		stats.Matches++
+		stats.Lives += summary.Lives
+		stats.LongestLife = max(stats.LongestLife, summary.LongestLife)
+		stats.PeakTerritory = max(stats.PeakTerritory, summary.PeakTerritory)
+		kills += summary.Kills
+		deaths += summary.Deaths
+		lived += summary.SecondsAlive
+	}
+	if stats.Lives > 0 {
+		stats.AverageLife = lived / float64(stats.Lives)
+	}
+	stats.KillsPerDeath = float64(kills) / float64(max(deaths, 1))
+	for i := len(history) - 1; i >= 0 && len(stats.Recent) < limit; i-- {
+		stats.Recent = append(stats.Recent, history[i])
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
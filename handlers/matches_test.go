@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// Every life ends with how it ended, and kills go to the killer and their life at the time.
+func TestMatchRecordsCausesAndKills(t *testing.T) {
+	useStore(t)
+	room := NewRoom("match", 8, 1, config.Default().Gameplay)
+	room.mu.Lock()
+	playersMutex.Lock()
+	for _, id := range []string{"a", "b", "c", "d"} {
+		room.addPlayerLocked(newPlayer(id, nil))
+	}
+	playersMutex.Unlock()
+	room.clients["a"] = &Client{ID: "a", logger: slog.Default()}
+	room.startMatchLocked()
+
+	playersMutex.Lock()
+	room.matchEventsLocked([]game.Event{
+		{Type: game.EventDeath, PlayerID: "a", OtherID: "b", Cause: models.CauseKilled},
+		{Type: game.EventRespawn, PlayerID: "a"},
+		{Type: game.EventDeath, PlayerID: "a", Cause: models.CauseHazard},
+		{Type: game.EventDeath, PlayerID: "c", Cause: models.CauseTrail},
+	})
+	playersMutex.Unlock()
+	room.leaveMatchLocked("b")
+	save := room.finishMatchLocked()
+	room.mu.Unlock()
+	save()
+
+	var ids []string
+	db.ForEach(matchesBucket, func(id string, _ json.RawMessage) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if len(ids) != 1 {
+		t.Fatalf("expected one saved match, got %v", ids)
+	}
+	var match models.Match
+	if err := db.Get(matchesBucket, ids[0], &match); err != nil {
+		t.Fatal(err)
+	}
+	players := make(map[string]models.MatchPlayer)
+	for _, player := range match.Players {
+		players[player.ID] = player
+	}
+
+	for _, test := range []struct {
+		id            string
+		bot           bool
+		kills, deaths int
+		lives         []models.Life
+	}{
+		{"a", false, 0, 2, []models.Life{{Cause: models.CauseKilled, KilledBy: "b"}, {Cause: models.CauseHazard}}},
+		{"b", true, 1, 0, []models.Life{{Cause: models.CauseLeft, Kills: 1}}},
+		{"c", true, 0, 1, []models.Life{{Cause: models.CauseTrail}}},
+		{"d", true, 0, 0, []models.Life{{Cause: models.CauseMatchEnd}}},
+	} {
+		player := players[test.id]
+		if player.Bot != test.bot || player.Kills != test.kills || player.Deaths != test.deaths || len(player.Lives) != len(test.lives) {
+			t.Errorf("%s: expected bot=%v, %d kills, %d deaths and %d lives, got %+v", test.id, test.bot, test.kills,
+				test.deaths, len(test.lives), player)
+			continue
+		}
+		for i, want := range test.lives {
+			life := player.Lives[i]
+			if life.Cause != want.Cause || life.KilledBy != want.KilledBy || life.Kills != want.Kills {
+				t.Errorf("%s life %d: expected %+v, got %+v", test.id, i+1, want, life)
+			}
+		}
+	}
+
+	// Only the human gets the match in their history
+	var history []models.MatchSummary
+	for _, id := range []string{"a", "b"} {
+		db.ForEach(playerMatchesPrefix+id, func(_ string, raw json.RawMessage) error {
+			var summary models.MatchSummary
+			json.Unmarshal(raw, &summary)
+			history = append(history, summary)
+			return nil
+		})
+	}
+	if len(history) != 1 || history[0].MatchID != match.ID || history[0].Deaths != 2 || history[0].Lives != 2 {
+		t.Fatalf("expected the match in a's history with 2 deaths and 2 lives, got %+v", history)
+	}
+}
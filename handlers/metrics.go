@@ -0,0 +1,125 @@
+// Package handlers metrics.go declares the server's Prometheus metrics and counts messages as they pass.
+package handlers
+
+import (
+	"bytes"
+	"runtime"
+	"time"
+
+	"github.com/4cecoder/multiplayer/metrics"
+)
+
+var (
+	tickDuration   = metrics.NewHistogram("multiplayer_tick_duration_seconds", "Time taken to step a room.", metrics.DefaultBuckets)
+	messagesIn     = metrics.NewCounterVec("multiplayer_messages_received_total", "Messages received from players and spectators by type.", "type")
+	messagesOut    = metrics.NewCounterVec("multiplayer_messages_sent_total", "Messages queued for players and spectators by type.", "type")
+	bytesSent      = metrics.NewCounter("multiplayer_bytes_sent_total", "Bytes written to player and spectator connections.")
+	sendOverflows  = metrics.NewCounter("multiplayer_send_buffer_overflows_total", "Messages that didn't fit in a player's send buffer and went to its MessageQueue.")
+	spectatorDrops = metrics.NewCounter("multiplayer_spectator_messages_dropped_total", "Messages dropped because a spectator's send buffer was full.")
+	reconnects     = metrics.NewCounter("multiplayer_reconnects_total", "Players that got their session back with a resume token.")
+
+	rateLimitPenalties  = metrics.NewCounterVec("multiplayer_rate_limit_penalties_total", "Penalties for going over a message rate limit: drop, warn, disconnect and ban.", "penalty")
+	connectionsRejected = metrics.NewCounterVec("multiplayer_connections_rejected_total", "WebSocket connections turned away by reason: perIP or banned.", "reason")
+	authThrottled       = metrics.NewCounter("multiplayer_auth_throttled_total", "Logins and registrations turned away for coming too fast from one address.")
+	anticheatStrikes    = metrics.NewCounterVec("multiplayer_anticheat_strikes_total", "Inputs rejected by the anti-cheat checks by kind of strike.", "kind")
+	playersFlagged      = metrics.NewCounter("multiplayer_players_flagged_total", "Times a player earned enough strikes to be flagged as a likely cheater.")
+	oversizedMessages   = metrics.NewCounter("multiplayer_oversized_messages_total", "Connections closed for sending a message over the size limit.")
+
+	eventsPublished = metrics.NewCounterVec("multiplayer_events_published_total", "Events published on the event bus by type.", "type")
+	eventsDropped   = metrics.NewCounterVec("multiplayer_events_dropped_total", "Events a subscriber missed because its buffer was full.", "subscriber")
+
+	checkpointsWritten = metrics.NewCounter("multiplayer_checkpoints_written_total", "Room checkpoints written to disk.")
+	roomRedirects      = metrics.NewCounter("multiplayer_room_redirects_total", "Players sent to the node that plays the room they asked for.")
+	webhookAttempts    = metrics.NewCounterVec("multiplayer_webhook_attempts_total", "Webhook delivery attempts by result: delivered, retry or failed.", "result")
+)
+
+// Incoming message types counted by name, anything else a client sends is counted as other
+var knownMessageTypes = map[string]bool{
+	"move": true, "capture": true, "chat": true, "join": true, "follow": true, "camera": true, "speed": true,
+}
+
+func init() {
+	started := time.Now()
+	metrics.NewGaugeFunc("multiplayer_connected_clients", "Players connected to the server.", func() float64 {
+		clientsMutex.Lock()
+		defer clientsMutex.Unlock()
+		return float64(len(clients))
+	})
+	metrics.NewGaugeFunc("multiplayer_rooms", "Rooms running.", func() float64 {
+		roomsMutex.Lock()
+		defer roomsMutex.Unlock()
+		return float64(len(rooms))
+	})
+	metrics.NewGaugeFunc("multiplayer_players_alive", "Players alive in every room, bots included.", func() float64 {
+		alive := 0
+		for _, room := range roomList() {
+			room.mu.Lock()
+			playersMutex.Lock()
+			for _, player := range room.world.Players() {
+				if player.IsAlive {
+					alive++
+				}
+			}
+			playersMutex.Unlock()
+			room.mu.Unlock()
+		}
+		return float64(alive)
+	})
+	metrics.NewGaugeFunc("multiplayer_bots", "Bots playing in every room.", func() float64 {
+		bots := 0
+		for _, room := range roomList() {
+			room.mu.Lock()
+			bots += len(room.bots)
+			room.mu.Unlock()
+		}
+		return float64(bots)
+	})
+	metrics.NewGaugeFunc("multiplayer_spectators", "Spectators watching a room.", func() float64 {
+		spectators := 0
+		for _, room := range roomList() {
+			room.mu.Lock()
+			spectators += len(room.spectators)
+			room.mu.Unlock()
+		}
+		return float64(spectators)
+	})
+	metrics.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	metrics.NewGaugeFunc("process_uptime_seconds", "Seconds since the server started.", func() float64 {
+		return time.Since(started).Seconds()
+	})
+}
+
+// roomList returns the rooms without holding roomsMutex, so each can be locked in turn.
+func roomList() []*Room {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	list := make([]*Room, 0, len(rooms))
+	for _, room := range rooms {
+		list = append(list, room)
+	}
+	return list
+}
+
+// messageType reads the type of an outgoing message, which always starts with its "type" field.
+func messageType(message []byte) string {
+	rest, ok := bytes.CutPrefix(message, []byte(`{"type":"`))
+	if !ok {
+		return "unknown"
+	}
+	end := bytes.IndexByte(rest, '"')
+	if end < 0 {
+		return "unknown"
+	}
+	return string(rest[:end])
+}
+
+// countIncoming counts a message from a client by its type.
+func countIncoming(messageType string) {
+	if !knownMessageTypes[messageType] {
+		messageType = "other"
+	}
+	messagesIn.With(messageType).Inc()
+}
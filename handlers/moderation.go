@@ -0,0 +1,380 @@
+// Package handlers moderation.go keeps banned players out, mutes players in chat and keeps the audit log of
+// what admins did about them. Bans and mutes live in the store and survive restarts.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/store"
+)
+
+const (
+	bansBucket  = "bans"  // ban ID to the ban
+	mutesBucket = "mutes" // player ID to when they were muted
+	auditBucket = "audit" // time of the action to the audit entry
+)
+
+// What a ban matches a connecting player by
+const (
+	BanClient  = "client"  // the client ID they play as
+	BanAccount = "account" // the username they log in with
+	BanIP      = "ip"      // the address they connect from, or a CIDR range of addresses
+)
+
+// MaxAuditLog is how many audit entries are kept, older ones are dropped.
+const MaxAuditLog = 10000
+
+var ErrInvalidBan = errors.New("bans need a kind of client, account or ip and a target")
+
+// activeBan is a ban with its address range parsed, for IP bans.
+type activeBan struct {
+	models.Ban
+	network *net.IPNet
+}
+
+// Mutex to protect access to the bans and mutes
+var moderationMutex sync.Mutex
+
+// Bans by ID, loaded from the store by LoadModeration
+var bans = make(map[string]activeBan)
+
+// Players whose chat is dropped until they are unmuted
+var mutes = make(map[string]bool)
+
+// Tells apart audit entries written in the same nanosecond
+var auditSequence atomic.Uint32
+
+// LoadModeration reads the bans and mutes from the store, dropping expired bans.
+func LoadModeration() error {
+	if db == nil {
+		return nil
+	}
+	now := time.Now()
+
+	moderationMutex.Lock()
+	defer moderationMutex.Unlock()
+
+	err := db.ForEach(bansBucket, func(key string, value json.RawMessage) error {
+		var ban models.Ban
+		if err := json.Unmarshal(value, &ban); err != nil {
+			return err
+		}
+		if ban.Expires != nil && !now.Before(*ban.Expires) {
+			return db.Delete(bansBucket, key)
+		}
+		active, err := parseBan(ban)
+		if err != nil {
+			slog.Warn("Skipping a ban that can't be read", "ban", key, "err", err)
+			return nil
+		}
+		bans[ban.ID] = active
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = db.ForEach(mutesBucket, func(key string, _ json.RawMessage) error {
+		mutes[key] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Loaded moderation", "bans", len(bans), "mutes", len(mutes))
+	return nil
+}
+
+// parseBan checks a ban's kind and target, normalising the target and parsing the range of an IP ban.
+func parseBan(ban models.Ban) (activeBan, error) {
+	ban.Target = strings.TrimSpace(ban.Target)
+	if ban.Target == "" {
+		return activeBan{}, ErrInvalidBan
+	}
+	switch ban.Kind {
+	case BanClient:
+	case BanAccount:
+		ban.Target = strings.ToLower(ban.Target)
+	case BanIP:
+		target := ban.Target
+		if !strings.Contains(target, "/") {
+			ip := net.ParseIP(target)
+			if ip == nil {
+				return activeBan{}, fmt.Errorf("%q is not an address or CIDR range", ban.Target)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			target = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, network, err := net.ParseCIDR(target)
+		if err != nil {
+			return activeBan{}, fmt.Errorf("%q is not an address or CIDR range", ban.Target)
+		}
+		return activeBan{Ban: ban, network: network}, nil
+	default:
+		return activeBan{}, ErrInvalidBan
+	}
+	return activeBan{Ban: ban}, nil
+}
+
+// addBan bans a client ID, account or address range, for good when duration is 0.
+func addBan(kind, target, reason, by string, duration time.Duration) (models.Ban, error) {
+	if db == nil {
+		return models.Ban{}, errors.New("bans need the store")
+	}
+	if duration < 0 {
+		return models.Ban{}, errors.New("ban duration can't be negative")
+	}
+	now := time.Now().UTC()
+	ban := models.Ban{ID: generateClientID(), Kind: kind, Target: target, Reason: reason, By: by, Created: now}
+	if duration > 0 {
+		expires := now.Add(duration)
+		ban.Expires = &expires
+	}
+	active, err := parseBan(ban)
+	if err != nil {
+		return models.Ban{}, err
+	}
+
+	moderationMutex.Lock()
+	defer moderationMutex.Unlock()
+	if err := db.Put(bansBucket, active.ID, active.Ban); err != nil {
+		return models.Ban{}, err
+	}
+	bans[active.ID] = active
+	return active.Ban, nil
+}
+
+// liftBan removes a ban by its ID, returning store.ErrNotFound if there is none.
+func liftBan(id string) (models.Ban, error) {
+	moderationMutex.Lock()
+	defer moderationMutex.Unlock()
+
+	active, ok := bans[id]
+	if !ok {
+		return models.Ban{}, store.ErrNotFound
+	}
+	if err := db.Delete(bansBucket, id); err != nil {
+		return models.Ban{}, err
+	}
+	delete(bans, id)
+	return active.Ban, nil
+}
+
+// bansOn returns the bans of a kind on a target, such as every ban on a client ID.
+func bansOn(kind, target string) []models.Ban {
+	moderationMutex.Lock()
+	defer moderationMutex.Unlock()
+
+	var found []models.Ban
+	for _, active := range bans {
+		if active.Kind == kind && active.Target == target {
+			found = append(found, active.Ban)
+		}
+	}
+	return found
+}
+
+// listBans returns the bans in force, oldest first, and forgets the ones that ran out.
+func listBans(now time.Time) []models.Ban {
+	moderationMutex.Lock()
+	defer moderationMutex.Unlock()
+
+	list := make([]models.Ban, 0, len(bans))
+	for id, active := range bans {
+		if active.Expires != nil && !now.Before(*active.Expires) {
+			delete(bans, id)
+			if err := db.Delete(bansBucket, id); err != nil {
+				slog.Error("Error deleting an expired ban", "ban", id, "err", err)
+			}
+			continue
+		}
+		list = append(list, active.Ban)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// banFor returns a ban in force on the client ID, the account or the address, if there is one.
+// username is empty for guests.
+func banFor(clientID, username, ip string, now time.Time) (models.Ban, bool) {
+	address := net.ParseIP(ip)
+	username = strings.ToLower(username)
+
+	moderationMutex.Lock()
+	defer moderationMutex.Unlock()
+
+	for _, active := range bans {
+		if active.Expires != nil && !now.Before(*active.Expires) {
+			continue
+		}
+		switch {
+		case active.Kind == BanClient && active.Target == clientID,
+			active.Kind == BanAccount && username != "" && active.Target == username,
+			active.Kind == BanIP && address != nil && active.network.Contains(address):
+			return active.Ban, true
+		}
+	}
+	return models.Ban{}, false
+}
+
+// banMessage is what a banned player is told.
+func banMessage(ban models.Ban) string {
+	message := "banned"
+	if ban.Reason != "" {
+		message += ": " + ban.Reason
+	}
+	if ban.Expires != nil {
+		message += " (until " + ban.Expires.Format(time.RFC3339) + ")"
+	}
+	return message
+}
+
+// enforceBan kicks the connected players a new ban applies to and returns their IDs.
+func enforceBan(ban models.Ban) []string {
+	active, err := parseBan(ban)
+	if err != nil {
+		return nil
+	}
+	accountPlayer := ""
+	if active.Kind == BanAccount && db != nil {
+		var found account
+		if db.Get(accountsBucket, active.Target, &found) == nil {
+			accountPlayer = found.PlayerID
+		}
+	}
+
+	clientsMutex.Lock()
+	var matched []string
+	for id, client := range clients {
+		switch active.Kind {
+		case BanClient:
+			if id == active.Target {
+				matched = append(matched, id)
+			}
+		case BanAccount:
+			if id == accountPlayer {
+				matched = append(matched, id)
+			}
+		case BanIP:
+			if address := net.ParseIP(remoteIP(client.RemoteAddr)); address != nil && active.network.Contains(address) {
+				matched = append(matched, id)
+			}
+		}
+	}
+	clientsMutex.Unlock()
+
+	kicked := matched[:0]
+	for _, id := range matched {
+		if kick(id, banMessage(ban)) {
+			kicked = append(kicked, id)
+		}
+	}
+	return kicked
+}
+
+// setMuted mutes or unmutes a player, keeping it in the store when there is one.
+func setMuted(playerID string, muted bool) error {
+	moderationMutex.Lock()
+	defer moderationMutex.Unlock()
+
+	if muted {
+		if db != nil {
+			if err := db.Put(mutesBucket, playerID, time.Now().UTC()); err != nil {
+				return err
+			}
+		}
+		mutes[playerID] = true
+		return nil
+	}
+	if db != nil {
+		if err := db.Delete(mutesBucket, playerID); err != nil {
+			return err
+		}
+	}
+	delete(mutes, playerID)
+	return nil
+}
+
+func isMuted(playerID string) bool {
+	moderationMutex.Lock()
+	defer moderationMutex.Unlock()
+
+	return mutes[playerID]
+}
+
+// audit adds an entry to the audit log, dropping the oldest entries past MaxAuditLog.
+func audit(entry models.AuditEntry) {
+	if entry.At.IsZero() {
+		entry.At = time.Now().UTC()
+	}
+	if db == nil {
+		return
+	}
+	// Keys sort in the order the actions happened
+	key := fmt.Sprintf("%020d-%010d", entry.At.UnixNano(), auditSequence.Add(1))
+	if err := db.Put(auditBucket, key, entry); err != nil {
+		slog.Error("Error writing the audit log", "action", entry.Action, "err", err)
+		return
+	}
+	if err := trimBucket(auditBucket, MaxAuditLog); err != nil {
+		slog.Error("Error trimming the audit log", "err", err)
+	}
+}
+
+// trimBucket deletes the first values of a bucket in key order until it holds at most max, for logs keyed by time.
+func trimBucket(bucket string, max int) error {
+	excess := db.Len(bucket) - max
+	if excess <= 0 {
+		return nil
+	}
+	errDone := errors.New("done")
+	err := db.ForEach(bucket, func(key string, _ json.RawMessage) error {
+		if excess == 0 {
+			return errDone
+		}
+		excess--
+		return db.Delete(bucket, key)
+	})
+	if errors.Is(err, errDone) {
+		return nil
+	}
+	return err
+}
+
+// auditLog returns up to limit of the latest audit entries, newest first.
+func auditLog(limit int) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+	if db == nil {
+		return entries, nil
+	}
+	err := db.ForEach(auditBucket, func(_ string, value json.RawMessage) error {
+		var entry models.AuditEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
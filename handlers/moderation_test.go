@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+func TestParseBan(t *testing.T) {
+	for _, test := range []struct {
+		kind, target string
+		network      string // the parsed range of IP bans
+		normalised   string
+		ok           bool
+	}{
+		{kind: BanIP, target: "10.1.2.3", network: "10.1.2.3/32", ok: true},
+		{kind: BanIP, target: " 10.1.0.0/16 ", network: "10.1.0.0/16", ok: true},
+		{kind: BanIP, target: "10.1.2.3/16", network: "10.1.0.0/16", ok: true},
+		{kind: BanIP, target: "2001:db8::1", network: "2001:db8::1/128", ok: true},
+		{kind: BanIP, target: "2001:db8::/32", network: "2001:db8::/32", ok: true},
+		{kind: BanIP, target: "10.1.2.300"},
+		{kind: BanIP, target: "10.1.0.0/33"},
+		{kind: BanIP, target: "example.com"},
+		{kind: BanAccount, target: " Alice ", normalised: "alice", ok: true},
+		{kind: BanClient, target: "3f2a", normalised: "3f2a", ok: true},
+		{kind: BanClient, target: "  "},
+		{kind: "player", target: "3f2a"},
+	} {
+		active, err := parseBan(models.Ban{Kind: test.kind, Target: test.target})
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%s %q: expected ok=%v, got %v", test.kind, test.target, test.ok, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if test.network != "" && active.network.String() != test.network {
+			t.Errorf("%s %q: expected range %s, got %s", test.kind, test.target, test.network, active.network)
+		}
+		if test.normalised != "" && active.Target != test.normalised {
+			t.Errorf("%s %q: expected target %q, got %q", test.kind, test.target, test.normalised, active.Target)
+		}
+	}
+}
+
+func TestBanFor(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	later := now.Add(time.Hour)
+
+	moderationMutex.Lock()
+	saved := bans
+	bans = make(map[string]activeBan)
+	moderationMutex.Unlock()
+	defer func() {
+		moderationMutex.Lock()
+		bans = saved
+		moderationMutex.Unlock()
+	}()
+	for _, ban := range []models.Ban{
+		{ID: "range", Kind: BanIP, Target: "10.1.0.0/16", Expires: &later},
+		{ID: "v6", Kind: BanIP, Target: "2001:db8::/32"},
+		{ID: "old", Kind: BanIP, Target: "192.168.0.0/16", Expires: &expired},
+		{ID: "account", Kind: BanAccount, Target: "Alice"},
+		{ID: "client", Kind: BanClient, Target: "c1"},
+	} {
+		active, err := parseBan(ban)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bans[ban.ID] = active
+	}
+
+	for _, test := range []struct {
+		client, username, ip string
+		ban                  string // the ban expected to match, empty for none
+	}{
+		{client: "c2", ip: "10.1.200.7", ban: "range"},
+		{client: "c2", ip: "::ffff:10.1.0.1", ban: "range"},
+		{client: "c2", ip: "10.2.0.1"},
+		{client: "c2", ip: "2001:db8:5::9", ban: "v6"},
+		{client: "c2", ip: "2001:db9::1"},
+		{client: "c2", ip: "192.168.1.1"},
+		{client: "c2", username: "ALICE", ip: "127.0.0.1", ban: "account"},
+		{client: "c2", username: "bob", ip: "127.0.0.1"},
+		{client: "c1", ip: "127.0.0.1", ban: "client"},
+		{client: "c2", ip: "not an address"},
+	} {
+		ban, banned := banFor(test.client, test.username, test.ip, now)
+		if banned != (test.ban != "") || ban.ID != test.ban {
+			t.Errorf("%s %q from %s: expected ban %q, got %q", test.client, test.username, test.ip, test.ban, ban.ID)
+		}
+	}
+}
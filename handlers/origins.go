@@ -0,0 +1,36 @@
+// Package handlers origins.go decides which web pages may open a WebSocket to the server.
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// checkOrigin lets browsers connect from the server's own pages, the pages of the other nodes in the room directory
+// and network.allowedOrigins. Connections without an Origin header come from programs like the Go client rather
+// than pages, and are let through.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range currentSettings().Network.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	// A page from another node sends its player here when this node plays their room
+	if clusterOrigin(origin) {
+		return true
+	}
+	slog.Warn("Rejected WebSocket from another origin", "origin", origin, "remote", r.RemoteAddr,
+		"request", middleware.GetReqID(r.Context()))
+	return false
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/4cecoder/multiplayer/directory"
+)
+
+// useAllowedOrigins sets network.allowedOrigins for the test, the settings in use are put back when it ends.
+func useAllowedOrigins(t *testing.T, origins ...string) {
+	settingsMutex.Lock()
+	previous := settings
+	settings.Network.AllowedOrigins = origins
+	settingsMutex.Unlock()
+	t.Cleanup(func() {
+		settingsMutex.Lock()
+		settings = previous
+		settingsMutex.Unlock()
+	})
+}
+
+// fromOrigin reports whether a WebSocket to game.example is let through from the origin.
+func fromOrigin(origin string) bool {
+	r := httptest.NewRequest("GET", "http://game.example/ws", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	return checkOrigin(r)
+}
+
+func TestCheckOrigin(t *testing.T) {
+	useAllowedOrigins(t, "https://friends.example/", "HTTP://Partner.Example")
+	for origin, want := range map[string]bool{
+		"":                                  true, // not a page
+		"http://game.example":               true,
+		"https://GAME.example":              true,
+		"https://friends.example":           true, // allowed with a trailing slash
+		"http://partner.example":            true,
+		"https://partner.example":           false, // another scheme
+		"https://evil.example":              false,
+		"https://game.example.evil.example": false,
+		"null":                              false,
+	} {
+		if got := fromOrigin(origin); got != want {
+			t.Errorf("%q: expected %v, got %v", origin, want, got)
+		}
+	}
+
+	useAllowedOrigins(t, "*")
+	if !fromOrigin("https://evil.example") {
+		t.Error("expected * to allow every origin")
+	}
+}
+
+func TestCheckOriginLetsClusterNodesIn(t *testing.T) {
+	useAllowedOrigins(t)
+	previous := roomDirectory
+	t.Cleanup(func() { roomDirectory = previous })
+	nodes := directory.NewMemory(time.Minute)
+	roomDirectory = nodes
+	if err := nodes.Register(directory.Node{ID: "eu1", URL: "https://eu1.example"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !fromOrigin("https://eu1.example") {
+		t.Error("expected the page of a live node to be let in")
+	}
+	if fromOrigin("https://us1.example") {
+		t.Error("expected a node that isn't in the directory to be rejected")
+	}
+	if err := nodes.Leave("eu1"); err != nil {
+		t.Fatal(err)
+	}
+	if fromOrigin("https://eu1.example") {
+		t.Error("expected a node that left to be rejected")
+	}
+}
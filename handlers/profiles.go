@@ -0,0 +1,276 @@
+// Package handlers profiles.go keeps players' names, colours and lifetime stats between connections.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/store"
+	"github.com/go-chi/chi"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	profilesBucket     = "profiles"
+	profileNamesBucket = "profileNames" // lower-cased display name to the ID of the profile using it
+
+	MinNameLength = 3
+	MaxNameLength = 16
+)
+
+var (
+	ErrNameTaken    = errors.New("name is already taken")
+	ErrInvalidName  = fmt.Errorf("names are %d to %d letters, digits, spaces, '-', '_' or '.'", MinNameLength, MaxNameLength)
+	ErrInvalidColor = errors.New("colours are #RRGGBB")
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// The database behind profiles and everything else that outlives a connection, nil until OpenStore is called
+var db *store.DB
+
+// Mutex to serialise renames so two players can't claim the same name at once
+var profilesMutex sync.Mutex
+
+// OpenStore opens the database at path, without it profiles are not kept.
+func OpenStore(path string) error {
+	opened, err := store.Open(path)
+	if err != nil {
+		return err
+	}
+	db = opened
+	slog.Info("Opened store", "path", path)
+	return nil
+}
+
+func CloseStore() error {
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
+// loadProfile returns the profile of a player ID, creating it on first sight, and marks it as seen now.
+func loadProfile(id string) (models.Profile, error) {
+	profile := models.Profile{ID: id}
+	if db == nil {
+		return profile, nil
+	}
+
+	now := time.Now().UTC()
+	err := db.Update(profilesBucket, id, &profile, func(exists bool) error {
+		if !exists {
+			profile.Created = now
+			profile.Level = levelFor(0)
+			slog.Info("Created profile", "client", id)
+		}
+		profile.LastSeen = now
+		return nil
+	})
+	return profile, err
+}
+
+// newProfilePlayer creates the player of a human client, wearing their profile's name and colour.
+func newProfilePlayer(clientID string, conn *websocket.Conn) *models.Player {
+	player := newPlayer(clientID, conn)
+	profile, err := loadProfile(clientID)
+	if err != nil {
+		slog.Error("Error loading profile", "client", clientID, "err", err)
+		return player
+	}
+	applyProfile(player, profile)
+	return player
+}
+
+func applyProfile(player *models.Player, profile models.Profile) {
+	if profile.Name != "" {
+		player.Name = profile.Name
+	}
+	if profile.Color != "" {
+		player.Color = profile.Color
+	}
+}
+
+// updateStats changes the lifetime stats of a profile, players without one are ignored.
+func updateStats(id string, change func(stats *models.ProfileStats)) {
+	if db == nil {
+		return
+	}
+	var profile models.Profile
+	err := db.Update(profilesBucket, id, &profile, func(exists bool) error {
+		if !exists {
+			return store.ErrNotFound
+		}
+		change(&profile.Stats)
+		return nil
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.Error("Error updating stats", "client", id, "err", err)
+	}
+}
+
+// validateName tidies up a display name and checks it is allowed.
+func validateName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if length := utf8.RuneCountInString(name); length < MinNameLength || length > MaxNameLength {
+		return "", ErrInvalidName
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(" -_.", r) {
+			return "", ErrInvalidName
+		}
+	}
+	return name, nil
+}
+
+// saveProfile applies an update to a stored profile, claiming the new name if it is free. The profile is changed
+// in one update so stats and XP awarded meanwhile aren't lost, only the name index is guarded by profilesMutex.
+func saveProfile(id string, update models.ProfileUpdate) (models.Profile, error) {
+	var profile models.Profile
+	if update.Color != "" && !colorPattern.MatchString(update.Color) {
+		return profile, ErrInvalidColor
+	}
+	name := ""
+	if update.Name != "" {
+		var err error
+		if name, err = validateName(update.Name); err != nil {
+			return profile, err
+		}
+	}
+
+	claimed := false
+	if name != "" {
+		var err error
+		if claimed, err = claimName(id, name); err != nil {
+			return profile, err
+		}
+	}
+
+	var previous string
+	err := db.Update(profilesBucket, id, &profile, func(exists bool) error {
+		if !exists {
+			return store.ErrNotFound
+		}
+		previous = profile.Name
+		if name != "" {
+			profile.Name = name
+		}
+		if update.Color != "" {
+			profile.Color = strings.ToUpper(update.Color)
+		}
+		return nil
+	})
+	switch {
+	case err != nil && claimed:
+		releaseName(name)
+	case err == nil && name != "" && previous != "" && !strings.EqualFold(previous, name):
+		releaseName(previous)
+	}
+	return profile, err
+}
+
+// claimName takes a name in the name index for id, returning true if it was free until now.
+func claimName(id, name string) (bool, error) {
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+
+	var owner string
+	err := db.Get(profileNamesBucket, strings.ToLower(name), &owner)
+	switch {
+	case err == nil && owner != id:
+		return false, ErrNameTaken
+	case err == nil:
+		return false, nil
+	case !errors.Is(err, store.ErrNotFound):
+		return false, err
+	}
+	return true, db.Put(profileNamesBucket, strings.ToLower(name), id)
+}
+
+// releaseName frees a name in the name index.
+func releaseName(name string) {
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+
+	if err := db.Delete(profileNamesBucket, strings.ToLower(name)); err != nil {
+		slog.Error("Error releasing name", "name", name, "err", err)
+	}
+}
+
+// GetProfile returns a player's profile.
+func GetProfile(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "profiles are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var profile models.Profile
+	err := db.Get(profilesBucket, chi.URLParam(r, "id"), &profile)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// UpdateProfile changes a player's display name and colour, they show from the next time the player joins a room.
+// Players can only change their own profile.
+func UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "profiles are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	claims, err := authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if claims.Subject != chi.URLParam(r, "id") {
+		http.Error(w, "players can only change their own profile", http.StatusForbidden)
+		return
+	}
+	var update models.ProfileUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&update); err != nil {
+		http.Error(w, "invalid profile: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profile, err := saveProfile(chi.URLParam(r, "id"), update)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, ErrNameTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrInvalidColor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Debug("Error writing JSON response", "err", err)
+	}
+}
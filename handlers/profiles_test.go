@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/store"
+)
+
+// useStore gives the test a store of its own, the previous one is put back when it ends.
+func useStore(t *testing.T) {
+	t.Helper()
+	opened, err := store.Open(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = opened
+	t.Cleanup(func() {
+		db = previous
+		opened.Close()
+	})
+}
+
+func nameOwner(t *testing.T, name string) string {
+	t.Helper()
+	var owner string
+	if err := db.Get(profileNamesBucket, name, &owner); err != nil && !errors.Is(err, store.ErrNotFound) {
+		t.Fatal(err)
+	}
+	return owner
+}
+
+func TestSaveProfileClaimsNames(t *testing.T) {
+	useStore(t)
+	for _, id := range []string{"a", "b"} {
+		if _, err := loadProfile(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	profile, err := saveProfile("a", models.ProfileUpdate{Name: "  Ann   Lee ", Color: "#ff0000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "Ann Lee" || profile.Color != "#FF0000" {
+		t.Fatalf("expected Ann Lee in #FF0000, got %q in %q", profile.Name, profile.Color)
+	}
+	if _, err := saveProfile("b", models.ProfileUpdate{Name: "ann lee"}); !errors.Is(err, ErrNameTaken) {
+		t.Fatalf("expected ErrNameTaken, got %v", err)
+	}
+	// Changing the case of your own name keeps it
+	if profile, err = saveProfile("a", models.ProfileUpdate{Name: "ANN LEE"}); err != nil || profile.Name != "ANN LEE" {
+		t.Fatalf("expected the name ANN LEE, got %q %v", profile.Name, err)
+	}
+	if nameOwner(t, "ann lee") != "a" {
+		t.Fatal("changing the case of a name released it")
+	}
+
+	// A new name frees the old one
+	if _, err := saveProfile("a", models.ProfileUpdate{Name: "Annie"}); err != nil {
+		t.Fatal(err)
+	}
+	if nameOwner(t, "ann lee") != "" || nameOwner(t, "annie") != "a" {
+		t.Fatal("renaming didn't move the name index")
+	}
+	if _, err := saveProfile("b", models.ProfileUpdate{Name: "Ann Lee"}); err != nil {
+		t.Fatalf("the released name couldn't be taken: %v", err)
+	}
+
+	// A profile that doesn't exist claims nothing
+	if _, err := saveProfile("nobody", models.ProfileUpdate{Name: "Ghost"}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if nameOwner(t, "ghost") != "" {
+		t.Fatal("a missing profile kept the name it asked for")
+	}
+}
+
+// Stats recorded while a player renames themselves are kept.
+func TestSaveProfileKeepsConcurrentStats(t *testing.T) {
+	useStore(t)
+	if _, err := loadProfile("a"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			updateStats("a", func(stats *models.ProfileStats) { stats.Kills++ })
+		}()
+		go func(i int) {
+			defer wg.Done()
+			color := "#00FF00"
+			if i%2 == 0 {
+				color = "#0000FF"
+			}
+			if _, err := saveProfile("a", models.ProfileUpdate{Color: color}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var profile models.Profile
+	if err := db.Get(profilesBucket, "a", &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Stats.Kills != 100 {
+		t.Fatalf("expected 100 kills, %d survived the profile changes", profile.Stats.Kills)
+	}
+}
@@ -4,7 +4,7 @@ package handlers
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
@@ -28,7 +28,7 @@ func (mq *MessageQueue) Enqueue(clientID string, message []byte) error {
 	mq.messages[clientID] = append(mq.messages[clientID], message)
 
 	if err := mq.persistMessage(clientID, message); err != nil {
-		log.Printf("Failed to persist message for client %s: %v", clientID, err)
+		slog.Error("Failed to persist message", "client", clientID, "err", err)
 		return fmt.Errorf("failed to persist message: %w", err)
 	}
 	return nil
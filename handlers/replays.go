@@ -0,0 +1,273 @@
+// Package handlers replays.go records rooms into replay files, serves them and streams them back to spectators.
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/replay"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	MinReplaySpeed = 0.5
+	MaxReplaySpeed = 8.0
+)
+
+// replayDir returns where replays are written, REPLAY_DIR=- turns recording off.
+func replayDir() string {
+	dir := os.Getenv("REPLAY_DIR")
+	if dir == "" {
+		return "replays"
+	}
+	if dir == "-" {
+		return ""
+	}
+	return dir
+}
+
+// startRecordingLocked starts a replay of the room if recording is on, the caller must hold r.mu.
+func (r *Room) startRecordingLocked() {
+	dir := replayDir()
+	if dir == "" || r.recorder != nil {
+		return
+	}
+	playersMutex.Lock()
+	recorder, err := replay.Create(dir, r.ID, r.world)
+	playersMutex.Unlock()
+	if err != nil {
+		r.logger.Error("Error starting replay", "err", err)
+		return
+	}
+	r.recorder = recorder
+	r.logger.Info("Recording room", "replay", recorder.Name)
+}
+
+// stopRecordingLocked finishes the room's replay, the caller must hold r.mu.
+func (r *Room) stopRecordingLocked() {
+	if r.recorder == nil {
+		return
+	}
+	playersMutex.Lock()
+	err := r.recorder.Close(r.world)
+	playersMutex.Unlock()
+	if err != nil {
+		r.logger.Error("Error finishing replay", "replay", r.recorder.Name, "err", err)
+	} else {
+		r.logger.Info("Saved replay", "replay", r.recorder.Name)
+	}
+	r.recorder = nil
+}
+
+// addPlayerLocked puts a player into the room's world and its replay, the caller must hold r.mu and playersMutex.
+func (r *Room) addPlayerLocked(player *models.Player) {
+	if r.recorder != nil {
+		r.recorder.Join(player)
+	}
+	r.world.AddPlayer(player)
+	r.standings[player.ID] = &standing{aliveSince: r.world.Tick, kills: r.carriedKills[player.ID]}
+	delete(r.carriedKills, player.ID)
+	r.joinMatchLocked(player)
+}
+
+// removePlayerLocked takes a player out of the room's world and records it, the caller must hold r.mu and playersMutex.
+func (r *Room) removePlayerLocked(playerID string) {
+	if _, ok := r.world.RemovePlayer(playerID); ok && r.recorder != nil {
+		r.recorder.Leave(playerID)
+	}
+	delete(r.standings, playerID)
+	r.leaveMatchLocked(playerID)
+}
+
+type replayInfo struct {
+	Name    string    `json:"name"`
+	RoomID  string    `json:"room"`
+	Started time.Time `json:"started"`
+	Size    int64     `json:"size"`
+	URL     string    `json:"url"`
+	Watch   string    `json:"watch"`
+}
+
+// ListReplays lists the finished replays, newest first.
+func ListReplays(w http.ResponseWriter, r *http.Request) {
+	list := []replayInfo{}
+	dir := replayDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !replay.ValidName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		header, err := replay.ReadHeader(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			slog.Warn("Skipping unreadable replay", "replay", entry.Name(), "err", err)
+			continue
+		}
+		list = append(list, replayInfo{
+			Name:    entry.Name(),
+			RoomID:  header.RoomID,
+			Started: header.Started,
+			Size:    info.Size(),
+			URL:     "/replays/" + entry.Name(),
+			Watch:   "/?replay=" + entry.Name(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.After(list[j].Started) })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		slog.Debug("Error writing replay list", "err", err)
+	}
+}
+
+// DownloadReplay serves a replay file.
+func DownloadReplay(w http.ResponseWriter, r *http.Request) {
+	path, ok := replayPath(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(path)+`"`)
+	http.ServeFile(w, r, path)
+}
+
+// WatchReplay re-simulates a replay and streams it to a signed-in player's spectator socket, ?speed= sets the
+// starting speed.
+func WatchReplay(w http.ResponseWriter, r *http.Request) {
+	if rejectWhileShuttingDown(w) {
+		return
+	}
+	// Watching a replay takes a session like playing does, and banned players can't, checked before the replay is read
+	logger := slog.With("request", middleware.GetReqID(r.Context()), "remote", r.RemoteAddr)
+	claims, ok := admitPlayer(w, r, logger)
+	if !ok {
+		return
+	}
+	path, ok := replayPath(w, r)
+	if !ok {
+		return
+	}
+	speed := 1.0
+	if value := r.URL.Query().Get("speed"); value != "" {
+		var err error
+		speed, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(w, "invalid speed", http.StatusBadRequest)
+			return
+		}
+	}
+	recorded, err := replay.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn, ok := upgrade(w, r, logger)
+	if !ok {
+		return
+	}
+	go serveReplay(conn, claims.Subject, filepath.Base(path), replay.NewPlayback(recorded), speed, logger)
+}
+
+// replayPath resolves the {name} URL parameter, writing an error response if there is no such replay.
+func replayPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := chi.URLParam(r, "name")
+	dir := replayDir()
+	if dir == "" || !replay.ValidName(name) {
+		http.NotFound(w, r)
+		return "", false
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		http.NotFound(w, r)
+		return "", false
+	}
+	return path, true
+}
+
+func clampReplaySpeed(speed float64) float64 {
+	return min(max(speed, MinReplaySpeed), MaxReplaySpeed)
+}
+
+// serveReplay plays a replay to a spectator at the speed they pick until it ends or they leave.
+func serveReplay(conn *websocket.Conn, id, name string, playback *replay.Playback, speed float64, logger *slog.Logger) {
+	defer releaseConnection(conn)
+	spectator := NewSpectator(conn, id, nil)
+	spectator.speed = clampReplaySpeed(speed)
+	spectator.logger = logger.With("client", id, "role", "spectator", "replay", name)
+	spectator.logger.Info("Spectator is watching replay")
+
+	writerDone := make(chan struct{})
+	go func() {
+		spectator.writeLoop()
+		close(writerDone)
+	}()
+
+	left := make(chan struct{})
+	go func() {
+		for spectator.readLoop() {
+			spectator.notify("replayOnly", name)
+		}
+		close(left)
+	}()
+
+	roomID := playback.Replay.Header.RoomID
+	config := playback.World.Config
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for !playback.Done() {
+		select {
+		case <-left:
+			spectator.close()
+			<-writerDone
+			conn.Close()
+			spectator.logger.Info("Spectator stopped watching replay")
+			return
+		case <-timer.C:
+		}
+
+		playback.Step()
+		snapshot := models.WorldSnapshot{
+			RoomID:   roomID,
+			Tick:     playback.World.Tick,
+			Width:    config.Width,
+			Height:   config.Height,
+			CellSize: config.CellSize,
+			Terrain:  config.Terrain,
+		}
+		for _, player := range playback.World.Players() {
+			snapshot.Players = append(snapshot.Players, playerStateOf(player))
+		}
+		spectator.sendSnapshot(snapshot)
+
+		spectator.mu.Lock()
+		interval := time.Duration(float64(time.Second) / (TickRate * spectator.speed))
+		spectator.mu.Unlock()
+		timer.Reset(interval)
+	}
+
+	spectator.notify("replayEnded", name)
+	spectator.close()
+	<-writerDone
+	if err := conn.Close(); err != nil {
+		spectator.logger.Debug("Error closing replay connection", "err", err)
+	}
+	<-left
+	spectator.logger.Info("Replay finished")
+}
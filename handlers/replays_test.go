@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/replay"
+	"github.com/go-chi/chi"
+)
+
+// Callers are checked before the replay is read, so nobody can make the server decode replays without a session.
+func TestWatchReplayChecksTheCallerFirst(t *testing.T) {
+	useAuth(t)
+	dir := t.TempDir()
+	t.Setenv("REPLAY_DIR", dir)
+	name := "broken" + replay.Ext
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("not a replay"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	watch := func(token string) int {
+		request := httptest.NewRequest(http.MethodGet, "/replays/"+name+"/watch", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		params := chi.NewRouteContext()
+		params.URLParams.Add("name", name)
+		request = request.WithContext(context.WithValue(request.Context(), chi.RouteCtxKey, params))
+		recorder := httptest.NewRecorder()
+		WatchReplay(recorder, request)
+		return recorder.Code
+	}
+
+	if code := watch(""); code != http.StatusUnauthorized {
+		t.Fatalf("watching without a session got %d, expected 401", code)
+	}
+	// With a session the replay is read, and this one doesn't decode
+	_, guest := post(t, GuestLogin, "", "")
+	if code := watch(guest.Token); code != http.StatusInternalServerError {
+		t.Fatalf("watching a broken replay got %d, expected 500", code)
+	}
+}
@@ -0,0 +1,631 @@
+// Package handlers room.go groups players and spectators into rooms that share a tick loop.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/bots"
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/mapdef"
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/replay"
+)
+
+const (
+	DefaultRoomID   = "default"
+	TickRate        = 20 // ticks per second
+	MaxRoomIDLength = 32 // room IDs are made of letters, digits, - and _
+)
+
+var (
+	errTooManyRooms  = errors.New("there are too many rooms, try again later")
+	errInvalidRoomID = fmt.Errorf("room IDs are at most %d letters, digits, - and _", MaxRoomIDLength)
+)
+
+// Mutex to protect access to the rooms map
+var roomsMutex sync.Mutex
+
+// Map of room ID to room
+var rooms = make(map[string]*Room)
+
+type Room struct {
+	ID       string
+	Capacity int // maximum number of players including bots, spectators are not counted
+
+	// Bots backfill the room: there are BotTarget bots minus one for every human player
+	BotTarget     int
+	BotDifficulty bots.Difficulty
+	BotStrategies []string
+
+	// Seed drives spawns, colours, bot IDs and bot decisions, the same seed and inputs play out the same game
+	Seed int64
+
+	mu           sync.Mutex
+	clients      map[string]*Client
+	bots         map[string]*roomBot
+	spectators   map[uint64]*Spectator
+	waitlist     []*Spectator // spectators waiting for a player slot, oldest first
+	world        *game.World
+	inputs       []game.Input     // inputs received since the last tick, applied in arrival order
+	recorder     *replay.Recorder // records the match while humans are playing
+	standings    map[string]*standing
+	carriedKills map[string]int  // kills of players restored from a checkpoint, given back when they resume
+	match        *matchRecord    // the match being played while humans are in the room
+	gameplay     config.Gameplay // the settings the world was built with
+	mapDef       *mapdef.Map     // the map the world was built from, nil for the gameplay field
+	mapIndex     int             // mapDef's place in the room's rotation, -1 if it isn't in it
+	mapStarted   time.Time
+	lastActive   time.Time // when someone was last in the room, it is closed once empty for Rooms.IdleTimeout
+	botsCreated  int
+	rng          *rand.Rand
+	stop         chan struct{} // closed to end the tick loop
+	stopOnce     sync.Once
+	logger       *slog.Logger
+}
+
+func NewRoom(id string, capacity int, seed int64, gameplay config.Gameplay) *Room {
+	mapIndex, mapDef := rotationMap(id, -1, false)
+	return &Room{
+		ID:            id,
+		Capacity:      capacity,
+		BotDifficulty: bots.Normal,
+		BotStrategies: bots.StrategyNames(),
+		Seed:          seed,
+		clients:       make(map[string]*Client),
+		bots:          make(map[string]*roomBot),
+		spectators:    make(map[uint64]*Spectator),
+		standings:     make(map[string]*standing),
+		carriedKills:  make(map[string]int),
+		world:         game.NewWorld(worldConfig(gameplay, mapDef, seed)),
+		gameplay:      gameplay,
+		mapDef:        mapDef,
+		mapIndex:      mapIndex,
+		mapStarted:    time.Now(),
+		lastActive:    time.Now(),
+		rng:           rand.New(rand.NewSource(seed)),
+		stop:          make(chan struct{}),
+		logger:        slog.With("room", id),
+	}
+}
+
+// roomSeed returns the configured seed if set, so a run can be reproduced, otherwise a fresh seed.
+func roomSeed(rooms config.Rooms) int64 {
+	if rooms.Seed != 0 {
+		return rooms.Seed
+	}
+	return time.Now().UnixNano()
+}
+
+// validRoomID reports whether a room ID asked for is one a room can have, empty being the default room.
+func validRoomID(id string) bool {
+	if len(id) > MaxRoomIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// getOrCreateRoom returns the room with the given ID, starting a new one if needed and there are fewer than
+// Rooms.MaxRooms.
+func getOrCreateRoom(id string) (*Room, error) {
+	if id == "" {
+		id = DefaultRoomID
+	}
+
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	room, ok := rooms[id]
+	if !ok {
+		settings := currentSettings()
+		if len(rooms) >= settings.Rooms.MaxRooms {
+			return nil, errTooManyRooms
+		}
+		room = NewRoom(id, settings.Rooms.Capacity, roomSeed(settings.Rooms), settings.Gameplay)
+		configureBots(room, settings.Rooms)
+		rooms[id] = room
+		go room.run()
+		room.logger.Info("Created room", "capacity", room.Capacity, "seed", room.Seed, "map", room.mapNameLocked())
+	}
+	// Asking for the room keeps it open until the player is in
+	room.mu.Lock()
+	room.lastActive = time.Now()
+	room.mu.Unlock()
+	return room, nil
+}
+
+// addClient adds a player to the room, making room by removing a bot if needed. It returns false if the room is full
+// or the player is already in it.
+func (r *Room) addClient(client *Client) bool {
+	r.mu.Lock()
+	if _, ok := r.clients[client.ID]; ok {
+		r.mu.Unlock()
+		return false
+	}
+	var evicted *models.Player
+	if len(r.clients)+len(r.bots) >= r.Capacity && len(r.bots) > 0 {
+		evicted = r.removeBotLocked()
+	}
+	if len(r.clients)+len(r.bots) >= r.Capacity {
+		r.mu.Unlock()
+		return false
+	}
+	if len(r.clients) == 0 {
+		r.startRoundLocked()
+		r.startRecordingLocked()
+		r.startMatchLocked()
+	}
+	r.clients[client.ID] = client
+	client.Room = r
+	playersMutex.Lock()
+	r.addPlayerLocked(client.Player)
+	playersMutex.Unlock()
+	r.mu.Unlock()
+
+	if evicted != nil {
+		r.broadcastRemovePlayer(evicted.ID)
+	}
+	return true
+}
+
+// startRoundLocked rebuilds the world when the gameplay settings were reloaded since it was built, or the room's
+// map is due to change, with the bots respawned on the new field. It runs as the first human joins, the caller
+// must hold r.mu.
+func (r *Room) startRoundLocked() {
+	gameplay := currentSettings().Gameplay
+	mapIndex, mapDef := rotationMap(r.ID, r.mapIndex, r.mapDueLocked())
+	if gameplay == r.gameplay && mapIndex == r.mapIndex && sameMap(mapDef, r.mapDef) {
+		return
+	}
+
+	r.rebuildWorldLocked(gameplay, mapIndex, mapDef)
+	r.logger.Info("Started a round on a new field", "map", r.mapNameLocked(),
+		"fieldWidth", r.world.Config.Width, "fieldHeight", r.world.Config.Height)
+}
+
+// rebuildWorldLocked replaces the world with a new one built from the gameplay settings and the map, and respawns
+// everyone in it, keeping their names and colours. The caller must hold r.mu.
+func (r *Room) rebuildWorldLocked(gameplay config.Gameplay, mapIndex int, mapDef *mapdef.Map) {
+	playersMutex.Lock()
+	defer playersMutex.Unlock()
+
+	previous := r.world.Players()
+	r.world = game.NewWorld(worldConfig(gameplay, mapDef, r.Seed))
+	r.gameplay = gameplay
+	r.mapIndex, r.mapDef, r.mapStarted = mapIndex, mapDef, time.Now()
+	clear(r.standings)
+	for _, player := range previous {
+		// Without land the new world spawns the player somewhere free
+		player.LandCapture, player.StartingLand = nil, nil
+		player.KillStreak = 0
+		r.addPlayerLocked(player)
+	}
+}
+
+// reset ends the room's match and starts a new one on a fresh field built from the current gameplay settings,
+// with everyone in the room respawned. The field is the named map, or the next map of the room's rotation when
+// the name is empty.
+func (r *Room) reset(name string) {
+	r.mu.Lock()
+	r.stopRecordingLocked()
+	saveMatch := r.finishMatchLocked()
+	mapIndex, mapDef := rotationMap(r.ID, r.mapIndex, true)
+	if name != "" {
+		mapIndex, mapDef, _ = pickMap(r.ID, name)
+	}
+	r.rebuildWorldLocked(currentSettings().Gameplay, mapIndex, mapDef)
+	if len(r.clients) > 0 {
+		r.startRecordingLocked()
+		r.startMatchLocked()
+	}
+	field := r.world.Config
+	mapName := r.mapNameLocked()
+	playersMutex.Lock()
+	var messages [][]byte
+	for _, player := range r.world.Players() {
+		messages = append(messages, renderMessage("updatePlayer", playerStateOf(player)),
+			renderMessage("captureTerritory", models.PlayerState{
+				ID:          player.ID,
+				Color:       player.Color,
+				LandCapture: player.LandCapture,
+			}))
+	}
+	playersMutex.Unlock()
+	r.mu.Unlock()
+
+	saveMatch()
+	content, err := json.Marshal(models.RoomReset{
+		Width:    field.Width,
+		Height:   field.Height,
+		CellSize: field.CellSize,
+		Map:      mapName,
+		Terrain:  field.Terrain,
+	})
+	if err != nil {
+		r.logger.Error("Error marshalling room reset", "err", err)
+		return
+	}
+	if notice, err := json.Marshal(SignalMessage{Type: "roomReset", Content: string(content)}); err == nil {
+		r.broadcastEveryone(notice)
+	}
+	for _, message := range messages {
+		if message != nil {
+			r.broadcast(message)
+		}
+	}
+}
+
+// field returns the configuration of the world the room is playing in.
+func (r *Room) field() game.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.world.Config
+}
+
+// removeClient frees the player's slot and tells the longest waiting spectator about it, returning the player as
+// they were when they left, or nil if the slot wasn't theirs.
+func (r *Room) removeClient(client *Client) *EventPlayer {
+	r.mu.Lock()
+	if r.clients[client.ID] != client {
+		r.mu.Unlock()
+		return nil
+	}
+	delete(r.clients, client.ID)
+	playersMutex.Lock()
+	left := r.eventPlayerLocked(client.ID)
+	r.removePlayerLocked(client.ID)
+	playersMutex.Unlock()
+	// The match is over once the last human leaves
+	saveMatch := func() {}
+	if len(r.clients) == 0 {
+		r.stopRecordingLocked()
+		saveMatch = r.finishMatchLocked()
+	}
+	var next *Spectator
+	if len(r.waitlist) > 0 {
+		next = r.waitlist[0]
+	}
+	r.mu.Unlock()
+
+	saveMatch()
+	if next != nil {
+		next.notify("slotAvailable", r.ID)
+	}
+	return left
+}
+
+func (r *Room) addSpectator(spectator *Spectator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spectators[spectator.ConnID] = spectator
+}
+
+func (r *Room) removeSpectator(spectator *Spectator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.spectators, spectator.ConnID)
+	r.removeWaitingLocked(spectator)
+}
+
+// waitForSlot puts the spectator at the back of the promotion queue.
+func (r *Room) waitForSlot(spectator *Spectator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.waitlist {
+		if s == spectator {
+			return
+		}
+	}
+	r.waitlist = append(r.waitlist, spectator)
+}
+
+func (r *Room) removeWaitingLocked(spectator *Spectator) {
+	for i, s := range r.waitlist {
+		if s == spectator {
+			r.waitlist = append(r.waitlist[:i], r.waitlist[i+1:]...)
+			return
+		}
+	}
+}
+
+// run advances the room at TickRate until the room is stopped or closes for being empty, and moves it on to its
+// next map when it is due.
+func (r *Room) run() {
+	ticker := time.NewTicker(time.Second / TickRate)
+	defer ticker.Stop()
+	housekeeping := time.NewTicker(time.Second)
+	defer housekeeping.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			r.step()
+			tickDuration.Observe(time.Since(start).Seconds())
+		case <-housekeeping.C:
+			r.rotateMap()
+			if r.closeIfIdle() {
+				return
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// closeIfIdle closes the room once nobody has played, watched or been able to resume in it for Rooms.IdleTimeout,
+// returning true if it did. A player asking for the room afterwards gets a new one.
+func (r *Room) closeIfIdle() bool {
+	resumable := hasSessions(r.ID)
+	roomsMutex.Lock()
+	r.mu.Lock()
+	now := time.Now()
+	if resumable || len(r.clients) > 0 || len(r.spectators) > 0 {
+		r.lastActive = now
+	}
+	idle := now.Sub(r.lastActive) >= currentSettings().Rooms.IdleTimeout
+	r.mu.Unlock()
+	if idle {
+		// Released before anyone can ask for the room again, so a player joining it next can be sent to any node
+		delete(rooms, r.ID)
+		releaseRoom(r.ID)
+	}
+	roomsMutex.Unlock()
+	if !idle {
+		return false
+	}
+
+	r.stopTicking()
+	dropCheckpoint(r)
+	r.logger.Info("Closed empty room")
+	return true
+}
+
+// queueInput stores a player's input for the next tick.
+func (r *Room) queueInput(input game.Input) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.inputs = append(r.inputs, input)
+}
+
+// queueMove queues a player's turn for the next tick unless it breaks the movement rules, returning the kind of
+// strike it earns if so. Turning the way the player is already heading is dropped quietly.
+func (r *Room) queueMove(playerID, direction string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queueMoveLocked(playerID, direction)
+}
+
+// queueMoveLocked is queueMove for players and bots alike, the caller must hold r.mu.
+func (r *Room) queueMoveLocked(playerID, direction string) string {
+	// The player heads the way of their last queued turn, or the way they are moving if there is none
+	heading := ""
+	queued := 0
+	for _, input := range r.inputs {
+		if input.PlayerID == playerID {
+			heading = input.Direction
+			queued++
+		}
+	}
+	if queued == 0 {
+		playersMutex.Lock()
+		if player := r.world.Player(playerID); player != nil {
+			heading = game.Heading(player)
+		}
+		playersMutex.Unlock()
+	}
+
+	switch {
+	case direction == heading:
+		return ""
+	case direction == game.Reverse(heading):
+		return strikeReversal
+	case queued >= MaxInputsPerTick:
+		return strikeInputRate
+	}
+	r.inputs = append(r.inputs, game.Input{PlayerID: playerID, Direction: direction})
+	return ""
+}
+
+// step lets the bots decide, advances the world with the queued inputs, then sends the results to players and spectators.
+func (r *Room) step() {
+	r.balanceBots()
+
+	r.mu.Lock()
+	r.driveBotsLocked()
+	inputs := r.inputs
+	r.inputs = nil
+
+	playersMutex.Lock()
+	events := r.world.Step(inputs)
+	r.matchEventsLocked(events)
+	var messages [][]byte
+	for _, player := range r.world.Players() {
+		if player.IsAlive && (player.VelocityX != 0 || player.VelocityY != 0) {
+			// Land only changes with captureTerritory, so moves leave it out
+			state := playerStateOf(player)
+			state.LandCapture, state.StartingLand = nil, nil
+			messages = append(messages, renderMessage("updatePlayer", state))
+		}
+	}
+	territoryChanged := false
+	for _, event := range events {
+		switch event.Type {
+		case game.EventCapture:
+			territoryChanged = true
+		case game.EventDeath:
+			messages = append(messages, renderMessage("removePlayer", models.PlayerState{ID: event.PlayerID}))
+			territoryChanged = true
+		case game.EventRespawn:
+			messages = append(messages, renderMessage("updatePlayer", playerStateOf(r.world.Player(event.PlayerID))))
+			territoryChanged = true
+		}
+	}
+	r.updateStandingsLocked(events)
+	// Published once the standings are up to date, so deaths carry the survival time of the life that ended
+	r.publishGameEventsLocked(events)
+	statUpdates := r.survivalUpdatesLocked()
+	// Land changes hands on captures and deaths, so everyone's territory is resent
+	if territoryChanged {
+		for _, player := range r.world.Players() {
+			messages = append(messages, renderMessage("captureTerritory", models.PlayerState{
+				ID:          player.ID,
+				Color:       player.Color,
+				LandCapture: player.LandCapture,
+			}))
+		}
+	}
+	var leaderboard []byte
+	if r.world.Tick%LeaderboardInterval == 0 {
+		leaderboard = r.leaderboardMessageLocked()
+	}
+	playersMutex.Unlock()
+
+	if r.recorder != nil {
+		if err := r.recorder.Step(r.world.Tick, inputs); err != nil {
+			r.logger.Error("Error recording replay, stopping", "replay", r.recorder.Name, "err", err)
+			r.stopRecordingLocked()
+		}
+	}
+	r.mu.Unlock()
+
+	for _, message := range messages {
+		if message != nil {
+			r.broadcast(message)
+		}
+	}
+	if leaderboard != nil {
+		r.broadcastEveryone(leaderboard)
+	}
+	for _, update := range statUpdates {
+		update()
+	}
+	r.broadcastSnapshot()
+}
+
+// playersLocked returns the human and bot players in the room, the caller must hold r.mu.
+func (r *Room) playersLocked() []*models.Player {
+	roomPlayers := make([]*models.Player, 0, len(r.clients)+len(r.bots))
+	for _, client := range r.clients {
+		roomPlayers = append(roomPlayers, client.Player)
+	}
+	for _, bot := range r.bots {
+		roomPlayers = append(roomPlayers, bot.Player)
+	}
+	return roomPlayers
+}
+
+// broadcast sends a message to every player in the room.
+func (r *Room) broadcast(message []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, client := range r.clients {
+		if client.Conn != nil {
+			client.SendMessage(message)
+		}
+	}
+}
+
+// broadcastEveryone sends a message to every player and spectator in the room.
+func (r *Room) broadcastEveryone(message []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, client := range r.clients {
+		if client.Conn != nil {
+			client.SendMessage(message)
+		}
+	}
+	for _, spectator := range r.spectators {
+		spectator.send(message)
+	}
+}
+
+func (r *Room) broadcastPlayerUpdate(player *models.Player) {
+	playersMutex.Lock()
+	message := renderMessage("updatePlayer", playerStateOf(player))
+	playersMutex.Unlock()
+	if message != nil {
+		r.broadcast(message)
+	}
+}
+
+func (r *Room) broadcastRemovePlayer(playerID string) {
+	if message := renderMessage("removePlayer", models.PlayerState{ID: playerID}); message != nil {
+		r.broadcast(message)
+	}
+}
+
+// renderMessage marshals a render instruction, returning nil if that fails.
+func renderMessage(instructionType string, state models.PlayerState) []byte {
+	message, err := json.Marshal(models.RenderInstruction{Type: instructionType, Payload: state})
+	if err != nil {
+		slog.Error("Error marshalling message", "type", instructionType, "err", err)
+		return nil
+	}
+	return message
+}
+
+// broadcastSnapshot sends each spectator the full world, framed by their own camera.
+func (r *Room) broadcastSnapshot() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.spectators) == 0 {
+		return
+	}
+
+	snapshot := models.WorldSnapshot{
+		RoomID:   r.ID,
+		Tick:     r.world.Tick,
+		Width:    r.world.Config.Width,
+		Height:   r.world.Config.Height,
+		CellSize: r.world.Config.CellSize,
+		Map:      r.mapNameLocked(),
+		Terrain:  r.world.Config.Terrain,
+		Players:  make([]models.PlayerState, 0, len(r.clients)+len(r.bots)),
+	}
+	playersMutex.Lock()
+	for _, player := range r.playersLocked() {
+		snapshot.Players = append(snapshot.Players, playerStateOf(player))
+	}
+	playersMutex.Unlock()
+
+	for _, spectator := range r.spectators {
+		spectator.sendSnapshot(snapshot)
+	}
+}
+
+// playerStateOf copies the wire-visible fields of a player.
+func playerStateOf(player *models.Player) models.PlayerState {
+	return models.PlayerState{
+		ID:               player.ID,
+		StartingPosition: player.StartingPosition,
+		Name:             player.Name,
+		Color:            player.Color,
+		X:                player.X,
+		Y:                player.Y,
+		VelocityX:        player.VelocityX,
+		VelocityY:        player.VelocityY,
+		LandCapture:      player.LandCapture,
+		PlayerTrail:      player.PlayerTrail,
+		StartingLand:     player.StartingLand,
+		IsAlive:          player.IsAlive,
+	}
+}
@@ -3,23 +3,28 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/4cecoder/multiplayer/auth"
+	"github.com/4cecoder/multiplayer/logging"
 	"github.com/4cecoder/multiplayer/models"
+	"github.com/go-chi/chi/middleware"
 	"github.com/google/uuid"
-	"log"
-	"math"
-	"math/rand"
+	"github.com/gorilla/websocket"
+	"log/slog"
 	"net/http"
 	"sync"
-)
-
-const (
-	FieldHeight = 600
-	FieldWidth  = 800
+	"time"
 )
 
 var players = make(map[string]*models.Player)
 var playersMutex sync.Mutex
 
+var errAlreadyPlaying = errors.New("you are already playing in another tab or connection")
+
+// Moves arrive several times a second per player, so only some are logged
+var moveSampler = logging.NewSampler(100)
+
 // Validate direction for movement
 func validateDirection(direction string) bool {
 	switch direction {
@@ -30,177 +35,289 @@ func validateDirection(direction string) bool {
 	}
 }
 
-// Update player velocity based on direction
-func updateVelocity(player *models.Player, direction string) {
-	playersMutex.Lock()
-	defer playersMutex.Unlock()
-
-	switch direction {
-	case "up":
-		player.VelocityY = -player.MaxVelocity * player.SpeedMultiplier
-		player.VelocityX = 0
-	case "down":
-		player.VelocityY = player.MaxVelocity * player.SpeedMultiplier
-		player.VelocityX = 0
-	case "left":
-		player.VelocityX = -player.MaxVelocity * player.SpeedMultiplier
-		player.VelocityY = 0
-	case "right":
-		player.VelocityX = player.MaxVelocity * player.SpeedMultiplier
-		player.VelocityY = 0
-	}
-}
-
-// Handle move messages by updating velocity and broadcasting the update
+// Handle move messages by queueing the direction for the player's room, the only thing a client gets to decide
 func handleMoveMessage(client *Client, message models.RenderInstruction) {
+	payload := message.Payload
+	if payload.ID != "" && payload.ID != client.ID {
+		recordStrike(client, strikeImpersonation, payload.ID)
+		return
+	}
+	if assertsState(payload) {
+		recordStrike(client, strikeClientState, "move")
+		return
+	}
 	// turn direction interface into string
-	direction, ok := message.Payload.Direction.(string)
-	if !ok {
-		log.Printf("Invalid direction %s for client %s", message.Payload.Direction, client.ID)
+	direction, ok := payload.Direction.(string)
+	if !ok || !validateDirection(direction) {
+		client.logger.Warn("Invalid direction", "direction", payload.Direction)
+		recordStrike(client, strikeInvalidInput, fmt.Sprint(payload.Direction))
 		return
 	}
 
-	if !validateDirection(direction) {
-		log.Printf("Invalid direction %s for client %s", message.Payload.Direction, client.ID)
+	if client.Room == nil {
+		client.logger.Debug("Client is not in a room, ignoring move")
 		return
 	}
 
-	log.Printf("Handling move direction %s for client %s", message.Payload.Direction, client.ID)
-	updateVelocity(client.Player, direction)
-	broadcastPlayerState(client.Player)
+	if moveSampler.Allow() {
+		client.logger.Debug("Handling move", "direction", direction, moveSampler.Sampled())
+	}
+	// The room applies the input on its next tick, moves the player and broadcasts the new state
+	if strike := client.Room.queueMove(client.ID, direction); strike != "" {
+		recordStrike(client, strike, direction)
+	}
 }
 
-// Function to broadcast the new player state to all clients
-func broadcastPlayerState(player *models.Player) {
-	state := makePlayerState(player)
-	playersMutex.Lock()
-	defer playersMutex.Unlock()
+func ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	logger := slog.With("request", middleware.GetReqID(r.Context()), "remote", r.RemoteAddr)
 
-	for _, client := range clients {
-		client.Send <- state
+	// The session token decides who the player is, everything is checked before a Client exists
+	claims, ok := admitPlayer(w, r, logger)
+	if !ok {
+		return
 	}
-}
+	clientID := claims.Subject
+	logger = logger.With("client", clientID)
 
-// Example function to create a JSON representation of the player's current state
-func makePlayerState(player *models.Player) []byte {
-	state, err := json.Marshal(player)
-	if err != nil {
-		log.Printf("Error marshaling player state: %v", err)
-		return nil
+	// A player plays from one connection at a time, other tabs share the session cookie but can only watch
+	query := r.URL.Query()
+	if query.Get("role") != "spectator" && clientConnected(clientID) {
+		logger.Info("Rejected WebSocket connection, the player is already connected")
+		http.Error(w, errAlreadyPlaying.Error(), http.StatusConflict)
+		return
 	}
-	return state
-}
 
-func handleClientMessages(client *Client) {
-	for {
-		event, ok := <-client.EventQueue
-		if !ok {
-			log.Println("Client disconnected, unregistering")
-			unregisterClient(client)
+	// A resume token from an earlier welcome gets the player back if it hasn't expired, it is only used up once
+	// the player is connected again
+	roomID := query.Get("room")
+	resumeToken := query.Get("resume")
+	var resumed *session
+	if resumeToken != "" {
+		s, ok := findSession(resumeToken)
+		switch {
+		case ok && s.ClientID != clientID:
+			logger.Warn("Rejected WebSocket connection, resume token belongs to another player", "owner", s.ClientID)
+			http.Error(w, "resume token belongs to another player", http.StatusForbidden)
 			return
-		}
-
-		switch event.Type {
-		case EventTypeMessage:
-			handleMessageEvent(client, event.Message)
-		case EventTypeMove:
-			var moveMessage models.RenderInstruction
-			if err := json.Unmarshal(event.Message, &moveMessage); err != nil {
-				log.Printf("Error decoding move message from client %s: %v", client.ID, err)
-				return
-			}
-			handleMoveMessage(client, moveMessage)
+		case ok:
+			resumed = s
+			roomID = s.RoomID
 		default:
-			log.Printf("Unhandled event type %d for client %s", event.Type, client.ID)
+			logger.Info("Unknown or expired resume token, starting a new player")
 		}
 	}
-}
 
-func ServeWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if !validRoomID(roomID) {
+		http.Error(w, errInvalidRoomID.Error(), http.StatusBadRequest)
+		return
+	}
+	if rejectWhileShuttingDown(w) {
+		return
+	}
+	// A resumed player comes back to the room here, anyone else plays the room on the node that owns it
+	if resumed == nil && redirectToOwner(w, r, roomID, true) {
+		return
+	}
+	room, err := getOrCreateRoom(roomID)
 	if err != nil {
-		log.Println("Error upgrading to WebSocket:", err)
+		// The room was given to this node for the player, let another node have it
+		if resumed == nil {
+			releaseRoom(roomID)
+		}
+		logger.Warn("Rejected WebSocket connection", "err", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	conn, ok := upgrade(w, r, logger)
+	if !ok {
+		return
+	}
+
+	logger = logger.With("room", room.ID)
+	if query.Get("role") == "spectator" {
+		logger.Info("Creating new spectator")
+		go serveSpectator(conn, clientID, room, false, logger)
 		return
 	}
 
-	clientID := r.Header.Get("X-Client-ID")
-	if clientID == "" {
-		clientID = generateClientID()
+	if resumed != nil && !takeSession(resumeToken, resumed) {
+		logger.Info("Resume token was used by another connection, starting a new player")
+		resumed = nil
+	}
+	client := NewClient(conn, clientID, NewMessageQueue())
+	client.logger = logger
+	if resumed != nil {
+		reconnects.Inc()
+		logger.Info("Resuming player")
+		client.Player = resumed.Player
+		client.Player.Conn = conn
+		client.ResumeToken = resumeToken
+	} else {
+		logger.Info("Creating new player")
+		client.Player = newProfilePlayer(clientID, conn)
+	}
+	if !registerClient(client) {
+		logger.Info("Closing WebSocket connection, the player connected again in the meantime")
+		saveSession(client, room)
+		closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, errAlreadyPlaying.Error())
+		conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+		conn.Close()
+		releaseConnection(conn)
+		return
+	}
+	if !room.addClient(client) {
+		logger.Info("Room is full, joining as a spectator")
+		unregisterClient(client)
+		// Keep a resumed player around so they can try again later
+		saveSession(client, room)
+		go serveSpectator(conn, clientID, room, true, logger)
+		return
 	}
-	log.Printf("Creating new player: %s", clientID)
+	startClient(client, room, resumed != nil)
+}
 
-	messageQueue := NewMessageQueue()
-	client := NewClient(conn, clientID, messageQueue)
+// admitPlayer checks the request's session token and bans before a WebSocket is opened, writing the error
+// response and returning false if the player is turned away.
+func admitPlayer(w http.ResponseWriter, r *http.Request, logger *slog.Logger) (auth.Claims, bool) {
+	claims, err := authenticate(r)
+	if err != nil {
+		logger.Info("Rejected WebSocket connection", "err", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return claims, false
+	}
+	logger = logger.With("client", claims.Subject)
+	if claimed := r.Header.Get("X-Client-ID"); claimed != "" && claimed != claims.Subject {
+		logger.Info("Rejected WebSocket connection, X-Client-ID doesn't match the token", "claimed", claimed)
+		http.Error(w, "client ID doesn't match the session token", http.StatusForbidden)
+		return claims, false
+	}
+	if ban, banned := banFor(claims.Subject, claims.Username, remoteIP(r.RemoteAddr), time.Now()); banned {
+		logger.Info("Rejected WebSocket connection from a banned player", "ban", ban.ID, "kind", ban.Kind, "reason", ban.Reason)
+		connectionsRejected.With("banned").Inc()
+		http.Error(w, banMessage(ban), http.StatusForbidden)
+		return claims, false
+	}
+	return claims, true
+}
 
-	// Create a new Player instance and associate it with the Client
-	player := &models.Player{
+// newPlayer creates the Player instance that is associated with a Client,
+// the room's world gives it a colour, a spawn point and starting land when it joins
+func newPlayer(clientID string, conn *websocket.Conn) *models.Player {
+	return &models.Player{
 		ID:               clientID,
 		StartingPosition: models.Point{X: 0, Y: 0},
 		Name:             "Player " + clientID,
-		Color:            randomColor(),
 		X:                0,
 		Y:                0,
 		VelocityX:        0,
 		VelocityY:        0,
 		Acceleration:     0.1,
-		MaxVelocity:      5,
 		Conn:             conn,
-		LandCapture:      make([][]bool, 100, 100),
 		PlayerTrail:      make([]models.Point, 0),
-		StartingLand:     make([][]bool, 100, 100),
 		IsAlive:          true,
 		KillStreak:       0,
 		SpeedMultiplier:  1,
 		WriteChan:        make(chan models.RenderInstruction, 16),
 	}
-	client.Player = player
+}
+
+// startClient welcomes a registered client that already holds a slot in the room and starts its pumps.
+func startClient(client *Client, room *Room, resumed bool) {
+	clientID := client.ID
+	client.Joined = time.Now()
+	if resumed {
+		client.emitEvent(Event{Type: EventTypeReconnect, RoomID: room.ID})
+	} else {
+		client.emitEvent(Event{Type: EventTypeLogin, RoomID: room.ID})
+	}
+	room.publishJoin(clientID, client)
 
 	// Add the player to the players map
 	playersMutex.Lock()
-	players[clientID] = player
+	players[clientID] = client.Player
 	playersMutex.Unlock()
-	log.Printf("Registering new client: %s", clientID)
-	registerClient(client)
 
 	go func() {
-		log.Printf("Starting ReadPump for client: %s", clientID)
+		client.logger.Debug("Starting ReadPump")
 		client.ReadPump()
+		leaveGame(client, room)
 	}()
 
 	go func() {
-		log.Printf("Starting WritePump for client: %s", clientID)
+		client.logger.Debug("Starting WritePump")
 		client.WritePump()
 	}()
 
-	go func() {
-		log.Printf("Starting handleClientMessages for client: %s", clientID)
-		handleClientMessages(client)
-	}()
+	field := room.field()
+	welcome, err := json.Marshal(models.WelcomeInstruction{
+		Type: "welcome",
+		Payload: models.Welcome{
+			PlayerID:    clientID,
+			RoomID:      room.ID,
+			ResumeToken: client.ResumeToken,
+			Resumed:     resumed,
+			Width:       field.Width,
+			Height:      field.Height,
+			CellSize:    field.CellSize,
+			Map:         room.currentMap(),
+			Terrain:     field.Terrain,
+		},
+	})
+	if err != nil {
+		client.logger.Error("Error marshalling welcome message", "err", err)
+	} else {
+		client.SendMessage(welcome)
+	}
+
+	client.logger.Debug("Broadcasting new player")
+	room.broadcastPlayerUpdate(client.Player)
+}
+
+// leaveGame removes a disconnected client from its room and tells the remaining players.
+func leaveGame(client *Client, room *Room) {
+	left := room.removeClient(client)
+	saveSession(client, room)
+	client.emitEvent(Event{Type: EventTypeLogout, RoomID: room.ID, Player: left, Played: time.Since(client.Joined).Seconds()})
+
+	playersMutex.Lock()
+	delete(players, client.ID)
+	playersMutex.Unlock()
+	unregisterClient(client)
 
-	log.Printf("Broadcasting new player: %s", clientID)
-	broadcastNewPlayer(player)
+	room.broadcastRemovePlayer(client.ID)
 }
 
-func registerClient(client *Client) {
+// registerClient adds the client to the connected clients unless its player is already connected, returning
+// false if so.
+func registerClient(client *Client) bool {
 	clientsMutex.Lock()         // Lock the mutex before accessing the map
 	defer clientsMutex.Unlock() // Ensure the mutex is unlocked at the end of the function
 
+	if other, ok := clients[client.ID]; ok && other != client {
+		return false
+	}
 	clients[client.ID] = client // Add the client to the map
-	log.Printf("Registered new client: %s", client.ID)
+	client.logger.Info("Registered client")
+	return true
 }
 
 func unregisterClient(client *Client) {
 	clientsMutex.Lock()         // Lock the mutex before accessing the map
 	defer clientsMutex.Unlock() // Ensure the mutex is unlocked at the end of the function
 
-	delete(clients, client.ID) // Remove the client from the map
-	log.Printf("Unregistered client: %s", client.ID)
-	// Additional cleanup can be added here
+	// Only the connection that registered the player removes it
+	if clients[client.ID] == client {
+		delete(clients, client.ID) // Remove the client from the map
+	}
+	client.logger.Info("Unregistered client")
 }
 
-func randomColor() string {
-	colors := []string{"#FF0000", "#00FF00", "#0000FF", "#FFFF00", "#00FFFF", "#FF00FF"}
-	return colors[rand.Intn(len(colors))]
+// clientConnected reports whether a player is connected.
+func clientConnected(clientID string) bool {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	_, ok := clients[clientID]
+	return ok
 }
 
 func generateClientID() string {
@@ -208,44 +325,17 @@ func generateClientID() string {
 	return uuid.New().String()
 }
 
-//func handleClientMessages(client *Client) {
-//	for {
-//		event, ok := <-client.EventQueue
-//		if !ok {
-//			// The event queue was closed, client has disconnected
-//			unregisterClient(client)
-//			return
-//		}
-//
-//		switch event.Type {
-//		case EventTypeMessage:
-//			handleMessageEvent(client, event.Message)
-//		case EventTypeError:
-//			log.Printf("Error from client %s: %v", client.ID, event.Err)
-//		case EventTypeReconnect:
-//			// Handle reconnect event
-//			log.Printf("Attempting to reconnect client %s", client.ID)
-//		case EventTypeMove:
-//			// Handle move event
-//			log.Printf("Received move signal from client %s: %s", client.ID, string(event.Message))
-//			handleMoveEvent(client, event.Message)
-//		default:
-//			panic("unhandled default case")
-//		}
-//	}
-//}
-
 func handleMoveEvent(client *Client, message []byte) {
-	// Decode the message
-	var gameMessage models.RenderInstruction
-	err := json.Unmarshal(message, &gameMessage)
+	// Decode the move payload sent as the content of a "move" signal, e.g. {"id": "...", "direction": "up"}
+	var payload models.PlayerState
+	err := json.Unmarshal(message, &payload)
 	if err != nil {
-		log.Printf("Error decoding move message from client %s: %v", client.ID, err)
+		client.logger.Warn("Error decoding move message", "err", err)
 		return
 	}
 
 	// Handle the move message
-	handleMoveMessage(client, gameMessage)
+	handleMoveMessage(client, models.RenderInstruction{Type: "move", Payload: payload})
 }
 
 func handleMessageEvent(client *Client, message []byte) {
@@ -253,7 +343,7 @@ func handleMessageEvent(client *Client, message []byte) {
 	var gameMessage models.RenderInstruction
 	err := json.Unmarshal(message, &gameMessage)
 	if err != nil {
-		log.Printf("Error decoding message from client %s: %v", client.ID, err)
+		client.logger.Warn("Error decoding message", "err", err)
 		return
 	}
 
@@ -262,292 +352,13 @@ func handleMessageEvent(client *Client, message []byte) {
 	case "move":
 		handleMoveMessage(client, gameMessage)
 	case "capture":
-		handleCaptureMessage(client, gameMessage)
+		// Captures are worked out by the room tick, see game.World.Step, clients don't get to claim land
+		recordStrike(client, strikeClientState, "capture")
 	case "chat":
-		handleChatMessage(client, gameMessage)
+		// Chat arrives as a signal, see handleChat
 	case "join":
-		// Broadcast the new player's information to all other clients
-		broadcastNewPlayer(client.Player)
+		// The room announces players as they join, see startClient
 	default:
-		log.Printf("Unknown game message type from client %s: %s", client.ID, gameMessage.Type)
-	}
-}
-
-func broadcastPlayerUpdate(player *models.Player) {
-	playerState := models.RenderInstruction{
-		Type: "updatePlayer",
-		Payload: models.PlayerState{
-			ID:               player.ID,
-			StartingPosition: player.StartingPosition,
-			Name:             player.Name,
-			Color:            player.Color,
-			X:                player.X,
-			Y:                player.Y,
-			VelocityX:        player.VelocityX,
-			VelocityY:        player.VelocityY,
-			LandCapture:      player.LandCapture,
-			PlayerTrail:      player.PlayerTrail,
-			StartingLand:     player.StartingLand,
-			IsAlive:          player.IsAlive,
-			Direction:        nil,
-		},
-	}
-
-	jsonMessage, err := json.Marshal(playerState)
-	if err != nil {
-		log.Println("error marshalling player update message:", err)
-		return
-	}
-
-	for _, client := range clients {
-		if client.Conn != nil {
-			client.SendMessage(jsonMessage)
-		}
-	}
-}
-
-func handleCaptureMessage(client *Client, message models.RenderInstruction) {
-	// Implement your game logic for handling capture messages
-	log.Printf("Received capture message from client %s: %+v", client.ID, message.Payload)
-	// Update the player's land capture based on the message
-	client.Player.LandCapture = message.Payload.LandCapture
-	// Check for and handle territory encapsulation
-	checkAndCaptureTerritory(client.Player.ID, models.Point{X: client.Player.X, Y: client.Player.Y})
-}
-
-func handleChatMessage(client *Client, message models.RenderInstruction) {
-	// Implement your game logic for handling chat messages
-	log.Printf("Received chat message from client %s: %+v", client.ID, message.Payload)
-	// Handle the chat message as needed
-}
-
-// updatePlayerPosition updates the player's position based on their velocity.
-func updatePlayerPosition(player *models.Player) {
-	newX, newY := player.X+player.VelocityX, player.Y+player.VelocityY
-	if newX < 0 {
-		newX = 0
-	} else if newX > FieldWidth-20 {
-		newX = FieldWidth - 20
-	}
-	if newY < 0 {
-		newY = 0
-	} else if newY > FieldHeight-20 {
-		newY = FieldHeight - 20
-	}
-	player.X, player.Y = newX, newY
-}
-
-// checkAndCaptureTerritory checks if the player has encapsulated an area and captures the territory.
-func checkAndCaptureTerritory(playerID string, newPos models.Point) {
-	player := players[playerID]
-	if player == nil {
-		log.Printf("Player %s not found in checkAndCaptureTerritory", playerID)
-		return
-	}
-
-	player.PlayerTrail = append(player.PlayerTrail, newPos) // Append new position to trail
-
-	// Detect if the trail closes a loop
-	if loopClosed(player.PlayerTrail) {
-		capturedPoints := calculateEnclosedArea(player.PlayerTrail)
-		updatePlayerLand(player, capturedPoints)
-		broadcastCapture(player, capturedPoints)
-	}
-}
-
-func loopClosed(points []models.Point) bool {
-	// Example logic to determine if the points form a loop
-	if len(points) < 4 {
-		return false
-	}
-	return points[0] == points[len(points)-1]
-}
-
-// updatePlayerLand updates the player's land based on captured territory
-func updatePlayerLand(player *models.Player, capturedPoints []models.Point) {
-	// Merge captured land with player's existing land
-	for _, point := range capturedPoints {
-		// Assuming LandCapture is adequately sized and coordinates are valid
-		x, y := int(point.X), int(point.Y)
-		if x >= 0 && x < len(player.LandCapture) && y >= 0 && y < len(player.LandCapture[x]) {
-			player.LandCapture[x][y] = true
-		}
-	}
-}
-
-// calculateEnclosedArea calculates the points inside the loop defined by the player's trail.
-func calculateEnclosedArea(trail []models.Point) []models.Point {
-	if len(trail) < 3 {
-		return nil // A valid closed area requires at least three points
-	}
-
-	var minX, maxX, minY, maxY float64
-	minX, maxX = trail[0].X, trail[0].X
-	minY, maxY = trail[0].Y, trail[0].Y
-
-	// Determine the bounding box of the trail
-	for _, point := range trail {
-		if point.X < minX {
-			minX = point.X
-		}
-		if point.X > maxX {
-			maxX = point.X
-		}
-		if point.Y < minY {
-			minY = point.Y
-		}
-		if point.Y > maxY {
-			maxY = point.Y
-		}
-	}
-
-	// Prepare to collect enclosed points
-	var enclosedPoints []models.Point
-
-	// Check each point in the bounding box
-	for x := math.Floor(minX); x <= math.Ceil(maxX); x++ {
-		for y := math.Floor(minY); y <= math.Ceil(maxY); y++ {
-			if isPointInsidePolygon(x, y, trail) {
-				enclosedPoints = append(enclosedPoints, models.Point{X: x, Y: y})
-			}
-		}
-	}
-
-	return enclosedPoints
-}
-
-// isPointInsidePolygon checks if a point is inside a polygon using the ray-casting method.
-func isPointInsidePolygon(x, y float64, polygon []models.Point) bool {
-	count := 0
-	n := len(polygon)
-	for i := 0; i < n; i++ {
-		j := (i + 1) % n
-		p1 := polygon[i]
-		p2 := polygon[j]
-		if (p1.Y > y) != (p2.Y > y) && (x < (p2.X-p1.X)*(y-p1.Y)/(p2.Y-p1.Y)+p1.X) {
-			count++
-		}
-	}
-	return count%2 != 0
-}
-
-// checkPlayerTrailCollision checks if the player has run into their own trail.
-func checkPlayerTrailCollision(player *models.Player) {
-	if len(player.PlayerTrail) > 2 {
-		for i := 0; i < len(player.PlayerTrail)-2; i++ {
-			if math.Sqrt(math.Pow(player.PlayerTrail[i].X-player.X, 2)+math.Pow(player.PlayerTrail[i].Y-player.Y, 2)) >= 40 {
-				if int(player.X) == int(player.PlayerTrail[i].X) && int(player.Y) == int(player.PlayerTrail[i].Y) {
-					// The player has run into their own trail
-					handlePlayerDeath(player)
-					return
-				}
-			}
-		}
-	}
-}
-
-func handlePlayerDeath(player *models.Player) {
-	playersMutex.Lock()
-	defer playersMutex.Unlock()
-
-	delete(players, player.ID)
-	log.Printf("Player %s has died and is removed from the game", player.ID)
-
-	// Additional cleanup actions, such as notifying other players
-}
-
-// checkPlayerTrailCollisions checks if the player has run into another player's trail.
-func checkPlayerTrailCollisions(client *Client) {
-	for _, otherPlayer := range clients {
-		if otherPlayer.ID != client.ID {
-			for _, point := range otherPlayer.Player.PlayerTrail {
-				if int(client.Player.X) == int(point.X) && int(client.Player.Y) == int(point.Y) {
-					// The player has run into another player's trail
-					handlePlayerDeath(client.Player)
-					otherPlayer.Player.KillStreak++
-					// Transfer the player's territory to the other player
-					for i := range client.Player.LandCapture {
-						for j := range client.Player.LandCapture[i] {
-							if client.Player.LandCapture[i][j] {
-								otherPlayer.Player.LandCapture[i][j] = true
-							}
-						}
-					}
-					return
-				}
-			}
-		}
-	}
-}
-
-// broadcastCapture sends the captured territory to all clients
-func broadcastCapture(player *models.Player, capturedPoints []models.Point) {
-	// Converting capturedPoints into a format suitable for JSON or other client communication
-	capturedForBroadcast := make([][]bool, len(player.LandCapture))
-	for i := range capturedForBroadcast {
-		capturedForBroadcast[i] = make([]bool, len(player.LandCapture[i]))
-	}
-
-	for _, point := range capturedPoints {
-		x, y := int(point.X), int(point.Y)
-		if x >= 0 && x < len(capturedForBroadcast) && y >= 0 && y < len(capturedForBroadcast[x]) {
-			capturedForBroadcast[x][y] = true
-		}
-	}
-
-	captureMessage := models.RenderInstruction{
-		Type: "captureTerritory",
-		Payload: models.PlayerState{
-			ID:          player.ID,
-			LandCapture: capturedForBroadcast,
-		},
-	}
-
-	// Convert the captureMessage to a JSON byte slice
-	jsonMessage, err := json.Marshal(captureMessage)
-	if err != nil {
-		log.Println("error marshalling captureMessage:", err)
-		return
-	}
-
-	for _, client := range clients {
-		// Check if the client's WebSocket connection is not nil before trying to write to it
-		if client.Conn != nil {
-			client.SendMessage(jsonMessage)
-
-		}
-	}
-}
-
-func broadcastNewPlayer(player *models.Player) {
-	newPlayerMessage := models.RenderInstruction{
-		Type: "updatePlayer",
-		Payload: models.PlayerState{
-			ID:               player.ID,
-			StartingPosition: player.StartingPosition,
-			Name:             player.Name,
-			Color:            player.Color,
-			X:                player.X,
-			Y:                player.Y,
-			VelocityX:        player.VelocityX,
-			VelocityY:        player.VelocityY,
-			LandCapture:      player.LandCapture,
-			PlayerTrail:      player.PlayerTrail,
-			StartingLand:     player.StartingLand,
-			IsAlive:          player.IsAlive,
-			Direction:        nil,
-		},
-	}
-
-	jsonMessage, err := json.Marshal(newPlayerMessage)
-	if err != nil {
-		log.Println("error marshalling new player message:", err)
-		return
-	}
-
-	for _, client := range clients {
-		if client.Conn != nil {
-			client.SendMessage(jsonMessage)
-		}
+		client.logger.Warn("Unknown game message type", "type", gameMessage.Type)
 	}
 }
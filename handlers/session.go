@@ -0,0 +1,99 @@
+// Package handlers session.go keeps the players of disconnected clients around so they can resume them.
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+)
+
+type session struct {
+	ClientID string
+	RoomID   string
+	Player   *models.Player
+	Expires  time.Time
+}
+
+// Mutex to protect access to the sessions map
+var sessionsMutex sync.Mutex
+
+// Map of resume token to the session it resumes
+var sessions = make(map[string]*session)
+
+func newResumeToken() string {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		slog.Error("Error generating resume token", "err", err)
+		return generateClientID()
+	}
+	return hex.EncodeToString(token)
+}
+
+// saveSession keeps a disconnected client's player so it can be resumed within the grace period.
+func saveSession(client *Client, room *Room) {
+	if client.ResumeToken == "" {
+		return
+	}
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	pruneSessionsLocked()
+	sessions[client.ResumeToken] = &session{
+		ClientID: client.ID,
+		RoomID:   room.ID,
+		Player:   client.Player,
+		Expires:  time.Now().Add(currentSettings().Queue.ResumeGracePeriod),
+	}
+}
+
+// findSession returns the session for a resume token, if it hasn't expired, leaving it to be taken.
+func findSession(token string) (*session, bool) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	pruneSessionsLocked()
+	s, ok := sessions[token]
+	return s, ok
+}
+
+// takeSession forgets a session found for a resume token once the player is back, returning false if it expired
+// or another connection took it in the meantime.
+func takeSession(token string, s *session) bool {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	pruneSessionsLocked()
+	if sessions[token] != s {
+		return false
+	}
+	delete(sessions, token)
+	return true
+}
+
+// hasSessions reports whether a player can still resume in the room.
+func hasSessions(roomID string) bool {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	now := time.Now()
+	for _, s := range sessions {
+		if s.RoomID == roomID && now.Before(s.Expires) {
+			return true
+		}
+	}
+	return false
+}
+
+func pruneSessionsLocked() {
+	now := time.Now()
+	for token, s := range sessions {
+		if now.After(s.Expires) {
+			delete(sessions, token)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// A resume token is only used up once its player is connected again, a request turned away leaves it be.
+func TestResumeTokenIsKeptUntilThePlayerIsBack(t *testing.T) {
+	useAuth(t)
+	_, owner := post(t, GuestLogin, "", "")
+	_, other := post(t, GuestLogin, "", "")
+	s := &session{ClientID: owner.PlayerID, RoomID: "resume", Player: newPlayer(owner.PlayerID, nil),
+		Expires: time.Now().Add(time.Minute)}
+	sessionsMutex.Lock()
+	sessions["t0ken"] = s
+	sessionsMutex.Unlock()
+	t.Cleanup(func() {
+		sessionsMutex.Lock()
+		delete(sessions, "t0ken")
+		sessionsMutex.Unlock()
+	})
+	connect := func(token string) int {
+		request := httptest.NewRequest(http.MethodGet, "/ws?resume=t0ken", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		ServeWebSocket(recorder, request)
+		return recorder.Code
+	}
+
+	if code := connect(other.Token); code != http.StatusForbidden {
+		t.Fatalf("another player resuming got %d, expected 403", code)
+	}
+	shuttingDown.Store(true)
+	code := connect(owner.Token)
+	shuttingDown.Store(false)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("resuming while shutting down got %d, expected 503", code)
+	}
+	if found, ok := findSession("t0ken"); !ok || found != s {
+		t.Fatal("a turned away request used up the resume token")
+	}
+
+	// Only one connection gets the player back
+	if !takeSession("t0ken", s) {
+		t.Fatal("the session wasn't taken")
+	}
+	if takeSession("t0ken", s) {
+		t.Fatal("the session was taken twice")
+	}
+}
@@ -0,0 +1,223 @@
+// Package handlers shutdown.go reports health and readiness and winds the game down when the server stops.
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/gorilla/websocket"
+)
+
+const sessionsBucket = "sessions" // resume token to a session saved over a restart
+
+// Set by BeginShutdown or Shutdown, new connections are turned away from then on
+var shuttingDown atomic.Bool
+
+// shutdownNotice is the content of the serverShutdown message.
+type shutdownNotice struct {
+	Reason         string  `json:"reason"`
+	ReconnectAfter float64 `json:"reconnectAfter"` // seconds to wait before reconnecting with the resume token
+}
+
+// savedSession is a session as kept in the store over a restart.
+type savedSession struct {
+	ClientID string              `json:"clientId"`
+	RoomID   string              `json:"roomId"`
+	Player   game.PlayerSnapshot `json:"player"`
+}
+
+// Healthz reports that the process is up.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether the server takes new connections, it fails once shutdown has started.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+		return
+	}
+	if db == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "store not open"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// BeginShutdown fails /readyz and turns new connections away while the players already here keep playing, so
+// load balancers stop sending players before the server stops listening.
+func BeginShutdown() {
+	if !shuttingDown.Swap(true) {
+		slog.Info("Draining, new connections are turned away")
+	}
+}
+
+// rejectWhileShuttingDown turns a request away once shutdown has started, returning true if it did.
+func rejectWhileShuttingDown(w http.ResponseWriter) bool {
+	if !shuttingDown.Load() {
+		return false
+	}
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+	return true
+}
+
+// Shutdown stops the rooms, tells every player and spectator to reconnect after reconnectAfter, sends them
+// what is still queued for them, saves matches, replays, stats and resumable sessions in place of the room
+// checkpoints, finishes the webhook
+// deliveries under way and frees its rooms in the room directory. It gives up on whatever is left when ctx is done.
+func Shutdown(ctx context.Context, reconnectAfter time.Duration) error {
+	shuttingDown.Store(true)
+	slog.Info("Shutting down, players are told to reconnect", "reconnectAfter", reconnectAfter)
+
+	roomsList := roomList()
+	for _, room := range roomsList {
+		room.stopTicking()
+	}
+
+	notice, err := json.Marshal(shutdownNotice{Reason: "server restarting", ReconnectAfter: reconnectAfter.Seconds()})
+	if err != nil {
+		return err
+	}
+	clientsMutex.Lock()
+	leaving := make([]*Client, 0, len(clients))
+	for _, client := range clients {
+		leaving = append(leaving, client)
+	}
+	clientsMutex.Unlock()
+	for _, client := range leaving {
+		go client.disconnect(ctx, SignalMessage{Type: "serverShutdown", Content: string(notice)},
+			websocket.CloseServiceRestart, "server restarting")
+	}
+	for _, room := range roomsList {
+		room.mu.Lock()
+		for _, spectator := range room.spectators {
+			spectator.notify("serverShutdown", string(notice))
+			go spectator.shutdown()
+		}
+		room.mu.Unlock()
+	}
+
+	// Leaving players save their stats, session and, for the last one in a room, the match and replay
+	for waitForClients(ctx) {
+	}
+	for _, room := range roomsList {
+		room.mu.Lock()
+		room.stopRecordingLocked()
+		saveMatch := room.finishMatchLocked()
+		room.mu.Unlock()
+		saveMatch()
+	}
+	saveSessions()
+	stopCheckpoints()
+	// The stats of the players who just left are still on their way through the event bus
+	if err := closeEvents(ctx); err != nil {
+		slog.Warn("Shutdown deadline passed with events still queued", "err", err)
+	}
+	if err := finishWebhooks(ctx); err != nil {
+		slog.Warn("Shutdown deadline passed with webhook deliveries running", "err", err)
+	}
+	leaveCluster()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("shutdown deadline passed with players still connected: %w", err)
+	}
+	slog.Info("Shutdown complete")
+	return nil
+}
+
+// waitForClients returns true while players are still connected and ctx isn't done.
+func waitForClients(ctx context.Context) bool {
+	clientsMutex.Lock()
+	remaining := len(clients)
+	clientsMutex.Unlock()
+	if remaining == 0 {
+		return false
+	}
+	select {
+	case <-ctx.Done():
+		slog.Warn("Shutdown deadline passed with players still connected", "players", remaining)
+		return false
+	case <-time.After(50 * time.Millisecond):
+		return true
+	}
+}
+
+// shutdown closes a spectator's connection once its notice had a moment to go out.
+func (s *Spectator) shutdown() {
+	time.Sleep(100 * time.Millisecond)
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+	if err := s.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+		s.logger.Debug("Error sending close", "err", err)
+	}
+	s.Conn.Close()
+}
+
+// stopTicking ends the room's tick loop.
+func (r *Room) stopTicking() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// saveSessions keeps the resumable sessions in the store so players can resume them after a restart.
+func saveSessions() {
+	if db == nil {
+		return
+	}
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	pruneSessionsLocked()
+	for token, s := range sessions {
+		playersMutex.Lock()
+		saved := savedSession{ClientID: s.ClientID, RoomID: s.RoomID, Player: game.SnapshotOf(s.Player)}
+		playersMutex.Unlock()
+		if err := db.Put(sessionsBucket, token, saved); err != nil {
+			slog.Error("Error saving session", "client", s.ClientID, "err", err)
+		}
+	}
+	slog.Info("Saved resumable sessions", "sessions", len(sessions))
+}
+
+// RestoreSessions loads the sessions saved by the last shutdown, each with a fresh grace period.
+func RestoreSessions() error {
+	if db == nil {
+		return nil
+	}
+	var tokens []string
+	err := db.ForEach(sessionsBucket, func(token string, raw json.RawMessage) error {
+		tokens = append(tokens, token)
+		var saved savedSession
+		if err := json.Unmarshal(raw, &saved); err != nil {
+			return fmt.Errorf("session %s: %w", token, err)
+		}
+		sessionsMutex.Lock()
+		sessions[token] = &session{
+			ClientID: saved.ClientID,
+			RoomID:   saved.RoomID,
+			Player:   saved.Player.Player(),
+			Expires:  time.Now().Add(currentSettings().Queue.ResumeGracePeriod),
+		}
+		sessionsMutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := db.Delete(sessionsBucket, token); err != nil {
+			return err
+		}
+	}
+	if len(tokens) > 0 {
+		slog.Info("Restored resumable sessions", "sessions", len(tokens))
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+// Package handlers spectator.go contains the read-only connections that watch a room without playing in it.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/gorilla/websocket"
+)
+
+// Numbers spectator connections, so one player can watch a room from several tabs
+var spectatorConns atomic.Uint64
+
+// Spectator receives world snapshots of a room, or of a replay when Room is nil, but has no Player entry in it.
+type Spectator struct {
+	ID     string // the watching player's ID
+	ConnID uint64 // tells apart the spectators of one player
+	Conn   *websocket.Conn
+	Send   chan []byte
+	Room   *Room
+
+	mu        sync.Mutex
+	closed    bool
+	following string          // ID of the followed player, empty when free-roaming
+	camera    models.Viewport // clamped to the field when a snapshot is sent, zero shows all of it
+	speed     float64         // replay speed
+	logger    *slog.Logger
+	limiter   *messageLimiter
+}
+
+func NewSpectator(conn *websocket.Conn, id string, room *Room) *Spectator {
+	return &Spectator{
+		ID:      id,
+		ConnID:  spectatorConns.Add(1),
+		Conn:    conn,
+		Send:    make(chan []byte, currentSettings().Queue.SpectatorBuffer),
+		Room:    room,
+		speed:   1,
+		logger:  slog.With("client", id, "role", "spectator"),
+		limiter: newMessageLimiter(currentSettings().Limits),
+	}
+}
+
+// serveSpectator runs a spectator connection until it closes or is promoted to a player.
+// When waiting is set the room was full and the spectator is queued for the next free slot.
+func serveSpectator(conn *websocket.Conn, id string, room *Room, waiting bool, logger *slog.Logger) {
+	spectator := NewSpectator(conn, id, room)
+	spectator.logger = logger.With("role", "spectator")
+	room.addSpectator(spectator)
+	spectator.logger.Info("Spectator is watching room")
+
+	writerDone := make(chan struct{})
+	go func() {
+		spectator.writeLoop()
+		close(writerDone)
+	}()
+
+	if waiting {
+		room.waitForSlot(spectator)
+		spectator.notify("roomFull", room.ID)
+	}
+
+	for spectator.readLoop() {
+		// The spectator asked to join, try to claim a player slot unless the server is going away
+		if shuttingDown.Load() {
+			spectator.notify("shuttingDown", "server is shutting down")
+			continue
+		}
+		client := NewClient(conn, id, NewMessageQueue())
+		client.logger = logger
+		client.Player = newProfilePlayer(id, conn)
+		if !registerClient(client) {
+			spectator.notify("alreadyPlaying", errAlreadyPlaying.Error())
+			continue
+		}
+		if !room.addClient(client) {
+			unregisterClient(client)
+			room.waitForSlot(spectator)
+			spectator.notify("roomFull", room.ID)
+			continue
+		}
+
+		logger.Info("Promoting spectator to player")
+		room.removeSpectator(spectator)
+		spectator.close()
+		<-writerDone
+		startClient(client, room, false)
+		return
+	}
+
+	room.removeSpectator(spectator)
+	spectator.close()
+	<-writerDone
+	err := conn.Close()
+	releaseConnection(conn)
+	if err != nil {
+		spectator.logger.Debug("Error closing spectator connection", "err", err)
+	}
+	spectator.logger.Info("Spectator left room")
+}
+
+// readLoop handles camera commands and returns true when the spectator asks to join as a player.
+func (s *Spectator) readLoop() bool {
+	for {
+		_, message, err := s.Conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				oversizedMessages.Inc()
+				s.logger.Warn("Closing spectator connection, message over the size limit")
+			}
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				s.logger.Warn("Spectator WebSocket error", "err", err)
+			}
+			return false
+		}
+
+		var signalMessage SignalMessage
+		err = json.Unmarshal(message, &signalMessage)
+		countIncoming(signalMessage.Type)
+		switch s.limiter.check(signalMessage.Type, time.Now()) {
+		case penaltyDrop, penaltyWarn:
+			continue
+		case penaltyDisconnect:
+			banned := floodedOff(remoteIP(s.Conn.RemoteAddr().String()), time.Now())
+			s.logger.Warn("Disconnecting spectator for sending too fast", "addressBanned", banned)
+			return false
+		}
+		if err != nil {
+			s.logger.Warn("Error decoding spectator message", "err", err)
+			continue
+		}
+
+		switch signalMessage.Type {
+		case "follow":
+			s.mu.Lock()
+			s.following = signalMessage.Content
+			s.mu.Unlock()
+		case "camera":
+			var camera models.Viewport
+			if err := json.Unmarshal([]byte(signalMessage.Content), &camera); err != nil {
+				s.logger.Warn("Invalid camera", "err", err)
+				continue
+			}
+			s.mu.Lock()
+			s.following = ""
+			s.camera = camera
+			s.mu.Unlock()
+		case "speed":
+			speed, err := strconv.ParseFloat(signalMessage.Content, 64)
+			if err != nil {
+				s.logger.Warn("Invalid speed", "err", err)
+				continue
+			}
+			s.mu.Lock()
+			s.speed = clampReplaySpeed(speed)
+			s.mu.Unlock()
+		case "join":
+			return true
+		default:
+			s.logger.Warn("Unknown spectator message type", "type", signalMessage.Type)
+		}
+	}
+}
+
+func (s *Spectator) writeLoop() {
+	for message := range s.Send {
+		err := s.Conn.WriteMessage(websocket.TextMessage, message)
+		if err != nil {
+			s.logger.Debug("Error writing to spectator", "err", err)
+			// Keep draining so senders never block on a dead spectator
+			for range s.Send {
+			}
+			return
+		}
+		bytesSent.Add(uint64(len(message)))
+	}
+}
+
+// send queues a message, dropping it when the spectator can't keep up since the next snapshot replaces it anyway.
+func (s *Spectator) send(message []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	messagesOut.With(messageType(message)).Inc()
+	select {
+	case s.Send <- message:
+	default:
+		s.logger.Debug("Send buffer is full, dropping snapshot")
+		spectatorDrops.Inc()
+	}
+}
+
+func (s *Spectator) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.Send)
+	}
+}
+
+func (s *Spectator) notify(messageType, content string) {
+	message, err := json.Marshal(SignalMessage{Type: messageType, Content: content})
+	if err != nil {
+		s.logger.Error("Error marshalling spectator notification", "err", err)
+		return
+	}
+	s.send(message)
+}
+
+// sendSnapshot frames the snapshot with the spectator's camera and sends it.
+func (s *Spectator) sendSnapshot(snapshot models.WorldSnapshot) {
+	s.mu.Lock()
+	snapshot.Camera = clampViewport(s.camera, snapshot.Width, snapshot.Height)
+	snapshot.Following = s.following
+	s.mu.Unlock()
+
+	if snapshot.Following != "" {
+		followed := false
+		for _, player := range snapshot.Players {
+			if player.ID == snapshot.Following {
+				snapshot.Camera.X = player.X - snapshot.Camera.Width/2
+				snapshot.Camera.Y = player.Y - snapshot.Camera.Height/2
+				snapshot.Camera = clampViewport(snapshot.Camera, snapshot.Width, snapshot.Height)
+				followed = true
+				break
+			}
+		}
+		if !followed {
+			// The followed player has left, fall back to free roam
+			snapshot.Following = ""
+		}
+	}
+
+	message, err := json.Marshal(models.SnapshotInstruction{Type: "worldSnapshot", Payload: snapshot})
+	if err != nil {
+		s.logger.Error("Error marshalling world snapshot", "err", err)
+		return
+	}
+	s.send(message)
+}
+
+// clampViewport keeps a camera inside a field of the given size.
+func clampViewport(v models.Viewport, width, height float64) models.Viewport {
+	if v.Width <= 0 || v.Width > width {
+		v.Width = width
+	}
+	if v.Height <= 0 || v.Height > height {
+		v.Height = height
+	}
+	if v.X < 0 {
+		v.X = 0
+	} else if v.X > width-v.Width {
+		v.X = width - v.Width
+	}
+	if v.Y < 0 {
+		v.Y = 0
+	} else if v.Y > height-v.Height {
+		v.Y = height - v.Height
+	}
+	return v
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/gorilla/websocket"
+)
+
+// watch connects a spectator of the player to the room, closed when the test ends.
+func watch(t *testing.T, room *Room, playerID string) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, ok := upgrade(w, r, slog.Default()); ok {
+			serveSpectator(conn, playerID, room, false, slog.Default())
+		}
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// expectSignal reads until a signal of the type arrives.
+func expectSignal(t *testing.T, conn *websocket.Conn, signalType string) SignalMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var signal SignalMessage
+		if err := conn.ReadJSON(&signal); err != nil {
+			t.Fatalf("no %s signal: %v", signalType, err)
+		}
+		if signal.Type == signalType {
+			return signal
+		}
+	}
+}
+
+func spectatorCount(room *Room) int {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return len(room.spectators)
+}
+
+// A player watching from two tabs gets both, and one closing leaves the other.
+func TestSpectatorsOfOnePlayerWatchTogether(t *testing.T) {
+	room := NewRoom("watch", 4, 1, config.Default().Gameplay)
+	first := watch(t, room, "p")
+	second := watch(t, room, "p")
+	for deadline := time.Now().Add(2 * time.Second); spectatorCount(room) < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected two spectators, the room has %d", spectatorCount(room))
+		}
+	}
+
+	room.broadcastEveryone([]byte(`{"type":"announcement","content":"hello"}`))
+	for _, conn := range []*websocket.Conn{first, second} {
+		if signal := expectSignal(t, conn, "announcement"); signal.Content != "hello" {
+			t.Fatalf("expected the announcement, got %+v", signal)
+		}
+	}
+
+	first.Close()
+	for deadline := time.Now().Add(2 * time.Second); spectatorCount(room) != 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected one spectator left, the room has %d", spectatorCount(room))
+		}
+	}
+}
+
+// Spectators aren't promoted to players once the server started shutting down.
+func TestSpectatorCantJoinWhileShuttingDown(t *testing.T) {
+	room := NewRoom("drain", 4, 1, config.Default().Gameplay)
+	conn := watch(t, room, "p")
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	if err := conn.WriteJSON(SignalMessage{Type: "join"}); err != nil {
+		t.Fatal(err)
+	}
+	expectSignal(t, conn, "shuttingDown")
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if len(room.clients) != 0 {
+		t.Fatalf("the spectator joined as a player: %d players", len(room.clients))
+	}
+}
@@ -0,0 +1,466 @@
+// Package handlers webhooks.go POSTs match ends, leaderboard records and bans to the webhooks admins subscribe,
+// signed with each webhook's secret, retrying failed deliveries with backoff and keeping a log of them.
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/store"
+	"github.com/4cecoder/multiplayer/webhook"
+	"github.com/go-chi/chi"
+)
+
+const (
+	webhooksBucket   = "webhooks"   // webhook ID to the webhook, with its secret
+	deliveriesBucket = "deliveries" // time the delivery started and its ID to the delivery
+)
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryRetrying  = "retrying"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Event types webhooks can subscribe to, "*" subscribes to all of them
+var webhookEventTypes = []EventType{EventTypeMatchEnd, EventTypeRecord, EventTypeBan}
+
+var ErrInvalidWebhook = errors.New("webhooks need an http or https URL and events of matchEnd, record, ban or *")
+
+// Mutex to protect access to the webhooks and the stopped flag
+var webhooksMutex sync.Mutex
+
+// Webhooks by ID, loaded from the store by LoadWebhooks
+var webhooks = make(map[string]models.Webhook)
+
+// Set by finishWebhooks, no delivery starts after it
+var webhooksStopped bool
+
+// Closed by finishWebhooks, deliveries waiting to be retried give up
+var webhooksStopping = make(chan struct{})
+
+// Cancels the attempts still running when the shutdown deadline passes
+var webhooksContext, cancelWebhooks = context.WithCancel(context.Background())
+
+// Deliveries still running
+var webhookDeliveries sync.WaitGroup
+
+// Receivers answer themselves, redirects are taken as the answer
+var webhookClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// LoadWebhooks reads the webhooks from the store.
+func LoadWebhooks() error {
+	if db == nil {
+		return nil
+	}
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+
+	err := db.ForEach(webhooksBucket, func(_ string, value json.RawMessage) error {
+		var hook models.Webhook
+		if err := json.Unmarshal(value, &hook); err != nil {
+			return err
+		}
+		webhooks[hook.ID] = hook
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Loaded webhooks", "webhooks", len(webhooks))
+	return nil
+}
+
+// validWebhook checks a webhook's URL and event types.
+func validWebhook(hook models.Webhook) bool {
+	target, err := url.Parse(hook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return false
+	}
+	if len(hook.Events) == 0 {
+		return false
+	}
+	for _, name := range hook.Events {
+		if name != "*" && !slices.ContainsFunc(webhookEventTypes, func(t EventType) bool { return t.String() == name }) {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribed reports whether a webhook wants events of a type.
+func subscribed(hook models.Webhook, t EventType) bool {
+	return slices.Contains(hook.Events, t.String()) || slices.Contains(hook.Events, "*")
+}
+
+// deliverEvent sends an event to every webhook subscribed to its type, it is the webhooks subscriber of the bus.
+func deliverEvent(event Event) {
+	if !slices.Contains(webhookEventTypes, event.Type) {
+		return
+	}
+	webhooksMutex.Lock()
+	var targets []models.Webhook
+	for _, hook := range webhooks {
+		if subscribed(hook, event.Type) {
+			targets = append(targets, hook)
+		}
+	}
+	webhooksMutex.Unlock()
+	if len(targets) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Error encoding webhook payload", "event", event.Type, "err", err)
+		return
+	}
+	for _, hook := range targets {
+		startDelivery(hook, event, body)
+	}
+}
+
+// startDelivery sends a payload to a webhook in the background and returns the delivery, or false once the
+// server is shutting down.
+func startDelivery(hook models.Webhook, event Event, body []byte) (models.Delivery, bool) {
+	delivery := models.Delivery{
+		ID:        generateClientID(),
+		WebhookID: hook.ID,
+		Event:     event.Type.String(),
+		Seq:       event.Seq,
+		Status:    DeliveryPending,
+		Created:   time.Now().UTC(),
+		Attempts:  []models.DeliveryAttempt{},
+	}
+
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	if webhooksStopped {
+		slog.Warn("Dropping webhook delivery, the server is shutting down", "webhook", hook.ID, "event", delivery.Event)
+		return models.Delivery{}, false
+	}
+	webhookDeliveries.Add(1)
+	go deliver(hook, delivery, body)
+	return delivery, true
+}
+
+// deliver makes the attempts at a delivery until one is taken, one is refused for good or they run out,
+// logging the delivery after each.
+func deliver(hook models.Webhook, delivery models.Delivery, body []byte) {
+	defer webhookDeliveries.Done()
+	settings := currentSettings().Webhooks
+	logger := slog.With("webhook", hook.ID, "delivery", delivery.ID, "event", delivery.Event)
+
+	for attempt := 1; ; attempt++ {
+		result := attemptDelivery(hook, delivery, body, settings.Timeout)
+		delivery.Attempts = append(delivery.Attempts, result)
+		delivery.NextAttempt = nil
+		switch {
+		case result.Status >= 200 && result.Status < 300:
+			delivery.Status = DeliveryDelivered
+			webhookAttempts.With("delivered").Inc()
+			logger.Debug("Delivered webhook", "attempts", attempt)
+		case attempt >= settings.MaxAttempts || (result.Status != 0 && !webhook.Retryable(result.Status)):
+			delivery.Status = DeliveryFailed
+			webhookAttempts.With("failed").Inc()
+			logger.Warn("Webhook delivery failed", "attempts", attempt, "status", result.Status, "err", result.Error)
+		default:
+			next := time.Now().UTC().Add(webhook.Backoff(attempt, settings.InitialBackoff, settings.MaxBackoff))
+			delivery.NextAttempt = &next
+			delivery.Status = DeliveryRetrying
+			webhookAttempts.With("retry").Inc()
+			logger.Debug("Webhook delivery will be retried", "attempt", attempt, "status", result.Status, "err", result.Error,
+				"next", next)
+		}
+		saveDelivery(delivery)
+		if delivery.NextAttempt == nil {
+			return
+		}
+
+		select {
+		case <-time.After(time.Until(*delivery.NextAttempt)):
+		case <-webhooksStopping:
+			logger.Warn("Gave up on webhook delivery, the server is shutting down", "attempts", attempt)
+			delivery.Status = DeliveryFailed
+			delivery.NextAttempt = nil
+			saveDelivery(delivery)
+			return
+		}
+		webhooksMutex.Lock()
+		_, ok := webhooks[hook.ID]
+		webhooksMutex.Unlock()
+		if !ok {
+			logger.Info("Dropped webhook delivery, the webhook was removed", "attempts", attempt)
+			delivery.Status = DeliveryFailed
+			delivery.NextAttempt = nil
+			saveDelivery(delivery)
+			return
+		}
+	}
+}
+
+// attemptDelivery POSTs a payload once, signed with a fresh timestamp.
+func attemptDelivery(hook models.Webhook, delivery models.Delivery, body []byte, timeout time.Duration) models.DeliveryAttempt {
+	started := time.Now()
+	attempt := models.DeliveryAttempt{At: started.UTC()}
+	ctx, cancel := context.WithTimeout(webhooksContext, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := started.Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "multiplayer-webhooks")
+	request.Header.Set(webhook.HeaderEvent, delivery.Event)
+	request.Header.Set(webhook.HeaderDelivery, delivery.ID)
+	request.Header.Set(webhook.HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(webhook.HeaderSignature, webhook.Sign(hook.Secret, timestamp, body))
+
+	response, err := webhookClient.Do(request)
+	attempt.Duration = float64(time.Since(started).Microseconds()) / 1000
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer response.Body.Close()
+	start, _ := io.ReadAll(io.LimitReader(response.Body, 256))
+	attempt.Status = response.StatusCode
+	attempt.Response = string(start)
+	return attempt
+}
+
+// saveDelivery writes a delivery to the log, dropping the oldest deliveries past Webhooks.DeliveryLog.
+func saveDelivery(delivery models.Delivery) {
+	if db == nil {
+		return
+	}
+	// Keys sort in the order the deliveries started
+	key := fmt.Sprintf("%020d-%s", delivery.Created.UnixNano(), delivery.ID)
+	if err := db.Put(deliveriesBucket, key, delivery); err != nil {
+		slog.Error("Error writing the delivery log", "delivery", delivery.ID, "err", err)
+		return
+	}
+	if err := trimBucket(deliveriesBucket, currentSettings().Webhooks.DeliveryLog); err != nil {
+		slog.Error("Error trimming the delivery log", "err", err)
+	}
+}
+
+// deliveryLog returns up to limit of a webhook's latest deliveries, newest first.
+func deliveryLog(webhookID string, limit int) ([]models.Delivery, error) {
+	deliveries := []models.Delivery{}
+	if db == nil {
+		return deliveries, nil
+	}
+	err := db.ForEach(deliveriesBucket, func(_ string, value json.RawMessage) error {
+		var delivery models.Delivery
+		if err := json.Unmarshal(value, &delivery); err != nil {
+			return err
+		}
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(deliveries)
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// finishWebhooks stops new deliveries and retries and waits for the attempts still running, cancelling them
+// when ctx is done.
+func finishWebhooks(ctx context.Context) error {
+	webhooksMutex.Lock()
+	if !webhooksStopped {
+		webhooksStopped = true
+		close(webhooksStopping)
+	}
+	webhooksMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		webhookDeliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancelWebhooks()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// newWebhookSecret generates a secret for a webhook created without one.
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// ListWebhooks returns the webhooks, oldest first, without their secrets.
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooksMutex.Lock()
+	list := make([]models.Webhook, 0, len(webhooks))
+	for _, hook := range webhooks {
+		hook.Secret = ""
+		list = append(list, hook)
+	}
+	webhooksMutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// CreateWebhook subscribes a URL to event types. The response is the only time the secret is shown, one is
+// generated when the request has none.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	request, ok := readAdminRequest(w, r)
+	if !ok {
+		return
+	}
+	if db == nil {
+		http.Error(w, "webhooks need the store", http.StatusServiceUnavailable)
+		return
+	}
+	hook := models.Webhook{
+		ID:      generateClientID(),
+		URL:     request.URL,
+		Events:  request.Events,
+		Secret:  request.Secret,
+		By:      adminName(r),
+		Created: time.Now().UTC(),
+	}
+	if !validWebhook(hook) {
+		http.Error(w, ErrInvalidWebhook.Error(), http.StatusBadRequest)
+		return
+	}
+	if hook.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hook.Secret = secret
+	}
+
+	webhooksMutex.Lock()
+	err := db.Put(webhooksBucket, hook.ID, hook)
+	if err == nil {
+		webhooks[hook.ID] = hook
+	}
+	webhooksMutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	adminAudit(r, "addWebhook", hook.ID, "", hook.URL)
+	adminLogger(r).Info("Added webhook", "webhook", hook.ID, "url", hook.URL, "events", hook.Events)
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// DeleteWebhook removes a webhook, its retries give up.
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	webhooksMutex.Lock()
+	hook, ok := webhooks[id]
+	err := store.ErrNotFound
+	if ok {
+		err = db.Delete(webhooksBucket, id)
+	}
+	if err == nil {
+		delete(webhooks, id)
+	}
+	webhooksMutex.Unlock()
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	adminAudit(r, "removeWebhook", id, "", hook.URL)
+	adminLogger(r).Info("Removed webhook", "webhook", id, "url", hook.URL)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PingWebhook sends a ping event to a webhook whatever it subscribes to, to try it. The delivery goes in the log.
+func PingWebhook(w http.ResponseWriter, r *http.Request) {
+	webhooksMutex.Lock()
+	hook, ok := webhooks[chi.URLParam(r, "id")]
+	webhooksMutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	event := Event{Type: EventTypePing, At: time.Now().UTC()}
+	body, err := json.Marshal(event)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	delivery, ok := startDelivery(hook, event, body)
+	if !ok {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, delivery)
+}
+
+// ListDeliveries returns a webhook's latest deliveries, newest first, 50 unless the limit parameter says otherwise.
+func ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	webhooksMutex.Lock()
+	_, ok := webhooks[id]
+	webhooksMutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	deliveries, err := deliveryLog(id, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/models"
+	"github.com/4cecoder/multiplayer/webhook"
+)
+
+// receiver is a webhook endpoint that answers with the given statuses in turn, the last one from then on.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		r.mu.Lock()
+		status := r.statuses[min(len(r.requests), len(r.statuses)-1)]
+		r.requests = append(r.requests, request)
+		r.bodies = append(r.bodies, body)
+		r.times = append(r.times, time.Now())
+		r.mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, http.StatusText(status))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// useWebhooks gives the test its own store, webhook settings and the one webhook, put back when it ends.
+func useWebhooks(t *testing.T, settings config.Webhooks, hook models.Webhook) {
+	useStore(t)
+	previous := currentSettings()
+	c := previous
+	c.Webhooks = settings
+	Configure(c)
+	webhooksMutex.Lock()
+	previousHooks := webhooks
+	webhooks = map[string]models.Webhook{hook.ID: hook}
+	webhooksMutex.Unlock()
+
+	t.Cleanup(func() {
+		webhooksMutex.Lock()
+		webhooks = previousHooks
+		webhooksMutex.Unlock()
+		Configure(previous)
+	})
+}
+
+// send delivers a ping to the webhook and waits until it is delivered or given up on.
+func send(t *testing.T, hook models.Webhook) models.Delivery {
+	t.Helper()
+	event := Event{Type: EventTypePing, Seq: 7, At: time.Now().UTC()}
+	delivery, ok := startDelivery(hook, event, []byte(`{"type":"ping"}`))
+	if !ok {
+		t.Fatal("the delivery didn't start")
+	}
+	webhookDeliveries.Wait()
+	deliveries, err := deliveryLog(hook.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].ID != delivery.ID {
+		t.Fatalf("expected the delivery %s in the log, found %+v", delivery.ID, deliveries)
+	}
+	return deliveries[0]
+}
+
+func TestWebhookDeliveryIsSignedAndRetried(t *testing.T) {
+	r := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	hook := models.Webhook{ID: "w1", URL: r.URL, Events: []string{"*"}, Secret: "s3cret"}
+	useWebhooks(t, config.Webhooks{MaxAttempts: 5, InitialBackoff: 50 * time.Millisecond, MaxBackoff: 80 * time.Millisecond,
+		Timeout: time.Second, DeliveryLog: 10}, hook)
+
+	delivery := send(t, hook)
+	if delivery.Status != DeliveryDelivered || delivery.Event != "ping" || delivery.Seq != 7 || delivery.NextAttempt != nil {
+		t.Fatalf("expected a delivered ping, got %+v", delivery)
+	}
+	if len(delivery.Attempts) != 3 {
+		t.Fatalf("expected 3 attempts in the log, got %+v", delivery.Attempts)
+	}
+	for i, status := range []int{500, 502, 200} {
+		if delivery.Attempts[i].Status != status {
+			t.Errorf("attempt %d: expected status %d in the log, got %d", i+1, status, delivery.Attempts[i].Status)
+		}
+	}
+	if delivery.Attempts[0].Response != "Internal Server Error" {
+		t.Errorf("expected the start of the response in the log, got %q", delivery.Attempts[0].Response)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) != 3 {
+		t.Fatalf("the receiver got %d requests, expected 3", len(r.requests))
+	}
+	for i, request := range r.requests {
+		if err := webhook.Verify(hook.Secret, request.Header, r.bodies[i], time.Minute, time.Now()); err != nil {
+			t.Errorf("attempt %d: %v", i+1, err)
+		}
+		if request.Header.Get(webhook.HeaderDelivery) != delivery.ID || request.Header.Get(webhook.HeaderEvent) != "ping" {
+			t.Errorf("attempt %d: expected delivery %s of a ping, got %q of %q", i+1, delivery.ID,
+				request.Header.Get(webhook.HeaderDelivery), request.Header.Get(webhook.HeaderEvent))
+		}
+	}
+	if err := webhook.Verify("other", r.requests[0].Header, r.bodies[0], time.Minute, time.Now()); err != webhook.ErrBadSignature {
+		t.Errorf("the signature checked out with another secret: %v", err)
+	}
+	// The wait doubles after each failure
+	for i, wait := range []time.Duration{50 * time.Millisecond, 80 * time.Millisecond} {
+		if gap := r.times[i+1].Sub(r.times[i]); gap < wait {
+			t.Errorf("attempt %d came %v after the one before, expected at least %v", i+2, gap, wait)
+		}
+	}
+}
+
+func TestWebhookDeliveryGivesUpAfterMaxAttempts(t *testing.T) {
+	r := newReceiver(t, http.StatusServiceUnavailable)
+	hook := models.Webhook{ID: "w2", URL: r.URL, Events: []string{"*"}, Secret: "s3cret"}
+	useWebhooks(t, config.Webhooks{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond,
+		Timeout: time.Second, DeliveryLog: 10}, hook)
+
+	delivery := send(t, hook)
+	if delivery.Status != DeliveryFailed || len(delivery.Attempts) != 3 || delivery.NextAttempt != nil {
+		t.Fatalf("expected a failed delivery after 3 attempts, got %+v", delivery)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) != 3 {
+		t.Fatalf("the receiver got %d requests, expected 3", len(r.requests))
+	}
+}
+
+func TestWebhookDeliveryIsNotRetriedWhenRefused(t *testing.T) {
+	r := newReceiver(t, http.StatusGone)
+	hook := models.Webhook{ID: "w3", URL: r.URL, Events: []string{"*"}, Secret: "s3cret"}
+	useWebhooks(t, config.Webhooks{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond,
+		Timeout: time.Second, DeliveryLog: 10}, hook)
+
+	delivery := send(t, hook)
+	if delivery.Status != DeliveryFailed || len(delivery.Attempts) != 1 || delivery.Attempts[0].Status != http.StatusGone {
+		t.Fatalf("expected one refused attempt, got %+v", delivery)
+	}
+}
+
+func TestWebhookDeliveryLogIsTrimmed(t *testing.T) {
+	r := newReceiver(t, http.StatusOK)
+	hook := models.Webhook{ID: "w4", URL: r.URL, Events: []string{"*"}, Secret: "s3cret"}
+	useWebhooks(t, config.Webhooks{MaxAttempts: 1, InitialBackoff: time.Second, MaxBackoff: time.Second,
+		Timeout: time.Second, DeliveryLog: 2}, hook)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		delivery, ok := startDelivery(hook, Event{Type: EventTypePing}, []byte(`{}`))
+		if !ok {
+			t.Fatal("the delivery didn't start")
+		}
+		webhookDeliveries.Wait()
+		ids = append(ids, delivery.ID)
+	}
+	deliveries, err := deliveryLog(hook.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newest first, without the oldest
+	if len(deliveries) != 2 || deliveries[0].ID != ids[2] || deliveries[1].ID != ids[1] {
+		t.Fatalf("expected deliveries %v, got %+v", []string{ids[2], ids[1]}, deliveries)
+	}
+}
@@ -0,0 +1,69 @@
+// Package logging sets up the server's structured logger and samples logs of high-frequency events.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the minimum level logged, it can be changed while the server runs.
+var Level = new(slog.LevelVar)
+
+// Setup makes slog's default logger, and the standard log package through it, write to w at LOG_LEVEL
+// (debug, info, warn or error, default info) in LOG_FORMAT (text or json, default text).
+func Setup(w io.Writer) error {
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := SetLevel(value); err != nil {
+			return err
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: Level}
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown LOG_FORMAT %q, use text or json", format)
+	}
+	// The log package writes through the same handler, it is only left for fatal errors
+	slog.SetDefault(slog.New(handler))
+	slog.SetLogLoggerLevel(slog.LevelError)
+	return nil
+}
+
+// SetLevel changes the minimum level logged by name.
+func SetLevel(name string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+	}
+	Level.Set(level)
+	return nil
+}
+
+// Sampler lets one in every n events through, for logging things that happen many times a second.
+type Sampler struct {
+	every uint64
+	seen  atomic.Uint64
+}
+
+func NewSampler(every uint64) *Sampler {
+	return &Sampler{every: max(every, 1)}
+}
+
+// Allow reports whether this event should be logged: the first one does, then every nth after it.
+func (s *Sampler) Allow() bool {
+	return (s.seen.Add(1)-1)%s.every == 0
+}
+
+// Sampled is an attribute saying how many events a logged one stands for.
+func (s *Sampler) Sampled() slog.Attr {
+	return slog.Uint64("sampled", s.every)
+}
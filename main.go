@@ -1,46 +1,242 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
+	"github.com/4cecoder/multiplayer/config"
+	"github.com/4cecoder/multiplayer/devcert"
 	"github.com/4cecoder/multiplayer/handlers"
+	"github.com/4cecoder/multiplayer/logging"
+	"github.com/4cecoder/multiplayer/metrics"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
+	// Load environment variables from .env file, before the logger reads LOG_LEVEL and LOG_FORMAT
+	envErr := godotenv.Load()
+	if err := logging.Setup(os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+	if envErr != nil {
+		slog.Debug("No .env file loaded", "err", envErr)
+	}
+	settings, configFile, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
+	}
+	handlers.Configure(settings)
+	if configFile != "" {
+		slog.Info("Loaded configuration", "file", configFile)
+	}
+
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	if err := handlers.OpenStore(filepath.Join(dataDir, "game.db")); err != nil {
+		log.Fatal(err)
+	}
+	defer handlers.CloseStore()
+	if err := handlers.InitAuth(); err != nil {
+		log.Fatal(err)
+	}
+	if err := handlers.LoadModeration(); err != nil {
+		log.Fatal(err)
+	}
+	if err := handlers.LoadWebhooks(); err != nil {
+		log.Fatal(err)
+	}
+	mapsDir := os.Getenv("MAPS_DIR")
+	if mapsDir == "" {
+		mapsDir = "maps"
+	}
+	if err := handlers.LoadMaps(mapsDir); err != nil {
+		log.Fatal(err)
+	}
+	if err := handlers.RestoreSessions(); err != nil {
+		slog.Error("Error restoring sessions", "err", err)
+	}
+	if err := handlers.RestoreCheckpoints(filepath.Join(dataDir, "checkpoints")); err != nil {
+		slog.Error("Error restoring checkpoints", "err", err)
+	}
+	handlers.StartCheckpoints()
+	achievementsFile := os.Getenv("ACHIEVEMENTS_FILE")
+	if achievementsFile == "" {
+		achievementsFile = "achievements.json"
+	}
+	if err := handlers.LoadAchievements(achievementsFile); err != nil {
+		log.Fatal(err)
+	}
+	handlers.StartEvents()
+	if err := handlers.StartCluster(); err != nil {
+		log.Fatal(err)
 	}
 
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Recoverer)
 	r.Use(LoggingMiddleware)
 
 	r.Get("/", handlers.HandleRoot)
+	r.Get("/metrics", metrics.Handler)
+	r.Get("/healthz", handlers.Healthz)
+	r.Get("/readyz", handlers.Readyz)
 	r.Get("/ws", handlers.ServeWebSocket)
+	r.Get("/lobby", handlers.Lobby)
+	r.Get("/replays", handlers.ListReplays)
+	r.Get("/replays/{name}", handlers.DownloadReplay)
+	r.Get("/replays/{name}/watch", handlers.WatchReplay)
+	r.Get("/profiles/{id}", handlers.GetProfile)
+	r.Put("/profiles/{id}", handlers.UpdateProfile)
+	r.Get("/leaderboards", handlers.ListLeaderboards)
+	r.Get("/leaderboards/{board}", handlers.GetLeaderboard)
+	r.Get("/api/matches/{id}", handlers.GetMatch)
+	r.Get("/achievements", handlers.ListAchievements)
+	r.Get("/maps", handlers.ListMaps)
+	r.Get("/api/players/{id}/stats", handlers.GetPlayerStats)
+	r.Post("/auth/guest", handlers.GuestLogin)
+	r.With(handlers.LimitAuth).Post("/auth/register", handlers.Register)
+	r.With(handlers.LimitAuth).Post("/auth/login", handlers.Login)
+	r.Post("/auth/logout", handlers.Logout)
+	r.Get("/auth/me", handlers.Me)
+	r.Route("/admin", func(r chi.Router) {
+		// The dashboard page asks for the token itself, the API behind it needs it
+		r.Get("/", handlers.AdminPage)
+		r.Group(func(r chi.Router) {
+			r.Use(handlers.RequireAdmin)
+			r.Get("/loglevel", handlers.GetLogLevel)
+			r.Put("/loglevel", handlers.SetLogLevel)
+			r.Get("/rooms", handlers.ListAdminRooms)
+			r.Post("/rooms/{id}/reset", handlers.ResetRoom)
+			r.Get("/clients", handlers.ListAdminClients)
+			r.Post("/clients/{id}/kick", handlers.KickClient)
+			r.Get("/players", handlers.ListAdminPlayers)
+			r.Post("/players/{id}/ban", handlers.BanPlayer)
+			r.Delete("/players/{id}/ban", handlers.UnbanPlayer)
+			r.Post("/players/{id}/mute", handlers.MutePlayer)
+			r.Delete("/players/{id}/mute", handlers.UnmutePlayer)
+			r.Post("/players/{id}/rename", handlers.RenamePlayer)
+			r.Post("/players/{id}/teleport", handlers.TeleportPlayer)
+			r.Get("/players/{id}/strikes", handlers.GetStrikes)
+			r.Delete("/players/{id}/strikes", handlers.ClearStrikes)
+			r.Get("/bans", handlers.ListBans)
+			r.Post("/bans", handlers.CreateBan)
+			r.Delete("/bans/{id}", handlers.LiftBan)
+			r.Get("/audit", handlers.ListAudit)
+			r.Get("/webhooks", handlers.ListWebhooks)
+			r.Post("/webhooks", handlers.CreateWebhook)
+			r.Delete("/webhooks/{id}", handlers.DeleteWebhook)
+			r.Post("/webhooks/{id}/ping", handlers.PingWebhook)
+			r.Get("/webhooks/{id}/deliveries", handlers.ListDeliveries)
+			r.Get("/cluster", handlers.GetCluster)
+			r.Post("/announcements", handlers.Announce)
+		})
+	})
 
 	// Serve static files
 	fileServer := http.FileServer(http.Dir("./static"))
 	r.Handle("/static/*", http.StripPrefix("/static/", fileServer))
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // Default port if not specified
+	port := settings.Network.Port
+	server := &http.Server{Addr: ":" + port, Handler: r}
+	certFile, keyFile := settings.Network.TLSCert, settings.Network.TLSKey
+	if settings.Network.DevTLS && certFile == "" {
+		certFile, keyFile = filepath.Join(dataDir, "dev-cert.pem"), filepath.Join(dataDir, "dev-key.pem")
 	}
+	if settings.Network.DevTLS {
+		hostname, _ := os.Hostname()
+		generated, err := devcert.Ensure(certFile, keyFile, []string{"localhost", "127.0.0.1", "::1", hostname})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if generated {
+			slog.Warn("Generated a self-signed certificate for development, browsers will warn about it", "cert", certFile)
+		}
+	}
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 
-	log.Printf("Server started on :%s", port)
-	err = http.ListenAndServe(":"+port, r)
-	if err != nil {
-		log.Fatal(err)
+	// Gameplay and room settings are reloaded on SIGHUP and whenever the configuration file changes
+	reload := make(chan struct{}, 1)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			reload <- struct{}{}
+		}
+	}()
+	if configFile != "" {
+		go config.Watch(stop, configFile, 2*time.Second, func() {
+			reload <- struct{}{}
+		})
+	}
+	go func() {
+		for range reload {
+			reloaded, _, err := config.Load(os.Args[1:])
+			if err != nil {
+				slog.Error("Keeping the current configuration, the new one is invalid", "err", err)
+				continue
+			}
+			handlers.ReloadConfig(reloaded)
+		}
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			slog.Info("Server started", "port", port, "tls", true, "cert", certFile)
+			serveErr <- server.ListenAndServeTLS(certFile, keyFile)
+			return
+		}
+		slog.Info("Server started", "port", port)
+		serveErr <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		slog.Error("Server stopped", "err", err)
 		return
+	case <-stop.Done():
+	}
+
+	// Fail readiness while still listening so load balancers move new players elsewhere, then stop taking
+	// connections, let the players go and save what they leave behind. The drain counts towards the timeout,
+	// and a second signal cuts it short.
+	again := make(chan os.Signal, 1)
+	signal.Notify(again, os.Interrupt, syscall.SIGTERM)
+	handlers.BeginShutdown()
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), settings.Network.ShutdownTimeout)
+	defer cancelShutdown()
+	drain := time.NewTimer(settings.Network.ShutdownDrain)
+	select {
+	case <-drain.C:
+	case <-again:
+		drain.Stop()
+		slog.Info("Signalled again, cutting the drain short")
+	case <-ctx.Done():
+		drain.Stop()
+	}
+	deadline, _ := ctx.Deadline()
+	slog.Info("Shutting down", "timeout", time.Until(deadline).Round(time.Millisecond))
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		slog.Error("Error stopping HTTP server", "err", err)
+	}
+	if err := handlers.Shutdown(ctx, settings.Network.ReconnectAfter); err != nil {
+		slog.Error("Error shutting down", "err", err)
 	}
 }
 
@@ -49,13 +245,21 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		// Start timer
 		start := time.Now()
 
-		// Process request
-		next.ServeHTTP(w, r)
+		// Process request, keeping the status it was answered with
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
 
 		// Stop timer
 		elapsed := time.Since(start)
 
 		// Log details of the request
-		log.Printf("%s %s %s", r.Method, r.RequestURI, elapsed)
+		slog.Info("Request",
+			"method", r.Method,
+			"path", r.RequestURI,
+			"status", ww.Status(),
+			"bytes", ww.BytesWritten(),
+			"duration", elapsed,
+			"request", middleware.GetReqID(r.Context()),
+		)
 	})
 }
@@ -0,0 +1,203 @@
+// Package mapdef loads map definitions: the size of a field, its cells and where players spawn, one JSON file per
+// map. A map is named after its file, so maps/arena.json is the map "arena".
+//
+// The layout draws the field one string per row of cells using the characters of game.Terrain, which is how
+// non-rectangular fields are made: cells left as spaces or past the end of a row are outside the field.
+// Obstacles and spawn zones can also be given as rectangles, drawn over the layout, which is easier for big maps.
+package mapdef
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/4cecoder/multiplayer/game"
+)
+
+// DefaultCellSize is the cell size of maps that don't set one.
+const DefaultCellSize = 20
+
+type Map struct {
+	Name      string   `json:"name"`
+	Width     float64  `json:"width"`    // pixels, the layout's width when left out
+	Height    float64  `json:"height"`   // pixels, the layout's height when left out
+	CellSize  float64  `json:"cellSize"` // pixels
+	Layout    []string `json:"layout,omitempty"`
+	Obstacles []Area   `json:"obstacles,omitempty"`
+	Spawns    []Area   `json:"spawns,omitempty"` // players spawn around these cells, anywhere free when there are none
+
+	terrain game.Terrain
+}
+
+// Area is a rectangle of cells.
+type Area struct {
+	Row  int    `json:"row"`
+	Col  int    `json:"col"`
+	Rows int    `json:"rows,omitempty"` // 1 when left out
+	Cols int    `json:"cols,omitempty"` // 1 when left out
+	Kind string `json:"kind,omitempty"` // obstacles only: "wall" (the default), "hazard" or "void"
+}
+
+var obstacleKinds = map[string]byte{"": game.Wall, "wall": game.Wall, "hazard": game.Hazard, "void": game.Void}
+
+// Load reads and checks a map file.
+func Load(path string) (Map, error) {
+	var m Map
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		return m, fmt.Errorf("%s: %w", path, err)
+	}
+	m.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+	if err := m.build(); err != nil {
+		return m, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// LoadDir reads every map file in dir. A missing dir has no maps, and a map that doesn't load is an error.
+func LoadDir(dir string) (map[string]Map, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	maps := make(map[string]Map, len(paths))
+	var errs []error
+	for _, path := range paths {
+		m, err := Load(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		maps[m.Name] = m
+	}
+	return maps, errors.Join(errs...)
+}
+
+// Terrain is the map's cells, empty for an open field.
+func (m Map) Terrain() game.Terrain {
+	return m.terrain
+}
+
+// Rows and Cols are the size of the map in cells.
+func (m Map) Rows() int { return int(m.Height / m.CellSize) }
+func (m Map) Cols() int { return int(m.Width / m.CellSize) }
+
+// build fills in the defaults, checks the map and draws its terrain.
+func (m *Map) build() error {
+	if m.CellSize == 0 {
+		m.CellSize = DefaultCellSize
+	}
+	if m.CellSize < 0 {
+		return errors.New("cellSize must be positive")
+	}
+	if m.Width == 0 {
+		for _, line := range m.Layout {
+			m.Width = max(m.Width, float64(len(line))*m.CellSize)
+		}
+	}
+	if m.Height == 0 {
+		m.Height = float64(len(m.Layout)) * m.CellSize
+	}
+	if !isMultiple(m.Width, m.CellSize) || !isMultiple(m.Height, m.CellSize) {
+		return errors.New("width and height must be multiples of cellSize")
+	}
+	rows, cols := m.Rows(), m.Cols()
+	if rows < 3 || cols < 3 {
+		return errors.New("the field must be at least three cells each way")
+	}
+	if len(m.Layout) > rows {
+		return fmt.Errorf("the layout has %d rows, the field %d", len(m.Layout), rows)
+	}
+
+	grid := make([][]byte, rows)
+	for row := range grid {
+		grid[row] = []byte(strings.Repeat(string(game.Open), cols))
+		if len(m.Layout) == 0 {
+			continue
+		}
+		var line string
+		if row < len(m.Layout) {
+			line = m.Layout[row]
+		}
+		if len(line) > cols {
+			return fmt.Errorf("layout row %d has %d cells, the field %d", row, len(line), cols)
+		}
+		for col := range grid[row] {
+			cell := byte(game.Void)
+			if col < len(line) {
+				cell = line[col]
+			}
+			switch cell {
+			case game.Open, game.Wall, game.Hazard, game.Void, game.Spawn:
+				grid[row][col] = cell
+			default:
+				return fmt.Errorf("layout row %d has %q, cells are one of %q", row, cell, ".# xS")
+			}
+		}
+	}
+
+	paint := func(a Area, kind byte, what string, i int) error {
+		if a.Rows == 0 {
+			a.Rows = 1
+		}
+		if a.Cols == 0 {
+			a.Cols = 1
+		}
+		if a.Row < 0 || a.Col < 0 || a.Rows < 0 || a.Cols < 0 || a.Row+a.Rows > rows || a.Col+a.Cols > cols {
+			return fmt.Errorf("%s %d is not within the %dx%d cells of the field", what, i, cols, rows)
+		}
+		for row := a.Row; row < a.Row+a.Rows; row++ {
+			for col := a.Col; col < a.Col+a.Cols; col++ {
+				// Spawn zones only mark cells players can walk on
+				if kind != game.Spawn || grid[row][col] == game.Open {
+					grid[row][col] = kind
+				}
+			}
+		}
+		return nil
+	}
+	for i, obstacle := range m.Obstacles {
+		kind, ok := obstacleKinds[obstacle.Kind]
+		if !ok {
+			return fmt.Errorf("obstacle %d has kind %q, it must be wall, hazard or void", i, obstacle.Kind)
+		}
+		if err := paint(obstacle, kind, "obstacle", i); err != nil {
+			return err
+		}
+	}
+	for i, spawn := range m.Spawns {
+		if err := paint(spawn, game.Spawn, "spawn", i); err != nil {
+			return err
+		}
+	}
+
+	m.terrain = nil
+	open := true
+	for _, row := range grid {
+		open = open && strings.Trim(string(row), string(game.Open)) == ""
+	}
+	if open {
+		return nil
+	}
+	for _, row := range grid {
+		m.terrain = append(m.terrain, string(row))
+	}
+	if len(m.terrain.SpawnCells(rows, cols)) == 0 {
+		return errors.New("there is nowhere to spawn, players need a 3x3 area they can walk on around a spawn cell")
+	}
+	return nil
+}
+
+func isMultiple(value, of float64) bool {
+	n := value / of
+	return n == float64(int64(n))
+}
@@ -0,0 +1,131 @@
+package mapdef
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/game"
+)
+
+// load writes a map file named name.json and loads it.
+func load(t *testing.T, name, definition string) (Map, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name+".json")
+	if err := os.WriteFile(path, []byte(definition), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestOpenField(t *testing.T) {
+	m, err := load(t, "open", `{"width": 200, "height": 100}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "open" || m.CellSize != DefaultCellSize || m.Rows() != 5 || m.Cols() != 10 {
+		t.Fatalf("expected the 10x5 map open with the default cell size, got %q %vpx %dx%d",
+			m.Name, m.CellSize, m.Cols(), m.Rows())
+	}
+	if m.Terrain() != nil {
+		t.Fatalf("an open field has no terrain, got %q", m.Terrain())
+	}
+}
+
+func TestLayoutSetsTheSize(t *testing.T) {
+	m, err := load(t, "ring", `{"cellSize": 10, "layout": [
+		" ..... ",
+		".......",
+		"..#....",
+		".......",
+		" ....."
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Width != 70 || m.Height != 50 {
+		t.Fatalf("expected a 70x50 field from the layout, got %vx%v", m.Width, m.Height)
+	}
+	// Spaces and the cells past the end of short rows are outside the field
+	want := game.Terrain{" ..... ", ".......", "..#....", ".......", " ..... "}
+	if strings.Join(m.Terrain(), "|") != strings.Join(want, "|") {
+		t.Fatalf("expected the terrain %q, got %q", want, m.Terrain())
+	}
+}
+
+func TestObstaclesAndSpawnsAreDrawnOverTheLayout(t *testing.T) {
+	m, err := load(t, "rooms", `{"width": 160, "height": 120, "obstacles": [
+		{"row": 0, "col": 4, "rows": 6},
+		{"row": 2, "col": 0, "cols": 2, "kind": "hazard"},
+		{"row": 5, "col": 7, "kind": "void"}
+	], "spawns": [{"row": 1, "col": 5, "rows": 3, "cols": 3}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := game.Terrain{
+		"....#...",
+		"....#SSS",
+		"xx..#SSS",
+		"....#SSS",
+		"....#...",
+		"....#.. ",
+	}
+	if strings.Join(m.Terrain(), "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected the terrain\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(m.Terrain(), "\n"))
+	}
+}
+
+func TestInvalidMaps(t *testing.T) {
+	for _, test := range []struct {
+		name, definition, err string
+	}{
+		{"unknown field", `{"width": 100, "height": 100, "colour": "red"}`, "unknown field"},
+		{"negative cells", `{"width": 100, "height": 100, "cellSize": -5}`, "cellSize must be positive"},
+		{"uneven size", `{"width": 110, "height": 100}`, "multiples of cellSize"},
+		{"too small", `{"width": 100, "height": 40}`, "at least three cells"},
+		{"short layout", `{"layout": ["...", "..."]}`, "at least three cells"},
+		{"layout too tall", `{"width": 60, "height": 60, "layout": ["...", "...", "...", "..."]}`, "the layout has 4 rows"},
+		{"layout too wide", `{"width": 60, "height": 60, "layout": ["....", "...", "..."]}`, "layout row 0 has 4 cells"},
+		{"unknown cell", `{"layout": ["...", ".o.", "..."]}`, `layout row 1 has 'o'`},
+		{"obstacle outside", `{"width": 100, "height": 100, "obstacles": [{"row": 4, "col": 3, "cols": 3}]}`, "obstacle 0 is not within"},
+		{"negative obstacle", `{"width": 100, "height": 100, "obstacles": [{"row": -1, "col": 0}]}`, "obstacle 0 is not within"},
+		{"unknown obstacle", `{"width": 100, "height": 100, "obstacles": [{"row": 1, "col": 1, "kind": "lava"}]}`, `obstacle 0 has kind "lava"`},
+		{"spawn outside", `{"width": 100, "height": 100, "spawns": [{"row": 0, "col": 5}]}`, "spawn 0 is not within"},
+		{"walled in", `{"layout": ["...", ".#.", "..."]}`, "nowhere to spawn"},
+		{"spawn by a wall", `{"width": 100, "height": 100, "obstacles": [{"row": 1, "col": 2}], "spawns": [{"row": 1, "col": 1}]}`, "nowhere to spawn"},
+	} {
+		if _, err := load(t, "bad", test.definition); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error about %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	maps, err := LoadDir("../maps")
+	if err != nil {
+		t.Fatalf("the maps that ship with the server don't load: %v", err)
+	}
+	for _, name := range []string{"arena", "classic", "donut"} {
+		if _, ok := maps[name]; !ok {
+			t.Errorf("map %s is missing from %v", name, maps)
+		}
+	}
+
+	// The maps that load are returned alongside the errors of those that don't
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "good.json"), []byte(`{"width": 100, "height": 100}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"width": 10}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a map"), 0o644)
+	maps, err = LoadDir(dir)
+	if err == nil || !strings.Contains(err.Error(), "bad.json") {
+		t.Fatalf("expected an error about bad.json, got %v", err)
+	}
+	if len(maps) != 1 || maps["good"].Name != "good" {
+		t.Fatalf("expected only the map good, got %v", maps)
+	}
+
+	if maps, err := LoadDir(filepath.Join(dir, "missing")); err != nil || len(maps) != 0 {
+		t.Fatalf("a missing directory should have no maps, got %v %v", maps, err)
+	}
+}
@@ -0,0 +1,213 @@
+// Package metrics keeps counters, gauges and histograms and serves them in the Prometheus text format,
+// so the server can be scraped without a client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are histogram bounds in seconds from 100µs to 1s, for things that take about a tick.
+var DefaultBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Every metric created, in the order they were created
+var (
+	registryMutex sync.Mutex
+	registry      []metric
+	names         = make(map[string]bool)
+)
+
+func register(name string, m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if names[name] {
+		panic("metrics: " + name + " registered twice")
+	}
+	names[name] = true
+	registry = append(registry, m)
+}
+
+// Counter is a number that only goes up.
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+type counter struct {
+	name, help string
+	Counter
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &counter{name: name, help: help}
+	register(name, c)
+	return &c.Counter
+}
+
+func (c *counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.name, c.Value())
+}
+
+// CounterVec is a set of counters told apart by the value of one label.
+type CounterVec struct {
+	name, help, label string
+
+	mu       sync.RWMutex
+	counters map[string]*Counter
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	v := &CounterVec{name: name, help: help, label: label, counters: make(map[string]*Counter)}
+	register(name, v)
+	return v
+}
+
+// With returns the counter for a label value, creating it on first use.
+func (v *CounterVec) With(value string) *Counter {
+	v.mu.RLock()
+	c, ok := v.counters[value]
+	v.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok = v.counters[value]; !ok {
+		c = &Counter{}
+		v.counters[value] = c
+	}
+	return c
+}
+
+func (v *CounterVec) write(w io.Writer) {
+	writeHeader(w, v.name, v.help, "counter")
+	v.mu.RLock()
+	values := make([]string, 0, len(v.counters))
+	for value := range v.counters {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", v.name, v.label, escape(value), v.counters[value].Value())
+	}
+	v.mu.RUnlock()
+}
+
+// gaugeFunc is a gauge read when the metrics are scraped.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value fn returns on every scrape.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(name, &gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	name, help string
+	bounds     []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(name, help string, bounds []float64) *Histogram {
+	h := &Histogram{name: name, help: help, bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+	register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.bounds, value)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// Write writes every metric in the Prometheus text format.
+func Write(w io.Writer) {
+	registryMutex.Lock()
+	metrics := append([]metric{}, registry...)
+	registryMutex.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics for Prometheus to scrape.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	Write(w)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the lines of the exposition that belong to the named metric.
+func scrape(t *testing.T, name string) []string {
+	t.Helper()
+	var out bytes.Buffer
+	Write(&out)
+	var lines []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 2 && fields[0] == "#" && fields[2] == name || strings.HasPrefix(line, name+" ") ||
+			strings.HasPrefix(line, name+"{") || strings.HasPrefix(line, name+"_") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func expectLines(t *testing.T, name string, want ...string) {
+	t.Helper()
+	got := scrape(t, name)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("%s:\ngot\n%s\nwant\n%s", name, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "Things counted.")
+	c.Inc()
+	c.Add(41)
+	if c.Value() != 42 {
+		t.Fatalf("expected 42, got %d", c.Value())
+	}
+	expectLines(t, "test_counter_total",
+		"# HELP test_counter_total Things counted.",
+		"# TYPE test_counter_total counter",
+		"test_counter_total 42")
+}
+
+func TestCounterVecSortsAndEscapesLabels(t *testing.T) {
+	v := NewCounterVec("test_vec_total", "Things by kind.", "kind")
+	v.With("move").Add(3)
+	v.With(`say "hi"`).Inc()
+	v.With("chat").Inc()
+	v.With("move").Inc()
+	expectLines(t, "test_vec_total",
+		"# HELP test_vec_total Things by kind.",
+		"# TYPE test_vec_total counter",
+		`test_vec_total{kind="chat"} 1`,
+		`test_vec_total{kind="move"} 4`,
+		`test_vec_total{kind="say \"hi\""} 1`)
+}
+
+func TestGaugeFuncIsReadOnScrape(t *testing.T) {
+	value := 1.5
+	NewGaugeFunc("test_gauge", "A level.", func() float64 { return value })
+	expectLines(t, "test_gauge", "# HELP test_gauge A level.", "# TYPE test_gauge gauge", "test_gauge 1.5")
+	value = math.Inf(1)
+	expectLines(t, "test_gauge", "# HELP test_gauge A level.", "# TYPE test_gauge gauge", "test_gauge +Inf")
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	h := NewHistogram("test_seconds", "How long.", []float64{0.1, 0.5, 1})
+	for _, value := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+		h.Observe(value)
+	}
+	expectLines(t, "test_seconds",
+		"# HELP test_seconds How long.",
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{le="0.1"} 2`,
+		`test_seconds_bucket{le="0.5"} 3`,
+		`test_seconds_bucket{le="1"} 4`,
+		`test_seconds_bucket{le="+Inf"} 5`,
+		"test_seconds_sum 3.15",
+		"test_seconds_count 5")
+}
+
+func TestRegisteringANameTwicePanics(t *testing.T) {
+	NewCounter("test_twice_total", "Once.")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a name twice didn't panic")
+		}
+	}()
+	NewCounter("test_twice_total", "Twice.")
+}
+
+func TestHandler(t *testing.T) {
+	NewCounter("test_handler_total", "Served.").Inc()
+	recorder := httptest.NewRecorder()
+	Handler(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if !strings.Contains(recorder.Body.String(), "\ntest_handler_total 1\n") {
+		t.Fatalf("the handler didn't serve the counter:\n%s", recorder.Body.String())
+	}
+}
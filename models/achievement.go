@@ -0,0 +1,24 @@
+// Package models achievement.go
+package models
+
+// Achievement is a goal players unlock once a metric reaches a threshold, defined in the achievements file.
+type Achievement struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Metric      string  `json:"metric"`
+	AtLeast     float64 `json:"atLeast"`
+	XP          int     `json:"xp"`
+}
+
+// Unlock is sent to a player who has just unlocked an achievement.
+type Unlock struct {
+	Achievement
+	TotalXP int `json:"totalXp"`
+	Level   int `json:"level"`
+}
+
+type UnlockInstruction struct {
+	Type    string `json:"type"`
+	Payload Unlock `json:"payload"`
+}
@@ -0,0 +1,108 @@
+// Package models admin.go
+package models
+
+import "time"
+
+// RoomInfo is a room as listed by the admin API.
+type RoomInfo struct {
+	ID         string  `json:"id"`
+	Capacity   int     `json:"capacity"`
+	Seed       int64   `json:"seed"`
+	Tick       uint64  `json:"tick"`
+	Width      float64 `json:"width"`
+	Height     float64 `json:"height"`
+	Map        string  `json:"map,omitempty"`
+	Clients    int     `json:"clients"`
+	Bots       int     `json:"bots"`
+	Spectators int     `json:"spectators"`
+	MatchID    string  `json:"matchId,omitempty"`
+	Replay     string  `json:"replay,omitempty"` // replay being recorded
+}
+
+// ClientInfo is a connected player as listed by the admin API.
+type ClientInfo struct {
+	ID         string    `json:"id"`
+	RoomID     string    `json:"roomId"`
+	RemoteAddr string    `json:"remoteAddr"`
+	RTT        float64   `json:"rtt"`       // milliseconds, 0 until measured
+	SendQueue  int       `json:"sendQueue"` // messages waiting in the send buffer
+	Overflow   int       `json:"overflow"`  // messages waiting in the MessageQueue
+	Joined     time.Time `json:"joined"`
+	Muted      bool      `json:"muted"`
+}
+
+// PlayerInfo is a player in the world as listed by the admin API, bots included.
+type PlayerInfo struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	RoomID    string  `json:"roomId"`
+	Bot       bool    `json:"bot"`
+	Alive     bool    `json:"alive"`
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	Territory float64 `json:"territory"` // percent of the field
+	Streak    int     `json:"streak"`
+	Strikes   int     `json:"strikes"` // anti-cheat strikes ever recorded
+	Flagged   bool    `json:"flagged"` // flagged as a likely cheater within the last StrikeWindow
+}
+
+// Ban keeps a player out of the game, by the client ID they play as, their account or the address they connect from.
+type Ban struct {
+	ID      string     `json:"id"`
+	Kind    string     `json:"kind"`   // client, account or ip
+	Target  string     `json:"target"` // client ID, username, or an address or CIDR range
+	Reason  string     `json:"reason"`
+	By      string     `json:"by"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"` // never when unset
+}
+
+// AuditEntry is a moderation or admin action, as kept in the audit log.
+type AuditEntry struct {
+	At      time.Time `json:"at"`
+	By      string    `json:"by"` // the admin's name, or the server for automatic actions
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	Remote  string    `json:"remote,omitempty"`
+	Request string    `json:"request,omitempty"`
+}
+
+// Strike is a rule a player broke, recorded by the server's anti-cheat checks.
+type Strike struct {
+	Kind   string    `json:"kind"` // reversal, inputRate, invalidInput, impersonation or clientState
+	Detail string    `json:"detail,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// StrikeLog is a player's latest strikes, oldest first.
+type StrikeLog struct {
+	PlayerID string     `json:"playerId"`
+	Total    int        `json:"total"`             // strikes ever recorded, including ones no longer listed
+	Flagged  *time.Time `json:"flagged,omitempty"` // when the player last had enough strikes to look like a cheater
+	Strikes  []Strike   `json:"strikes"`
+}
+
+// RoomReset is the content of the roomReset signal, the field the room starts over on.
+type RoomReset struct {
+	Width    float64  `json:"width"`
+	Height   float64  `json:"height"`
+	CellSize float64  `json:"cellSize"`
+	Map      string   `json:"map,omitempty"`
+	Terrain  []string `json:"terrain,omitempty"`
+}
+
+// ChatLine is a chat message relayed to a room.
+type ChatLine struct {
+	PlayerID string    `json:"id"`
+	Name     string    `json:"name"`
+	Text     string    `json:"text"`
+	At       time.Time `json:"at"`
+}
+
+// ChatInstruction carries a chat line to players and spectators.
+type ChatInstruction struct {
+	Type    string   `json:"type"`
+	Payload ChatLine `json:"payload"`
+}
@@ -0,0 +1,44 @@
+// Package models leaderboard.go
+package models
+
+import "time"
+
+// Standing is a player's place on a room's live leaderboard.
+type Standing struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Color     string  `json:"color"`
+	Territory float64 `json:"territory"` // percent of the field owned
+	Kills     int     `json:"kills"`     // since joining the room
+	Streak    int     `json:"streak"`    // kills since the last death
+	IsAlive   bool    `json:"isAlive"`
+}
+
+// Leaderboard is a room's live ranking, best territory first.
+type Leaderboard struct {
+	RoomID    string     `json:"roomId"`
+	Tick      uint64     `json:"tick"`
+	Standings []Standing `json:"standings"`
+}
+
+type LeaderboardInstruction struct {
+	Type    string      `json:"type"`
+	Payload Leaderboard `json:"payload"`
+}
+
+// Record is a player's score on a persistent leaderboard.
+type Record struct {
+	Rank     int       `json:"rank"`
+	PlayerID string    `json:"playerId"`
+	Name     string    `json:"name,omitempty"`
+	Score    float64   `json:"score"`
+	At       time.Time `json:"at"` // when the score was last raised
+}
+
+// RecordBoard is one period of a persistent leaderboard, best score first.
+type RecordBoard struct {
+	Board   string   `json:"board"`
+	Period  string   `json:"period"`
+	Key     string   `json:"key"` // the day or week the board covers, "all" for all-time
+	Records []Record `json:"records"`
+}
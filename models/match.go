@@ -0,0 +1,77 @@
+// Package models match.go
+package models
+
+import "time"
+
+// Ways a life can end
+const (
+	CauseKilled   = "killed"   // ran into another player's trail
+	CauseTrail    = "trail"    // ran into their own trail
+	CauseHazard   = "hazard"   // ran into a hazard on the map
+	CauseLeft     = "left"     // left the room alive
+	CauseMatchEnd = "matchEnd" // still alive when the match ended
+)
+
+// Match is a finished game in a room, from the first human joining until the last one leaves.
+type Match struct {
+	ID       string        `json:"id"`
+	RoomID   string        `json:"roomId"`
+	Replay   string        `json:"replay,omitempty"` // name of the match's replay, if it was recorded
+	Started  time.Time     `json:"started"`
+	Ended    time.Time     `json:"ended"`
+	Duration float64       `json:"duration"` // seconds
+	Players  []MatchPlayer `json:"players"`
+}
+
+// MatchPlayer is how a player did in a match, bots included.
+type MatchPlayer struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Color         string    `json:"color"`
+	Bot           bool      `json:"bot,omitempty"`
+	Joined        time.Time `json:"joined"`
+	Left          time.Time `json:"left"`
+	PeakTerritory float64   `json:"peakTerritory"` // percent of the field
+	Kills         int       `json:"kills"`
+	Deaths        int       `json:"deaths"`
+	Lives         []Life    `json:"lives"`
+}
+
+// Life is one spawn of a player, up to their death or leaving.
+type Life struct {
+	Started       time.Time `json:"started"`
+	Duration      float64   `json:"duration"` // seconds
+	PeakTerritory float64   `json:"peakTerritory"`
+	Kills         int       `json:"kills"`
+	Cause         string    `json:"cause"`              // one of the Cause constants
+	KilledBy      string    `json:"killedBy,omitempty"` // the killer's ID when Cause is killed
+}
+
+// MatchSummary is a player's part in a match, as kept in their history.
+type MatchSummary struct {
+	MatchID       string    `json:"matchId"`
+	RoomID        string    `json:"roomId"`
+	Started       time.Time `json:"started"`
+	Ended         time.Time `json:"ended"`
+	SecondsPlayed float64   `json:"secondsPlayed"`
+	SecondsAlive  float64   `json:"secondsAlive"`
+	PeakTerritory float64   `json:"peakTerritory"`
+	Kills         int       `json:"kills"`
+	Deaths        int       `json:"deaths"`
+	Lives         int       `json:"lives"`
+	LongestLife   float64   `json:"longestLife"` // seconds
+}
+
+// PlayerStats are a player's lifetime totals together with figures worked out from their match history.
+type PlayerStats struct {
+	PlayerID      string         `json:"playerId"`
+	Name          string         `json:"name,omitempty"`
+	Lifetime      ProfileStats   `json:"lifetime"`
+	Matches       int            `json:"matches"`
+	Lives         int            `json:"lives"`
+	AverageLife   float64        `json:"averageLife"` // seconds
+	LongestLife   float64        `json:"longestLife"`
+	PeakTerritory float64        `json:"peakTerritory"`
+	KillsPerDeath float64        `json:"killsPerDeath"`
+	Recent        []MatchSummary `json:"recent"` // newest first
+}
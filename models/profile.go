@@ -0,0 +1,36 @@
+// Package models profile.go
+package models
+
+import "time"
+
+// Profile is what the server remembers about a player between connections.
+type Profile struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name,omitempty"`  // chosen display name, empty until the player picks one
+	Color    string       `json:"color,omitempty"` // preferred colour as #RRGGBB
+	Created  time.Time    `json:"created"`
+	LastSeen time.Time    `json:"lastSeen"`
+	Stats    ProfileStats `json:"stats"`
+
+	XP           int                  `json:"xp"`
+	Level        int                  `json:"level"`
+	Achievements map[string]time.Time `json:"achievements,omitempty"` // achievement ID to when it was unlocked
+}
+
+// ProfileStats are lifetime totals over every game the player joined.
+type ProfileStats struct {
+	Games          int     `json:"games"`
+	Kills          int     `json:"kills"`
+	Deaths         int     `json:"deaths"`
+	Captures       int     `json:"captures"`
+	CellsCaptured  int     `json:"cellsCaptured"`
+	BestTerritory  int     `json:"bestTerritory"` // most cells owned at once
+	BestKillStreak int     `json:"bestKillStreak"`
+	SecondsPlayed  float64 `json:"secondsPlayed"`
+}
+
+// ProfileUpdate is the body of a profile change, empty fields are left alone.
+type ProfileUpdate struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
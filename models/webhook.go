@@ -0,0 +1,35 @@
+// Package models webhook.go
+package models
+
+import "time"
+
+// Webhook is a subscription that gets events POSTed to its URL.
+type Webhook struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events"`           // event types, or "*" for all of them
+	Secret  string    `json:"secret,omitempty"` // signs the payloads, only shown when the webhook is created
+	By      string    `json:"by"`
+	Created time.Time `json:"created"`
+}
+
+// Delivery is one event sent to a webhook, with every attempt made at it.
+type Delivery struct {
+	ID          string            `json:"id"`
+	WebhookID   string            `json:"webhookId"`
+	Event       string            `json:"event"`
+	Seq         uint64            `json:"seq,omitempty"` // the event's sequence number
+	Status      string            `json:"status"`        // pending, retrying, delivered or failed
+	Created     time.Time         `json:"created"`
+	NextAttempt *time.Time        `json:"nextAttempt,omitempty"`
+	Attempts    []DeliveryAttempt `json:"attempts"`
+}
+
+// DeliveryAttempt is one POST of a delivery.
+type DeliveryAttempt struct {
+	At       time.Time `json:"at"`
+	Status   int       `json:"status,omitempty"` // HTTP status, 0 when there was no response
+	Error    string    `json:"error,omitempty"`
+	Response string    `json:"response,omitempty"` // start of the response body
+	Duration float64   `json:"duration"`           // milliseconds
+}
@@ -0,0 +1,58 @@
+// Package models world.go
+package models
+
+// Viewport is the rectangle of the field a spectator's camera is looking at.
+type Viewport struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// WorldSnapshot is the full state of a room as sent to spectators every tick.
+type WorldSnapshot struct {
+	RoomID    string        `json:"roomId"`
+	Tick      uint64        `json:"tick"`
+	Width     float64       `json:"width"`
+	Height    float64       `json:"height"`
+	CellSize  float64       `json:"cellSize"`
+	Map       string        `json:"map,omitempty"`
+	Terrain   []string      `json:"terrain,omitempty"`
+	Players   []PlayerState `json:"players"`
+	Camera    Viewport      `json:"camera"`
+	Following string        `json:"following,omitempty"`
+}
+
+type SnapshotInstruction struct {
+	Type    string        `json:"type"`
+	Payload WorldSnapshot `json:"payload"`
+}
+
+// Welcome is the handshake sent to a player once they have a slot in a room.
+type Welcome struct {
+	PlayerID    string `json:"id"`
+	RoomID      string `json:"roomId"`
+	ResumeToken string `json:"resumeToken"` // pass as ?resume= when reconnecting to get the same player back
+	Resumed     bool   `json:"resumed"`
+
+	// The room's field, which can differ between rounds when the server's gameplay settings or the room's map change
+	Width    float64  `json:"width"`
+	Height   float64  `json:"height"`
+	CellSize float64  `json:"cellSize"`
+	Map      string   `json:"map,omitempty"`     // empty for the plain field of the gameplay settings
+	Terrain  []string `json:"terrain,omitempty"` // the map's cells, one string per row: . open, # wall, x hazard, space outside the field, S spawn
+}
+
+// MapInfo is a map as listed by GET /maps.
+type MapInfo struct {
+	Name     string   `json:"name"`
+	Width    float64  `json:"width"`
+	Height   float64  `json:"height"`
+	CellSize float64  `json:"cellSize"`
+	Terrain  []string `json:"terrain,omitempty"`
+}
+
+type WelcomeInstruction struct {
+	Type    string  `json:"type"`
+	Payload Welcome `json:"payload"`
+}
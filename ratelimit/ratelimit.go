@@ -0,0 +1,41 @@
+// Package ratelimit has the token buckets the server uses to limit how fast clients send messages.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket holds up to Burst tokens and refills at Rate tokens per second, each allowed event takes one.
+// A Bucket is safe for concurrent use.
+type Bucket struct {
+	Rate  float64
+	Burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket.
+func NewBucket(rate float64, burst int) *Bucket {
+	return &Bucket{Rate: rate, Burst: burst, tokens: float64(burst)}
+}
+
+// Allow takes a token if there is one, refilling the bucket for the time passed since the last call first.
+func (b *Bucket) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.last) {
+		if !b.last.IsZero() {
+			b.tokens = min(float64(b.Burst), b.tokens+now.Sub(b.last).Seconds()*b.Rate)
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
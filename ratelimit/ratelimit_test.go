@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func allowed(b *Bucket, now time.Time, tries int) int {
+	n := 0
+	for i := 0; i < tries; i++ {
+		if b.Allow(now) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestBucketAllowsABurstThenRefills(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := NewBucket(10, 5)
+	if n := allowed(b, start, 10); n != 5 {
+		t.Fatalf("a full bucket allowed %d of 10, expected the burst of 5", n)
+	}
+	// 10 per second is a token every 100ms
+	if n := allowed(b, start.Add(250*time.Millisecond), 10); n != 2 {
+		t.Fatalf("after 250ms the bucket allowed %d, expected 2", n)
+	}
+	// The half token left over counts towards the next one
+	if n := allowed(b, start.Add(300*time.Millisecond), 10); n != 1 {
+		t.Fatalf("after 50ms more the bucket allowed %d, expected 1", n)
+	}
+	if n := allowed(b, start.Add(350*time.Millisecond), 10); n != 0 {
+		t.Fatalf("after another 50ms the bucket allowed %d, expected 0", n)
+	}
+}
+
+func TestBucketRefillStopsAtTheBurst(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := NewBucket(10, 3)
+	allowed(b, start, 3)
+	if n := allowed(b, start.Add(time.Hour), 10); n != 3 {
+		t.Fatalf("after an hour the bucket allowed %d, expected the burst of 3", n)
+	}
+}
+
+func TestBucketIgnoresTheClockGoingBack(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := NewBucket(10, 2)
+	allowed(b, start, 2)
+	if n := allowed(b, start.Add(-time.Minute), 5); n != 0 {
+		t.Fatalf("going back in time allowed %d, expected 0", n)
+	}
+	if n := allowed(b, start.Add(100*time.Millisecond), 5); n != 1 {
+		t.Fatalf("100ms after the last call the bucket allowed %d, expected 1", n)
+	}
+}
+
+func TestBucketIsSafeForConcurrentUse(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewBucket(1, 100)
+	var wg sync.WaitGroup
+	var n atomic.Int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.Add(int64(allowed(b, now, 50)))
+		}()
+	}
+	wg.Wait()
+	if n.Load() != 100 {
+		t.Fatalf("400 concurrent tries allowed %d, expected the burst of 100", n.Load())
+	}
+}
@@ -0,0 +1,82 @@
+// Package replay playback.go re-simulates a replay one tick at a time.
+package replay
+
+import (
+	"fmt"
+
+	"github.com/4cecoder/multiplayer/game"
+)
+
+// Playback steps a restored world through a replay's frames.
+type Playback struct {
+	Replay *Replay
+	World  *game.World
+
+	next     int // index of the next frame to apply
+	finished bool
+}
+
+func NewPlayback(replay *Replay) *Playback {
+	return &Playback{Replay: replay, World: game.Restore(replay.Header.World)}
+}
+
+// Done reports whether the match has been played to its end.
+func (p *Playback) Done() bool {
+	return p.finished
+}
+
+// Step simulates the next tick and returns its events, the last step also applies the final roster changes.
+func (p *Playback) Step() []game.Event {
+	if p.finished {
+		return nil
+	}
+
+	var events []game.Event
+	if p.World.Tick < p.Replay.End {
+		tick := p.World.Tick + 1
+		var inputs []game.Input
+		if p.next < len(p.Replay.Frames) && p.Replay.Frames[p.next].Tick == tick {
+			frame := p.Replay.Frames[p.next]
+			p.next++
+			events = p.apply(frame.Roster)
+			inputs = frame.Inputs
+		}
+		events = append(events, p.World.Step(inputs)...)
+	}
+	if p.World.Tick >= p.Replay.End {
+		events = append(events, p.apply(p.Replay.Final)...)
+		p.finished = true
+	}
+	return events
+}
+
+func (p *Playback) apply(roster []Change) []game.Event {
+	var events []game.Event
+	for _, change := range roster {
+		if change.Join != nil {
+			events = append(events, p.World.AddPlayer(change.Join.Player()))
+		} else if event, ok := p.World.RemovePlayer(change.Leave); ok {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Run plays the replay to its end.
+func (p *Playback) Run() {
+	for !p.Done() {
+		p.Step()
+	}
+}
+
+// Verify plays the replay to its end and checks it finished in the world that was recorded.
+func (p *Playback) Verify() error {
+	p.Run()
+	if p.Replay.Digest == "" {
+		return fmt.Errorf("replay has no final digest, the recording was cut short")
+	}
+	if digest := p.World.Digest(); digest != p.Replay.Digest {
+		return fmt.Errorf("replay diverged: ended in %s, recorded %s", digest, p.Replay.Digest)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Package replay records matches into compact files and plays them back by re-simulating them.
+//
+// A replay file is gzipped JSON lines: a Header holding the world as the match started,
+// then one Frame for every tick where something happened. Re-running the frames through
+// game.World reproduces the match exactly, so positions never need to be stored.
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/models"
+)
+
+const (
+	Version = 1
+	Ext     = ".replay.gz"
+)
+
+type Header struct {
+	Version int           `json:"version"`
+	RoomID  string        `json:"room"`
+	Started time.Time     `json:"started"`
+	World   game.Snapshot `json:"world"`
+}
+
+// Frame is everything that happened on one tick: roster changes since the previous tick, in the order
+// they happened, then the inputs applied on the tick. The last frame of a file has End set, its roster
+// changes happened after its tick (the last players leaving) and it has the digest of the final world.
+type Frame struct {
+	Tick   uint64       `json:"t"`
+	Roster []Change     `json:"r,omitempty"`
+	Inputs []game.Input `json:"i,omitempty"`
+	End    bool         `json:"end,omitempty"`
+	Digest string       `json:"digest,omitempty"`
+}
+
+// Change is a player joining, as they were before the world placed them, or leaving.
+type Change struct {
+	Join  *game.PlayerSnapshot `json:"join,omitempty"`
+	Leave string               `json:"leave,omitempty"`
+}
+
+// Recorder writes a match to a replay file. It is not safe for concurrent use, the room serialises calls.
+// The file is written under a .part name and only gets its real name once the match is closed.
+type Recorder struct {
+	Name string
+	Path string
+
+	file    *os.File
+	gz      *gzip.Writer
+	encoder *json.Encoder
+	frame   Frame
+}
+
+// Create starts a replay of the world in dir, named after the room and the start time.
+func Create(dir, roomID string, world *game.World) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	started := time.Now().UTC()
+	name := fmt.Sprintf("%s-%s%s", sanitize(roomID), started.Format("20060102-150405.000"), Ext)
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(file)
+	r := &Recorder{Name: name, Path: path, file: file, gz: gz, encoder: json.NewEncoder(gz)}
+	header := Header{Version: Version, RoomID: roomID, Started: started, World: world.Snapshot()}
+	if err := r.encoder.Encode(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.frame.Tick = world.Tick + 1
+	return r, nil
+}
+
+// Join records a player joining, call it before the world adds them.
+func (r *Recorder) Join(player *models.Player) {
+	snapshot := game.SnapshotOf(player)
+	r.frame.Roster = append(r.frame.Roster, Change{Join: &snapshot})
+}
+
+func (r *Recorder) Leave(playerID string) {
+	r.frame.Roster = append(r.frame.Roster, Change{Leave: playerID})
+}
+
+// Step records the inputs of a tick that has just been simulated, skipping ticks where nothing happened.
+func (r *Recorder) Step(tick uint64, inputs []game.Input) error {
+	r.frame.Tick = tick
+	r.frame.Inputs = inputs
+	var err error
+	if len(r.frame.Roster) > 0 || len(r.frame.Inputs) > 0 {
+		err = r.encoder.Encode(r.frame)
+	}
+	r.frame = Frame{Tick: tick + 1}
+	return err
+}
+
+// Close ends the replay with the world as the match finished and closes the file.
+func (r *Recorder) Close(world *game.World) error {
+	end := Frame{Tick: world.Tick, Roster: r.frame.Roster, End: true, Digest: world.Digest()}
+	err := r.encoder.Encode(end)
+	if closeErr := r.gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(r.file.Name(), r.Path)
+}
+
+// Replay is a whole replay file in memory.
+type Replay struct {
+	Header Header
+	Frames []Frame
+	End    uint64   // last tick of the match
+	Final  []Change // roster changes after the last tick
+	Digest string   // digest of the final world, empty if the recording was cut short
+}
+
+// Read decodes a replay, a file without an end frame (the server stopped mid-match) ends at its last frame.
+func Read(reader io.Reader) (*Replay, error) {
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(gz))
+	replay := &Replay{}
+	if err := decoder.Decode(&replay.Header); err != nil {
+		return nil, fmt.Errorf("reading replay header: %w", err)
+	}
+	if replay.Header.Version != Version {
+		return nil, fmt.Errorf("unsupported replay version %d", replay.Header.Version)
+	}
+	replay.End = replay.Header.World.Tick
+
+	for {
+		var frame Frame
+		err := decoder.Decode(&frame)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading replay frame: %w", err)
+		}
+		replay.End = max(replay.End, frame.Tick)
+		if frame.End {
+			replay.Final = frame.Roster
+			replay.Digest = frame.Digest
+			break
+		}
+		replay.Frames = append(replay.Frames, frame)
+	}
+	return replay, nil
+}
+
+func Open(path string) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// ReadHeader reads only the header of a replay file.
+func ReadHeader(path string) (Header, error) {
+	var header Header
+	file, err := os.Open(path)
+	if err != nil {
+		return header, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return header, err
+	}
+	defer gz.Close()
+	err = json.NewDecoder(gz).Decode(&header)
+	return header, err
+}
+
+// ValidName reports whether name is a bare replay file name, so it can't be used to reach other files.
+func ValidName(name string) bool {
+	return strings.HasSuffix(name, Ext) && filepath.Base(name) == name && !strings.HasPrefix(name, ".")
+}
+
+// sanitize keeps room IDs from adding path separators or odd characters to file names.
+func sanitize(roomID string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, roomID)
+}
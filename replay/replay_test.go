@@ -0,0 +1,83 @@
+package replay
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/4cecoder/multiplayer/game"
+	"github.com/4cecoder/multiplayer/models"
+)
+
+// TestRecordAndVerify records a short match the way a room does and checks it re-simulates to the same world.
+func TestRecordAndVerify(t *testing.T) {
+	config := game.DefaultConfig()
+	config.Seed = 7
+	world := game.NewWorld(config)
+	recorder, err := Create(t.TempDir(), "test", world)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		player := &models.Player{ID: id, Name: id, IsAlive: true}
+		recorder.Join(player)
+		world.AddPlayer(player)
+	}
+	script := map[int][]game.Input{
+		1:  {{PlayerID: "a", Direction: "right"}, {PlayerID: "b", Direction: "left"}},
+		20: {{PlayerID: "a", Direction: "down"}},
+		35: {{PlayerID: "b", Direction: "up"}},
+		50: {{PlayerID: "a", Direction: "left"}},
+	}
+	for i := 1; i <= 80; i++ {
+		world.Step(script[i])
+		if err := recorder.Step(world.Tick, script[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder.Leave("b")
+	world.RemovePlayer("b")
+	if err := recorder.Close(world); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := Open(recorder.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewPlayback(recorded).Verify(); err != nil {
+		t.Fatal(err)
+	}
+	recorded.Digest = "tampered"
+	if err := NewPlayback(recorded).Verify(); err == nil {
+		t.Fatal("a replay with the wrong digest verified")
+	}
+}
+
+// TestReplayFiles re-simulates the replay files matching REPLAY_FILES and checks they end the way they were
+// recorded, for example REPLAY_FILES='replays/*.replay.gz' go test ./replay -run ReplayFiles.
+func TestReplayFiles(t *testing.T) {
+	pattern := os.Getenv("REPLAY_FILES")
+	if pattern == "" {
+		t.Skip("REPLAY_FILES is not set")
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no replay files match %s", pattern)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			recorded, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := NewPlayback(recorded).Verify(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
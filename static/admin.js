@@ -0,0 +1,198 @@
+// The admin dashboard lists rooms, clients, players, bans and the audit log every few seconds and calls the
+// admin API with the token typed in, kept for the browser tab only.
+const refreshInterval = 2000;
+
+function adminToken() {
+    return sessionStorage.getItem('adminToken') || '';
+}
+
+// adminRequest calls the admin API, returning the parsed JSON body if there is one
+async function adminRequest(method, path, body) {
+    const response = await fetch('/admin' + path, {
+        method: method,
+        headers: {
+            'Authorization': 'Bearer ' + adminToken(),
+            'Content-Type': 'application/json',
+            'X-Admin-Name': sessionStorage.getItem('adminName') || '',
+        },
+        body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (!response.ok) {
+        throw new Error(method + ' ' + path + ': ' + response.status + ' ' + (await response.text()).trim());
+    }
+    return response.status === 204 ? null : response.json();
+}
+
+function setStatus(text) {
+    document.getElementById('adminStatus').textContent = text;
+}
+
+// act runs an action and refreshes the tables, showing what went wrong if it failed
+async function act(description, method, path, body) {
+    try {
+        await adminRequest(method, path, body);
+        setStatus(description);
+        refresh();
+    } catch (err) {
+        setStatus(err.message);
+    }
+}
+
+function button(label, onClick) {
+    const element = document.createElement('button');
+    element.className = 'button';
+    element.textContent = label;
+    element.addEventListener('click', onClick);
+    return element;
+}
+
+function fillTable(id, items, columns, actions) {
+    const body = document.querySelector('#' + id + ' tbody');
+    body.innerHTML = '';
+    items.forEach(item => {
+        const row = document.createElement('tr');
+        columns(item).forEach(value => {
+            const cell = document.createElement('td');
+            cell.textContent = value;
+            row.appendChild(cell);
+        });
+        const cell = document.createElement('td');
+        actions(item).forEach(element => cell.appendChild(element));
+        row.appendChild(cell);
+        body.appendChild(row);
+    });
+}
+
+async function refresh() {
+    if (!adminToken()) {
+        return;
+    }
+    let rooms, clients, players, bans, audit;
+    try {
+        [rooms, clients, players, bans, audit] = await Promise.all([
+            adminRequest('GET', '/rooms'),
+            adminRequest('GET', '/clients'),
+            adminRequest('GET', '/players'),
+            adminRequest('GET', '/bans'),
+            adminRequest('GET', '/audit?limit=50'),
+        ]);
+    } catch (err) {
+        setStatus(err.message);
+        return;
+    }
+
+    fillTable('rooms', rooms, room => [
+        room.id, room.tick, (room.map ? room.map + ' ' : '') + room.width + 'x' + room.height,
+        room.clients + '/' + room.capacity, room.bots, room.spectators, room.matchId || '',
+    ], room => [
+        button('Reset', () => {
+            const map = prompt('Reset room ' + room.id + '? Its match ends and everyone respawns, on the map named ' +
+                'here or the next one of its rotation.', '');
+            if (map !== null) {
+                act('Reset room ' + room.id, 'POST', '/rooms/' + encodeURIComponent(room.id) + '/reset', {map: map.trim()});
+            }
+        }),
+    ]);
+
+    fillTable('clients', clients, client => [
+        client.id, client.roomId, client.remoteAddr, client.rtt.toFixed(1), client.sendQueue, client.overflow,
+        new Date(client.joined).toLocaleTimeString(),
+    ], client => [
+        button('Kick', () => {
+            const reason = prompt('Reason for kicking ' + client.id + ':', '');
+            if (reason !== null) {
+                act('Kicked ' + client.id, 'POST', '/clients/' + client.id + '/kick', {reason: reason});
+            }
+        }),
+        button('Ban', () => {
+            const reason = prompt('Reason for banning ' + client.id + ':', '');
+            if (reason !== null) {
+                act('Banned ' + client.id, 'POST', '/players/' + client.id + '/ban', {reason: reason});
+            }
+        }),
+        button('Ban address', () => {
+            const address = client.remoteAddr.replace(/:\d+$/, '').replace(/^\[|\]$/g, '');
+            const reason = prompt('Reason for banning ' + address + ':', '');
+            if (reason !== null) {
+                act('Banned ' + address, 'POST', '/bans', {kind: 'ip', target: address, reason: reason});
+            }
+        }),
+        client.muted
+            ? button('Unmute', () => act('Unmuted ' + client.id, 'DELETE', '/players/' + client.id + '/mute'))
+            : button('Mute', () => act('Muted ' + client.id, 'POST', '/players/' + client.id + '/mute')),
+    ]);
+
+    fillTable('players', players, player => [
+        player.name + (player.bot ? ' (bot)' : ''), player.id, player.roomId,
+        player.alive ? Math.round(player.x) + ', ' + Math.round(player.y) : 'dead',
+        player.territory.toFixed(1) + '%', player.streak, player.strikes + (player.flagged ? ' (flagged)' : ''),
+    ], player => [
+        button('Strikes', async () => {
+            try {
+                const log = await adminRequest('GET', '/players/' + player.id + '/strikes');
+                const lines = log.strikes.slice(-20).map(strike =>
+                    new Date(strike.at).toLocaleTimeString() + ' ' + strike.kind + ' ' + (strike.detail || ''));
+                if (confirm(log.total + ' strikes, latest:\n' + lines.join('\n') + '\n\nClear them?')) {
+                    act('Cleared strikes of ' + player.id, 'DELETE', '/players/' + player.id + '/strikes');
+                }
+            } catch (err) {
+                setStatus(err.message);
+            }
+        }),
+        button('Rename', () => {
+            const name = prompt('New name for ' + player.name + ':', player.name);
+            if (name) {
+                act('Renamed ' + player.id, 'POST', '/players/' + player.id + '/rename', {name: name});
+            }
+        }),
+        button('Teleport', () => {
+            const destination = prompt('Teleport ' + player.name + ' to x, y:', Math.round(player.x) + ', ' + Math.round(player.y));
+            if (destination) {
+                const [x, y] = destination.split(',').map(value => parseFloat(value));
+                act('Teleported ' + player.id, 'POST', '/players/' + player.id + '/teleport', {x: x, y: y});
+            }
+        }),
+    ]);
+
+    fillTable('bans', bans, ban => [
+        ban.kind, ban.target, ban.reason, ban.by, new Date(ban.created).toLocaleString(),
+        ban.expires ? new Date(ban.expires).toLocaleString() : 'never',
+    ], ban => [
+        button('Lift', () => act('Lifted the ban on ' + ban.target, 'DELETE', '/bans/' + ban.id)),
+    ]);
+
+    fillTable('audit', audit, entry => [
+        new Date(entry.at).toLocaleString(), entry.by, entry.action, entry.target || '', entry.reason || '',
+        entry.detail || '',
+    ], () => []);
+}
+
+window.addEventListener('load', function () {
+    document.getElementById('adminName').value = sessionStorage.getItem('adminName') || '';
+    document.getElementById('tokenForm').addEventListener('submit', function (event) {
+        event.preventDefault();
+        sessionStorage.setItem('adminToken', document.getElementById('adminToken').value);
+        sessionStorage.setItem('adminName', document.getElementById('adminName').value);
+        setStatus('');
+        refresh();
+    });
+    document.getElementById('announceForm').addEventListener('submit', function (event) {
+        event.preventDefault();
+        const input = document.getElementById('announcement');
+        act('Sent announcement', 'POST', '/announcements', {message: input.value});
+        input.value = '';
+    });
+    document.getElementById('banForm').addEventListener('submit', function (event) {
+        event.preventDefault();
+        const target = document.getElementById('banTarget');
+        act('Banned ' + target.value, 'POST', '/bans', {
+            kind: document.getElementById('banKind').value,
+            target: target.value,
+            reason: document.getElementById('banReason').value,
+            duration: document.getElementById('banDuration').value.trim(),
+        });
+        target.value = '';
+    });
+    refresh();
+    setInterval(refresh, refreshInterval);
+});
@@ -1,11 +1,73 @@
-const port = 8080;
-// const siteURL = 'http://isdlife.com';
-const siteURL = 'http://localhost';
+// The server injects its settings into the page, the API and the socket live on the host the page came from
+const pageConfig = window.pageConfig || {socketPath: '/ws', reconnectAfter: 5};
+const siteURL = window.location.origin;
+const socketHost = (window.location.protocol === 'https:' ? 'wss://' : 'ws://') + window.location.host;
 let socket;
 let playerID = null;
 
+// Open the page with ?role=spectator to watch a room, and ?room=<id> to pick one.
+// ?replay=<name>&speed=<0.5-8> watches a recorded match instead.
+const pageParams = new URLSearchParams(window.location.search);
+const replayName = pageParams.get('replay');
+let replaySpeed = parseFloat(pageParams.get('speed')) || 1;
+let replayEnded = false;
+let kicked = false;
+let isSpectator = pageParams.get('role') === 'spectator' || replayName !== null;
+let snapshotPlayers = [];
+let spectatorCamera = {x: 0, y: 0, width: 800, height: 600};
+let followedID = '';
+let reconnectDelay = pageConfig.reconnectAfter * 1000;
+// The server sends the field's size and map in its welcome, they can change between rounds
+let cellSize = 20;
+let terrainKey = '';
+
+function socketURL() {
+    if (replayName !== null) {
+        return socketHost + '/replays/' + encodeURIComponent(replayName) + '/watch?speed=' + replaySpeed;
+    }
+    const params = new URLSearchParams();
+    if (pageParams.get('room')) {
+        params.set('room', pageParams.get('room'));
+    }
+    if (isSpectator) {
+        params.set('role', 'spectator');
+    } else if (sessionStorage.getItem('resumeToken')) {
+        // Get our player back after a reload or a dropped connection
+        params.set('resume', sessionStorage.getItem('resumeToken'));
+    }
+    const query = params.toString();
+    return socketHost + pageConfig.socketPath + (query ? '?' + query : '');
+}
+
+// Browsers don't follow a redirected WebSocket handshake, so the lobby is asked where the room is played first.
+// The socket goes to that node unless it is the one that served the page, whose host the browser already knows.
+function findSocketURL() {
+    const url = socketURL();
+    if (replayName !== null) {
+        return Promise.resolve(url);
+    }
+    const room = pageParams.get('room');
+    return fetch(siteURL + '/lobby' + (room ? '?room=' + encodeURIComponent(room) : ''))
+        .then(response => response.ok ? response.json() : null)
+        .then(lobby => {
+            if (!lobby || lobby.node === pageConfig.node) {
+                return url;
+            }
+            const target = new URL(url);
+            const owner = new URL(lobby.socket);
+            target.protocol = owner.protocol;
+            target.host = owner.host;
+            return target.toString();
+        })
+        .catch(() => url);
+}
+
 function connectToWebSocket() {
-    socket = new WebSocket('ws://' + siteURL.replace('http://', '') + ':' + port + '/ws');
+    findSocketURL().then(openSocket);
+}
+
+function openSocket(url) {
+    socket = new WebSocket(url);
     console.log('WebSocket connection opened:', socket);
 
     // Listen for messages
@@ -27,17 +89,27 @@ function connectToWebSocket() {
     // Listen for connection closing
     socket.addEventListener('close', function (event) {
         console.log('WebSocket connection closed:', event);
+        if (replayEnded || kicked) {
+            return;
+        }
         // Attempt to reconnect after a short delay
-        setTimeout(connectToWebSocket, 5000);
+        setTimeout(connectToWebSocket, reconnectDelay);
     });
 }
 
 function handleRenderInstruction(instruction) {
     switch (instruction.type) {
+        case 'welcome':
+            playerID = instruction.payload.id;
+            sessionStorage.setItem('resumeToken', instruction.payload.resumeToken);
+            resizeField(instruction.payload);
+            break;
         case 'updatePlayer':
+            if (instruction.payload.id === playerID) {
+                heading = headingOf(instruction.payload);
+            }
             updatePlayerPosition(instruction.payload);
             updatePlayerTrail(instruction.payload);
-            playerID = instruction.payload.id;
             break;
         case 'captureTerritory':
             updateTerritory(instruction.payload);
@@ -48,9 +120,200 @@ function handleRenderInstruction(instruction) {
         case 'newPlayer':
             createPlayerElement(instruction.payload);
             break;
+        case 'worldSnapshot':
+            renderSnapshot(instruction.payload);
+            break;
+        case 'leaderboard':
+            renderLeaderboard(instruction.payload);
+            break;
+        case 'achievementUnlocked':
+            showAchievement(instruction.payload);
+            break;
+        case 'roomFull':
+            isSpectator = true;
+            console.log('Room is full, spectating until a slot frees up');
+            break;
+        case 'slotAvailable':
+            // Take the free slot straight away
+            sendSignal('join', '');
+            isSpectator = false;
+            break;
+        case 'shuttingDown':
+            // The server won't take new players, keep watching until it restarts
+            isSpectator = true;
+            addChatLine(instruction.content, 'notice');
+            break;
+        case 'serverShutdown': {
+            // The server is restarting, come back when it says and resume our player
+            const notice = JSON.parse(instruction.content);
+            reconnectDelay = notice.reconnectAfter * 1000;
+            console.log('Server is shutting down:', notice.reason);
+            break;
+        }
+        case 'chat':
+            addChatLine(instruction.payload.name + ': ' + instruction.payload.text, '');
+            break;
+        case 'rateLimited':
+        case 'muted':
+            addChatLine(instruction.content, 'notice');
+            break;
+        case 'announcement':
+            showAnnouncement(instruction.content);
+            addChatLine(instruction.content, 'notice');
+            break;
+        case 'kicked':
+            // Stay disconnected, reconnecting would only be kicked again if we are banned
+            kicked = true;
+            showAnnouncement('You were kicked: ' + instruction.content);
+            break;
+        case 'roomReset': {
+            // The room starts over on a fresh field, maybe another map, the players and their land follow
+            document.querySelectorAll('#gameArea .territory-cell, #gameArea .trail-point').forEach(element => element.remove());
+            const field = JSON.parse(instruction.content);
+            resizeField(field);
+            addChatLine(field.map ? 'The room was reset onto ' + field.map : 'The room was reset', 'notice');
+            break;
+        }
+        case 'replayEnded':
+            console.log('Replay finished:', instruction.content);
+            replayEnded = true;
+            break;
+    }
+}
+
+// The live leaderboard lists everyone in the room, best territory first
+function renderLeaderboard(leaderboard) {
+    const list = document.getElementById('leaderboard');
+    list.innerHTML = '';
+    leaderboard.standings.forEach(standing => {
+        const row = document.createElement('li');
+        row.style.color = standing.color;
+        if (standing.id === playerID) {
+            row.classList.add('self');
+        }
+        if (!standing.isAlive) {
+            row.classList.add('dead');
+        }
+        row.textContent = (standing.name || standing.id.slice(0, 8)) + ' ' + standing.territory.toFixed(1) + '% '
+            + standing.kills + ' kills' + (standing.streak > 1 ? ' (streak ' + standing.streak + ')' : '');
+        list.appendChild(row);
+    });
+}
+
+// Pop up an unlocked achievement for a few seconds
+function showAchievement(unlock) {
+    const toast = document.createElement('div');
+    toast.className = 'achievement';
+    toast.textContent = 'Achievement unlocked: ' + unlock.name + ' (+' + unlock.xp + ' XP, level ' + unlock.level + ')';
+    toast.title = unlock.description;
+    document.body.appendChild(toast);
+    setTimeout(() => toast.remove(), 4000);
+}
+
+// Announcements from the server stay on screen a little longer than achievements
+function showAnnouncement(text) {
+    const toast = document.createElement('div');
+    toast.className = 'announcement';
+    toast.textContent = text;
+    document.body.appendChild(toast);
+    setTimeout(() => toast.remove(), 8000);
+}
+
+// addChatLine adds a line to the chat box, keeping the last 50
+function addChatLine(text, className) {
+    const lines = document.getElementById('chatLines');
+    const line = document.createElement('li');
+    line.textContent = text;
+    if (className) {
+        line.className = className;
     }
+    lines.appendChild(line);
+    while (lines.children.length > 50) {
+        lines.removeChild(lines.firstChild);
+    }
+    lines.scrollTop = lines.scrollHeight;
+}
+
+function sendSignal(type, content) {
+    socket.send(JSON.stringify({type: type, content: content}));
+}
+
+function renderSnapshot(snapshot) {
+    snapshotPlayers = snapshot.players;
+    spectatorCamera = snapshot.camera;
+    paintTerrain(snapshot);
+
+    const seen = new Set();
+    snapshot.players.forEach(player => {
+        updatePlayerPosition(player);
+        seen.add(player.id);
+    });
+    document.querySelectorAll('#gameArea .player').forEach(element => {
+        if (!seen.has(element.id)) {
+            removePlayer({id: element.id});
+        }
+    });
+
+    // Move the field under the camera so the viewport fills the game area
+    const gameArea = document.getElementById('gameArea');
+    const scale = Math.min(snapshot.width / snapshot.camera.width, snapshot.height / snapshot.camera.height);
+    Array.from(gameArea.children).forEach(element => {
+        element.style.transform = 'scale(' + scale + ') translate(' + -snapshot.camera.x + 'px, ' + -snapshot.camera.y + 'px)';
+        element.style.transformOrigin = '0 0';
+    });
 }
 
+// Spectator controls: F follows the next player, arrow keys free-roam the camera, J asks to join,
+// [ and ] slow down and speed up a replay
+document.addEventListener('keydown', function (event) {
+    if (!isSpectator) {
+        return;
+    }
+    const step = 40;
+    let camera = Object.assign({}, spectatorCamera);
+    switch (event.code) {
+        case 'KeyF': {
+            const ids = snapshotPlayers.map(player => player.id);
+            const current = ids.indexOf(followedID);
+            followedID = ids.length ? ids[(current + 1) % ids.length] : '';
+            sendSignal('follow', followedID);
+            return;
+        }
+        case 'KeyJ':
+            sendSignal('join', '');
+            return;
+        case 'BracketLeft':
+        case 'BracketRight':
+            replaySpeed = Math.min(8, Math.max(0.5, event.code === 'BracketLeft' ? replaySpeed / 2 : replaySpeed * 2));
+            sendSignal('speed', String(replaySpeed));
+            return;
+        case 'ArrowUp':
+            camera.y -= step;
+            break;
+        case 'ArrowDown':
+            camera.y += step;
+            break;
+        case 'ArrowLeft':
+            camera.x -= step;
+            break;
+        case 'ArrowRight':
+            camera.x += step;
+            break;
+        case 'Equal':
+            camera.width /= 1.25;
+            camera.height /= 1.25;
+            break;
+        case 'Minus':
+            camera.width *= 1.25;
+            camera.height *= 1.25;
+            break;
+        default:
+            return;
+    }
+    followedID = '';
+    sendSignal('camera', JSON.stringify(camera));
+});
+
 function updatePlayerPosition(player) {
     let playerElement = document.getElementById(player.id);
     if (!playerElement) {
@@ -85,19 +348,69 @@ function updatePlayerTrail(player) {
     trailElement.appendChild(pointElement);
 }
 
+function resizeField(field) {
+    if (!field.width || !field.height || !field.cellSize) {
+        return;
+    }
+    cellSize = field.cellSize;
+    const gameArea = document.getElementById('gameArea');
+    gameArea.style.width = field.width + 'px';
+    gameArea.style.height = field.height + 'px';
+    paintTerrain(field);
+}
+
+// paintTerrain draws the map's walls, hazards and the outside of its field, open cells show the field itself.
+// Rows are strings of cells: . open, # wall, x hazard, space outside the field and S spawn, short rows end outside.
+function paintTerrain(field) {
+    const terrain = field.terrain || [];
+    const key = field.width + 'x' + field.height + '/' + field.cellSize + ':' + terrain.join('\n');
+    if (key === terrainKey) {
+        return;
+    }
+    terrainKey = key;
+
+    const gameArea = document.getElementById('gameArea');
+    gameArea.querySelectorAll('.terrain-cell').forEach(element => element.remove());
+    const kinds = {'#': 'wall', 'x': 'hazard', ' ': 'void'};
+    const cols = Math.round(field.width / field.cellSize);
+    terrain.forEach((row, y) => {
+        for (let x = 0; x < cols; x++) {
+            const kind = kinds[row[x] || ' '];
+            if (!kind) {
+                continue;
+            }
+            const cell = document.createElement('div');
+            cell.className = 'terrain-cell ' + kind;
+            cell.style.left = x * cellSize + 'px';
+            cell.style.top = y * cellSize + 'px';
+            cell.style.width = cellSize + 'px';
+            cell.style.height = cellSize + 'px';
+            gameArea.insertBefore(cell, gameArea.firstChild);
+        }
+    });
+}
+
+// updateTerritory paints the player's land and clears cells they have lost since the last update
 function updateTerritory(player) {
     player.landCapture.forEach((row, y) => {
         row.forEach((captured, x) => {
+            let cellId = `cell-${x}-${y}`;
+            let cell = document.getElementById(cellId);
             if (captured) {
-                let cellId = `cell-${x}-${y}`;
-                let cell = document.getElementById(cellId);
                 if (!cell) {
                     cell = document.createElement('div');
                     cell.id = cellId;
                     cell.className = 'territory-cell';
+                    cell.style.left = x * cellSize + 'px';
+                    cell.style.top = y * cellSize + 'px';
+                    cell.style.width = cellSize + 'px';
+                    cell.style.height = cellSize + 'px';
                     document.getElementById('gameArea').appendChild(cell);
                 }
+                cell.dataset.owner = player.id;
                 cell.style.backgroundColor = player.color;
+            } else if (cell && cell.dataset.owner === player.id) {
+                cell.parentNode.removeChild(cell);
             }
         });
     });
@@ -114,9 +427,36 @@ function removePlayer(player) {
     }
 }
 
+// The way our player is moving, from the server's updates. The server rejects turning straight back, and turning
+// the way we already go does nothing, so neither is sent.
+let heading = '';
+const reverse = {up: 'down', down: 'up', left: 'right', right: 'left'};
+
+function sendMove(direction) {
+    if (direction === heading || direction === reverse[heading]) {
+        return;
+    }
+    // Create the signal message with type 'move'
+    const signalMessage = {
+        type: 'move',
+        content: JSON.stringify({id: playerID, direction: direction})
+    };
+    socket.send(JSON.stringify(signalMessage));
+}
+
+function headingOf(player) {
+    if (player.velocityY < 0) return 'up';
+    if (player.velocityY > 0) return 'down';
+    if (player.velocityX < 0) return 'left';
+    if (player.velocityX > 0) return 'right';
+    return '';
+}
 
 document.addEventListener('keydown', function (event) {
-    if (playerID !== null) {
+    if (event.target.tagName === 'INPUT') {
+        return; // typing in the pause menu
+    }
+    if (playerID !== null && !isSpectator) {
         let direction = '';
         switch (event.code) {
             case 'KeyW': // W key
@@ -131,27 +471,10 @@ document.addEventListener('keydown', function (event) {
             case 'KeyD': // D key
                 direction = 'right';
                 break;
-            case 'Space': // Space key
-                direction = 'stop';
-                break;
             default:
                 return; // Ignore other keys
         }
-
-        // Construct the movePayload object
-        const movePayload = {
-            id: playerID,
-            direction: direction
-        };
-
-        // Create the signal message with type 'move'
-        const signalMessage = {
-            type: 'move',
-            content: JSON.stringify(movePayload)
-        };
-
-        // Send the signal message to the server
-        socket.send(JSON.stringify(signalMessage));
+        sendMove(direction);
     }
 });
 
@@ -161,8 +484,7 @@ function setupGamepad() {
         console.log("Gamepad connected at index %d: %s. %d buttons, %d axes.",
             e.gamepad.index, e.gamepad.id,
             e.gamepad.buttons.length, e.gamepad.axes.length);
-        setInterval(updateGamepadState, 1000 / 60)
-        requestAnimationFrame(updateGamepadState);
+        setInterval(updateGamepadState, 1000 / 60);
     });
 
     window.addEventListener("gamepaddisconnected", function (e) {
@@ -171,6 +493,9 @@ function setupGamepad() {
     });
 }
 
+// The stick is polled 60 times a second, but only changes of direction are sent
+let gamepadDirection = '';
+
 function updateGamepadState() {
     let gamepads = navigator.getGamepads();
     for (let i = 0; i < gamepads.length; i++) {
@@ -187,26 +512,106 @@ function updateGamepadState() {
                 direction = 'right';
             }
 
-            if (direction) {
-                // Construct the movePayload object
-                const movePayload = {
-                    id: playerID,
-                    direction: direction
-                };
-
-                // Create the signal message with type 'move'
-                const signalMessage = {
-                    type: 'move',
-                    content: JSON.stringify(movePayload)
-                };
-
-                // Send the signal message to the server
-                socket.send(JSON.stringify(signalMessage));
+            if (direction && direction !== gamepadDirection) {
+                gamepadDirection = direction;
+                sendMove(direction);
             }
         }
     }
 }
 
+// Escape opens the pause menu where players set the name and colour they get from their next game
+function togglePauseMenu() {
+    const menu = document.getElementById('pauseMenu');
+    const opening = menu.style.display === 'none';
+    menu.style.display = opening ? 'block' : 'none';
+    if (opening && playerID !== null) {
+        fetch(siteURL + '/profiles/' + encodeURIComponent(playerID), {credentials: 'include'})
+            .then(response => response.ok ? response.json() : null)
+            .then(profile => {
+                if (profile) {
+                    document.getElementById('playerName').value = profile.name || '';
+                    document.getElementById('playerColor').value = (profile.color || '#ffffff').toLowerCase();
+                }
+            });
+    }
+}
+
+function saveProfile() {
+    const status = document.getElementById('profileStatus');
+    fetch(siteURL + '/profiles/' + encodeURIComponent(playerID), {
+        method: 'PUT',
+        credentials: 'include',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({
+            name: document.getElementById('playerName').value,
+            color: document.getElementById('playerColor').value
+        })
+    }).then(response => {
+        if (response.ok) {
+            status.textContent = 'Saved, it shows from your next game';
+        } else {
+            response.text().then(text => status.textContent = text);
+        }
+    });
+}
+
+// ensureSession makes sure the browser has a session cookie, logging in as a guest if it has none
+function ensureSession() {
+    return fetch(siteURL + '/auth/me', {credentials: 'include'})
+        .then(response => response.ok ? response.json() : fetch(siteURL + '/auth/guest', {
+            method: 'POST',
+            credentials: 'include'
+        }).then(guest => guest.json()))
+        .then(session => {
+            document.getElementById('accountStatus').textContent = session.guest ? 'Playing as a guest' : 'Logged in as ' + session.username;
+            return session;
+        });
+}
+
+// Log in or register from the pause menu, then reconnect as that player
+function submitAccount(action) {
+    const status = document.getElementById('accountStatus');
+    fetch(siteURL + '/auth/' + action, {
+        method: 'POST',
+        credentials: 'include',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({
+            username: document.getElementById('username').value,
+            password: document.getElementById('password').value
+        })
+    }).then(response => {
+        if (!response.ok) {
+            response.text().then(text => status.textContent = text);
+            return;
+        }
+        document.getElementById('password').value = '';
+        // The resume token belongs to the old player
+        sessionStorage.removeItem('resumeToken');
+        ensureSession().then(() => socket.close());
+        togglePauseMenu();
+    });
+}
+
+document.addEventListener('keydown', function (event) {
+    if (event.code === 'Escape' && playerID !== null) {
+        togglePauseMenu();
+    }
+});
+
+window.addEventListener('load', function () {
+    document.getElementById('saveProfileButton').addEventListener('click', saveProfile);
+    document.getElementById('resumeButton').addEventListener('click', togglePauseMenu);
+    document.getElementById('loginButton').addEventListener('click', () => submitAccount('login'));
+    document.getElementById('registerButton').addEventListener('click', () => submitAccount('register'));
+    document.getElementById('chatInput').addEventListener('keydown', function (event) {
+        if (event.code !== 'Enter' || !this.value.trim() || isSpectator) {
+            return;
+        }
+        sendSignal('chat', this.value);
+        this.value = '';
+    });
+    ensureSession().then(connectToWebSocket);
+});
 
-setupGamepad(); // Initialize gamepad processing
-connectToWebSocket();
\ No newline at end of file
+setupGamepad(); // Initialize gamepad processing
\ No newline at end of file
@@ -0,0 +1,281 @@
+// Package store is a small embedded key-value database. Values are JSON documents in named buckets,
+// kept in memory and persisted to an append-only log file that is compacted when it grows.
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// compactAfter is how many stale records the log may hold before it is rewritten
+const compactAfter = 1000
+
+type DB struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	buckets map[string]map[string]json.RawMessage
+	records int // records in the log, live or not
+	live    int
+}
+
+// record is one line of the log, a put or a delete.
+type record struct {
+	Bucket  string          `json:"b"`
+	Key     string          `json:"k"`
+	Value   json.RawMessage `json:"v,omitempty"`
+	Deleted bool            `json:"d,omitempty"`
+}
+
+// Open loads the database at path, creating it if needed. A record cut short by a crash is ignored.
+func Open(path string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db := &DB{path: path, buckets: make(map[string]map[string]json.RawMessage)}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	if err := db.compact(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) load() error {
+	file, err := os.Open(db.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	var bad error // only the last line may be broken, a write cut short by a crash
+	for scanner.Scan() {
+		line++
+		if bad != nil {
+			return bad
+		}
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			bad = fmt.Errorf("%s line %d: %w", db.path, line, err)
+			continue
+		}
+		db.apply(rec)
+	}
+	return scanner.Err()
+}
+
+func (db *DB) apply(rec record) {
+	bucket := db.buckets[rec.Bucket]
+	if bucket == nil {
+		bucket = make(map[string]json.RawMessage)
+		db.buckets[rec.Bucket] = bucket
+	}
+	_, existed := bucket[rec.Key]
+	if rec.Deleted {
+		delete(bucket, rec.Key)
+		if existed {
+			db.live--
+		}
+	} else {
+		bucket[rec.Key] = rec.Value
+		if !existed {
+			db.live++
+		}
+	}
+	db.records++
+}
+
+// compact rewrites the log with only the live records and reopens it for appending.
+func (db *DB) compact() error {
+	if db.file != nil {
+		if err := db.writer.Flush(); err != nil {
+			return err
+		}
+		db.file.Close()
+	}
+
+	tmp := db.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, name := range sortedKeys(db.buckets) {
+		bucket := db.buckets[name]
+		for _, key := range sortedKeys(bucket) {
+			if err := encoder.Encode(record{Bucket: name, Key: key, Value: bucket[key]}); err != nil {
+				file.Close()
+				return err
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		return err
+	}
+
+	db.file, err = os.OpenFile(db.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	db.writer = bufio.NewWriter(db.file)
+	db.records = db.live
+	return nil
+}
+
+// write appends a record to the log and applies it, the caller must hold db.mu.
+func (db *DB) write(rec record) error {
+	if db.file == nil {
+		return errors.New("store is closed")
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := db.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := db.writer.Flush(); err != nil {
+		return err
+	}
+	db.apply(rec)
+	if db.records-db.live > compactAfter && db.records > 2*db.live {
+		return db.compact()
+	}
+	return nil
+}
+
+// Get decodes the value stored under key into value, returning ErrNotFound if there is none.
+func (db *DB) Get(bucket, key string, value any) error {
+	db.mu.RLock()
+	raw, ok := db.buckets[bucket][key]
+	db.mu.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(raw, value)
+}
+
+func (db *DB) Put(bucket, key string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(record{Bucket: bucket, Key: key, Value: raw})
+}
+
+func (db *DB) Delete(bucket, key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.buckets[bucket][key]; !ok {
+		return nil
+	}
+	return db.write(record{Bucket: bucket, Key: key, Deleted: true})
+}
+
+// Update reads the value under key into value, lets fn change it and stores the result, all without
+// another writer getting in between. exists tells fn whether there was a value. If fn returns an error
+// nothing is stored.
+func (db *DB) Update(bucket, key string, value any, fn func(exists bool) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	raw, exists := db.buckets[bucket][key]
+	if exists {
+		if err := json.Unmarshal(raw, value); err != nil {
+			return err
+		}
+	}
+	if err := fn(exists); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return db.write(record{Bucket: bucket, Key: key, Value: raw})
+}
+
+// ForEach calls fn for every value in a bucket in key order, stopping at the first error.
+// It works on a copy, so fn may write to the database.
+func (db *DB) ForEach(bucket string, fn func(key string, value json.RawMessage) error) error {
+	db.mu.RLock()
+	keys := sortedKeys(db.buckets[bucket])
+	values := make([]json.RawMessage, len(keys))
+	for i, key := range keys {
+		values[i] = db.buckets[bucket][key]
+	}
+	db.mu.RUnlock()
+
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of values in a bucket.
+func (db *DB) Len(bucket string) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.buckets[bucket])
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.file == nil {
+		return nil
+	}
+	err := db.writer.Flush()
+	if syncErr := db.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := db.file.Close(); err == nil {
+		err = closeErr
+	}
+	db.file = nil
+	return err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
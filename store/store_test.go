@@ -0,0 +1,186 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type doc struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func open(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func expect(t *testing.T, db *DB, bucket, key string, want doc) {
+	t.Helper()
+	var got doc
+	if err := db.Get(bucket, key, &got); err != nil {
+		t.Fatalf("%s/%s: %v", bucket, key, err)
+	}
+	if got != want {
+		t.Fatalf("%s/%s: got %+v, want %+v", bucket, key, got, want)
+	}
+}
+
+func expectMissing(t *testing.T, db *DB, bucket, key string) {
+	t.Helper()
+	if err := db.Get(bucket, key, &doc{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("%s/%s: expected ErrNotFound, got %v", bucket, key, err)
+	}
+}
+
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestReopenKeepsWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "test.db")
+	db := open(t, path)
+	if err := db.Put("players", "a", doc{Name: "a", Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("players", "b", doc{Name: "b", Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("bans", "a", doc{Name: "ban"}); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Update("players", "a", &doc{}, func(exists bool) error {
+		if !exists {
+			t.Fatal("update didn't find a")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var updated doc
+	if err := db.Update("players", "c", &updated, func(exists bool) error {
+		updated = doc{Name: "c", Count: 3}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("players", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = open(t, path)
+	expect(t, db, "players", "a", doc{Name: "a", Count: 1})
+	expect(t, db, "players", "c", doc{Name: "c", Count: 3})
+	expect(t, db, "bans", "a", doc{Name: "ban"})
+	expectMissing(t, db, "players", "b")
+	if n := db.Len("players"); n != 2 {
+		t.Fatalf("expected 2 players, got %d", n)
+	}
+	var keys []string
+	db.ForEach("players", func(key string, _ json.RawMessage) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Fatalf("expected keys a and c in order, got %v", keys)
+	}
+}
+
+func TestTruncatedLastRecordIsIgnored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db := open(t, path)
+	if err := db.Put("players", "a", doc{Name: "a", Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A crash halfway through writing the next record
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"b":"players","k":"b","v":{"name":"b","co`)
+	file.Close()
+
+	db = open(t, path)
+	expect(t, db, "players", "a", doc{Name: "a", Count: 1})
+	expectMissing(t, db, "players", "b")
+	if err := db.Put("players", "b", doc{Name: "b", Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = open(t, path)
+	expect(t, db, "players", "b", doc{Name: "b", Count: 2})
+}
+
+func TestBrokenRecordBeforeTheEndFailsToOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	data := `{"b":"players","k":"a","v":{"name":"a"}}` + "\n" + `{"b":"pla` + "\n" + `{"b":"players","k":"c","v":{"name":"c"}}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatal("opened a database with a broken record in the middle")
+	}
+}
+
+func TestCompactionKeepsLatestValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db := open(t, path)
+	writes := 3 * compactAfter
+	for i := 1; i <= writes; i++ {
+		if err := db.Put("counts", "hot", doc{Count: i}); err != nil {
+			t.Fatal(err)
+		}
+		if i%100 == 0 {
+			if err := db.Put("counts", "cold", doc{Count: i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Put("counts", "gone", doc{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("counts", "gone"); err != nil {
+		t.Fatal(err)
+	}
+	if n := lines(t, path); n > compactAfter+3 {
+		t.Fatalf("the log has %d records after %d writes, it wasn't compacted", n, writes)
+	}
+	expect(t, db, "counts", "hot", doc{Count: writes})
+	expect(t, db, "counts", "cold", doc{Count: writes})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening compacts the log down to the live records
+	db = open(t, path)
+	expect(t, db, "counts", "hot", doc{Count: writes})
+	expect(t, db, "counts", "cold", doc{Count: writes})
+	expectMissing(t, db, "counts", "gone")
+	if n := lines(t, path); n != 2 {
+		t.Fatalf("expected 2 records after reopening, got %d", n)
+	}
+}
@@ -0,0 +1,70 @@
+// Package webhook signs and checks webhook payloads and decides when a failed delivery is tried again.
+//
+// A payload is signed with HMAC-SHA256 over the Unix timestamp, a dot and the body, so a receiver can check the
+// payload came from a server that knows the secret and reject old payloads replayed at it.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderSignature = "X-Webhook-Signature" // "sha256=" and the hex HMAC
+	HeaderTimestamp = "X-Webhook-Timestamp" // Unix seconds the payload was signed at
+	HeaderEvent     = "X-Webhook-Event"     // the event type
+	HeaderDelivery  = "X-Webhook-Delivery"  // the same for every attempt at one delivery
+)
+
+var (
+	ErrBadSignature = errors.New("webhook signature doesn't match")
+	ErrStale        = errors.New("webhook timestamp is too far from now")
+)
+
+// Sign returns the signature header value for a body signed at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature and that it was signed within tolerance of now.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return ErrStale
+	}
+	signature := strings.TrimSpace(header.Get(HeaderSignature))
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Backoff is how long to wait after the given failed attempt, counting from 1: initial, doubled for each attempt
+// after the first and capped at max.
+func Backoff(attempt int, initial, max time.Duration) time.Duration {
+	wait := initial
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	return min(wait, max)
+}
+
+// Retryable reports whether a response status is worth another attempt: timeouts, rate limits and server errors.
+// Other client errors mean the receiver will never take the payload.
+func Retryable(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signed(secret string, at time.Time, body []byte) http.Header {
+	header := http.Header{}
+	header.Set(HeaderTimestamp, strconv.FormatInt(at.Unix(), 10))
+	header.Set(HeaderSignature, Sign(secret, at.Unix(), body))
+	return header
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"type":"ping"}`)
+
+	if err := Verify("s3cret", signed("s3cret", now, body), body, time.Minute, now); err != nil {
+		t.Fatalf("a good signature didn't check out: %v", err)
+	}
+	if err := Verify("other", signed("s3cret", now, body), body, time.Minute, now); err != ErrBadSignature {
+		t.Fatalf("expected ErrBadSignature for another secret, got %v", err)
+	}
+	if err := Verify("s3cret", signed("s3cret", now, body), []byte(`{"type":"pong"}`), time.Minute, now); err != ErrBadSignature {
+		t.Fatalf("expected ErrBadSignature for another body, got %v", err)
+	}
+	// The timestamp is signed too, so it can't be moved forward to replay an old payload
+	header := signed("s3cret", now.Add(-time.Hour), body)
+	header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	if err := Verify("s3cret", header, body, time.Minute, now); err != ErrBadSignature {
+		t.Fatalf("expected ErrBadSignature for a changed timestamp, got %v", err)
+	}
+	for _, at := range []time.Time{now.Add(-2 * time.Minute), now.Add(2 * time.Minute)} {
+		if err := Verify("s3cret", signed("s3cret", at, body), body, time.Minute, now); err != ErrStale {
+			t.Fatalf("expected ErrStale for a payload signed at %v, got %v", at, err)
+		}
+	}
+	if err := Verify("s3cret", http.Header{}, body, time.Minute, now); err != ErrBadSignature {
+		t.Fatalf("expected ErrBadSignature without headers, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		attempt := i + 1
+		if got := Backoff(attempt, time.Second, 10*time.Second); got != want {
+			t.Errorf("attempt %d: expected to wait %v, got %v", attempt, want, got)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusRequestTimeout:      true,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusBadRequest:          false,
+		http.StatusNotFound:            false,
+		http.StatusGone:                false,
+	} {
+		if Retryable(status) != want {
+			t.Errorf("status %d: expected retryable=%v", status, want)
+		}
+	}
+}